@@ -21,51 +21,27 @@ const (
 	RevisionRequested Label = "cindy:revision-requested"
 )
 
-// AllLabels returns all valid Cindy labels.
+// AllLabels returns all labels of the default pipeline.
 func AllLabels() []Label {
-	return []Label{
-		Ready, Analyzing, Approved, Blocked, Deploying,
-		Deployed, Rejected, Rollback, HumanReview, RevisionRequested,
-	}
-}
-
-// validTransitions maps each label to its allowed next states.
-var validTransitions = map[Label][]Label{
-	Ready:             {Analyzing},
-	Analyzing:         {Approved, Rejected, HumanReview, Blocked, RevisionRequested},
-	Approved:          {Deploying, Blocked},
-	Blocked:           {Approved},
-	Deploying:         {Deployed, Rollback},
-	HumanReview:       {Approved, Rejected, RevisionRequested},
-	RevisionRequested: {Ready},
-	Deployed:          {Rollback},
+	return DefaultPipeline().Labels()
 }
 
 // CanTransition returns true if transitioning from one label to another is valid
-// according to the Cindy protocol state machine.
+// according to the Cindy protocol state machine (the default pipeline).
 func CanTransition(from, to Label) bool {
-	targets, ok := validTransitions[from]
-	if !ok {
-		return false
-	}
-	for _, t := range targets {
-		if t == to {
-			return true
-		}
-	}
-	return false
+	return DefaultPipeline().CanTransition(from, to)
 }
 
-// ValidTransitionsFrom returns the list of valid target labels from a given label.
+// ValidTransitionsFrom returns the list of valid target labels from a given label
+// in the default pipeline.
 // Returns nil if the label has no valid transitions (terminal state or unknown).
 func ValidTransitionsFrom(from Label) []Label {
-	return validTransitions[from]
+	return DefaultPipeline().ValidTransitionsFrom(from)
 }
 
-// IsTerminal returns true if a label has no valid outgoing transitions
-// (i.e., Rejected or Rollback with no further transitions defined,
-// though Deployed can transition to Rollback).
+// IsTerminal returns true if a label is a terminal state of the default
+// pipeline (Rejected). Deployed and Rollback are not terminal since they
+// can transition to Rollback and RevisionRequested.
 func IsTerminal(l Label) bool {
-	targets := validTransitions[l]
-	return len(targets) == 0
+	return DefaultPipeline().IsTerminal(l)
 }
@@ -32,6 +32,7 @@ func TestCanTransition_ValidPaths(t *testing.T) {
 		{HumanReview, RevisionRequested},
 		{RevisionRequested, Ready},
 		{Deployed, Rollback},
+		{Rollback, RevisionRequested},
 	}
 
 	for _, tt := range tests {
@@ -86,8 +87,8 @@ func TestIsTerminal(t *testing.T) {
 	if !IsTerminal(Rejected) {
 		t.Error("expected Rejected to be terminal")
 	}
-	if !IsTerminal(Rollback) {
-		t.Error("expected Rollback to be terminal")
+	if IsTerminal(Rollback) {
+		t.Error("expected Rollback to not be terminal (can transition to RevisionRequested)")
 	}
 	if IsTerminal(Ready) {
 		t.Error("expected Ready to not be terminal")
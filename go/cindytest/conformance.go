@@ -0,0 +1,365 @@
+package cindytest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"testing"
+
+	cindy "github.com/nimsforest/cindy/go"
+)
+
+// LabelerFactory returns a new, empty Labeler configured with the default
+// pipeline. It is called once per subtest.
+type LabelerFactory func(t *testing.T) cindy.Labeler
+
+// RunLabelerConformance checks that the Labelers returned by newLabeler honor
+// the protocol: one label per branch, only pipeline labels, valid branch
+// names, and — driven through a cindy.Engine, including a RandomWalk — only
+// valid transitions.
+// Labelers that implement cindy.RecordingLabeler must also keep a valid,
+// ordered history, and cindy.LabelSwappers must refuse stale swaps.
+func RunLabelerConformance(t *testing.T, newLabeler LabelerFactory) {
+	t.Run("UnlabeledBranch", func(t *testing.T) {
+		l := newLabeler(t)
+		label, err := l.GetLabel("feature/unlabeled")
+		if err != nil || label != "" {
+			t.Errorf("GetLabel on an unlabeled branch = %q, %v; want no label", label, err)
+		}
+		all, err := l.AllLabels()
+		if err != nil || len(all) != 0 {
+			t.Errorf("AllLabels on an empty labeler = %v, %v; want none", all, err)
+		}
+	})
+
+	t.Run("SingleLabelPerBranch", func(t *testing.T) {
+		l := newLabeler(t)
+		mustSet(t, l, "feature/a", cindy.Ready)
+		mustSet(t, l, "feature/a", cindy.Analyzing)
+		expectLabel(t, l, "feature/a", cindy.Analyzing)
+
+		all, err := l.AllLabels()
+		if err != nil {
+			t.Fatalf("AllLabels: %v", err)
+		}
+		if len(all) != 1 || all["feature/a"] != cindy.Analyzing {
+			t.Errorf("AllLabels = %v, want only feature/a at %s", all, cindy.Analyzing)
+		}
+		if b, err := l.BranchesWithLabel(cindy.Ready); err != nil || len(b) != 0 {
+			t.Errorf("BranchesWithLabel(%s) = %v, %v; the replaced label must be gone", cindy.Ready, b, err)
+		}
+	})
+
+	t.Run("BranchesWithLabel", func(t *testing.T) {
+		l := newLabeler(t)
+		mustSet(t, l, "feature/b", cindy.Approved)
+		mustSet(t, l, "feature/a", cindy.Approved)
+		mustSet(t, l, "feature/c", cindy.Blocked)
+
+		got, err := l.BranchesWithLabel(cindy.Approved)
+		if err != nil {
+			t.Fatalf("BranchesWithLabel: %v", err)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != "feature/a,feature/b" {
+			t.Errorf("BranchesWithLabel(%s) = %v, want [feature/a feature/b]", cindy.Approved, got)
+		}
+		if got, err := l.BranchesWithLabel(cindy.Deployed); err != nil || len(got) != 0 {
+			t.Errorf("BranchesWithLabel(%s) = %v, %v; want none", cindy.Deployed, got, err)
+		}
+	})
+
+	t.Run("BranchNames", func(t *testing.T) {
+		l := newLabeler(t)
+		branches := []string{"main", "feature/deep/nested/branch", "release/1.2.x", "fix/ümlaut", "user+tag/x"}
+		for _, b := range branches {
+			mustSet(t, l, b, cindy.HumanReview)
+		}
+		for _, b := range branches {
+			expectLabel(t, l, b, cindy.HumanReview)
+		}
+		all, err := l.AllLabels()
+		if err != nil || len(all) != len(branches) {
+			t.Errorf("AllLabels = %v, %v; want %d branches", all, err, len(branches))
+		}
+	})
+
+	t.Run("RejectsInvalidBranch", func(t *testing.T) {
+		l := newLabeler(t)
+		for _, b := range []string{"", "feature..x", "-rf", "HEAD", "a b", "x.lock"} {
+			if err := l.SetLabel(b, cindy.Ready); err == nil {
+				t.Errorf("SetLabel(%q) succeeded, want an error", b)
+			}
+		}
+	})
+
+	t.Run("RejectsUnknownLabel", func(t *testing.T) {
+		l := newLabeler(t)
+		for _, label := range []cindy.Label{"cindy:shipped", "ready", ""} {
+			if err := l.SetLabel("feature/a", label); err == nil {
+				t.Errorf("SetLabel(%q) succeeded, want an error", label)
+			}
+		}
+		expectLabel(t, l, "feature/a", "")
+	})
+
+	t.Run("Isolation", func(t *testing.T) {
+		l := newLabeler(t)
+		mustSet(t, l, "feature/a", cindy.Ready)
+		mustSet(t, l, "feature/a-b", cindy.Deployed)
+		mustSet(t, l, "feature/ab", cindy.Rejected)
+		expectLabel(t, l, "feature/a", cindy.Ready)
+		expectLabel(t, l, "feature/a-b", cindy.Deployed)
+		expectLabel(t, l, "feature/ab", cindy.Rejected)
+	})
+
+	t.Run("EngineTransitions", func(t *testing.T) {
+		l := newLabeler(t)
+		e := cindy.NewEngine(l, nil)
+		p := e.Pipeline()
+		path := []cindy.Label{cindy.Ready, cindy.Analyzing, cindy.Approved, cindy.Deploying, cindy.Deployed}
+		for _, to := range path {
+			from, _ := l.GetLabel("feature/x")
+			for _, bad := range p.Labels() {
+				if bad == to || p.CanTransition(from, bad) || (from == "" && bad == p.Entry()) {
+					continue
+				}
+				_, err := e.ApplyTransition(cindy.TransitionRequest{Branch: "feature/x", To: bad})
+				if !errors.Is(err, cindy.ErrInvalidTransition) {
+					t.Errorf("%s → %s: got %v, want ErrInvalidTransition", labelOrNone(from), bad, err)
+				}
+				expectLabel(t, l, "feature/x", from)
+			}
+			if _, err := e.ApplyTransition(cindy.TransitionRequest{Branch: "feature/x", To: to, Metadata: cindy.Metadata{Actor: "conformance"}}); err != nil {
+				t.Fatalf("%s → %s: %v", labelOrNone(from), to, err)
+			}
+			expectLabel(t, l, "feature/x", to)
+		}
+	})
+
+	t.Run("CompareAndSwap", func(t *testing.T) {
+		l := newLabeler(t)
+		s, ok := l.(cindy.LabelSwapper)
+		if !ok {
+			t.Skip("labeler does not support compare-and-swap")
+		}
+		if err := s.CompareAndSwapLabel("feature/x", "", cindy.Ready, cindy.Metadata{}); err != nil {
+			t.Fatalf("swap from unlabeled: %v", err)
+		}
+		for _, stale := range []cindy.Label{"", cindy.Analyzing} {
+			err := s.CompareAndSwapLabel("feature/x", stale, cindy.Approved, cindy.Metadata{})
+			var conflict *cindy.ConflictError
+			if !errors.As(err, &conflict) || conflict.Actual != cindy.Ready {
+				t.Errorf("swap expecting %s: got %v, want a ConflictError reporting %s", labelOrNone(stale), err, cindy.Ready)
+			}
+			expectLabel(t, l, "feature/x", cindy.Ready)
+		}
+		if err := s.CompareAndSwapLabel("feature/x", cindy.Ready, cindy.Analyzing, cindy.Metadata{}); err != nil {
+			t.Fatalf("swap %s → %s: %v", cindy.Ready, cindy.Analyzing, err)
+		}
+		expectLabel(t, l, "feature/x", cindy.Analyzing)
+		if b, err := l.BranchesWithLabel(cindy.Ready); err != nil || len(b) != 0 {
+			t.Errorf("BranchesWithLabel(%s) = %v, %v; the swapped label must be gone", cindy.Ready, b, err)
+		}
+	})
+
+	t.Run("RandomWalk", func(t *testing.T) {
+		RandomWalk(t, newLabeler(t), rand.New(rand.NewPCG(1, 2)), 60)
+	})
+
+	t.Run("History", func(t *testing.T) {
+		l := newLabeler(t)
+		rl, ok := l.(cindy.RecordingLabeler)
+		if !ok {
+			t.Skip("labeler does not record history")
+		}
+		path := []cindy.Label{cindy.Ready, cindy.Analyzing, cindy.HumanReview, cindy.RevisionRequested, cindy.Ready}
+		for i, to := range path {
+			meta := cindy.Metadata{Actor: fmt.Sprintf("actor-%d", i), Reason: "step " + string(to)}
+			if err := rl.SetLabelWithMetadata("feature/x", to, meta); err != nil {
+				t.Fatalf("SetLabelWithMetadata(%s): %v", to, err)
+			}
+		}
+		if err := rl.RemoveLabel("feature/x", cindy.Metadata{Actor: "cleanup"}); err != nil {
+			t.Fatalf("RemoveLabel: %v", err)
+		}
+		expectLabel(t, l, "feature/x", "")
+
+		history, err := rl.History("feature/x")
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+		if len(history) != len(path)+1 {
+			t.Fatalf("History has %d transitions, want %d", len(history), len(path)+1)
+		}
+		var from cindy.Label
+		for i, to := range append(path, "") {
+			h := history[i]
+			if h.Branch != "feature/x" || h.From != from || h.To != to || h.Timestamp.IsZero() {
+				t.Errorf("transition #%d = %v, want %s → %s with a timestamp", i, h, labelOrNone(from), labelOrNone(to))
+			}
+			from = to
+		}
+		if history[0].Actor != "actor-0" || history[len(path)].Actor != "cleanup" {
+			t.Errorf("actors not recorded: %v", history)
+		}
+		if v := cindy.ValidateHistory(history); len(v) > 0 {
+			t.Errorf("ValidateHistory: %v", v)
+		}
+	})
+}
+
+// RandomWalk requests steps random labels for a branch of l through a
+// cindy.Engine, supplying whatever the built-in guards need, and checks that
+// every valid transition is applied, every invalid one is refused and the
+// branch never reaches a label it could not legally hold.
+func RandomWalk(t *testing.T, l cindy.Labeler, rng *rand.Rand, steps int) {
+	t.Helper()
+	const branch = "feature/walk"
+	e := cindy.NewEngine(l, nil)
+	p := e.Pipeline()
+	labels := p.Labels()
+
+	rollback := cindy.NewRollbackReview(cindy.RollbackRecord{Branch: branch, Revision: 1, Cause: "random walk"})
+	rollback.Comments[0].Resolved = true
+	manifest := &cindy.Manifest{Revision: 2, RespondsTo: &rollback.ID}
+
+	var current cindy.Label
+	for i := 0; i < steps; i++ {
+		to := labels[rng.IntN(len(labels))]
+		if current == "" && rng.IntN(2) == 0 {
+			to = p.Entry()
+		}
+		allowed := p.CanTransition(current, to) || current == "" && to == p.Entry()
+		_, err := e.ApplyTransition(cindy.TransitionRequest{
+			Branch:   branch,
+			To:       to,
+			Metadata: cindy.Metadata{Actor: "random-walk", Reason: fmt.Sprintf("step %d", i)},
+			Manifest: manifest,
+			Reviews:  []cindy.Review{rollback},
+		})
+		switch {
+		case allowed && err != nil:
+			t.Fatalf("step %d: %s → %s refused: %v", i, labelOrNone(current), to, err)
+		case !allowed && err == nil:
+			t.Fatalf("step %d: %s → %s applied but is invalid", i, labelOrNone(current), to)
+		case allowed:
+			current = to
+		}
+		expectLabel(t, l, branch, current)
+	}
+
+	if rl, ok := l.(cindy.RecordingLabeler); ok {
+		history, err := rl.History(branch)
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+		if v := p.ValidateHistory(history); len(v) > 0 {
+			t.Errorf("random walk left an invalid history: %v", v)
+		}
+	}
+}
+
+// RunTransitionConformance checks canTransition against every pair of
+// protocol labels (SPEC §3.1).
+func RunTransitionConformance(t *testing.T, canTransition func(from, to cindy.Label) bool) {
+	f, err := Transitions()
+	if err != nil {
+		t.Fatalf("loading fixtures: %v", err)
+	}
+	valid := make(map[[2]cindy.Label]bool, len(f.Valid))
+	for _, v := range f.Valid {
+		valid[v] = true
+	}
+	for _, from := range f.Labels {
+		for _, to := range f.Labels {
+			want := valid[[2]cindy.Label{from, to}]
+			if got := canTransition(from, to); got != want {
+				t.Errorf("%s → %s: got %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+// RunManifestConformance runs check on every manifest fixture. check parses
+// the manifest and returns its schema safety violations (SPEC §5), or an
+// error if it cannot be parsed.
+func RunManifestConformance(t *testing.T, check func(data []byte) ([]cindy.SchemaViolation, error)) {
+	manifests, err := Manifests()
+	if err != nil {
+		t.Fatalf("loading fixtures: %v", err)
+	}
+	for _, f := range manifests {
+		t.Run(f.Name, func(t *testing.T) {
+			violations, err := check(f.Manifest)
+			if !f.Valid {
+				if err == nil {
+					t.Errorf("%s: parsed, want an error", f.Description)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", f.Description, err)
+			}
+			var got, want []string
+			for _, v := range violations {
+				got = append(got, v.Subject+"."+v.Field)
+			}
+			for _, v := range f.Violations {
+				want = append(want, v.Subject+"."+v.Field)
+			}
+			sort.Strings(got)
+			sort.Strings(want)
+			if strings.Join(got, " ") != strings.Join(want, " ") {
+				t.Errorf("%s: violations %v, want %v", f.Description, got, want)
+			}
+		})
+	}
+}
+
+// RunReviewConformance runs blocking on every review fixture. blocking parses
+// the review and reports whether it blocks the branch (SPEC §7.3).
+func RunReviewConformance(t *testing.T, blocking func(data []byte) (bool, error)) {
+	reviews, err := Reviews()
+	if err != nil {
+		t.Fatalf("loading fixtures: %v", err)
+	}
+	for _, f := range reviews {
+		t.Run(f.Name, func(t *testing.T) {
+			got, err := blocking(f.Review)
+			if err != nil {
+				t.Fatalf("%s: %v", f.Description, err)
+			}
+			if got != f.Blocking {
+				t.Errorf("%s: blocking = %v, want %v", f.Description, got, f.Blocking)
+			}
+		})
+	}
+}
+
+func mustSet(t *testing.T, l cindy.Labeler, branch string, label cindy.Label) {
+	t.Helper()
+	if err := l.SetLabel(branch, label); err != nil {
+		t.Fatalf("SetLabel(%s, %s): %v", branch, label, err)
+	}
+}
+
+func expectLabel(t *testing.T, l cindy.Labeler, branch string, want cindy.Label) {
+	t.Helper()
+	got, err := l.GetLabel(branch)
+	if err != nil {
+		t.Fatalf("GetLabel(%s): %v", branch, err)
+	}
+	if got != want {
+		t.Errorf("GetLabel(%s) = %s, want %s", branch, labelOrNone(got), labelOrNone(want))
+	}
+}
+
+func labelOrNone(l cindy.Label) string {
+	if l == "" {
+		return "(none)"
+	}
+	return string(l)
+}
@@ -0,0 +1,62 @@
+package cindytest
+
+import (
+	"encoding/json"
+	"os/exec"
+	"testing"
+
+	cindy "github.com/nimsforest/cindy/go"
+)
+
+func TestMemoryLabelerConformance(t *testing.T) {
+	RunLabelerConformance(t, func(t *testing.T) cindy.Labeler {
+		return cindy.NewMemoryLabeler()
+	})
+}
+
+func TestGitLabelerConformance(t *testing.T) {
+	RunLabelerConformance(t, func(t *testing.T) cindy.Labeler {
+		dir := t.TempDir()
+		for _, args := range [][]string{
+			{"init"},
+			{"config", "user.email", "test@test.com"},
+			{"config", "user.name", "Test"},
+			{"commit", "--allow-empty", "-m", "init"},
+		} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git %v: %s: %v", args, out, err)
+			}
+		}
+		gl, err := cindy.NewGitLabeler(dir)
+		if err != nil {
+			t.Fatalf("NewGitLabeler: %v", err)
+		}
+		return gl
+	})
+}
+
+func TestTransitionConformance(t *testing.T) {
+	RunTransitionConformance(t, cindy.CanTransition)
+}
+
+func TestManifestConformance(t *testing.T) {
+	RunManifestConformance(t, func(data []byte) ([]cindy.SchemaViolation, error) {
+		m, err := cindy.ParseManifest(data)
+		if err != nil {
+			return nil, err
+		}
+		return cindy.ValidateSchemaChanges(m), nil
+	})
+}
+
+func TestReviewConformance(t *testing.T) {
+	RunReviewConformance(t, func(data []byte) (bool, error) {
+		var r cindy.Review
+		if err := json.Unmarshal(data, &r); err != nil {
+			return false, err
+		}
+		return cindy.IsBlocking(&r), nil
+	})
+}
@@ -0,0 +1,131 @@
+// Package cindytest is a conformance kit for implementations of the Cindy
+// protocol (SPEC.md §9).
+//
+// Go implementations of cindy.Labeler run RunLabelerConformance from their
+// tests. Orchestrators written in Go run RunTransitionConformance,
+// RunManifestConformance and RunReviewConformance against their own logic;
+// orchestrators in other languages can use the fixture corpus in the
+// fixtures directory directly — every fixture is a JSON file holding the
+// input and the expected outcome.
+package cindytest
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	cindy "github.com/nimsforest/cindy/go"
+)
+
+//go:embed fixtures
+var fixtures embed.FS
+
+// Fixtures returns the fixture corpus rooted at the fixtures directory.
+func Fixtures() fs.FS {
+	sub, err := fs.Sub(fixtures, "fixtures")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// TransitionFixture lists the protocol's labels and its valid transitions.
+// Every pair of labels not listed in Valid is an invalid transition.
+type TransitionFixture struct {
+	Labels []cindy.Label    `json:"labels"`
+	Valid  [][2]cindy.Label `json:"valid"`
+}
+
+// ViolationFixture identifies an expected schema violation. The rule text is
+// left to the implementation.
+type ViolationFixture struct {
+	Subject string `json:"subject"`
+	Field   string `json:"field"`
+}
+
+// ManifestFixture is a manifest and the outcome a conformant implementation
+// must reach for it.
+type ManifestFixture struct {
+	Name        string `json:"-"`
+	Description string `json:"description"`
+	// Valid is false if the manifest must fail to parse.
+	Valid bool `json:"valid"`
+	// Violations are the schema safety violations of a valid manifest.
+	Violations []ViolationFixture `json:"violations"`
+	Manifest   json.RawMessage    `json:"manifest"`
+}
+
+// ReviewFixture is a review and whether it must block the pipeline.
+type ReviewFixture struct {
+	Name        string          `json:"-"`
+	Description string          `json:"description"`
+	Blocking    bool            `json:"blocking"`
+	Review      json.RawMessage `json:"review"`
+}
+
+// Transitions returns the transition fixture.
+func Transitions() (TransitionFixture, error) {
+	var f TransitionFixture
+	data, err := fs.ReadFile(fixtures, "fixtures/transitions.json")
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("transitions.json: %w", err)
+	}
+	return f, nil
+}
+
+// Manifests returns the manifest fixtures, sorted by name.
+func Manifests() ([]ManifestFixture, error) {
+	var out []ManifestFixture
+	err := readFixtures("fixtures/manifests", func(name string, data []byte) error {
+		f := ManifestFixture{Name: name}
+		if err := json.Unmarshal(data, &f); err != nil {
+			return err
+		}
+		out = append(out, f)
+		return nil
+	})
+	return out, err
+}
+
+// Reviews returns the review fixtures, sorted by name.
+func Reviews() ([]ReviewFixture, error) {
+	var out []ReviewFixture
+	err := readFixtures("fixtures/reviews", func(name string, data []byte) error {
+		f := ReviewFixture{Name: name}
+		if err := json.Unmarshal(data, &f); err != nil {
+			return err
+		}
+		out = append(out, f)
+		return nil
+	})
+	return out, err
+}
+
+func readFixtures(dir string, fn func(name string, data []byte) error) error {
+	entries, err := fs.ReadDir(fixtures, dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".json" {
+			continue
+		}
+		data, err := fs.ReadFile(fixtures, path.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(e.Name(), ".json")
+		if err := fn(name, data); err != nil {
+			return fmt.Errorf("%s/%s: %w", path.Base(dir), e.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	cindy "github.com/nimsforest/cindy/go"
+)
+
+// stdin is where the pre-receive hook reads ref updates from.
+var stdin io.Reader = os.Stdin
+
+// pusherEnv lists the variables git servers use to name the authenticated
+// pusher, in order of preference.
+var pusherEnv = []string{"CINDY_PUSHER", "GITEA_PUSHER_NAME", "GL_USERNAME", "GL_USER", "REMOTE_USER"}
+
+// runHook enforces the protocol as a server-side git hook. Install it as
+//
+//	#!/bin/sh
+//	exec cindy hook pre-receive
+//
+// or, where only the update hook is available, exec cindy hook update "$@".
+func runHook(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	kind := args[0]
+	if kind != "pre-receive" && kind != "update" {
+		fmt.Fprintf(stderr, "cindy hook: unknown hook %q\n%s", kind, usage)
+		return 2
+	}
+	h := &cindy.ReceiveHook{Pipeline: cindy.DefaultPipeline()}
+	fs := flag.NewFlagSet("cindy hook "+kind, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	repo := fs.String("repo", ".", "repository receiving the push")
+	pipelinePath := fs.String("pipeline", "", "pipeline definition (default: the built-in pipeline)")
+	permsPath := fs.String("permissions", "", "actors and the transitions they may apply")
+	trustActors := fs.Bool("trust-recorded-actors", false, "without an authenticated pusher, authorize steps as the actor the push records, which the pusher can set to anyone")
+	keysPath := fs.String("keys", "", "key ring of trusted signers, for -require-signed")
+	fs.Func("require-signed", "refuse unsigned transitions into this label (repeatable; needs -keys)", func(l string) error {
+		h.RequireSigned = append(h.RequireSigned, cindy.Label(l))
+		return nil
+	})
+	allowed := make(map[cindy.Label][]string)
+	fs.Func("allow", "restrict a label to actors, as label=actor,actor (repeatable)", func(v string) error {
+		label, actors, ok := strings.Cut(v, "=")
+		if !ok || actors == "" {
+			return fmt.Errorf("want label=actor,actor, got %q", v)
+		}
+		allowed[cindy.Label(label)] = append(allowed[cindy.Label(label)], strings.Split(actors, ",")...)
+		return nil
+	})
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	h.Repo = *repo
+	h.TrustRecordedActors = *trustActors
+
+	if *pipelinePath != "" {
+		p, err := cindy.LoadPipeline(*pipelinePath)
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: %v\n", err)
+			return 1
+		}
+		h.Pipeline = p
+	}
+	for _, name := range pusherEnv {
+		if h.Pusher = os.Getenv(name); h.Pusher != "" {
+			break
+		}
+	}
+	if *keysPath != "" {
+		keys, err := cindy.LoadKeyRing(*keysPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: %v\n", err)
+			return 1
+		}
+		h.Keys = keys
+	} else if len(h.RequireSigned) > 0 {
+		fmt.Fprintln(stderr, "cindy: -require-signed needs -keys")
+		return 2
+	}
+	var checks []func(actor string, t cindy.Transition, prior []cindy.Transition) error
+	if *permsPath != "" {
+		perms, err := cindy.LoadPermissions(*permsPath, h.Pipeline)
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: %v\n", err)
+			return 1
+		}
+		gl, err := cindy.NewGitLabeler(*repo, cindy.WithPipeline(h.Pipeline))
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: %v\n", err)
+			return 1
+		}
+		checks = append(checks, perms.Authorizer(gl))
+	}
+	if len(allowed) > 0 {
+		checks = append(checks, func(actor string, t cindy.Transition, _ []cindy.Transition) error {
+			actors, restricted := allowed[t.To]
+			if !restricted {
+				return nil
+			}
+			for _, a := range actors {
+				if a == actor {
+					return nil
+				}
+			}
+			return fmt.Errorf("%q may not apply %s", actor, t.To)
+		})
+	}
+	if len(checks) > 0 {
+		h.Authorize = func(actor string, t cindy.Transition, prior []cindy.Transition) error {
+			for _, check := range checks {
+				if err := check(actor, t, prior); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	var rejections []cindy.RefRejection
+	var err error
+	if kind == "pre-receive" {
+		var updates []cindy.RefUpdate
+		if updates, err = cindy.ParseRefUpdates(stdin); err == nil {
+			rejections, err = h.PreReceive(updates)
+		}
+	} else {
+		if fs.NArg() != 3 {
+			fmt.Fprint(stderr, usage)
+			return 2
+		}
+		rejections, err = h.Update(cindy.RefUpdate{Ref: fs.Arg(0), Old: fs.Arg(1), New: fs.Arg(2)})
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+	for _, r := range rejections {
+		fmt.Fprintf(stderr, "cindy: rejected %s\n", r)
+	}
+	if len(rejections) > 0 {
+		return 1
+	}
+	return 0
+}
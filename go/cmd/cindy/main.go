@@ -0,0 +1,61 @@
+// Command cindy is the command-line interface to the Cindy protocol tools.
+//
+// Usage:
+//
+//	cindy policy test [-pipeline file] policy.json [manifest.json ...]
+//	cindy policy vars
+//	cindy serve [-addr host:port] [-repo dir] [-pipeline file] [-reviews dir] [-permissions file] [-tokens file] [-keys file] [-require-signed label] [-webhook url] [-openapi]
+//	cindy hook pre-receive [-repo dir] [-pipeline file] [-permissions file] [-keys file] [-require-signed label] [-allow label=actor,...]
+//	cindy hook update [-repo dir] [-pipeline file] [-permissions file] [-keys file] [-require-signed label] [-allow label=actor,...] ref old new
+//	cindy keygen -id id -actor actor -out file
+//	cindy verify [-repo dir] [-pipeline file] -keys file [-label label] [-json]
+//	cindy report [-repo dir] [-pipeline file] [-since date] [-until date] [-format markdown|json|csv]
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage:
+  cindy policy test [-pipeline file] policy.json [manifest.json ...]
+  cindy policy vars
+  cindy serve [-addr host:port] [-repo dir] [-pipeline file] [-reviews dir] [-permissions file] [-tokens file] [-keys file] [-require-signed label] [-webhook url] [-openapi]
+  cindy hook pre-receive [-repo dir] [-pipeline file] [-permissions file] [-keys file] [-require-signed label] [-allow label=actor,...]
+  cindy hook update [-repo dir] [-pipeline file] [-permissions file] [-keys file] [-require-signed label] [-allow label=actor,...] ref old new
+  cindy keygen -id id -actor actor -out file
+  cindy verify [-repo dir] [-pipeline file] -keys file [-label label] [-json]
+  cindy report [-repo dir] [-pipeline file] [-since date] [-until date] [-format markdown|json|csv]
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	switch args[0] {
+	case "policy":
+		return runPolicy(args[1:], stdout, stderr)
+	case "serve":
+		return runServe(args[1:], stdout, stderr)
+	case "hook":
+		return runHook(args[1:], stdout, stderr)
+	case "keygen":
+		return runKeygen(args[1:], stdout, stderr)
+	case "verify":
+		return runVerify(args[1:], stdout, stderr)
+	case "report":
+		return runReport(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+	fmt.Fprintf(stderr, "cindy: unknown command %q\n%s", args[0], usage)
+	return 2
+}
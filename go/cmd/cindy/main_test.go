@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cindy "github.com/nimsforest/cindy/go"
+)
+
+// TestMain lets tests run the test binary as the cindy command, for git
+// hooks to call.
+func TestMain(m *testing.M) {
+	if os.Getenv("CINDY_TEST_MAIN") == "1" {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+func TestPolicyTest(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"policy", "test", "../../../examples/policy.json", "../../../examples/manifest-extension.json"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "ok   manifest-field-removal.json: cindy:rejected (schema-safety)") {
+		t.Errorf("unexpected output:\n%s", stdout.String())
+	}
+}
+
+func TestPolicyTest_Failure(t *testing.T) {
+	dir := t.TempDir()
+	manifest, _ := filepath.Abs("../../../examples/manifest-extension.json")
+	policy := `{
+		"name": "strict",
+		"rules": [],
+		"default": {"then": "cindy:human-review"},
+		"tests": [{"manifest": "` + manifest + `", "expect": "cindy:approved"}]
+	}`
+	path := filepath.Join(dir, "policy.json")
+	if err := os.WriteFile(path, []byte(policy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"policy", "test", path}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit 1, got %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "got cindy:human-review (default)") {
+		t.Errorf("unexpected output:\n%s", stdout.String())
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"frobnicate"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit 2, got %d", code)
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"serve", "-openapi"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	var doc struct {
+		OpenAPI string         `json:"openapi"`
+		Paths   map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if doc.OpenAPI != "3.1.0" || doc.Paths["/v1/labels/{branch}"] == nil {
+		t.Errorf("unexpected document: %s", stdout.String())
+	}
+}
+
+func TestHookPreReceive(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	server, work := filepath.Join(dir, "server.git"), filepath.Join(dir, "work")
+	git := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	git("init", "-q", "--bare", server)
+	git("clone", "-q", server, work)
+	git("-C", work, "-c", "user.name=agent", "-c", "user.email=agent@example.com", "commit", "-q", "--allow-empty", "-m", "init")
+	git("-C", work, "push", "-q", "origin", "HEAD")
+	script := "#!/bin/sh\nCINDY_TEST_MAIN=1 CINDY_PUSHER=agent exec '" + exe + "' hook pre-receive -allow cindy:deployed=deployer\n"
+	if err := os.WriteFile(filepath.Join(server, "hooks", "pre-receive"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	gl, err := cindy.NewGitLabeler(work, cindy.WithPushPolicy(cindy.PushRequired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []cindy.Label{cindy.Ready, cindy.Analyzing, cindy.Approved, cindy.Deploying} {
+		if err := gl.SetLabelWithMetadata("feature/x", l, cindy.Metadata{Actor: "agent"}); err != nil {
+			t.Fatalf("%s: %v", l, err)
+		}
+	}
+	err = gl.SetLabelWithMetadata("feature/x", cindy.Deployed, cindy.Metadata{Actor: "agent"})
+	var pe *cindy.PushError
+	if !errors.As(err, &pe) || !strings.Contains(err.Error(), `"agent" may not apply cindy:deployed`) {
+		t.Errorf("expected the hook to reject the push, got %v", err)
+	}
+}
+
+func TestKeygenVerify(t *testing.T) {
+	dir := t.TempDir()
+	seedPath, ringPath := filepath.Join(dir, "bot.key"), filepath.Join(dir, "keys.json")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"keygen", "-id", "bot-1", "-actor", "deploy-bot", "-out", seedPath}, &stdout, &stderr); code != 0 {
+		t.Fatalf("keygen exit %d: %s", code, stderr.String())
+	}
+	ring := `{"keys": [` + strings.TrimSpace(stdout.String()) + `]}`
+	if err := os.WriteFile(ringPath, []byte(ring), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	seed, err := os.ReadFile(seedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := cindy.ParseSigner("bot-1", strings.TrimSpace(string(seed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=agent", "-c", "user.email=agent@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	gl, err := cindy.NewGitLabeler(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := cindy.TransitionRequest{Branch: "feature/a", To: cindy.Ready, Metadata: cindy.Metadata{Actor: "deploy-bot"}}
+	signer.SignRequest(&req, "")
+	if err := gl.SetLabelWithMetadata("feature/a", cindy.Ready, req.Metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"verify", "-repo", repo, "-keys", ringPath}, &stdout, &stderr); code != 0 {
+		t.Fatalf("verify exit %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "signed by deploy-bot (bot-1)") {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+
+	if err := gl.SetLabelWithMetadata("feature/b", cindy.Ready, cindy.Metadata{Actor: "deploy-bot"}); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if code := run([]string{"verify", "-repo", repo, "-keys", ringPath}, &stdout, &stderr); code != 1 {
+		t.Errorf("verify with an unsigned label: exit %d, want 1", code)
+	}
+	if !strings.Contains(stdout.String(), "1 of 2 labels not validly signed") {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+}
+
+func TestReport(t *testing.T) {
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=agent", "-c", "user.email=agent@example.com"}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "init")
+	git("checkout", "-q", "-b", "feature/a")
+	if err := os.MkdirAll(filepath.Join(repo, ".cindy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, cindy.ManifestPath), []byte(`{"revision": 1, "subjects_affected": ["orders"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "manifest")
+
+	gl, err := cindy.NewGitLabeler(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	for i, l := range []cindy.Label{cindy.Ready, cindy.Analyzing, cindy.Approved, cindy.Deploying, cindy.Deployed} {
+		if err := gl.SetLabelWithMetadata("feature/a", l, cindy.Metadata{Actor: "agent-7", Timestamp: start.Add(time.Duration(i) * time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"report", "-repo", repo, "-since", "2026-06-01", "-until", "2026-06-02", "-format", "csv"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	for _, want := range []string{
+		"total,,1,1,14400,14400,0,0.0000,0,1,0,0.0000,0,,\n",
+		"actor,agent-7,1,1,14400,",
+		"subject,orders,1,1,14400,",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, stdout.String())
+		}
+	}
+
+	stdout.Reset()
+	if code := run([]string{"report", "-repo", repo, "-until", "2026-06-02"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "# Pipeline report\n\n2026-05-03 – 2026-06-02") {
+		t.Errorf("unexpected Markdown:\n%s", stdout.String())
+	}
+	if code := run([]string{"report", "-repo", repo, "-label", "shipped=cindy:deployed"}, &stdout, &stderr); code != 2 {
+		t.Errorf("unknown role: exit %d, want 2", code)
+	}
+
+	// A branch with an invalid manifest is counted by actor only.
+	git("checkout", "-q", "-b", "feature/bad")
+	if err := os.WriteFile(filepath.Join(repo, cindy.ManifestPath), []byte(`{"revision": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("commit", "-q", "-am", "broken manifest")
+	if err := gl.SetLabelWithMetadata("feature/bad", cindy.Ready, cindy.Metadata{Actor: "agent-8", Timestamp: start}); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"report", "-repo", repo, "-since", "2026-06-01", "-until", "2026-06-02", "-format", "csv"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "actor,agent-8,1,") || !strings.Contains(stderr.String(), "skipping the manifest of feature/bad") {
+		t.Errorf("unexpected report:\n%s\nstderr: %s", stdout.String(), stderr.String())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	cindy "github.com/nimsforest/cindy/go"
+)
+
+func runPolicy(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	switch args[0] {
+	case "test":
+		return runPolicyTest(args[1:], stdout, stderr)
+	case "vars":
+		vars := cindy.PolicyVariables()
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stdout, "%-32s %s\n", name, vars[name])
+		}
+		return 0
+	}
+	fmt.Fprintf(stderr, "cindy policy: unknown command %q\n%s", args[0], usage)
+	return 2
+}
+
+// runPolicyTest runs the tests declared in a policy file, then evaluates the
+// policy against any further manifests given on the command line.
+func runPolicyTest(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cindy policy test", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	pipelinePath := fs.String("pipeline", "", "pipeline definition (default: the built-in pipeline)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	var pipeline *cindy.Pipeline
+	if *pipelinePath != "" {
+		p, err := cindy.LoadPipeline(*pipelinePath)
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: %v\n", err)
+			return 1
+		}
+		pipeline = p
+	}
+	policyPath := fs.Arg(0)
+	policy, err := cindy.LoadPolicy(policyPath, pipeline)
+	if err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	for _, r := range policy.RunPolicyTests(filepath.Dir(policyPath), nil) {
+		switch {
+		case r.Err != nil:
+			failed++
+			fmt.Fprintf(stdout, "FAIL %s: %v\n", r.Manifest, r.Err)
+		case !r.Passed():
+			failed++
+			fmt.Fprintf(stdout, "FAIL %s: got %s, want %s\n", r.Manifest, r.Decision, r.Expect)
+		default:
+			fmt.Fprintf(stdout, "ok   %s: %s\n", r.Manifest, r.Decision)
+		}
+	}
+	for _, path := range fs.Args()[1:] {
+		d, err := policy.EvaluateManifestFile(path, nil)
+		if err != nil {
+			failed++
+			fmt.Fprintf(stdout, "FAIL %s: %v\n", path, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "     %s: %s\n", path, d)
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(stdout, "%d failed\n", failed)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	cindy "github.com/nimsforest/cindy/go"
+)
+
+// runReport summarizes the transition history of a repository over a
+// window of time.
+func runReport(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cindy report", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	repo := fs.String("repo", ".", "git repository holding the labels")
+	pipelinePath := fs.String("pipeline", "", "pipeline definition (default: the built-in pipeline)")
+	sinceFlag := fs.String("since", "", "start of the window, as a date or RFC 3339 time (default: 30 days before -until)")
+	untilFlag := fs.String("until", "", "end of the window, excluded (default: now)")
+	format := fs.String("format", "markdown", "output format: markdown, json or csv")
+	roles := make(map[string]cindy.Label)
+	fs.Func("label", "role=label: the pipeline label playing a role the report counts, e.g. deployed=cindy:live (repeatable; roles: ready, analyzing, human_review, approved, rejected, deployed, rollback)", func(v string) error {
+		role, label, ok := strings.Cut(v, "=")
+		if !ok {
+			return errors.New("want role=label")
+		}
+		roles[role] = cindy.Label(label)
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "markdown" && *format != "json" && *format != "csv" {
+		fmt.Fprintf(stderr, "cindy report: unknown format %q\n", *format)
+		return 2
+	}
+
+	until, since := time.Now().UTC(), time.Time{}
+	var err error
+	if *untilFlag != "" {
+		if until, err = parseTime(*untilFlag); err != nil {
+			fmt.Fprintf(stderr, "cindy report: -until: %v\n", err)
+			return 2
+		}
+	}
+	since = until.AddDate(0, 0, -30)
+	if *sinceFlag != "" {
+		if since, err = parseTime(*sinceFlag); err != nil {
+			fmt.Fprintf(stderr, "cindy report: -since: %v\n", err)
+			return 2
+		}
+	}
+
+	var labels cindy.ReportLabels
+	if err := decodeRoles(roles, &labels); err != nil {
+		fmt.Fprintf(stderr, "cindy report: -label: %v\n", err)
+		return 2
+	}
+
+	pipeline := cindy.DefaultPipeline()
+	if *pipelinePath != "" {
+		p, err := cindy.LoadPipeline(*pipelinePath)
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: %v\n", err)
+			return 1
+		}
+		pipeline = p
+	}
+	gl, err := cindy.NewGitLabeler(*repo, cindy.WithPipeline(pipeline))
+	if err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+	manifests, err := branchManifests(gl, *repo, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+	report, err := cindy.BuildReport(gl, cindy.ReportOptions{Since: since, Until: until, Manifests: manifests, Labels: labels})
+	if err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	case "csv":
+		io.WriteString(stdout, report.CSV())
+	default:
+		io.WriteString(stdout, report.Markdown())
+	}
+	return 0
+}
+
+// branchManifests reads the manifest of every labeled or archived branch
+// that still exists and has one. Branches whose manifest cannot be read are
+// left out, with a warning on stderr, and so only counted by actor.
+func branchManifests(gl *cindy.GitLabeler, repo string, stderr io.Writer) (map[string]*cindy.Manifest, error) {
+	existing, err := gl.Branches()
+	if err != nil {
+		return nil, err
+	}
+	labels, err := gl.AllLabels()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := gl.ArchivedLabels()
+	if err != nil {
+		return nil, err
+	}
+	manifests := make(map[string]*cindy.Manifest)
+	for _, set := range []map[string]cindy.Label{labels, archived} {
+		for branch := range set {
+			if !existing[branch] || manifests[branch] != nil {
+				continue
+			}
+			m, err := cindy.LoadBranchManifest(repo, branch)
+			if errors.Is(err, cindy.ErrNoManifest) {
+				continue
+			}
+			if err != nil {
+				fmt.Fprintf(stderr, "cindy: skipping the manifest of %s: %v\n", branch, err)
+				continue
+			}
+			manifests[branch] = m
+		}
+	}
+	return manifests, nil
+}
+
+// decodeRoles sets the fields of labels named by the keys of roles.
+func decodeRoles(roles map[string]cindy.Label, labels *cindy.ReportLabels) error {
+	data, _ := json.Marshal(roles)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(labels)
+}
+
+// parseTime accepts an RFC 3339 time or a date, taken as midnight UTC.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cindy "github.com/nimsforest/cindy/go"
+	"github.com/nimsforest/cindy/go/metrics"
+)
+
+// runServe serves the HTTP API over the labels of a git repository.
+func runServe(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cindy serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	repo := fs.String("repo", ".", "git repository holding the branches and labels")
+	pipelinePath := fs.String("pipeline", "", "pipeline definition (default: the built-in pipeline)")
+	reviewsDir := fs.String("reviews", "", "directory reviews are stored in (default: cindy/reviews in the git directory)")
+	printSpec := fs.Bool("openapi", false, "print the OpenAPI description and exit")
+	poll := fs.Duration("poll", 2*time.Second, "how often to check for label changes to publish as events")
+	deadLetters := fs.String("dead-letters", "", "file undeliverable webhook events are appended to (default: cindy/dead-letters.jsonl in the git directory)")
+	permsPath := fs.String("permissions", "", "actors and the transitions they may apply (default: anyone may apply any transition)")
+	tokensPath := fs.String("tokens", "", "JSON object mapping each actor to the SHA-256 hex digest of their bearer token; label changes and reviews then require a token")
+	keysPath := fs.String("keys", "", "key ring of trusted signers, for /v1/verify and -require-signed")
+	var requireSigned []cindy.Label
+	fs.Func("require-signed", "refuse unsigned transitions into this label (repeatable; needs -keys)", func(l string) error {
+		requireSigned = append(requireSigned, cindy.Label(l))
+		return nil
+	})
+	pushRemote := fs.String("push-remote", "", "remote to fetch pushed branches from before reading their manifests (default: read from -repo)")
+	insecurePush := fs.Bool("insecure-push", false, "receive pushes at /v1/push without $CINDY_PUSH_SECRET, trusting any caller and the pusher it names")
+	var webhooks []cindy.Webhook
+	fs.Func("webhook", "URL to POST label change events to, signed with $CINDY_WEBHOOK_SECRET if set (repeatable)", func(url string) error {
+		webhooks = append(webhooks, cindy.Webhook{URL: url, Secret: os.Getenv("CINDY_WEBHOOK_SECRET")})
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	pipeline := cindy.DefaultPipeline()
+	if *pipelinePath != "" {
+		p, err := cindy.LoadPipeline(*pipelinePath)
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: %v\n", err)
+			return 1
+		}
+		pipeline = p
+	}
+	if *printSpec {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(cindy.OpenAPI(pipeline))
+		return 0
+	}
+
+	m := metrics.New()
+	srv, gitDir, err := newServer(*repo, pipeline, *reviewsDir, m)
+	if err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+	srv.Metrics = m
+	srv.Engine.OnReject(m.Rejected)
+	if *permsPath != "" {
+		perms, err := cindy.LoadPermissions(*permsPath, pipeline)
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: %v\n", err)
+			return 1
+		}
+		srv.Engine.SetPermissions(perms)
+	}
+	if *tokensPath != "" {
+		var digests map[string]string
+		data, err := os.ReadFile(*tokensPath)
+		if err == nil {
+			err = json.Unmarshal(data, &digests)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: reading tokens: %v\n", err)
+			return 1
+		}
+		srv.Authenticate = cindy.BearerTokens(digests)
+	}
+	if *keysPath != "" {
+		keys, err := cindy.LoadKeyRing(*keysPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: %v\n", err)
+			return 1
+		}
+		srv.Keys = keys
+		for _, l := range requireSigned {
+			srv.Engine.AddGuard(cindy.AnyLabel, l, "signed", cindy.RequireSignature(keys))
+		}
+	} else if len(requireSigned) > 0 {
+		fmt.Fprintln(stderr, "cindy: -require-signed needs -keys")
+		return 2
+	}
+	if *deadLetters == "" {
+		*deadLetters = filepath.Join(gitDir, "cindy", "dead-letters.jsonl")
+	}
+	srv.Events = cindy.NewDispatcher(cindy.DispatcherOptions{
+		Webhooks:    webhooks,
+		DeadLetters: &cindy.FileDeadLetterLog{Path: *deadLetters},
+	})
+	// Without a secret anyone could submit branches as any pusher, so
+	// /v1/push stays unmounted unless that is asked for.
+	if secret := os.Getenv("CINDY_PUSH_SECRET"); secret != "" || *insecurePush {
+		srv.Push = &cindy.PushReceiver{
+			Engine:  srv.Engine,
+			Repo:    *repo,
+			Remote:  *pushRemote,
+			Secret:  secret,
+			Reviews: srv.Reviews,
+		}
+	} else {
+		fmt.Fprintln(stderr, "cindy: $CINDY_PUSH_SECRET is not set; not receiving pushes at /v1/push (see -insecure-push)")
+	}
+	// Labels may also change through git directly, so events and metrics
+	// come from watching the labels rather than from the server's own
+	// transitions.
+	go cindy.WatchLabels(context.Background(), srv.Engine.Labeler(), *poll, func(t cindy.Transition) {
+		srv.Events.Publish(t)
+		m.Observe(t)
+	}, func(err error) {
+		fmt.Fprintf(stderr, "cindy: watching labels: %v\n", err)
+	})
+	fmt.Fprintf(stderr, "cindy: serving %s on http://%s\n", *repo, *addr)
+	if err := http.ListenAndServe(*addr, srv); err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// newServer builds a Server over the git repository at repo, timing its
+// labeler calls in m, and returns it with the repository's git directory.
+func newServer(repo string, pipeline *cindy.Pipeline, reviewsDir string, m *metrics.Metrics) (*cindy.Server, string, error) {
+	gl, err := cindy.NewGitLabeler(repo, cindy.WithPipeline(pipeline))
+	if err != nil {
+		return nil, "", err
+	}
+	out, err := exec.Command("git", "-C", repo, "rev-parse", "--path-format=absolute", "--git-common-dir").Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("locating git directory: %w", err)
+	}
+	gitDir := strings.TrimSpace(string(out))
+	if reviewsDir == "" {
+		reviewsDir = filepath.Join(gitDir, "cindy", "reviews")
+	}
+	reviews, err := cindy.NewFileReviewStore(reviewsDir)
+	if err != nil {
+		return nil, "", err
+	}
+	e := cindy.NewEngine(m.Instrument(gl), nil)
+	e.SetReviewStore(reviews)
+	return &cindy.Server{Engine: e, Repo: repo, Reviews: reviews}, gitDir, nil
+}
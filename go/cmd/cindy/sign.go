@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	cindy "github.com/nimsforest/cindy/go"
+)
+
+// runKeygen creates a signing key, writing its seed to a file and printing
+// the key ring entry for it.
+func runKeygen(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cindy keygen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	id := fs.String("id", "", "key ID")
+	actor := fs.String("actor", "", "actor the key signs for")
+	out := fs.String("out", "", "file to write the private key seed to")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *id == "" || *actor == "" || *out == "" {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	signer, err := cindy.GenerateSigner(*id)
+	if err == nil {
+		err = os.WriteFile(*out, []byte(signer.Seed()+"\n"), 0o600)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+	enc := json.NewEncoder(stdout)
+	enc.Encode(cindy.TrustedKey{ID: *id, Actor: *actor, PublicKey: signer.PublicKey()})
+	return 0
+}
+
+// runVerify reports the signature behind each branch's current label and
+// fails if any is not valid.
+func runVerify(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cindy verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	repo := fs.String("repo", ".", "git repository holding the labels")
+	pipelinePath := fs.String("pipeline", "", "pipeline definition (default: the built-in pipeline)")
+	keysPath := fs.String("keys", "", "key ring of trusted signers")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	var labels []cindy.Label
+	fs.Func("label", "only check branches carrying this label (repeatable)", func(l string) error {
+		labels = append(labels, cindy.Label(l))
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *keysPath == "" {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	pipeline := cindy.DefaultPipeline()
+	if *pipelinePath != "" {
+		p, err := cindy.LoadPipeline(*pipelinePath)
+		if err != nil {
+			fmt.Fprintf(stderr, "cindy: %v\n", err)
+			return 1
+		}
+		pipeline = p
+	}
+	keys, err := cindy.LoadKeyRing(*keysPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+	gl, err := cindy.NewGitLabeler(*repo, cindy.WithPipeline(pipeline))
+	if err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+	results, err := cindy.VerifyLabels(gl, keys, labels...)
+	if err != nil {
+		fmt.Fprintf(stderr, "cindy: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(cindy.VerificationReport{Labels: append([]cindy.LabelVerification{}, results...)})
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Status != cindy.SignatureValid {
+			failed++
+		}
+		if *asJSON {
+			continue
+		}
+		if r.Status == cindy.SignatureValid {
+			fmt.Fprintf(stdout, "%-9s %s %s: signed by %s (%s)\n", r.Status, r.Branch, r.Label, r.Signer, r.KeyID)
+		} else {
+			fmt.Fprintf(stdout, "%-9s %s %s: %s\n", r.Status, r.Branch, r.Label, r.Reason)
+		}
+	}
+	if failed > 0 {
+		if !*asJSON {
+			fmt.Fprintf(stdout, "%d of %d labels not validly signed\n", failed, len(results))
+		}
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,276 @@
+package cindy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoDeployment is returned by Deployer.Status for branches it has not deployed.
+var ErrNoDeployment = errors.New("no deployment")
+
+// DeployState is the state of a deployment or rollback.
+type DeployState string
+
+const (
+	DeployRunning   DeployState = "running"
+	DeploySucceeded DeployState = "succeeded"
+	DeployFailed    DeployState = "failed"
+)
+
+// DeployProgress is a progress report from a running deployment or rollback.
+type DeployProgress struct {
+	Branch  string
+	Action  string // "deploy" or "rollback"
+	Message string
+	Time    time.Time
+}
+
+// DeployStatus describes a branch's last deployment or rollback.
+type DeployStatus struct {
+	Branch   string
+	Action   string
+	State    DeployState
+	ExitCode int
+	Log      string
+	Started  time.Time
+	Finished time.Time
+	Err      error
+}
+
+// Deployer carries out the cindy:deploying step. Implementations report
+// progress through progress, which may be nil, and must honor ctx
+// cancellation.
+type Deployer interface {
+	// Deploy deploys branch and returns once it is live or has failed.
+	Deploy(ctx context.Context, branch string, progress func(DeployProgress)) error
+	// Status returns the branch's last deployment or rollback.
+	Status(ctx context.Context, branch string) (*DeployStatus, error)
+	// Rollback restores the state from before the branch was deployed.
+	Rollback(ctx context.Context, branch string, progress func(DeployProgress)) error
+}
+
+// DeployCommands are the shell commands a CommandDeployer runs.
+type DeployCommands struct {
+	Deploy   string `json:"deploy"`
+	Rollback string `json:"rollback"`
+}
+
+// CommandDeployer is a Deployer that runs shell commands, standing in for a
+// real deployment system when testing the lifecycle locally. Commands run
+// with `sh -c` in Dir with CINDY_BRANCH and CINDY_ACTION set; their combined
+// output is reported line by line as progress and kept in the status log.
+// A non-zero exit code fails the deployment.
+type CommandDeployer struct {
+	// Dir is the working directory of the commands.
+	Dir string
+	// Env is added to the environment of the commands.
+	Env []string
+	// Commands are used for branches not matched by Branches.
+	Commands DeployCommands
+	// Branches overrides Commands for branches matching a key, which is a
+	// branch name or a path.Match pattern such as "hotfix/*".
+	Branches map[string]DeployCommands
+
+	mu     sync.Mutex
+	status map[string]*DeployStatus
+}
+
+// Deploy runs the branch's deploy command.
+func (d *CommandDeployer) Deploy(ctx context.Context, branch string, progress func(DeployProgress)) error {
+	return d.run(ctx, branch, "deploy", d.commandsFor(branch).Deploy, progress)
+}
+
+// Rollback runs the branch's rollback command. Branches without one succeed
+// without running anything.
+func (d *CommandDeployer) Rollback(ctx context.Context, branch string, progress func(DeployProgress)) error {
+	return d.run(ctx, branch, "rollback", d.commandsFor(branch).Rollback, progress)
+}
+
+// Status returns the branch's last deployment or rollback.
+func (d *CommandDeployer) Status(ctx context.Context, branch string) (*DeployStatus, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.status[branch]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", ErrNoDeployment, branch)
+	}
+	out := *s
+	return &out, nil
+}
+
+func (d *CommandDeployer) commandsFor(branch string) DeployCommands {
+	if c, ok := d.Branches[branch]; ok {
+		return c
+	}
+	for pattern, c := range d.Branches {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return c
+		}
+	}
+	return d.Commands
+}
+
+func (d *CommandDeployer) run(ctx context.Context, branch, action, command string, progress func(DeployProgress)) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+	status := &DeployStatus{Branch: branch, Action: action, State: DeployRunning, Started: time.Now()}
+	d.setStatus(status)
+
+	var log bytes.Buffer
+	exitCode := 0
+	var err error
+	if command == "" {
+		if action == "deploy" {
+			err = errors.New("no deploy command configured")
+		}
+	} else {
+		out := &lineWriter{fn: func(line string) {
+			log.WriteString(line + "\n")
+			if progress != nil {
+				progress(DeployProgress{Branch: branch, Action: action, Message: line, Time: time.Now()})
+			}
+		}}
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Dir = d.Dir
+		cmd.Env = append(append(os.Environ(), d.Env...), "CINDY_BRANCH="+branch, "CINDY_ACTION="+action)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		// Children of the shell may outlive it on cancellation while holding
+		// the output open; don't wait for them.
+		cmd.WaitDelay = time.Second
+		err = cmd.Run()
+		out.flush()
+		if err != nil {
+			err = fmt.Errorf("%s %s: %w", action, branch, err)
+		}
+		if cmd.ProcessState != nil {
+			exitCode = cmd.ProcessState.ExitCode()
+		}
+	}
+
+	done := *status
+	done.ExitCode = exitCode
+	done.Log = log.String()
+	done.Finished = time.Now()
+	done.State = DeploySucceeded
+	if err != nil {
+		done.State = DeployFailed
+		done.Err = err
+	}
+	d.setStatus(&done)
+	return err
+}
+
+func (d *CommandDeployer) setStatus(s *DeployStatus) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.status == nil {
+		d.status = make(map[string]*DeployStatus)
+	}
+	d.status[s.Branch] = s
+}
+
+// lineWriter calls fn for every complete line written to it. The output of
+// a command's stdout and stderr may interleave, so writes are serialized.
+type lineWriter struct {
+	mu  sync.Mutex
+	buf []byte
+	fn  func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.fn(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// DeployOptions configures RegisterDeployer.
+type DeployOptions struct {
+	// Context bounds deployments and rollbacks. Defaults to context.Background().
+	Context context.Context
+	// Timeout, if set, limits each deployment and rollback.
+	Timeout time.Duration
+	// Progress receives progress reports. May be nil.
+	Progress func(DeployProgress)
+	// Actor is recorded in the metadata of the transitions the deployer
+	// applies. Defaults to "cindy-deployer".
+	Actor string
+	// Signer, if set, signs those transitions, so they pass
+	// RequireSignature. Its key must belong to Actor.
+	Signer *Signer
+}
+
+// RegisterDeployer wires d into e:
+//   - a branch entering cindy:deploying is deployed, then moved to
+//     cindy:deployed on success or cindy:rollback on failure, with the
+//     failure as the rollback cause
+//   - a branch entering cindy:rollback is rolled back with d.Rollback
+//
+// Deployments run inside ApplyTransition, which returns once the branch has
+// left cindy:deploying; a failed deployment is reported as a *HookError.
+// Callers that must not block run it in a goroutine.
+func RegisterDeployer(e *Engine, d Deployer, opts DeployOptions) {
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+	if opts.Actor == "" {
+		opts.Actor = "cindy-deployer"
+	}
+	withTimeout := func() (context.Context, context.CancelFunc) {
+		if opts.Timeout > 0 {
+			return context.WithTimeout(opts.Context, opts.Timeout)
+		}
+		return context.WithCancel(opts.Context)
+	}
+
+	e.AddHook(AnyLabel, Deploying, "deploy", func(tc *TransitionContext) error {
+		ctx, cancel := withTimeout()
+		err := d.Deploy(ctx, tc.Branch, opts.Progress)
+		cancel()
+
+		req := TransitionRequest{Branch: tc.Branch, To: Deployed, Manifest: tc.Manifest, Reviews: tc.Reviews}
+		req.Metadata = Metadata{Actor: opts.Actor, Reason: "deployed"}
+		if err != nil {
+			req.To = Rollback
+			req.Metadata.Reason = "deploy failed: " + err.Error()
+		}
+		if opts.Signer != nil {
+			opts.Signer.SignRequest(&req, Deploying)
+		}
+		_, terr := e.ApplyTransition(req)
+		return errors.Join(err, terr)
+	})
+
+	e.AddHook(AnyLabel, Rollback, "rollback", func(tc *TransitionContext) error {
+		ctx, cancel := withTimeout()
+		defer cancel()
+		return d.Rollback(ctx, tc.Branch, opts.Progress)
+	})
+}
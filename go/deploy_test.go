@@ -0,0 +1,139 @@
+package cindy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func approvedBranch(t *testing.T, branch string) (*MemoryLabeler, *Engine) {
+	t.Helper()
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	for _, to := range []Label{Ready, Analyzing, Approved} {
+		if _, err := e.ApplyTransition(TransitionRequest{Branch: branch, To: to}); err != nil {
+			t.Fatalf("→ %s: %v", to, err)
+		}
+	}
+	return ml, e
+}
+
+func TestCommandDeployer(t *testing.T) {
+	dir := t.TempDir()
+	d := &CommandDeployer{
+		Dir:      dir,
+		Env:      []string{"TARGET=staging"},
+		Commands: DeployCommands{Deploy: `echo "deploying $CINDY_BRANCH to $TARGET"; echo done >&2`},
+		Branches: map[string]DeployCommands{"hotfix/*": {Deploy: "echo hotfix; exit 3"}},
+	}
+
+	var lines []string
+	err := d.Deploy(context.Background(), "feature/x", func(p DeployProgress) { lines = append(lines, p.Message) })
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if strings.Join(lines, "|") != "deploying feature/x to staging|done" {
+		t.Errorf("unexpected progress %q", lines)
+	}
+	s, err := d.Status(context.Background(), "feature/x")
+	if err != nil || s.State != DeploySucceeded || s.ExitCode != 0 || s.Log != "deploying feature/x to staging\ndone\n" {
+		t.Errorf("unexpected status %+v, %v", s, err)
+	}
+
+	if err := d.Deploy(context.Background(), "hotfix/y", nil); err == nil {
+		t.Fatal("expected failing command to fail the deployment")
+	}
+	if s, _ := d.Status(context.Background(), "hotfix/y"); s.State != DeployFailed || s.ExitCode != 3 || s.Log != "hotfix\n" {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	if _, err := d.Status(context.Background(), "feature/none"); !errors.Is(err, ErrNoDeployment) {
+		t.Errorf("expected ErrNoDeployment, got %v", err)
+	}
+	if err := d.Rollback(context.Background(), "feature/x", nil); err != nil {
+		t.Errorf("rollback without a command should succeed, got %v", err)
+	}
+}
+
+func TestCommandDeployer_Cancel(t *testing.T) {
+	d := &CommandDeployer{Commands: DeployCommands{Deploy: "sleep 10"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := d.Deploy(ctx, "feature/x", nil); err == nil {
+		t.Fatal("expected cancelled deployment to fail")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("deployment was not cancelled")
+	}
+}
+
+func TestRegisterDeployer_Success(t *testing.T) {
+	ml, e := approvedBranch(t, "feature/x")
+	marker := filepath.Join(t.TempDir(), "deployed")
+	RegisterDeployer(e, &CommandDeployer{Commands: DeployCommands{Deploy: "touch " + marker}}, DeployOptions{})
+
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Deploying}); err != nil {
+		t.Fatalf("ApplyTransition: %v", err)
+	}
+	if label, _ := ml.GetLabel("feature/x"); label != Deployed {
+		t.Errorf("expected deployed, got %s", label)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("deploy command did not run: %v", err)
+	}
+	history, _ := ml.History("feature/x")
+	if last := history[len(history)-1]; last.Actor != "cindy-deployer" {
+		t.Errorf("unexpected actor %q", last.Actor)
+	}
+}
+
+func TestRegisterDeployer_Signed(t *testing.T) {
+	ml, e := approvedBranch(t, "feature/x")
+	bot := newTestSigner(t, "deployer-1")
+	ring := newTestKeyRing(t, TrustedKey{ID: "deployer-1", Actor: "cindy-deployer", PublicKey: bot.PublicKey()})
+	e.AddGuard(AnyLabel, Deployed, "signed", RequireSignature(ring))
+	e.AddGuard(AnyLabel, Rollback, "signed", RequireSignature(ring))
+	RegisterDeployer(e, &CommandDeployer{Commands: DeployCommands{Deploy: "true"}}, DeployOptions{Signer: bot})
+
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Deploying}); err != nil {
+		t.Fatalf("ApplyTransition: %v", err)
+	}
+	if label, _ := ml.GetLabel("feature/x"); label != Deployed {
+		t.Errorf("expected deployed, got %s", label)
+	}
+	results, _ := VerifyLabels(ml, ring)
+	if len(results) != 1 || results[0].Status != SignatureValid {
+		t.Errorf("deploy not validly signed: %+v", results)
+	}
+}
+
+func TestRegisterDeployer_Failure(t *testing.T) {
+	ml, e := approvedBranch(t, "feature/x")
+	marker := filepath.Join(t.TempDir(), "rolled-back")
+	d := &CommandDeployer{Commands: DeployCommands{
+		Deploy:   "echo migration failed >&2; exit 1",
+		Rollback: "echo $CINDY_ACTION > " + marker,
+	}}
+	RegisterDeployer(e, d, DeployOptions{})
+
+	tr, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Deploying})
+	var he *HookError
+	if tr == nil || !errors.As(err, &he) || he.Hook != "deploy" {
+		t.Fatalf("expected deploy hook error, got %v, %v", tr, err)
+	}
+	if label, _ := ml.GetLabel("feature/x"); label != Rollback {
+		t.Fatalf("expected rollback, got %s", label)
+	}
+	history, _ := ml.History("feature/x")
+	if cause := history[len(history)-1].Reason; !strings.Contains(cause, "deploy failed") {
+		t.Errorf("expected rollback cause recorded, got %q", cause)
+	}
+	if data, err := os.ReadFile(marker); err != nil || string(data) != "rollback\n" {
+		t.Errorf("rollback command did not run: %q, %v", data, err)
+	}
+}
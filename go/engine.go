@@ -0,0 +1,403 @@
+package cindy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// AnyLabel matches every label when registering guards and hooks.
+const AnyLabel Label = "*"
+
+// ErrInvalidTransition is wrapped by errors reporting a transition the pipeline does not allow.
+var ErrInvalidTransition = errors.New("invalid transition")
+
+// TransitionError reports a transition rejected by the pipeline's state machine.
+type TransitionError struct {
+	Branch string
+	From   Label
+	To     Label
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s for %s: %s → %s", ErrInvalidTransition, e.Branch, labelOrNone(e.From), e.To)
+}
+
+func (e *TransitionError) Unwrap() error { return ErrInvalidTransition }
+
+// GuardError reports a guard that refused a transition.
+type GuardError struct {
+	Guard string
+	From  Label
+	To    Label
+	Err   error
+}
+
+func (e *GuardError) Error() string {
+	return fmt.Sprintf("guard %s refused %s → %s: %v", e.Guard, labelOrNone(e.From), e.To, e.Err)
+}
+
+func (e *GuardError) Unwrap() error { return e.Err }
+
+// HookError reports a post-transition hook that failed. The transition itself
+// has already been applied when a HookError is returned.
+type HookError struct {
+	Hook string
+	Err  error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("hook %s: %v", e.Hook, e.Err)
+}
+
+func (e *HookError) Unwrap() error { return e.Err }
+
+// TransitionRequest asks an Engine to move a branch to a new label.
+type TransitionRequest struct {
+	Branch   string
+	To       Label
+	Metadata Metadata
+	// Manifest and Reviews give guards and hooks context about the change.
+	// Guards that need them fail when they are missing. A nil Reviews is
+	// loaded from the engine's ReviewStore, if it has one; a branch without
+	// reviews has an empty, non-nil Reviews.
+	Manifest *Manifest
+	Reviews  []Review
+	// Expect, if not nil, makes the transition conditional: it fails with a
+	// *ConflictError unless the branch carries *Expect ("" meaning
+	// unlabeled).
+	Expect *Label
+}
+
+// TransitionContext is what guards and hooks see of a transition.
+type TransitionContext struct {
+	TransitionRequest
+	From     Label
+	Labeler  Labeler
+	Pipeline *Pipeline
+}
+
+// Guard decides whether a transition may happen. A non-nil error refuses it.
+type Guard func(ctx *TransitionContext) error
+
+// Hook runs after a transition has been applied, e.g. to notify, merge or deploy.
+type Hook func(ctx *TransitionContext) error
+
+// RejectHook is told about a transition the engine refused, with the error
+// ApplyTransition returns. ctx.From is empty if the checks stopped before
+// the branch's label was read.
+type RejectHook func(ctx *TransitionContext, err error)
+
+type guardRule struct {
+	from, to Label
+	name     string
+	guard    Guard
+}
+
+type hookRule struct {
+	from, to Label
+	name     string
+	hook     Hook
+}
+
+// Engine applies transitions to a Labeler, enforcing the pipeline's state
+// machine, running pre-transition guards and post-transition hooks.
+// ApplyTransition is the single entry point; it is safe for concurrent use.
+type Engine struct {
+	labeler  Labeler
+	pipeline *Pipeline
+
+	mu      sync.RWMutex
+	named   map[string]Guard
+	guards  []guardRule
+	hooks   []hookRule
+	reject  []RejectHook
+	perms   *Permissions
+	reviews ReviewStore
+}
+
+// NewEngine creates an Engine that applies transitions to l. A nil pipeline
+// means the one l was configured with, or DefaultPipeline.
+//
+// The built-in guards "no-schema-violations", "no-blocking-reviews",
+// "rollback-cause", "rollback-review" and "rollback-resubmission" are
+// pre-registered for use in pipeline definitions.
+func NewEngine(l Labeler, p *Pipeline) *Engine {
+	if p == nil {
+		p = pipelineOf(l)
+	}
+	e := &Engine{
+		labeler:  l,
+		pipeline: p,
+		named:    make(map[string]Guard),
+	}
+	e.RegisterGuard("no-schema-violations", NoSchemaViolations())
+	e.RegisterGuard("no-blocking-reviews", NoBlockingReviews())
+	e.RegisterGuard("rollback-cause", RequireRollbackCause())
+	e.RegisterGuard("rollback-review", RequireRollbackReview())
+	e.RegisterGuard("rollback-resubmission", RequireRollbackResubmission())
+	return e
+}
+
+// Labeler returns the Labeler the engine applies transitions to.
+func (e *Engine) Labeler() Labeler {
+	return e.labeler
+}
+
+// Pipeline returns the pipeline the engine enforces.
+func (e *Engine) Pipeline() *Pipeline {
+	return e.pipeline
+}
+
+// RegisterGuard makes g available under name to the guards declared in the
+// pipeline definition. Registering an existing name replaces it.
+func (e *Engine) RegisterGuard(name string, g Guard) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.named[name] = g
+}
+
+// AddGuard runs g before every from → to transition. Either label may be AnyLabel.
+func (e *Engine) AddGuard(from, to Label, name string, g Guard) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.guards = append(e.guards, guardRule{from: from, to: to, name: name, guard: g})
+}
+
+// AddHook runs h after every applied from → to transition, in registration
+// order. Either label may be AnyLabel.
+func (e *Engine) AddHook(from, to Label, name string, h Hook) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.hooks = append(e.hooks, hookRule{from: from, to: to, name: name, hook: h})
+}
+
+// OnReject calls h, in registration order, for every transition the engine
+// refuses, e.g. to count rejections.
+func (e *Engine) OnReject(h RejectHook) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reject = append(e.reject, h)
+}
+
+// SetPermissions makes every transition subject to p: the metadata actor
+// must be allowed to apply it, and its identity is recorded in the
+// metadata. Rules with NotAuthor need a RecordingLabeler to know the
+// branch's authors. A nil p lifts the restriction.
+func (e *Engine) SetPermissions(p *Permissions) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.perms = p
+}
+
+// Permissions returns the Permissions set with SetPermissions, or nil.
+func (e *Engine) Permissions() *Permissions {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.perms
+}
+
+// SetReviewStore makes the engine load the reviews of requests that carry
+// none from s, so review guards see them. A nil s stops it.
+func (e *Engine) SetReviewStore(s ReviewStore) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reviews = s
+}
+
+// ApplyTransition moves req.Branch to req.To. It checks, in order:
+//   - the transition is allowed by the pipeline (unlabeled branches may only
+//     enter at cindy:ready)
+//   - the actor is allowed to apply it, if the engine has Permissions
+//   - every guard declared on the transition in the pipeline definition
+//   - every guard added with AddGuard that matches the transition
+//
+// All refusing guards are reported together. The label is then written only
+// if the branch still carries the label the checks saw, so a concurrent
+// change fails with a *ConflictError instead of being overwritten. Once the
+// label is applied,
+// matching hooks run; their failures are returned as *HookError alongside the
+// recorded transition. Refused transitions are reported to OnReject hooks.
+func (e *Engine) ApplyTransition(req TransitionRequest) (*Transition, error) {
+	ctx := &TransitionContext{TransitionRequest: req, Labeler: e.labeler, Pipeline: e.pipeline}
+	t, err := e.apply(ctx)
+	if t == nil {
+		e.mu.RLock()
+		reject := e.reject
+		e.mu.RUnlock()
+		for _, h := range reject {
+			h(ctx, err)
+		}
+	}
+	return t, err
+}
+
+func (e *Engine) apply(ctx *TransitionContext) (*Transition, error) {
+	req := &ctx.TransitionRequest
+	if err := ValidateBranchName(req.Branch); err != nil {
+		return nil, err
+	}
+	if err := e.pipeline.checkLabel(req.To); err != nil {
+		return nil, err
+	}
+	from, err := e.labeler.GetLabel(req.Branch)
+	if err != nil {
+		return nil, err
+	}
+	ctx.From = from
+	if req.Expect != nil && *req.Expect != from {
+		return nil, &ConflictError{Branch: req.Branch, Expected: *req.Expect, Actual: from}
+	}
+	if !(from == "" && req.To == e.pipeline.Entry()) && !e.pipeline.CanTransition(from, req.To) {
+		return nil, &TransitionError{Branch: req.Branch, From: from, To: req.To}
+	}
+	if err := e.authorize(req, from); err != nil {
+		return nil, err
+	}
+	req.Metadata = req.Metadata.stamp()
+	if err := e.loadReviews(req); err != nil {
+		return nil, err
+	}
+
+	e.mu.RLock()
+	var errs []error
+	for _, name := range e.pipeline.Guards(from, req.To) {
+		g, ok := e.named[name]
+		if !ok {
+			errs = append(errs, &GuardError{Guard: name, From: from, To: req.To, Err: errors.New("guard not registered")})
+			continue
+		}
+		if err := g(ctx); err != nil {
+			errs = append(errs, &GuardError{Guard: name, From: from, To: req.To, Err: err})
+		}
+	}
+	for _, r := range e.guards {
+		if matches(r.from, from) && matches(r.to, req.To) {
+			if err := r.guard(ctx); err != nil {
+				errs = append(errs, &GuardError{Guard: r.name, From: from, To: req.To, Err: err})
+			}
+		}
+	}
+	var hooks []hookRule
+	for _, r := range e.hooks {
+		if matches(r.from, from) && matches(r.to, req.To) {
+			hooks = append(hooks, r)
+		}
+	}
+	e.mu.RUnlock()
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := swapLabel(e.labeler, req.Branch, from, req.To, req.Metadata); err != nil {
+		return nil, err
+	}
+	t := &Transition{Branch: req.Branch, From: from, To: req.To, Metadata: req.Metadata}
+
+	for _, r := range hooks {
+		if err := r.hook(ctx); err != nil {
+			errs = append(errs, &HookError{Hook: r.name, Err: err})
+		}
+	}
+	return t, errors.Join(errs...)
+}
+
+// loadReviews fills in req.Reviews from the engine's ReviewStore.
+func (e *Engine) loadReviews(req *TransitionRequest) error {
+	e.mu.RLock()
+	store := e.reviews
+	e.mu.RUnlock()
+	if req.Reviews != nil || store == nil {
+		return nil
+	}
+	reviews, err := store.Reviews(req.Branch)
+	if err != nil {
+		return err
+	}
+	req.Reviews = append([]Review{}, reviews...)
+	return nil
+}
+
+// authorize checks req against the engine's permissions and records the
+// actor's identity in its metadata.
+func (e *Engine) authorize(req *TransitionRequest, from Label) error {
+	e.mu.RLock()
+	perms := e.perms
+	e.mu.RUnlock()
+	if perms == nil {
+		return nil
+	}
+	var authors []string
+	if rl, ok := e.labeler.(RecordingLabeler); ok {
+		history, err := rl.History(req.Branch)
+		if err != nil {
+			return err
+		}
+		authors = e.pipeline.Authors(history)
+	}
+	if err := perms.Check(req.Metadata.Actor, from, req.To, authors); err != nil {
+		return err
+	}
+	a, _ := perms.Actor(req.Metadata.Actor)
+	req.Metadata = req.Metadata.identify(a)
+	return nil
+}
+
+func matches(pattern, l Label) bool {
+	return pattern == AnyLabel || pattern == l
+}
+
+// SchemaViolationError reports the schema safety violations that made
+// NoSchemaViolations refuse a transition.
+type SchemaViolationError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaViolationError) Error() string {
+	return fmt.Sprintf("%d schema violation(s), first: %s", len(e.Violations), e.Violations[0])
+}
+
+// NoSchemaViolations refuses transitions for changes whose manifest breaks
+// the schema safety rules. It requires TransitionRequest.Manifest.
+func NoSchemaViolations() Guard {
+	return func(ctx *TransitionContext) error {
+		if ctx.Manifest == nil {
+			return errors.New("manifest required")
+		}
+		if v := ValidateSchemaChanges(ctx.Manifest); len(v) > 0 {
+			return &SchemaViolationError{Violations: v}
+		}
+		return nil
+	}
+}
+
+// NoBlockingReviews refuses transitions while any review in
+// TransitionRequest.Reviews is blocking. It requires TransitionRequest.Reviews.
+func NoBlockingReviews() Guard {
+	return func(ctx *TransitionContext) error {
+		if ctx.Reviews == nil {
+			return errors.New("reviews required")
+		}
+		for i := range ctx.Reviews {
+			if IsBlocking(&ctx.Reviews[i]) {
+				return fmt.Errorf("review %s by %s has unresolved requested changes", ctx.Reviews[i].ID, ctx.Reviews[i].Actor)
+			}
+		}
+		return nil
+	}
+}
+
+// RequireHumanActor refuses transitions whose metadata actor is not a human,
+// as decided by isHuman. Typical use is guarding HumanReview → Approved so
+// agents cannot approve their own escalated changes.
+func RequireHumanActor(isHuman func(actor string) bool) Guard {
+	return func(ctx *TransitionContext) error {
+		if ctx.Metadata.Actor == "" {
+			return errors.New("actor required")
+		}
+		if !isHuman(ctx.Metadata.Actor) {
+			return fmt.Errorf("actor %s is not a human", ctx.Metadata.Actor)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,218 @@
+package cindy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEngine_EnforcesStateMachine(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+
+	_, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Approved})
+	var te *TransitionError
+	if !errors.As(err, &te) || !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("expected TransitionError for unlabeled → approved, got %v", err)
+	}
+
+	tr, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready, Metadata: Metadata{Actor: "agent-1"}})
+	if err != nil {
+		t.Fatalf("ApplyTransition: %v", err)
+	}
+	if tr.From != "" || tr.To != Ready || tr.Actor != "agent-1" || tr.Timestamp.IsZero() {
+		t.Errorf("unexpected transition %+v", tr)
+	}
+
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Deployed}); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("expected ready → deployed to be refused, got %v", err)
+	}
+
+	history, _ := ml.History("feature/x")
+	if len(history) != 1 || history[0].Actor != "agent-1" {
+		t.Errorf("expected one recorded transition, got %v", history)
+	}
+}
+
+func TestEngine_Guards(t *testing.T) {
+	ml := NewMemoryLabeler()
+	ml.SetLabel("feature/x", Ready)
+	ml.SetLabel("feature/x", Analyzing)
+
+	e := NewEngine(ml, nil)
+	e.AddGuard(Analyzing, Approved, "no-schema-violations", NoSchemaViolations())
+	e.AddGuard(AnyLabel, Approved, "no-blocking-reviews", NoBlockingReviews())
+
+	bad := &Manifest{SchemaChanges: []SchemaChange{{Subject: "a.b", FieldsRemoved: []string{"x"}}}}
+	blocking := Review{ID: "r1", Actor: "bot", Verdict: RequestChanges, Comments: []ReviewComment{{ID: "c1"}}}
+
+	_, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Approved, Manifest: bad, Reviews: []Review{blocking}})
+	var ge *GuardError
+	if !errors.As(err, &ge) {
+		t.Fatalf("expected GuardError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "no-schema-violations") || !strings.Contains(err.Error(), "no-blocking-reviews") {
+		t.Errorf("expected both refusing guards reported, got %v", err)
+	}
+	if label, _ := ml.GetLabel("feature/x"); label != Analyzing {
+		t.Errorf("refused transition must not change the label, got %s", label)
+	}
+
+	// Guards on other transitions do not apply.
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: HumanReview}); err != nil {
+		t.Fatalf("expected analyzing → human-review to pass, got %v", err)
+	}
+}
+
+func TestEngine_RequireHumanActor(t *testing.T) {
+	ml := NewMemoryLabeler()
+	for _, l := range []Label{Ready, Analyzing, HumanReview} {
+		ml.SetLabel("feature/x", l)
+	}
+
+	e := NewEngine(ml, nil)
+	humans := map[string]bool{"alice": true}
+	e.AddGuard(HumanReview, Approved, "human-approval", RequireHumanActor(func(a string) bool { return humans[a] }))
+
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Approved, Metadata: Metadata{Actor: "agent-1"}}); err == nil {
+		t.Fatal("expected agent approval to be refused")
+	}
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Approved}); err == nil {
+		t.Fatal("expected anonymous approval to be refused")
+	}
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Approved, Metadata: Metadata{Actor: "alice"}}); err != nil {
+		t.Fatalf("expected human approval to pass, got %v", err)
+	}
+}
+
+func TestEngine_PipelineGuards(t *testing.T) {
+	p := loadStagingPipeline(t)
+	ml := NewMemoryLabeler()
+	ml.SetPipeline(p)
+	for _, l := range []Label{Ready, Analyzing, Approved, staging} {
+		ml.SetLabel("feature/pay", l)
+	}
+
+	e := NewEngine(ml, nil)
+	if e.Pipeline() != p {
+		t.Fatal("expected engine to use the labeler's pipeline")
+	}
+
+	// The pipeline declares "staging-soak"; unregistered guards fail closed.
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/pay", To: Deploying}); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Fatalf("expected unregistered guard to refuse, got %v", err)
+	}
+
+	soaked := false
+	e.RegisterGuard("staging-soak", func(ctx *TransitionContext) error {
+		if !soaked {
+			return errors.New("soak not finished")
+		}
+		return nil
+	})
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/pay", To: Deploying}); err == nil {
+		t.Fatal("expected soak guard to refuse")
+	}
+	soaked = true
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/pay", To: Deploying}); err != nil {
+		t.Fatalf("expected soak guard to pass, got %v", err)
+	}
+}
+
+func TestEngine_Hooks(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+
+	var calls []string
+	e.AddHook(AnyLabel, AnyLabel, "log", func(ctx *TransitionContext) error {
+		calls = append(calls, string(ctx.From)+">"+string(ctx.To))
+		return nil
+	})
+	e.AddHook(Ready, Analyzing, "notify", func(ctx *TransitionContext) error {
+		return errors.New("webhook down")
+	})
+
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready}); err != nil {
+		t.Fatalf("ApplyTransition: %v", err)
+	}
+	tr, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Analyzing})
+	var he *HookError
+	if !errors.As(err, &he) || he.Hook != "notify" {
+		t.Fatalf("expected HookError from notify, got %v", err)
+	}
+	if tr == nil || tr.To != Analyzing {
+		t.Errorf("expected transition returned despite hook failure, got %v", tr)
+	}
+	if label, _ := ml.GetLabel("feature/x"); label != Analyzing {
+		t.Errorf("hook failure must not undo the transition, got %s", label)
+	}
+	if strings.Join(calls, ",") != ">cindy:ready,cindy:ready>cindy:analyzing" {
+		t.Errorf("unexpected hook calls %v", calls)
+	}
+}
+
+func TestEngine_LabelerErrors(t *testing.T) {
+	ml := NewMemoryLabeler()
+	ml.FailNextWrites(1, nil)
+	e := NewEngine(ml, nil)
+
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready}); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected labeler error to surface, got %v", err)
+	}
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "bad..name", To: Ready}); !errors.Is(err, ErrInvalidBranch) {
+		t.Errorf("expected ErrInvalidBranch, got %v", err)
+	}
+}
+
+func TestEngine_Expect(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	walk(t, ml, "feature/x", time.Now(), Ready)
+
+	stale := Label("")
+	_, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Analyzing, Expect: &stale})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.Actual != Ready || !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a ConflictError reporting %s, got %v", Ready, err)
+	}
+
+	current := Ready
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Analyzing, Expect: &current}); err != nil {
+		t.Fatalf("ApplyTransition: %v", err)
+	}
+}
+
+func TestEngine_OnReject(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	var rejected []string
+	e.OnReject(func(ctx *TransitionContext, err error) {
+		rejected = append(rejected, fmt.Sprintf("%s→%s", labelOrNone(ctx.From), ctx.To))
+	})
+	e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready})
+	e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Deployed})
+	e.ApplyTransition(TransitionRequest{Branch: "bad..name", To: Ready})
+	if want := []string{"cindy:ready→cindy:deployed", "(none)→cindy:ready"}; fmt.Sprint(rejected) != fmt.Sprint(want) {
+		t.Errorf("rejections %v, want %v", rejected, want)
+	}
+}
+
+func TestEngine_ConcurrentWriterConflicts(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	walk(t, ml, "feature/x", time.Now(), Ready, Analyzing)
+
+	// Another writer moves the branch after the engine has checked the
+	// transition but before it writes; the engine must not overwrite it.
+	e.AddGuard(Analyzing, Approved, "race", func(ctx *TransitionContext) error {
+		return ml.SetLabel("feature/x", HumanReview)
+	})
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Approved}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if label, _ := ml.GetLabel("feature/x"); label != HumanReview {
+		t.Errorf("expected the concurrent write to stand, got %s", label)
+	}
+}
@@ -0,0 +1,556 @@
+package cindy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event is a label change signal (SPEC §8), as delivered to webhooks and
+// event stream subscribers.
+type Event struct {
+	// ID increases with every event a Dispatcher publishes. Event streams
+	// use it to resume after a reconnect.
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Transition Transition `json:"transition"`
+}
+
+// EventTransition is the Event type of a label change.
+const EventTransition = "transition"
+
+// SignatureHeader carries the HMAC-SHA256 of a webhook body, as
+// "sha256=<hex>", when the webhook has a secret.
+const SignatureHeader = "X-Cindy-Signature"
+
+// Sign returns the signature header value of body under secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature is the signature of body under
+// secret, comparing in constant time.
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, body)))
+}
+
+// Webhook is an HTTP endpoint that receives events as JSON POSTs.
+type Webhook struct {
+	URL string
+	// Secret, if set, signs every delivery in SignatureHeader.
+	Secret string
+	// Labels, if set, restricts deliveries to transitions into these labels.
+	Labels []Label
+}
+
+func (w Webhook) wants(ev Event) bool {
+	if len(w.Labels) == 0 {
+		return true
+	}
+	for _, l := range w.Labels {
+		if l == ev.Transition.To {
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetter is an event a webhook never accepted.
+type DeadLetter struct {
+	Webhook  string    `json:"webhook"`
+	Event    Event     `json:"event"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
+
+// DeadLetterLog keeps the deliveries a Dispatcher gave up on, for
+// inspection and replay.
+type DeadLetterLog interface {
+	RecordDeadLetter(d DeadLetter) error
+}
+
+// MemoryDeadLetterLog is an in-memory DeadLetterLog.
+type MemoryDeadLetterLog struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+// RecordDeadLetter appends d to the log.
+func (m *MemoryDeadLetterLog) RecordDeadLetter(d DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.letters = append(m.letters, d)
+	return nil
+}
+
+// DeadLetters returns the recorded dead letters, oldest first.
+func (m *MemoryDeadLetterLog) DeadLetters() []DeadLetter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]DeadLetter(nil), m.letters...)
+}
+
+// FileDeadLetterLog appends dead letters to a file, one JSON object per line.
+type FileDeadLetterLog struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// RecordDeadLetter appends d to the file.
+func (f *FileDeadLetterLog) RecordDeadLetter(d DeadLetter) error {
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o755); err != nil {
+		return fmt.Errorf("recording dead letter: %w", err)
+	}
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("recording dead letter: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("recording dead letter: %w", err)
+	}
+	return file.Close()
+}
+
+// DispatcherOptions configures a Dispatcher.
+type DispatcherOptions struct {
+	Webhooks []Webhook
+	// Client sends webhook deliveries. Defaults to a client with a 10s timeout.
+	Client *http.Client
+	// MaxAttempts is how often a delivery is tried before it is dead-lettered.
+	// Defaults to 5.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each further
+	// retry up to MaxBackoff. Defaults to 1s and 1m.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// DeadLetters records deliveries that failed every attempt, or were
+	// refused outright. If nil they are dropped.
+	DeadLetters DeadLetterLog
+	// QueueSize bounds each webhook's backlog; events beyond it are
+	// dead-lettered. Defaults to 1024.
+	QueueSize int
+	// Replay is how many recent events stream subscribers can resume from.
+	// Defaults to 256.
+	Replay int
+	// KeepAlive is the interval of comment lines sent to idle event streams.
+	// Defaults to 15s.
+	KeepAlive time.Duration
+}
+
+// Dispatcher fans events out to webhooks and to server-sent event streams.
+// Each webhook gets its own queue and worker, so a slow or failing endpoint
+// delays only itself, and receives events in the order they were published.
+//
+// Feed a Dispatcher with RegisterEvents, for transitions applied through an
+// Engine, or WatchLabels, for label changes made by any writer.
+type Dispatcher struct {
+	opts DispatcherOptions
+
+	mu     sync.Mutex
+	seq    int
+	recent []Event
+	subs   map[chan Event]bool
+	queues []chan Event
+	closed bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a Dispatcher and starts its webhook workers.
+func NewDispatcher(opts DispatcherOptions) *Dispatcher {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Minute
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.Replay <= 0 {
+		opts.Replay = 256
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = 15 * time.Second
+	}
+	d := &Dispatcher{opts: opts, subs: make(map[chan Event]bool)}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, w := range opts.Webhooks {
+		q := make(chan Event, opts.QueueSize)
+		d.queues = append(d.queues, q)
+		d.wg.Add(1)
+		go d.deliverLoop(w, q)
+	}
+	return d
+}
+
+// Publish assigns t the next event ID and hands it to every webhook and
+// stream subscriber. It never blocks on delivery.
+func (d *Dispatcher) Publish(t Transition) Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+	ev := Event{ID: strconv.Itoa(d.seq), Type: EventTransition, Transition: t}
+	if d.closed {
+		return ev
+	}
+	d.recent = append(d.recent, ev)
+	if len(d.recent) > d.opts.Replay {
+		d.recent = d.recent[len(d.recent)-d.opts.Replay:]
+	}
+	for i, q := range d.queues {
+		if !d.opts.Webhooks[i].wants(ev) {
+			continue
+		}
+		select {
+		case q <- ev:
+		default:
+			d.deadLetter(d.opts.Webhooks[i], ev, 0, errors.New("delivery queue full"))
+		}
+	}
+	for sub := range d.subs {
+		select {
+		case sub <- ev:
+		default:
+			// A subscriber that cannot keep up is disconnected rather than
+			// silently skipped; it can resume from its last event ID.
+			delete(d.subs, sub)
+			close(sub)
+		}
+	}
+	return ev
+}
+
+// Close stops accepting events and waits until the queued webhook
+// deliveries finish or ctx is done, in which case pending retries are
+// abandoned and dead-lettered.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, q := range d.queues {
+			close(q)
+		}
+		for sub := range d.subs {
+			delete(d.subs, sub)
+			close(sub)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) deliverLoop(w Webhook, q chan Event) {
+	defer d.wg.Done()
+	for ev := range q {
+		d.deliver(w, ev)
+	}
+}
+
+// deliver posts ev to w, retrying with exponential backoff, and
+// dead-letters it if every attempt fails.
+func (d *Dispatcher) deliver(w Webhook, ev Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		d.deadLetter(w, ev, 0, err)
+		return
+	}
+	delay := d.opts.Backoff
+	var attempt int
+	for attempt = 1; ; attempt++ {
+		retry, err := d.post(w, ev, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= d.opts.MaxAttempts {
+			d.deadLetter(w, ev, attempt, err)
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-d.ctx.Done():
+			d.deadLetter(w, ev, attempt, fmt.Errorf("dispatcher closed: %w", err))
+			return
+		}
+		delay = min(delay*2, d.opts.MaxBackoff)
+	}
+}
+
+// post sends one delivery attempt and reports whether a failure is worth retrying.
+func (d *Dispatcher) post(w Webhook, ev Event, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cindy-Event", ev.Type)
+	req.Header.Set("X-Cindy-Delivery", ev.ID)
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("webhook responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+}
+
+// deadLetter records a failed delivery. Callers may hold d.mu.
+func (d *Dispatcher) deadLetter(w Webhook, ev Event, attempts int, err error) {
+	if d.opts.DeadLetters == nil {
+		return
+	}
+	d.opts.DeadLetters.RecordDeadLetter(DeadLetter{
+		Webhook:  w.URL,
+		Event:    ev,
+		Attempts: attempts,
+		Error:    err.Error(),
+		Time:     time.Now().UTC(),
+	})
+}
+
+// subscribe registers a stream subscriber and returns the retained events
+// published after lastID, which the subscriber has not seen yet.
+func (d *Dispatcher) subscribe(lastID string) (chan Event, []Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ch := make(chan Event, 64)
+	if d.closed {
+		close(ch)
+		return ch, nil
+	}
+	d.subs[ch] = true
+	var backlog []Event
+	if last, err := strconv.Atoi(lastID); err == nil {
+		for _, ev := range d.recent {
+			if id, _ := strconv.Atoi(ev.ID); id > last {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+	return ch, backlog
+}
+
+func (d *Dispatcher) unsubscribe(ch chan Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.subs[ch] {
+		delete(d.subs, ch)
+		close(ch)
+	}
+}
+
+// ServeHTTP streams events as server-sent events, for live dashboards. A
+// client reconnecting with a Last-Event-ID header first receives the
+// retained events it missed. The query parameters branch and label filter
+// the stream by branch and by the label transitioned into.
+func (d *Dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	branch, label := r.URL.Query().Get("branch"), Label(r.URL.Query().Get("label"))
+	wanted := func(ev Event) bool {
+		return (branch == "" || ev.Transition.Branch == branch) && (label == "" || ev.Transition.To == label)
+	}
+
+	ch, backlog := d.subscribe(r.Header.Get("Last-Event-ID"))
+	defer d.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, ev := range backlog {
+		if wanted(ev) {
+			writeEvent(w, ev)
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(d.opts.KeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !wanted(ev) {
+				continue
+			}
+			writeEvent(w, ev)
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w io.Writer, ev Event) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+}
+
+// RegisterEvents publishes every transition e applies to d.
+func RegisterEvents(e *Engine, d *Dispatcher) {
+	e.AddHook(AnyLabel, AnyLabel, "events", func(ctx *TransitionContext) error {
+		d.Publish(Transition{Branch: ctx.Branch, From: ctx.From, To: ctx.To, Metadata: ctx.Metadata})
+		return nil
+	})
+}
+
+// WatchLabels polls l every interval and calls publish for each label
+// change, until ctx is done. Changes present when it starts are not
+// reported. For RecordingLabelers the recorded transitions are published,
+// with their metadata, including intermediate steps between two polls and
+// labels that change and change back; otherwise a transition from the old
+// to the new label is synthesized, and a round trip within one poll is
+// missed. A poll that fails is reported to onError, if not nil, and retried
+// on the next tick; WatchLabels only returns ctx.Err().
+func WatchLabels(ctx context.Context, l Labeler, interval time.Duration, publish func(Transition), onError func(error)) error {
+	rl, recording := l.(RecordingLabeler)
+	var labels map[string]Label
+	seen := make(map[string]int)
+
+	// snapshot records the labels and history lengths changes are reported
+	// against.
+	snapshot := func() error {
+		current, err := l.AllLabels()
+		if err != nil {
+			return err
+		}
+		for branch := range current {
+			if !recording {
+				continue
+			}
+			history, err := rl.History(branch)
+			if err != nil {
+				return err
+			}
+			seen[branch] = len(history)
+		}
+		labels = current
+		return nil
+	}
+	poll := func() error {
+		current, err := l.AllLabels()
+		if err != nil {
+			return err
+		}
+		// With history, every labeled branch is checked for new entries,
+		// so round trips between two polls are seen too.
+		changed := make(map[string]bool)
+		for branch, label := range current {
+			if recording || labels[branch] != label {
+				changed[branch] = true
+			}
+		}
+		for branch := range labels {
+			if _, ok := current[branch]; !ok {
+				changed[branch] = true
+			}
+		}
+		for _, branch := range sortedBranches(changed) {
+			if !recording {
+				publish(Transition{Branch: branch, From: labels[branch], To: current[branch], Metadata: Metadata{}.stamp()})
+				continue
+			}
+			// Published transitions are remembered as they go, so a
+			// failure part way through does not publish them twice.
+			history, err := rl.History(branch)
+			if err != nil {
+				return err
+			}
+			for _, t := range history[min(seen[branch], len(history)):] {
+				publish(t)
+			}
+			seen[branch] = len(history)
+		}
+		labels = current
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	step := snapshot
+	for {
+		if err := step(); err != nil {
+			if onError != nil {
+				onError(err)
+			}
+		} else {
+			step = poll
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func sortedBranches(set map[string]bool) []string {
+	branches := make([]string, 0, len(set))
+	for b := range set {
+		branches = append(branches, b)
+	}
+	sort.Strings(branches)
+	return branches
+}
@@ -0,0 +1,341 @@
+package cindy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receiver is a webhook endpoint recording the events it accepts. fail is
+// called with the attempt number and returns the status to respond with.
+type receiver struct {
+	mu       sync.Mutex
+	events   []Event
+	attempts int
+	secret   string
+	fail     func(attempt int) int
+	badSig   bool
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.attempts++
+	if rc.secret != "" && !VerifySignature(rc.secret, body, r.Header.Get(SignatureHeader)) {
+		rc.badSig = true
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if rc.fail != nil {
+		if status := rc.fail(rc.attempts); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	var ev Event
+	json.Unmarshal(body, &ev)
+	rc.events = append(rc.events, ev)
+}
+
+func (rc *receiver) received() []Event {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]Event(nil), rc.events...)
+}
+
+func closeDispatcher(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestDispatcher_Webhooks(t *testing.T) {
+	signed := &receiver{secret: "s3cret"}
+	approvals := &receiver{}
+	srvSigned, srvApprovals := httptest.NewServer(signed), httptest.NewServer(approvals)
+	defer srvSigned.Close()
+	defer srvApprovals.Close()
+
+	d := NewDispatcher(DispatcherOptions{Webhooks: []Webhook{
+		{URL: srvSigned.URL, Secret: "s3cret"},
+		{URL: srvApprovals.URL, Labels: []Label{Approved}},
+	}})
+	for _, to := range []Label{Ready, Analyzing, Approved, Deploying} {
+		d.Publish(Transition{Branch: "feature/x", To: to, Metadata: Metadata{Actor: "agent"}})
+	}
+	closeDispatcher(t, d)
+
+	if signed.badSig {
+		t.Error("delivery signature did not verify")
+	}
+	got := signed.received()
+	if len(got) != 4 {
+		t.Fatalf("expected 4 deliveries, got %d", len(got))
+	}
+	for i, ev := range got {
+		if ev.Type != EventTransition || ev.ID != strconv.Itoa(i+1) || ev.Transition.Actor != "agent" {
+			t.Errorf("delivery %d out of order or incomplete: %+v", i, ev)
+		}
+	}
+	if got := approvals.received(); len(got) != 1 || got[0].Transition.To != Approved {
+		t.Errorf("label filter: got %+v", got)
+	}
+}
+
+func TestDispatcher_RetriesAndDeadLetters(t *testing.T) {
+	flaky := &receiver{fail: func(attempt int) int {
+		if attempt <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}}
+	down := &receiver{fail: func(int) int { return http.StatusBadGateway }}
+	refusing := &receiver{fail: func(int) int { return http.StatusBadRequest }}
+	var urls []string
+	for _, rc := range []*receiver{flaky, down, refusing} {
+		srv := httptest.NewServer(rc)
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+
+	dead := &MemoryDeadLetterLog{}
+	d := NewDispatcher(DispatcherOptions{
+		Webhooks:    []Webhook{{URL: urls[0]}, {URL: urls[1]}, {URL: urls[2]}},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		DeadLetters: dead,
+	})
+	d.Publish(Transition{Branch: "feature/x", To: Ready})
+	closeDispatcher(t, d)
+
+	if len(flaky.received()) != 1 || flaky.attempts != 3 {
+		t.Errorf("flaky webhook: %d deliveries after %d attempts", len(flaky.received()), flaky.attempts)
+	}
+	letters := dead.DeadLetters()
+	if len(letters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %+v", letters)
+	}
+	byURL := map[string]DeadLetter{}
+	for _, l := range letters {
+		byURL[l.Webhook] = l
+	}
+	if l := byURL[urls[1]]; l.Attempts != 3 || !strings.Contains(l.Error, "502") || l.Event.Transition.Branch != "feature/x" {
+		t.Errorf("unavailable webhook dead letter: %+v", l)
+	}
+	if l := byURL[urls[2]]; l.Attempts != 1 {
+		t.Errorf("client errors should not be retried: %+v", l)
+	}
+}
+
+func TestDispatcher_CloseAbandonsRetries(t *testing.T) {
+	down := &receiver{fail: func(int) int { return http.StatusInternalServerError }}
+	srv := httptest.NewServer(down)
+	defer srv.Close()
+
+	dead := &MemoryDeadLetterLog{}
+	d := NewDispatcher(DispatcherOptions{Webhooks: []Webhook{{URL: srv.URL}}, Backoff: time.Hour, DeadLetters: dead})
+	d.Publish(Transition{Branch: "feature/x", To: Ready})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Close(ctx); err == nil {
+		t.Error("expected Close to report the abandoned retry")
+	}
+	if letters := dead.DeadLetters(); len(letters) != 1 {
+		t.Errorf("expected the abandoned delivery to be dead-lettered, got %+v", letters)
+	}
+}
+
+// readEvents reads n server-sent events from body.
+func readEvents(t *testing.T, body io.Reader, n int) []Event {
+	t.Helper()
+	var events []Event
+	sc := bufio.NewScanner(body)
+	for len(events) < n && sc.Scan() {
+		if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			var ev Event
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatalf("decoding event: %v", err)
+			}
+			events = append(events, ev)
+		}
+	}
+	if len(events) < n {
+		t.Fatalf("stream ended after %d of %d events: %v", len(events), n, sc.Err())
+	}
+	return events
+}
+
+func TestDispatcher_EventStream(t *testing.T) {
+	d := NewDispatcher(DispatcherOptions{})
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+	defer closeDispatcher(t, d)
+
+	d.Publish(Transition{Branch: "feature/a", To: Ready})
+	d.Publish(Transition{Branch: "feature/b", To: Ready})
+
+	// A client resuming after event 1 first gets what it missed, then live
+	// events, filtered to its branch.
+	req, _ := http.NewRequest("GET", srv.URL+"?branch=feature/b", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+
+	d.Publish(Transition{Branch: "feature/a", To: Analyzing})
+	d.Publish(Transition{Branch: "feature/b", To: Analyzing})
+	events := readEvents(t, resp.Body, 2)
+	if events[0].ID != "2" || events[0].Transition.To != Ready || events[1].ID != "4" || events[1].Transition.To != Analyzing {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestRegisterEvents(t *testing.T) {
+	d := NewDispatcher(DispatcherOptions{})
+	defer closeDispatcher(t, d)
+	ch, _ := d.subscribe("")
+
+	e := NewEngine(NewMemoryLabeler(), nil)
+	RegisterEvents(e, d)
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready, Metadata: Metadata{Actor: "agent"}}); err != nil {
+		t.Fatal(err)
+	}
+	ev := <-ch
+	if ev.Transition.Branch != "feature/x" || ev.Transition.From != "" || ev.Transition.To != Ready || ev.Transition.Actor != "agent" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestWatchLabels(t *testing.T) {
+	for _, recording := range []bool{true, false} {
+		ml := NewMemoryLabeler()
+		walk(t, ml, "feature/old", time.Now(), Ready)
+		var l Labeler = ml
+		if !recording {
+			l = plainLabeler{ml}
+		}
+
+		var mu sync.Mutex
+		var got []Transition
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- WatchLabels(ctx, l, time.Millisecond, func(tr Transition) {
+				mu.Lock()
+				got = append(got, tr)
+				mu.Unlock()
+			}, nil)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		walk(t, ml, "feature/x", time.Now(), Ready, Analyzing)
+		ml.RemoveLabel("feature/old", Metadata{Actor: "cleanup"})
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			mu.Lock()
+			n := len(got)
+			mu.Unlock()
+			if n >= 2 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		<-done
+
+		mu.Lock()
+		var summary []string
+		for _, tr := range got {
+			summary = append(summary, tr.String())
+		}
+		mu.Unlock()
+		// Intermediate steps are only known from history, and may fall
+		// into one poll without it.
+		joined := strings.Join(summary, "; ")
+		if !strings.Contains(joined, "feature/x: ") || !strings.Contains(joined, "feature/old: cindy:ready → (none)") {
+			t.Errorf("recording=%v: unexpected events %s", recording, joined)
+		}
+		if recording && !strings.Contains(joined, "feature/x: (none) → cindy:ready") {
+			t.Errorf("recording=%v: expected the intermediate transition from history, got %s", recording, joined)
+		}
+	}
+}
+
+// flakyLabeler fails AllLabels while failing is set.
+type flakyLabeler struct {
+	*MemoryLabeler
+	failing atomic.Bool
+}
+
+func (f *flakyLabeler) AllLabels() (map[string]Label, error) {
+	if f.failing.Load() {
+		return nil, errors.New("repository unavailable")
+	}
+	return f.MemoryLabeler.AllLabels()
+}
+
+func TestWatchLabels_RetriesAndRoundTrips(t *testing.T) {
+	fl := &flakyLabeler{MemoryLabeler: NewMemoryLabeler()}
+	fl.failing.Store(true)
+	walk(t, fl.MemoryLabeler, "feature/x", time.Now(), Ready)
+
+	events := make(chan Transition, 16)
+	errs := make(chan error, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- WatchLabels(ctx, fl, time.Millisecond, func(tr Transition) { events <- tr }, func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		})
+	}()
+	select {
+	case <-errs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("error not reported")
+	}
+	fl.failing.Store(false)
+	time.Sleep(20 * time.Millisecond)
+
+	// A round trip between two polls is still published from history.
+	walk(t, fl.MemoryLabeler, "feature/x", time.Now(), Analyzing, Ready)
+	var got []string
+	for len(got) < 2 {
+		select {
+		case tr := <-events:
+			got = append(got, tr.String())
+		case <-time.After(2 * time.Second):
+			t.Fatalf("events stopped after %v", got)
+		}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("WatchLabels returned %v", err)
+	}
+	if want := "feature/x: cindy:ready → cindy:analyzing; feature/x: cindy:analyzing → cindy:ready"; strings.Join(got, "; ") != want {
+		t.Errorf("got %s", strings.Join(got, "; "))
+	}
+}
@@ -0,0 +1,265 @@
+package cindy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LabelInspector is implemented by Labelers that can report every label
+// attached to a branch, including duplicates that AllLabels collapses.
+type LabelInspector interface {
+	RawLabels() (map[string][]Label, error)
+}
+
+// BranchLister is implemented by Labelers that know which branches exist.
+type BranchLister interface {
+	Branches() (map[string]bool, error)
+}
+
+// FsckIssueKind classifies a consistency problem found by Fsck.
+type FsckIssueKind string
+
+const (
+	// FsckMultipleLabels means a branch carries more than one label.
+	FsckMultipleLabels FsckIssueKind = "multiple-labels"
+	// FsckOrphanedLabel means a label refers to a branch that no longer exists.
+	FsckOrphanedLabel FsckIssueKind = "orphaned-label"
+	// FsckInvalidHistory means a branch's history contains invalid transitions.
+	FsckInvalidHistory FsckIssueKind = "invalid-history"
+)
+
+// FsckIssue is a single consistency problem.
+type FsckIssue struct {
+	Kind   FsckIssueKind
+	Branch string
+	Labels []Label
+	Detail string
+	// Repaired is true if Fsck fixed the issue.
+	Repaired bool
+}
+
+func (i FsckIssue) String() string {
+	s := fmt.Sprintf("%s %s: %s", i.Kind, i.Branch, i.Detail)
+	if i.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// FsckOptions configures Fsck.
+type FsckOptions struct {
+	// Repair applies the deterministic resolution for each repairable issue.
+	Repair bool
+	// Actor is recorded in the metadata of repairs. Defaults to "cindy-fsck".
+	Actor string
+}
+
+// ResolveLabels returns the label repair keeps when a branch carries all of
+// labels in the default pipeline. See Pipeline.ResolveLabels.
+func ResolveLabels(labels []Label) Label {
+	return DefaultPipeline().ResolveLabels(labels)
+}
+
+// ResolveLabels returns the label repair keeps when a branch carries all of
+// labels: the most conservative one, so a branch tagged both deployed and
+// rejected is never left deployable. Terminal labels are the most
+// conservative; the others rank by how many transitions they are from the
+// deploy end of the pipeline, so labels that send a branch back rank above
+// the entry label and labels next to deployment rank last.
+func (p *Pipeline) ResolveLabels(labels []Label) Label {
+	for _, l := range p.resolutionOrder() {
+		for _, have := range labels {
+			if have == l {
+				return l
+			}
+		}
+	}
+	if len(labels) > 0 {
+		return labels[0]
+	}
+	return ""
+}
+
+// resolutionOrder ranks the pipeline's labels from most to least
+// conservative. The deploy end is the label furthest from the entry label
+// that does not only lead back towards it, e.g. cindy:deployed rather than
+// cindy:rollback.
+func (p *Pipeline) resolutionOrder() []Label {
+	depth := p.distances(p.entry, p.transitions)
+	leadsBack := func(l Label) bool {
+		for _, to := range p.transitions[l] {
+			if depth[to] >= depth[l] {
+				return false
+			}
+		}
+		return len(p.transitions[l]) > 0
+	}
+	var end Label
+	for _, l := range p.labels {
+		if _, reached := depth[l]; reached && !p.terminal[l] && !leadsBack(l) && (end == "" || depth[l] > depth[end]) {
+			end = l
+		}
+	}
+
+	preds := make(map[Label][]Label)
+	for from, targets := range p.transitions {
+		for _, to := range targets {
+			preds[to] = append(preds[to], from)
+		}
+	}
+	toEnd := p.distances(end, preds)
+	// Labels that cannot reach the deploy end rank above all that can.
+	rank := func(l Label) int {
+		if d, ok := toEnd[l]; ok {
+			return d
+		}
+		return len(p.labels)
+	}
+
+	var order, rest []Label
+	for _, l := range p.labels {
+		if p.terminal[l] {
+			order = append(order, l)
+		} else {
+			rest = append(rest, l)
+		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool { return rank(rest[i]) > rank(rest[j]) })
+	return append(order, rest...)
+}
+
+// distances returns the number of edges from start to each label reachable
+// from it over edges.
+func (p *Pipeline) distances(start Label, edges map[Label][]Label) map[Label]int {
+	dist := map[Label]int{start: 0}
+	queue := []Label{start}
+	for len(queue) > 0 {
+		l := queue[0]
+		queue = queue[1:]
+		for _, next := range edges[l] {
+			if _, seen := dist[next]; !seen {
+				dist[next] = dist[l] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+	return dist
+}
+
+// Fsck checks the labels managed by l for consistency:
+//   - branches with more than one label (requires LabelInspector)
+//   - labels for branches that no longer exist (requires BranchLister)
+//   - histories containing transitions invalid in l's pipeline (requires RecordingLabeler)
+//
+// Checks whose interface l does not implement are skipped. With opts.Repair,
+// multiple labels are collapsed to the pipeline's ResolveLabels and orphaned labels are
+// removed. History is append-only, so invalid histories are only reported.
+func Fsck(l Labeler, opts FsckOptions) ([]FsckIssue, error) {
+	if opts.Actor == "" {
+		opts.Actor = "cindy-fsck"
+	}
+
+	current, err := l.AllLabels()
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]string, 0, len(current))
+	for b := range current {
+		branches = append(branches, b)
+	}
+	sort.Strings(branches)
+
+	var issues []FsckIssue
+
+	if inspector, ok := l.(LabelInspector); ok {
+		raw, err := inspector.RawLabels()
+		if err != nil {
+			return nil, err
+		}
+		for _, branch := range branches {
+			labels := raw[branch]
+			if len(labels) < 2 {
+				continue
+			}
+			keep := pipelineOf(l).ResolveLabels(labels)
+			issue := FsckIssue{
+				Kind:   FsckMultipleLabels,
+				Branch: branch,
+				Labels: labels,
+				Detail: fmt.Sprintf("labeled %s; resolution keeps %s", joinLabels(labels), keep),
+			}
+			if opts.Repair {
+				meta := Metadata{Actor: opts.Actor, Reason: "fsck: resolved multiple labels " + joinLabels(labels), Correction: true}
+				if err := setLabel(l, branch, keep, meta); err != nil {
+					return issues, fmt.Errorf("repairing %s: %w", branch, err)
+				}
+				current[branch] = keep
+				issue.Repaired = true
+			}
+			issues = append(issues, issue)
+		}
+	}
+
+	if lister, ok := l.(BranchLister); ok {
+		existing, err := lister.Branches()
+		if err != nil {
+			return nil, err
+		}
+		for _, branch := range branches {
+			if existing[branch] {
+				continue
+			}
+			issue := FsckIssue{
+				Kind:   FsckOrphanedLabel,
+				Branch: branch,
+				Labels: []Label{current[branch]},
+				Detail: fmt.Sprintf("labeled %s but the branch does not exist", current[branch]),
+			}
+			if rl, ok := l.(RecordingLabeler); ok && opts.Repair {
+				meta := Metadata{Actor: opts.Actor, Reason: "fsck: branch no longer exists", Correction: true}
+				if err := rl.RemoveLabel(branch, meta); err != nil {
+					return issues, fmt.Errorf("repairing %s: %w", branch, err)
+				}
+				issue.Repaired = true
+			}
+			issues = append(issues, issue)
+		}
+	}
+
+	if rl, ok := l.(RecordingLabeler); ok {
+		pipeline := pipelineOf(l)
+		for _, branch := range branches {
+			history, err := rl.History(branch)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range pipeline.ValidateHistory(history) {
+				issues = append(issues, FsckIssue{
+					Kind:   FsckInvalidHistory,
+					Branch: branch,
+					Labels: []Label{v.Transition.From, v.Transition.To},
+					Detail: fmt.Sprintf("transition #%d %s → %s: %s", v.Index, labelOrNone(v.Transition.From), labelOrNone(v.Transition.To), v.Rule),
+				})
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+// setLabel applies a label, recording meta when l supports it.
+func setLabel(l Labeler, branch string, label Label, meta Metadata) error {
+	if rl, ok := l.(RecordingLabeler); ok {
+		return rl.SetLabelWithMetadata(branch, label, meta)
+	}
+	return l.SetLabel(branch, label)
+}
+
+func joinLabels(labels []Label) string {
+	s := make([]string, len(labels))
+	for i, l := range labels {
+		s[i] = string(l)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,285 @@
+package cindy
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateHistory(t *testing.T) {
+	valid := []Transition{
+		{From: "", To: Ready},
+		{From: Ready, To: Analyzing},
+		{From: Analyzing, To: Analyzing},
+		{From: Analyzing, To: Approved},
+		{From: Approved, To: ""},
+	}
+	if v := ValidateHistory(valid); len(v) != 0 {
+		t.Errorf("expected no violations, got %v", v)
+	}
+
+	invalid := []Transition{
+		{From: "", To: Approved},
+		{From: Approved, To: Deployed},
+		{From: Ready, To: Analyzing},
+		{From: Analyzing, To: Rejected, Metadata: Metadata{Correction: true}},
+	}
+	v := ValidateHistory(invalid)
+	if len(v) != 3 {
+		t.Fatalf("expected 3 violations, got %d: %v", len(v), v)
+	}
+	for i, want := range []int{0, 1, 2} {
+		if v[i].Index != want {
+			t.Errorf("violation %d: index %d, want %d", i, v[i].Index, want)
+		}
+	}
+}
+
+func TestGitLabeler_History(t *testing.T) {
+	repo := initGitRepo(t)
+	gl, _ := NewGitLabeler(repo)
+
+	ts := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := gl.SetLabelWithMetadata("feature/test", Ready, Metadata{Actor: "agent-7", Reason: "submitted", Timestamp: ts}); err != nil {
+		t.Fatalf("SetLabelWithMetadata: %v", err)
+	}
+	if err := gl.SetLabel("feature/test", Analyzing); err != nil {
+		t.Fatalf("SetLabel: %v", err)
+	}
+	if err := gl.RemoveLabel("feature/test", Metadata{Actor: "janitor"}); err != nil {
+		t.Fatalf("RemoveLabel: %v", err)
+	}
+
+	if label, _ := gl.GetLabel("feature/test"); label != "" {
+		t.Errorf("expected label removed, got %s", label)
+	}
+
+	history, err := gl.History("feature/test")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected 3 transitions, got %d: %v", len(history), history)
+	}
+	first := history[0]
+	if first.From != "" || first.To != Ready || first.Actor != "agent-7" || first.Reason != "submitted" || !first.Timestamp.Equal(ts) {
+		t.Errorf("unexpected first transition %+v", first)
+	}
+	if history[1].From != Ready || history[1].To != Analyzing || history[1].Timestamp.IsZero() {
+		t.Errorf("unexpected second transition %+v", history[1])
+	}
+	if history[2].From != Analyzing || history[2].To != "" || history[2].Actor != "janitor" {
+		t.Errorf("unexpected third transition %+v", history[2])
+	}
+
+	if h, err := gl.History("feature/none"); err != nil || len(h) != 0 {
+		t.Errorf("expected empty history, got %v, %v", h, err)
+	}
+}
+
+func TestGitLabeler_HistoryWriteFails(t *testing.T) {
+	repo := initGitRepo(t)
+	gl, _ := NewGitLabeler(repo)
+	if err := gl.SetLabel("feature/test", Ready); err != nil {
+		t.Fatal(err)
+	}
+
+	// A held lock on the history ref makes recording the transition fail;
+	// the label tags must not move without it. The lock is not a conflict
+	// with another writer, so it is not reported as one.
+	lock := filepath.Join(repo, ".git", historyRef("feature/test")+".lock")
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gl.SetLabel("feature/test", Analyzing); err == nil || errors.Is(err, ErrConflict) {
+		t.Fatalf("SetLabel: got %v, want a failure other than ErrConflict", err)
+	}
+	if err := gl.CompareAndSwapLabel("feature/test", Ready, Analyzing, Metadata{}); err == nil || errors.Is(err, ErrConflict) {
+		t.Fatalf("CompareAndSwapLabel: got %v, want a failure other than ErrConflict", err)
+	}
+	if err := gl.RemoveLabel("feature/test", Metadata{}); err == nil || errors.Is(err, ErrConflict) {
+		t.Fatalf("RemoveLabel: got %v, want a failure other than ErrConflict", err)
+	}
+	if raw, _ := gl.RawLabels(); len(raw["feature/test"]) != 1 || raw["feature/test"][0] != Ready {
+		t.Errorf("labels changed without history: %v", raw["feature/test"])
+	}
+	if h, _ := gl.History("feature/test"); len(h) != 1 {
+		t.Errorf("expected 1 transition, got %v", h)
+	}
+}
+
+func TestGitLabeler_SyncHistory(t *testing.T) {
+	repoA := initGitRepo(t)
+	bare := addBareRemote(t, repoA, "origin")
+	repoB := initGitRepo(t)
+	exec.Command("git", "-C", repoB, "remote", "add", "origin", bare).Run()
+
+	a, _ := NewGitLabeler(repoA)
+	b, _ := NewGitLabeler(repoB)
+
+	a.SetLabelWithMetadata("feature/test", Ready, Metadata{Actor: "agent-a"})
+	if _, err := b.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	history, err := b.History("feature/test")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(history) != 1 || history[0].Actor != "agent-a" {
+		t.Errorf("expected history pulled from remote, got %v", history)
+	}
+}
+
+func TestFsck_MultipleLabels(t *testing.T) {
+	repo := initGitRepo(t)
+	exec.Command("git", "-C", repo, "branch", "feature/test").Run()
+	gl, _ := NewGitLabeler(repo)
+
+	for _, l := range []Label{Ready, Analyzing, Approved, Deploying, Deployed} {
+		gl.SetLabel("feature/test", l)
+	}
+	// A second agent tagged the same branch behind the labeler's back.
+	if out, err := exec.Command("git", "-C", repo, "tag", "cindy/rejected/feature/test", "HEAD").CombinedOutput(); err != nil {
+		t.Fatalf("git tag: %s", out)
+	}
+
+	issues, err := Fsck(gl, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck: %v", err)
+	}
+	if len(issues) != 1 || issues[0].Kind != FsckMultipleLabels || issues[0].Repaired {
+		t.Fatalf("expected one unrepaired multiple-labels issue, got %v", issues)
+	}
+
+	issues, err = Fsck(gl, FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Fsck repair: %v", err)
+	}
+	if len(issues) != 1 || !issues[0].Repaired {
+		t.Fatalf("expected repaired issue, got %v", issues)
+	}
+
+	raw, _ := gl.RawLabels()
+	if got := raw["feature/test"]; len(got) != 1 || got[0] != Rejected {
+		t.Errorf("expected only rejected to remain, got %v", got)
+	}
+
+	// The repair is a correction, so the history stays valid.
+	issues, _ = Fsck(gl, FsckOptions{})
+	if len(issues) != 0 {
+		t.Errorf("expected clean fsck after repair, got %v", issues)
+	}
+}
+
+func TestFsck_CustomPipeline(t *testing.T) {
+	p, err := NewPipeline(PipelineConfig{
+		Name:   "ops",
+		Labels: []Label{"cindy:live", "cindy:ok", "cindy:checking", "cindy:queued", "cindy:dropped", "cindy:reverted"},
+		Entry:  "cindy:queued",
+		Transitions: []TransitionConfig{
+			{From: "cindy:queued", To: "cindy:checking"},
+			{From: "cindy:checking", To: "cindy:ok"},
+			{From: "cindy:checking", To: "cindy:dropped"},
+			{From: "cindy:ok", To: "cindy:live"},
+			{From: "cindy:live", To: "cindy:reverted"},
+			{From: "cindy:reverted", To: "cindy:queued"},
+		},
+		Terminal: []Label{"cindy:dropped"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		labels []Label
+		want   Label
+	}{
+		{[]Label{"cindy:live", "cindy:dropped"}, "cindy:dropped"},
+		{[]Label{"cindy:live", "cindy:reverted"}, "cindy:reverted"},
+		{[]Label{"cindy:ok", "cindy:queued"}, "cindy:queued"},
+		{[]Label{"cindy:live", "cindy:checking"}, "cindy:checking"},
+	}
+	for _, tt := range tests {
+		if got := p.ResolveLabels(tt.labels); got != tt.want {
+			t.Errorf("ResolveLabels(%v) = %s, want %s", tt.labels, got, tt.want)
+		}
+	}
+
+	repo := initGitRepo(t)
+	exec.Command("git", "-C", repo, "branch", "feature/test").Run()
+	gl, _ := NewGitLabeler(repo, WithPipeline(p))
+	walk(t, gl, "feature/test", time.Now(), "cindy:queued", "cindy:checking", "cindy:ok", "cindy:live")
+	if out, err := exec.Command("git", "-C", repo, "tag", "cindy/checking/feature/test", "HEAD").CombinedOutput(); err != nil {
+		t.Fatalf("git tag: %s", out)
+	}
+	if _, err := Fsck(gl, FsckOptions{Repair: true}); err != nil {
+		t.Fatalf("Fsck repair: %v", err)
+	}
+	if raw, _ := gl.RawLabels(); len(raw["feature/test"]) != 1 || raw["feature/test"][0] != "cindy:checking" {
+		t.Errorf("expected only cindy:checking to remain, got %v", raw["feature/test"])
+	}
+}
+
+func TestFsck_OrphanedLabel(t *testing.T) {
+	repo := initGitRepo(t)
+	exec.Command("git", "-C", repo, "branch", "feature/live").Run()
+	gl, _ := NewGitLabeler(repo)
+
+	gl.SetLabel("feature/live", Ready)
+	gl.SetLabel("feature/gone", Ready)
+
+	issues, err := Fsck(gl, FsckOptions{Repair: true, Actor: "ops"})
+	if err != nil {
+		t.Fatalf("Fsck: %v", err)
+	}
+	if len(issues) != 1 || issues[0].Kind != FsckOrphanedLabel || issues[0].Branch != "feature/gone" || !issues[0].Repaired {
+		t.Fatalf("expected repaired orphan for feature/gone, got %v", issues)
+	}
+	all, _ := gl.AllLabels()
+	if _, ok := all["feature/gone"]; ok {
+		t.Error("expected orphaned label removed")
+	}
+	history, _ := gl.History("feature/gone")
+	if last := history[len(history)-1]; last.To != "" || last.Actor != "ops" || !last.Correction {
+		t.Errorf("expected recorded correction, got %+v", last)
+	}
+}
+
+func TestFsck_InvalidHistory(t *testing.T) {
+	ml := NewMemoryLabeler()
+	ml.SetLabel("feature/ok", Ready)
+	ml.SetLabel("feature/ok", Analyzing)
+	ml.SetLabel("feature/bad", Ready)
+	ml.SetLabel("feature/bad", Deployed)
+
+	issues, err := Fsck(ml, FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Fsck: %v", err)
+	}
+	if len(issues) != 1 {
+		t.Fatalf("expected 1 issue, got %v", issues)
+	}
+	if issues[0].Kind != FsckInvalidHistory || issues[0].Branch != "feature/bad" || issues[0].Repaired {
+		t.Errorf("unexpected issue %v", issues[0])
+	}
+}
+
+func TestResolveLabels(t *testing.T) {
+	tests := []struct {
+		labels []Label
+		want   Label
+	}{
+		{[]Label{Deployed, Rejected}, Rejected},
+		{[]Label{Approved, Blocked}, Blocked},
+		{[]Label{Deployed, Rollback}, Rollback},
+		{[]Label{Ready}, Ready},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := ResolveLabels(tt.labels); got != tt.want {
+			t.Errorf("ResolveLabels(%v) = %s, want %s", tt.labels, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package cindy
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func FuzzTagRoundTrip(f *testing.F) {
+	for _, b := range []string{
+		"main", "feature/foo", "feature/deep/nested/branch", "release/1.2.x",
+		"fix/ümlaut", "a%b", "user+tag/x", "feat/with space", "x~y^z", "-", "a..b", "",
+	} {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, branch string) {
+		if ValidateBranchName(branch) != nil {
+			return
+		}
+		for _, label := range AllLabels() {
+			tag := TagName(label, branch)
+			if err := ValidateBranchName(tag); err != nil {
+				t.Fatalf("TagName(%s, %q) = %q is not a valid ref name: %v", label, branch, tag, err)
+			}
+			gotLabel, gotBranch, ok := ParseTag(tag)
+			if !ok || gotLabel != label || gotBranch != branch {
+				t.Fatalf("ParseTag(TagName(%s, %q)) = %s, %q, %v", label, branch, gotLabel, gotBranch, ok)
+			}
+		}
+	})
+}
+
+func FuzzParseTag(f *testing.F) {
+	for _, tag := range []string{
+		"cindy/ready/main", "cindy/human-review/feature/x", "cindy/ready/a%20b",
+		"cindy/ready/%zz", "cindy/ready/", "cindy/unknown/x", "v1.0.0", "cindy/ready/%2E%2E",
+	} {
+		f.Add(tag)
+	}
+	f.Fuzz(func(t *testing.T, tag string) {
+		label, branch, ok := ParseTag(tag)
+		if !ok {
+			if label != "" || branch != "" {
+				t.Fatalf("ParseTag(%q) failed but returned %s, %q", tag, label, branch)
+			}
+			return
+		}
+		if !DefaultPipeline().HasLabel(label) {
+			t.Fatalf("ParseTag(%q) returned unknown label %s", tag, label)
+		}
+		if err := ValidateBranchName(branch); err != nil {
+			t.Fatalf("ParseTag(%q) returned invalid branch %q: %v", tag, branch, err)
+		}
+		// Non-canonical escapes parse, but the branch re-encodes canonically.
+		if l, b, ok := ParseTag(TagName(label, branch)); !ok || l != label || b != branch {
+			t.Fatalf("re-encoding %q is not stable: %s, %q, %v", tag, l, b, ok)
+		}
+	})
+}
+
+func FuzzParseManifest(f *testing.F) {
+	f.Add([]byte(`{"revision": 1, "responds_to": null, "schema_changes": [{"subject": "a.b", "fields_removed": ["x"]}]}`))
+	f.Add([]byte(`{"revision": "one"}`))
+	f.Add([]byte(`{"depends_on": ["feature/a", null]}`))
+	f.Add([]byte(`[]`))
+	f.Add([]byte(`null`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := ParseManifest(data)
+		if err != nil {
+			return
+		}
+		ValidateSchemaChanges(m)
+		HasSchemaChanges(m)
+		HasDependencies(m)
+
+		encoded, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		again, err := ParseManifest(encoded)
+		if err != nil {
+			t.Fatalf("re-parsing %s: %v", encoded, err)
+		}
+		reencoded, _ := json.Marshal(again)
+		if !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("manifest does not round-trip:\n%s\n%s", encoded, reencoded)
+		}
+	})
+}
+
+// FuzzStateMachine drives a branch through the default pipeline with an
+// arbitrary sequence of requested labels and checks that the Engine applies
+// exactly the valid transitions. Every request carries a resubmitted
+// manifest and a resolved rollback review, so the default guards pass and
+// the rollback and resubmission edges are explored too.
+func FuzzStateMachine(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 4, 5, 7})
+	f.Add([]byte{0, 1, 9, 0, 1, 8, 2, 3, 2})
+	f.Add([]byte{5, 6, 0, 0, 1, 6, 1})
+	f.Add([]byte{0, 1, 2, 4, 5, 7, 9, 0, 1})
+	f.Fuzz(func(t *testing.T, steps []byte) {
+		labels := AllLabels()
+		ml := NewMemoryLabeler()
+		e := NewEngine(ml, nil)
+		rollback := NewRollbackReview(RollbackRecord{Branch: "feature/x", Revision: 1, Cause: "fuzz"})
+		rollback.Comments[0].Resolved = true
+		manifest := &Manifest{Revision: 2, RespondsTo: &rollback.ID}
+		var current Label
+		for _, s := range steps {
+			to := labels[int(s)%len(labels)]
+			_, err := e.ApplyTransition(TransitionRequest{
+				Branch:   "feature/x",
+				To:       to,
+				Metadata: Metadata{Reason: "fuzz"},
+				Manifest: manifest,
+				Reviews:  []Review{rollback},
+			})
+			allowed := CanTransition(current, to) || current == "" && to == Ready
+			switch {
+			case allowed && err != nil:
+				t.Fatalf("%s → %s refused: %v", labelOrNone(current), to, err)
+			case !allowed && err == nil:
+				t.Fatalf("%s → %s applied but is invalid", labelOrNone(current), to)
+			case allowed:
+				current = to
+			}
+			if got, _ := ml.GetLabel("feature/x"); got != current {
+				t.Fatalf("label is %s, want %s", labelOrNone(got), labelOrNone(current))
+			}
+		}
+		history, _ := ml.History("feature/x")
+		if v := ValidateHistory(history); len(v) > 0 {
+			t.Fatalf("invalid history: %v", v)
+		}
+	})
+}
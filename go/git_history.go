@@ -0,0 +1,220 @@
+package cindy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// historyRefPrefix is where GitLabeler keeps per-branch transition history.
+// Each ref points at a chain of empty-tree commits, one per transition, whose
+// message is the JSON-encoded Transition. The chain survives tag deletion and
+// is pushed alongside the label tags.
+const historyRefPrefix = "refs/cindy/history/"
+
+// archiveRefPrefix holds labels archived by Prune, named like their tags:
+// refs/cindy/archive/cindy/<label>/<branch>.
+const archiveRefPrefix = "refs/cindy/archive/"
+
+func historyRef(branch string) string {
+	return historyRefPrefix + escapeBranch(branch)
+}
+
+// History returns the recorded transitions for a branch, oldest first.
+func (gl *GitLabeler) History(branch string) ([]Transition, error) {
+	if err := ValidateBranchName(branch); err != nil {
+		return nil, err
+	}
+	head, err := gl.resolveRef(historyRef(branch))
+	if err != nil || head == "" {
+		return nil, err
+	}
+
+	cmd := exec.Command("git", "-C", gl.repoPath, "log", "--reverse", "--format=%B", head)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("reading history for %s: %w", branch, err)
+	}
+	var history []Transition
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var t Transition
+		if err := json.Unmarshal([]byte(line), &t); err != nil {
+			return nil, fmt.Errorf("reading history for %s: %w", branch, err)
+		}
+		history = append(history, t)
+	}
+	return history, nil
+}
+
+// RawLabels returns every label attached to each branch, including the
+// duplicates that AllLabels collapses.
+func (gl *GitLabeler) RawLabels() (map[string][]Label, error) {
+	idx, err := gl.index()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]Label, len(idx.tags))
+	for branch, tags := range idx.tags {
+		for _, tag := range tags {
+			if l, _, ok := gl.pipeline.ParseTag(tag); ok {
+				result[branch] = append(result[branch], l)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Branches returns the local branches and the branches of the configured remote.
+func (gl *GitLabeler) Branches() (map[string]bool, error) {
+	remotePrefix := "refs/remotes/" + gl.remote + "/"
+	cmd := exec.Command("git", "-C", gl.repoPath, "for-each-ref", "--format=%(refname)", "refs/heads/", remotePrefix)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing branches: %w", err)
+	}
+	branches := make(map[string]bool)
+	for _, ref := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		switch {
+		case strings.HasPrefix(ref, "refs/heads/"):
+			branches[strings.TrimPrefix(ref, "refs/heads/")] = true
+		case strings.HasPrefix(ref, remotePrefix) && ref != remotePrefix+"HEAD":
+			branches[strings.TrimPrefix(ref, remotePrefix)] = true
+		}
+	}
+	return branches, nil
+}
+
+// historyUpdate records t as a new commit for the branch's history ref and
+// returns the update-ref --stdin instruction that moves the ref to it. The
+// instruction names the ref's current value, so the transaction it joins
+// fails if a concurrent writer appended first and neither entry is dropped.
+func (gl *GitLabeler) historyUpdate(t Transition) (string, error) {
+	ref := historyRef(t.Branch)
+	parent, err := gl.resolveRef(ref)
+	if err != nil {
+		return "", err
+	}
+	tree, err := gl.emptyTree()
+	if err != nil {
+		return "", err
+	}
+	msg, err := json.Marshal(t)
+	if err != nil {
+		return "", fmt.Errorf("encoding transition: %w", err)
+	}
+
+	args := []string{"-C", gl.repoPath, "commit-tree", tree, "-m", string(msg)}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	name := t.Actor
+	if name == "" {
+		name = "cindy"
+	}
+	date := t.Timestamp.Format(time.RFC3339)
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+name, "GIT_AUTHOR_EMAIL=cindy@localhost", "GIT_AUTHOR_DATE="+date,
+		"GIT_COMMITTER_NAME="+name, "GIT_COMMITTER_EMAIL=cindy@localhost", "GIT_COMMITTER_DATE="+date,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("recording history for %s: %w", t.Branch, err)
+	}
+
+	commit := strings.TrimSpace(string(out))
+	if parent == "" {
+		return fmt.Sprintf("create %s %s\n", ref, commit), nil
+	}
+	return fmt.Sprintf("update %s %s %s\n", ref, commit, parent), nil
+}
+
+// emptyTree returns the id of the empty tree, writing it if necessary.
+func (gl *GitLabeler) emptyTree() (string, error) {
+	cmd := exec.Command("git", "-C", gl.repoPath, "hash-object", "-t", "tree", "-w", "--stdin")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("writing empty tree: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// resolveRef returns the object a ref points at, or "" if it does not exist.
+func (gl *GitLabeler) resolveRef(ref string) (string, error) {
+	cmd := exec.Command("git", "-C", gl.repoPath, "for-each-ref", "--format=%(objectname)", ref)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", ref, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ArchiveLabel moves the branch's label tag under refs/cindy/archive/ and
+// records the removal in the branch's history, which is kept as is. The
+// previous archived label, the tags and the history are updated in one ref
+// transaction.
+func (gl *GitLabeler) ArchiveLabel(branch string, meta Metadata) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+	idx, err := gl.index()
+	if err != nil {
+		return err
+	}
+	label := idx.labels[branch]
+	if label == "" {
+		return nil
+	}
+	defer gl.Invalidate()
+
+	tag := TagName(label, branch)
+	obj, err := gl.resolveRef("refs/tags/" + tag)
+	if err != nil {
+		return err
+	}
+	archived, err := gl.listRefs(archiveRefPrefix)
+	if err != nil {
+		return err
+	}
+	update, err := gl.historyUpdate(Transition{Branch: branch, From: label, Metadata: meta.stamp()})
+	if err != nil {
+		return err
+	}
+
+	var tx strings.Builder
+	for ref, old := range archived {
+		if _, b, ok := gl.pipeline.ParseTag(strings.TrimPrefix(ref, archiveRefPrefix)); ok && b == branch && ref != archiveRefPrefix+tag {
+			fmt.Fprintf(&tx, "delete %s %s\n", ref, old)
+		}
+	}
+	fmt.Fprintf(&tx, "update %s%s %s\n", archiveRefPrefix, tag, obj)
+	for _, t := range idx.tags[branch] {
+		fmt.Fprintf(&tx, "delete refs/tags/%s\n", t)
+	}
+	tx.WriteString(update)
+	if err := gl.updateRefs(tx.String()); err != nil {
+		return fmt.Errorf("archiving %s: %w", tag, err)
+	}
+	return gl.publish(branch, label, "")
+}
+
+// ArchivedLabels returns the last archived label of each archived branch.
+func (gl *GitLabeler) ArchivedLabels() (map[string]Label, error) {
+	refs, err := gl.listRefs(archiveRefPrefix)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]Label, len(refs))
+	for ref := range refs {
+		if label, branch, ok := gl.pipeline.ParseTag(strings.TrimPrefix(ref, archiveRefPrefix)); ok {
+			result[branch] = label
+		}
+	}
+	return result, nil
+}
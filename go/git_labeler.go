@@ -1,132 +1,742 @@
 package cindy
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 )
 
+// DefaultRemote is the remote GitLabeler pushes to unless configured otherwise.
+const DefaultRemote = "origin"
+
+// remoteRefPrefix is the private namespace Sync fetches remote Cindy tags into.
+const remoteRefPrefix = "refs/cindy/remotes/"
+
+// PushPolicy controls how GitLabeler propagates label changes to its remote.
+type PushPolicy int
+
+const (
+	// PushBestEffort pushes each change immediately. A failed push does not fail
+	// SetLabel; the change stays pending until Push or Sync succeeds.
+	PushBestEffort PushPolicy = iota
+	// PushRequired pushes each change immediately and returns a *PushError from
+	// SetLabel if the push fails. The local change is kept and stays pending.
+	PushRequired
+	// PushDeferred never pushes from SetLabel. Changes accumulate locally until
+	// Push or Sync is called, which suits agents working offline.
+	PushDeferred
+)
+
+func (p PushPolicy) String() string {
+	switch p {
+	case PushBestEffort:
+		return "best-effort"
+	case PushRequired:
+		return "required"
+	case PushDeferred:
+		return "deferred"
+	}
+	return fmt.Sprintf("PushPolicy(%d)", int(p))
+}
+
+// ErrNoRemote is returned when a push is required but the configured remote does not exist.
+var ErrNoRemote = errors.New("remote not configured")
+
+// PushError reports a label change that could not be pushed to the remote.
+type PushError struct {
+	Remote string
+	Branch string
+	Err    error
+}
+
+func (e *PushError) Error() string {
+	return fmt.Sprintf("pushing label for %s to %s: %v", e.Branch, e.Remote, e.Err)
+}
+
+func (e *PushError) Unwrap() error { return e.Err }
+
+// PendingPush is a local label change that has not reached the remote yet.
+// From is the label the remote is believed to hold; To is the local label.
+type PendingPush struct {
+	Branch string
+	From   Label
+	To     Label
+	// Err is the most recent push failure, or nil if the change was deferred.
+	Err error
+}
+
+// SyncConflict describes a branch whose label changed both locally and on the
+// remote since the last successful push.
+type SyncConflict struct {
+	Branch string
+	Base   Label
+	Local  Label
+	Remote Label
+}
+
+func (c SyncConflict) String() string {
+	return fmt.Sprintf("%s: local %s, remote %s (last pushed %s)", c.Branch, labelOrNone(c.Local), labelOrNone(c.Remote), labelOrNone(c.Base))
+}
+
+// SyncReport summarizes the outcome of GitLabeler.Sync.
+type SyncReport struct {
+	// Pushed lists branches whose pending local label was pushed.
+	Pushed []string
+	// Pulled lists branches whose local label was replaced by the remote one.
+	Pulled []string
+	// Conflicts lists branches left untouched because both sides changed.
+	Conflicts []SyncConflict
+}
+
+// GitLabelerOption configures a GitLabeler.
+type GitLabelerOption func(*GitLabeler)
+
+// WithRemote sets the remote name used for pushing and syncing labels.
+func WithRemote(name string) GitLabelerOption {
+	return func(gl *GitLabeler) { gl.remote = name }
+}
+
+// WithPushPolicy sets how label changes are pushed to the remote.
+func WithPushPolicy(p PushPolicy) GitLabelerOption {
+	return func(gl *GitLabeler) { gl.pushPolicy = p }
+}
+
+// WithPipeline sets the pipeline whose labels the labeler accepts and parses.
+// Defaults to DefaultPipeline.
+func WithPipeline(p *Pipeline) GitLabelerOption {
+	return func(gl *GitLabeler) { gl.pipeline = p }
+}
+
 // GitLabeler manages Cindy labels as git tags.
 type GitLabeler struct {
-	repoPath string
+	repoPath   string
+	remote     string
+	pushPolicy PushPolicy
+	pipeline   *Pipeline
+
+	// gitDir is the absolute common git directory, used to detect ref changes.
+	gitDir string
+
+	mu      sync.Mutex
+	pending map[string]*PendingPush
+	cache   *tagIndex
+}
+
+// tagIndex is an in-memory view of the repository's Cindy tags.
+type tagIndex struct {
+	stamp   string
+	labels  map[string]Label
+	tags    map[string][]string
+	byLabel map[Label][]string
 }
 
 // NewGitLabeler creates a new GitLabeler for the given repository path.
 // Returns an error if the path is not a git repository.
-func NewGitLabeler(repoPath string) (*GitLabeler, error) {
+// By default labels are pushed best-effort to DefaultRemote.
+func NewGitLabeler(repoPath string, opts ...GitLabelerOption) (*GitLabeler, error) {
 	cmd := exec.Command("git", "-C", repoPath, "rev-parse", "--git-dir")
 	if err := cmd.Run(); err != nil {
 		return nil, fmt.Errorf("not a git repository: %s", repoPath)
 	}
-	return &GitLabeler{repoPath: repoPath}, nil
+	gl := &GitLabeler{
+		repoPath:   repoPath,
+		remote:     DefaultRemote,
+		pushPolicy: PushBestEffort,
+		pipeline:   DefaultPipeline(),
+		pending:    make(map[string]*PendingPush),
+	}
+	for _, opt := range opts {
+		opt(gl)
+	}
+	out, err := exec.Command("git", "-C", repoPath, "rev-parse", "--path-format=absolute", "--git-common-dir").Output()
+	if err == nil {
+		gl.gitDir = strings.TrimSpace(string(out))
+	}
+	return gl, nil
 }
 
-// GetLabel returns the current Cindy label for a branch by searching git tags.
+// Pipeline returns the pipeline the labeler accepts labels from.
+func (gl *GitLabeler) Pipeline() *Pipeline {
+	return gl.pipeline
+}
+
+// Remote returns the name of the remote labels are pushed to.
+func (gl *GitLabeler) Remote() string {
+	return gl.remote
+}
+
+// GetLabel returns the current Cindy label for a branch from the cached tag index.
 func (gl *GitLabeler) GetLabel(branch string) (Label, error) {
-	tags, err := gl.listTags()
-	if err != nil {
+	if err := ValidateBranchName(branch); err != nil {
 		return "", err
 	}
-
-	for _, tag := range tags {
-		label, tagBranch, ok := ParseTag(tag)
-		if ok && tagBranch == branch {
-			return label, nil
-		}
+	idx, err := gl.index()
+	if err != nil {
+		return "", err
 	}
-	return "", nil
+	return idx.labels[branch], nil
 }
 
 // SetLabel sets the Cindy label for a branch by creating a git tag.
 // Any existing Cindy tag for the branch is deleted first.
-// Pushes to remote best-effort (failure is logged to stderr but not returned).
+// The change is then pushed according to the labeler's PushPolicy.
+// Malformed branch names and unknown labels are rejected before git is invoked.
 func (gl *GitLabeler) SetLabel(branch string, label Label) error {
-	// Delete any existing tag for this branch.
-	tags, err := gl.listTags()
+	return gl.SetLabelWithMetadata(branch, label, Metadata{})
+}
+
+// SetLabelWithMetadata is SetLabel that also appends the transition, with
+// meta, to the branch's history ref. The tags and the history ref are
+// updated together or not at all.
+func (gl *GitLabeler) SetLabelWithMetadata(branch string, label Label, meta Metadata) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+	if err := gl.pipeline.checkLabel(label); err != nil {
+		return err
+	}
+	idx, err := gl.index()
 	if err != nil {
 		return err
 	}
-	for _, tag := range tags {
-		_, tagBranch, ok := ParseTag(tag)
-		if ok && tagBranch == branch {
-			if err := gl.deleteTag(tag); err != nil {
-				return fmt.Errorf("deleting old tag %s: %w", tag, err)
-			}
-			gl.pushDeleteTag(tag)
+	defer gl.Invalidate()
+
+	// The old tags go, the new one is created at HEAD and the transition is
+	// recorded in one ref transaction, so the labels never disagree with
+	// the history.
+	from := idx.labels[branch]
+	update, err := gl.historyUpdate(Transition{Branch: branch, From: from, To: label, Metadata: meta.stamp()})
+	if err != nil {
+		return err
+	}
+	newTag := TagName(label, branch)
+	var tx strings.Builder
+	for _, tag := range idx.tags[branch] {
+		if tag != newTag {
+			fmt.Fprintf(&tx, "delete refs/tags/%s\n", tag)
+		}
+	}
+	fmt.Fprintf(&tx, "update refs/tags/%s HEAD\n", newTag)
+	tx.WriteString(update)
+	if err := gl.updateRefs(tx.String()); err != nil {
+		return fmt.Errorf("setting %s on %s: %w", label, branch, err)
+	}
+	return gl.publish(branch, from, label)
+}
+
+// CompareAndSwapLabel sets the label for a branch if it currently carries
+// old. The tags are replaced in a single ref transaction that fails if any
+// label tag for the branch or its history changed since it was read, so of
+// two concurrent writers expecting the same label exactly one succeeds.
+func (gl *GitLabeler) CompareAndSwapLabel(branch string, old, label Label, meta Metadata) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+	if err := gl.pipeline.checkLabel(label); err != nil {
+		return err
+	}
+	defer gl.Invalidate()
+
+	head, err := exec.Command("git", "-C", gl.repoPath, "rev-parse", "--verify", "HEAD^{commit}").Output()
+	if err != nil {
+		return fmt.Errorf("resolving HEAD: %w", err)
+	}
+	refs, err := gl.listRefs("refs/tags/" + TagPrefix)
+	if err != nil {
+		return err
+	}
+	conflict := func() error {
+		gl.Invalidate()
+		actual, err := gl.GetLabel(branch)
+		if err != nil {
+			return err
 		}
+		return &ConflictError{Branch: branch, Expected: old, Actual: actual}
 	}
 
-	// Create new tag at HEAD.
-	newTag := TagName(label, branch)
-	cmd := exec.Command("git", "-C", gl.repoPath, "tag", newTag, "HEAD")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("creating tag %s: %s", newTag, strings.TrimSpace(string(out)))
+	update, err := gl.historyUpdate(Transition{Branch: branch, From: old, To: label, Metadata: meta.stamp()})
+	if err != nil {
+		return err
 	}
 
-	gl.pushTag(newTag)
-	return nil
+	// Every other label's tag must stay absent; the expected one is
+	// replaced only if it still points where it did. The history entry is
+	// part of the same transaction.
+	var tx strings.Builder
+	for _, l := range gl.pipeline.Labels() {
+		ref := "refs/tags/" + TagName(l, branch)
+		switch {
+		case l == old && refs[ref] == "":
+			return conflict()
+		case l == old && l == label:
+			fmt.Fprintf(&tx, "update %s %s %s\n", ref, strings.TrimSpace(string(head)), refs[ref])
+		case l == old:
+			fmt.Fprintf(&tx, "delete %s %s\n", ref, refs[ref])
+		case l == label:
+			fmt.Fprintf(&tx, "create %s %s\n", ref, strings.TrimSpace(string(head)))
+		default:
+			fmt.Fprintf(&tx, "verify %s\n", ref)
+		}
+	}
+	tx.WriteString(update)
+	if err := gl.updateRefs(tx.String()); errors.Is(err, ErrConflict) {
+		return conflict()
+	} else if err != nil {
+		return err
+	}
+	return gl.publish(branch, old, label)
+}
+
+// RemoveLabel deletes every Cindy tag for a branch and records the removal.
+func (gl *GitLabeler) RemoveLabel(branch string, meta Metadata) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+	idx, err := gl.index()
+	if err != nil {
+		return err
+	}
+	defer gl.Invalidate()
+
+	from := idx.labels[branch]
+	if from == "" {
+		return nil
+	}
+	update, err := gl.historyUpdate(Transition{Branch: branch, From: from, Metadata: meta.stamp()})
+	if err != nil {
+		return err
+	}
+	var tx strings.Builder
+	for _, tag := range idx.tags[branch] {
+		fmt.Fprintf(&tx, "delete refs/tags/%s\n", tag)
+	}
+	tx.WriteString(update)
+	if err := gl.updateRefs(tx.String()); err != nil {
+		return fmt.Errorf("removing label from %s: %w", branch, err)
+	}
+	return gl.publish(branch, from, "")
 }
 
-// AllLabels returns all branches with Cindy labels by parsing git tags.
+// AllLabels returns all branches with Cindy labels from the cached tag index.
 func (gl *GitLabeler) AllLabels() (map[string]Label, error) {
-	tags, err := gl.listTags()
+	idx, err := gl.index()
 	if err != nil {
 		return nil, err
 	}
 
-	result := make(map[string]Label)
-	for _, tag := range tags {
-		label, branch, ok := ParseTag(tag)
-		if ok {
-			result[branch] = label
-		}
+	result := make(map[string]Label, len(idx.labels))
+	for branch, label := range idx.labels {
+		result[branch] = label
 	}
 	return result, nil
 }
 
-func (gl *GitLabeler) listTags() ([]string, error) {
-	cmd := exec.Command("git", "-C", gl.repoPath, "tag", "-l", TagPrefix+"*")
-	out, err := cmd.Output()
+// BranchesWithLabel returns the branches currently carrying label, sorted by name.
+func (gl *GitLabeler) BranchesWithLabel(label Label) ([]string, error) {
+	idx, err := gl.index()
 	if err != nil {
-		return nil, fmt.Errorf("listing tags: %w", err)
+		return nil, err
 	}
-	raw := strings.TrimSpace(string(out))
-	if raw == "" {
-		return nil, nil
+	return append([]string(nil), idx.byLabel[label]...), nil
+}
+
+// Invalidate drops the cached tag index so the next read reloads it from git.
+// Writes through the labeler invalidate automatically; call this only if refs
+// may have changed in a way the cache cannot detect.
+func (gl *GitLabeler) Invalidate() {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	gl.cache = nil
+}
+
+// PendingPushes returns the label changes not yet pushed to the remote, sorted by branch.
+func (gl *GitLabeler) PendingPushes() []PendingPush {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+
+	result := make([]PendingPush, 0, len(gl.pending))
+	for _, p := range gl.pending {
+		result = append(result, *p)
 	}
-	return strings.Split(raw, "\n"), nil
+	sort.Slice(result, func(i, j int) bool { return result[i].Branch < result[j].Branch })
+	return result
 }
 
-func (gl *GitLabeler) deleteTag(tag string) error {
-	cmd := exec.Command("git", "-C", gl.repoPath, "tag", "-d", tag)
+// Push pushes every pending label change to the remote. Changes that fail
+// stay pending; their errors are joined into the returned error.
+func (gl *GitLabeler) Push() error {
+	var errs []error
+	for _, p := range gl.PendingPushes() {
+		err := gl.pushChange(p.Branch, p.From, p.To)
+		gl.settle(p.Branch, p.From, p.To, err)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sync fetches the remote's Cindy tags and reconciles them with local ones.
+// Both are compared with the remote labels as of the last Sync or push,
+// which are kept in refs/cindy/remotes/<remote>/tags, so unpushed changes
+// survive a restart:
+//   - branches changed only locally are pushed
+//   - branches changed only on the remote are updated locally
+//   - branches changed on both sides are reported as conflicts and left untouched
+//
+// Push failures are returned as a joined error alongside the report.
+func (gl *GitLabeler) Sync() (*SyncReport, error) {
+	if !gl.hasRemote() {
+		return nil, fmt.Errorf("syncing labels: %w: %s", ErrNoRemote, gl.remote)
+	}
+
+	mirror := remoteRefPrefix + gl.remote + "/tags/"
+	historyMirror := remoteRefPrefix + gl.remote + "/history/"
+	baseRefs, err := gl.listRefs(mirror)
+	if err != nil {
+		return nil, err
+	}
+	base, baseObj := gl.parseMirror(baseRefs)
+	cmd := exec.Command("git", "-C", gl.repoPath, "fetch", "--prune", "--no-tags", gl.remote,
+		"+refs/tags/"+TagPrefix+"*:"+mirror+"*",
+		"+"+historyRefPrefix+"*:"+historyMirror+"*")
 	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+		return nil, fmt.Errorf("fetching labels from %s: %s", gl.remote, strings.TrimSpace(string(out)))
+	}
+
+	remoteRefs, err := gl.listRefs(mirror)
+	if err != nil {
+		return nil, err
+	}
+	remote, remoteObj := gl.parseMirror(remoteRefs)
+	remoteHistory, err := gl.listRefs(historyMirror)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := gl.AllLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	branches := make(map[string]bool)
+	for _, set := range []map[string]Label{local, remote, base} {
+		for b := range set {
+			branches[b] = true
+		}
+	}
+	gl.mu.Lock()
+	for b := range gl.pending {
+		branches[b] = true
+	}
+	gl.mu.Unlock()
+
+	report := &SyncReport{}
+	var errs []error
+	for _, branch := range sortedBranches(branches) {
+		l, r, b := local[branch], remote[branch], base[branch]
+		switch {
+		case l == r:
+			gl.settle(branch, r, l, nil)
+		case r == b:
+			err := gl.pushChange(branch, r, l)
+			gl.settle(branch, r, l, err)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			report.Pushed = append(report.Pushed, branch)
+		case l == b:
+			history := remoteHistory[historyMirror+escapeBranch(branch)]
+			if err := gl.adopt(branch, l, r, remoteObj[branch], history); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			gl.settle(branch, r, r, nil)
+			report.Pulled = append(report.Pulled, branch)
+		default:
+			// Keep the old base, so the conflict is reported again until
+			// one side gives way.
+			if err := gl.setMirror(branch, b, baseObj[branch]); err != nil {
+				errs = append(errs, err)
+			}
+			report.Conflicts = append(report.Conflicts, SyncConflict{Branch: branch, Base: b, Local: l, Remote: r})
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// parseMirror returns the label and tag object of each branch among the
+// mirrored remote tag refs.
+func (gl *GitLabeler) parseMirror(refs map[string]string) (map[string]Label, map[string]string) {
+	mirror := remoteRefPrefix + gl.remote + "/tags/"
+	labels := make(map[string]Label)
+	objs := make(map[string]string)
+	for ref, obj := range refs {
+		l, branch, ok := gl.pipeline.ParseTag(TagPrefix + strings.TrimPrefix(ref, mirror))
+		if ok {
+			labels[branch] = l
+			objs[branch] = obj
+		}
+	}
+	return labels, objs
+}
+
+// setMirror records label, tagging obj, as the remote label of branch in the
+// mirror Sync compares against. An empty label records none.
+func (gl *GitLabeler) setMirror(branch string, label Label, obj string) error {
+	var tx strings.Builder
+	tx.WriteString("start\n")
+	for _, l := range gl.pipeline.Labels() {
+		ref := remoteRefPrefix + gl.remote + "/tags/" + strings.TrimPrefix(TagName(l, branch), TagPrefix)
+		if l == label {
+			fmt.Fprintf(&tx, "update %s %s\n", ref, obj)
+		} else {
+			fmt.Fprintf(&tx, "delete %s\n", ref)
+		}
+	}
+	tx.WriteString("prepare\ncommit\n")
+	cmd := exec.Command("git", "-C", gl.repoPath, "update-ref", "--stdin")
+	cmd.Stdin = strings.NewReader(tx.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("recording remote label of %s: %s", branch, strings.TrimSpace(string(out)))
 	}
 	return nil
 }
 
-func (gl *GitLabeler) hasRemote() bool {
-	cmd := exec.Command("git", "-C", gl.repoPath, "remote", "get-url", "origin")
-	return cmd.Run() == nil
+// adopt replaces the local label and history of a branch with the remote ones.
+func (gl *GitLabeler) adopt(branch string, local, remote Label, obj, history string) error {
+	defer gl.Invalidate()
+	if history != "" {
+		cmd := exec.Command("git", "-C", gl.repoPath, "update-ref", historyRef(branch), history)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("updating history for %s: %s", branch, strings.TrimSpace(string(out)))
+		}
+	}
+	if local != "" {
+		tag := TagName(local, branch)
+		if err := gl.deleteTag(tag); err != nil {
+			return fmt.Errorf("deleting old tag %s: %w", tag, err)
+		}
+	}
+	if remote == "" {
+		return nil
+	}
+	ref := "refs/tags/" + TagName(remote, branch)
+	cmd := exec.Command("git", "-C", gl.repoPath, "update-ref", ref, obj)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("creating tag %s: %s", TagName(remote, branch), strings.TrimSpace(string(out)))
+	}
+	return nil
 }
 
-func (gl *GitLabeler) pushTag(tag string) {
-	if !gl.hasRemote() {
+// publish pushes or queues a local label change according to the push policy.
+func (gl *GitLabeler) publish(branch string, from, to Label) error {
+	gl.mu.Lock()
+	if p, ok := gl.pending[branch]; ok {
+		// The remote still holds the label from the earliest unpushed change.
+		from = p.From
+	}
+	gl.mu.Unlock()
+
+	switch gl.pushPolicy {
+	case PushDeferred:
+		gl.mu.Lock()
+		gl.pending[branch] = &PendingPush{Branch: branch, From: from, To: to}
+		gl.mu.Unlock()
+		return nil
+	case PushBestEffort:
+		if !gl.hasRemote() {
+			return nil
+		}
+	}
+
+	err := gl.pushChange(branch, from, to)
+	gl.settle(branch, from, to, err)
+	if err != nil && gl.pushPolicy == PushRequired {
+		return err
+	}
+	return nil
+}
+
+// settle records the outcome of a push attempt for a branch.
+func (gl *GitLabeler) settle(branch string, from, to Label, err error) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	if err == nil {
+		delete(gl.pending, branch)
 		return
 	}
-	cmd := exec.Command("git", "-C", gl.repoPath, "push", "origin", tag)
+	gl.pending[branch] = &PendingPush{Branch: branch, From: from, To: to, Err: err}
+}
+
+// pushChange atomically replaces the remote tag for from with the tag for to,
+// together with the branch's history ref, so receivers observe a single
+// transition rather than a delete and a create.
+func (gl *GitLabeler) pushChange(branch string, from, to Label) error {
+	if from == to {
+		return nil
+	}
+	if !gl.hasRemote() {
+		return &PushError{Remote: gl.remote, Branch: branch, Err: ErrNoRemote}
+	}
+	args := []string{"-C", gl.repoPath, "push", "--atomic", gl.remote}
+	if from != "" {
+		args = append(args, ":refs/tags/"+TagName(from, branch))
+	}
+	if to != "" {
+		args = append(args, "refs/tags/"+TagName(to, branch))
+	}
+	if head, _ := gl.resolveRef(historyRef(branch)); head != "" {
+		args = append(args, historyRef(branch))
+	}
+	cmd := exec.Command("git", args...)
 	if out, err := cmd.CombinedOutput(); err != nil {
-		fmt.Fprintf(os.Stderr, "warning: failed to push tag %s: %s\n", tag, strings.TrimSpace(string(out)))
+		return &PushError{Remote: gl.remote, Branch: branch, Err: errors.New(strings.TrimSpace(string(out)))}
+	}
+	// The remote now holds to. Failing to record it only makes the next
+	// Sync see the labels as equal, so the error is not reported.
+	var obj string
+	if to != "" {
+		obj, _ = gl.resolveRef("refs/tags/" + TagName(to, branch))
 	}
+	gl.setMirror(branch, to, obj)
+	return nil
 }
 
-func (gl *GitLabeler) pushDeleteTag(tag string) {
-	if !gl.hasRemote() {
-		return
+// index returns the tag index, reloading it with a single for-each-ref call
+// when the cache is empty or the repository's tag refs have changed.
+func (gl *GitLabeler) index() (*tagIndex, error) {
+	stamp := gl.refStamp()
+
+	gl.mu.Lock()
+	cached := gl.cache
+	gl.mu.Unlock()
+	if cached != nil && stamp != "" && cached.stamp == stamp {
+		return cached, nil
+	}
+
+	cmd := exec.Command("git", "-C", gl.repoPath, "for-each-ref", "--format=%(refname:lstrip=2)", "refs/tags/"+TagPrefix)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing tags: %w", err)
 	}
-	cmd := exec.Command("git", "-C", gl.repoPath, "push", "origin", "--delete", tag)
+
+	idx := &tagIndex{
+		stamp:   stamp,
+		labels:  make(map[string]Label),
+		tags:    make(map[string][]string),
+		byLabel: make(map[Label][]string),
+	}
+	for _, tag := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		label, branch, ok := gl.pipeline.ParseTag(tag)
+		if !ok {
+			continue
+		}
+		if _, seen := idx.labels[branch]; !seen {
+			idx.labels[branch] = label
+			idx.byLabel[label] = append(idx.byLabel[label], branch)
+		}
+		idx.tags[branch] = append(idx.tags[branch], tag)
+	}
+	for _, branches := range idx.byLabel {
+		sort.Strings(branches)
+	}
+
+	gl.mu.Lock()
+	gl.cache = idx
+	gl.mu.Unlock()
+	return idx, nil
+}
+
+// refStamp fingerprints the on-disk tag refs without spawning git: the size
+// and modification time of packed-refs plus every directory under
+// refs/tags/cindy. Creating, deleting or replacing a loose ref renames a file
+// in its directory, which updates that directory's modification time.
+// Returns "" when the refs cannot be inspected, which disables caching.
+func (gl *GitLabeler) refStamp() string {
+	if gl.gitDir == "" {
+		return ""
+	}
+	var b strings.Builder
+	if fi, err := os.Stat(filepath.Join(gl.gitDir, "packed-refs")); err == nil {
+		fmt.Fprintf(&b, "packed:%d:%d;", fi.Size(), fi.ModTime().UnixNano())
+	}
+	root := filepath.Join(gl.gitDir, "refs", "tags", strings.TrimSuffix(TagPrefix, "/"))
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			fmt.Fprintf(&b, "%s:%d;", path, fi.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return b.String()
+}
+
+// listRefs returns the refs under prefix mapped to the object they point at.
+func (gl *GitLabeler) listRefs(prefix string) (map[string]string, error) {
+	cmd := exec.Command("git", "-C", gl.repoPath, "for-each-ref", "--format=%(objectname) %(refname)", prefix)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing refs: %w", err)
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		obj, ref, ok := strings.Cut(line, " ")
+		if ok {
+			refs[ref] = obj
+		}
+	}
+	return refs, nil
+}
+
+// updateRefs applies the update-ref --stdin instructions in tx as one
+// transaction: either every ref moves or none does. It fails with
+// ErrConflict if a ref does not have the old value tx expects.
+func (gl *GitLabeler) updateRefs(tx string) error {
+	var stderr strings.Builder
+	cmd := exec.Command("git", "-C", gl.repoPath, "update-ref", "--stdin")
+	cmd.Stdin = strings.NewReader("start\n" + tx + "prepare\ncommit\n")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		for _, stale := range []string{"but expected", "reference already exists", "unable to resolve reference"} {
+			if strings.Contains(msg, stale) {
+				return fmt.Errorf("%w: %s", ErrConflict, msg)
+			}
+		}
+		return fmt.Errorf("updating refs: %s", msg)
+	}
+	return nil
+}
+
+func (gl *GitLabeler) deleteTag(tag string) error {
+	cmd := exec.Command("git", "-C", gl.repoPath, "tag", "-d", tag)
 	if out, err := cmd.CombinedOutput(); err != nil {
-		fmt.Fprintf(os.Stderr, "warning: failed to delete remote tag %s: %s\n", tag, strings.TrimSpace(string(out)))
+		return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (gl *GitLabeler) hasRemote() bool {
+	cmd := exec.Command("git", "-C", gl.repoPath, "remote", "get-url", gl.remote)
+	return cmd.Run() == nil
+}
+
+func labelOrNone(l Label) string {
+	if l == "" {
+		return "(none)"
 	}
+	return string(l)
 }
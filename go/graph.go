@@ -0,0 +1,222 @@
+package cindy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DOT renders the pipeline's state machine as a Graphviz digraph. Terminal
+// states are drawn with a double border and transition edges are annotated
+// with their guards. Render with e.g. `dot -Tsvg`.
+func (p *Pipeline) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(p.name))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+	b.WriteString("  start [shape=point];\n")
+	fmt.Fprintf(&b, "  start -> %s;\n", dotQuote(string(p.entry)))
+	for _, l := range p.labels {
+		attrs := ""
+		if p.terminal[l] {
+			attrs = " [peripheries=2]"
+		}
+		fmt.Fprintf(&b, "  %s%s;\n", dotQuote(string(l)), attrs)
+	}
+	for _, t := range p.config.Transitions {
+		attrs := ""
+		if len(t.Guards) > 0 {
+			attrs = fmt.Sprintf(" [label=%s]", dotQuote(strings.Join(t.Guards, ", ")))
+		}
+		fmt.Fprintf(&b, "  %s -> %s%s;\n", dotQuote(string(t.From)), dotQuote(string(t.To)), attrs)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the pipeline's state machine as a Mermaid state diagram,
+// suitable for embedding in Markdown.
+func (p *Pipeline) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	b.WriteString("  direction LR\n")
+	for _, l := range p.labels {
+		fmt.Fprintf(&b, "  state %s as %s\n", mermaidQuote(string(l)), mermaidID(l))
+	}
+	fmt.Fprintf(&b, "  [*] --> %s\n", mermaidID(p.entry))
+	for _, t := range p.config.Transitions {
+		fmt.Fprintf(&b, "  %s --> %s", mermaidID(t.From), mermaidID(t.To))
+		if len(t.Guards) > 0 {
+			fmt.Fprintf(&b, " : %s", strings.Join(t.Guards, ", "))
+		}
+		b.WriteString("\n")
+	}
+	for _, l := range p.labels {
+		if p.terminal[l] {
+			fmt.Fprintf(&b, "  %s --> [*]\n", mermaidID(l))
+		}
+	}
+	return b.String()
+}
+
+// LivePipeline is a snapshot of the branches moving through a pipeline: the
+// label of each branch and, where known, its manifest.
+type LivePipeline struct {
+	Pipeline *Pipeline
+	// Labels maps each labeled branch to its current label.
+	Labels map[string]Label
+	// Manifests maps branches to their manifests. Branches without a
+	// manifest are drawn without dependency edges.
+	Manifests map[string]*Manifest
+}
+
+// Snapshot captures the current labels of l. manifests may be nil.
+func Snapshot(l Labeler, manifests map[string]*Manifest) (*LivePipeline, error) {
+	labels, err := l.AllLabels()
+	if err != nil {
+		return nil, err
+	}
+	return &LivePipeline{Pipeline: pipelineOf(l), Labels: labels, Manifests: manifests}, nil
+}
+
+// groups returns the branches grouped by label, in pipeline label order.
+// Labels the pipeline does not declare come last, sorted by name.
+func (lp *LivePipeline) groups() ([]Label, map[Label][]string) {
+	byLabel := make(map[Label][]string)
+	for branch, l := range lp.Labels {
+		byLabel[l] = append(byLabel[l], branch)
+	}
+	var order []Label
+	p := lp.Pipeline
+	if p == nil {
+		p = DefaultPipeline()
+	}
+	for _, l := range p.labels {
+		if len(byLabel[l]) > 0 {
+			order = append(order, l)
+		}
+	}
+	var extra []Label
+	for l := range byLabel {
+		if !p.HasLabel(l) {
+			extra = append(extra, l)
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool { return extra[i] < extra[j] })
+	order = append(order, extra...)
+	for _, branches := range byLabel {
+		sort.Strings(branches)
+	}
+	return order, byLabel
+}
+
+// dependencies returns the depends_on edges as [branch, dependency] pairs,
+// sorted, and the dependencies that carry no label.
+func (lp *LivePipeline) dependencies() ([][2]string, []string) {
+	var edges [][2]string
+	unlabeled := make(map[string]bool)
+	for branch, m := range lp.Manifests {
+		if m == nil {
+			continue
+		}
+		if _, ok := lp.Labels[branch]; !ok {
+			continue
+		}
+		for _, dep := range m.DependsOn {
+			edges = append(edges, [2]string{branch, dep})
+			if _, ok := lp.Labels[dep]; !ok {
+				unlabeled[dep] = true
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i][0] != edges[j][0] {
+			return edges[i][0] < edges[j][0]
+		}
+		return edges[i][1] < edges[j][1]
+	})
+	var missing []string
+	for dep := range unlabeled {
+		missing = append(missing, dep)
+	}
+	sort.Strings(missing)
+	return edges, missing
+}
+
+// DOT renders the live pipeline as a Graphviz digraph: one cluster per label
+// holding its branches, and a dashed edge from each branch to the branches it
+// depends on. Dependencies that carry no label are drawn outside the clusters.
+func (lp *LivePipeline) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph pipeline {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+	order, byLabel := lp.groups()
+	for i, l := range order {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%s;\n", dotQuote(string(l)))
+		for _, branch := range byLabel[l] {
+			fmt.Fprintf(&b, "    %s;\n", dotQuote(branch))
+		}
+		b.WriteString("  }\n")
+	}
+	edges, missing := lp.dependencies()
+	for _, dep := range missing {
+		fmt.Fprintf(&b, "  %s [style=dashed];\n", dotQuote(dep))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %s -> %s [style=dashed, label=\"depends on\"];\n", dotQuote(e[0]), dotQuote(e[1]))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the live pipeline as a Mermaid flowchart with one subgraph
+// per label and a dotted edge from each branch to the branches it depends on.
+func (lp *LivePipeline) Mermaid() string {
+	order, byLabel := lp.groups()
+	edges, missing := lp.dependencies()
+
+	// Branch names are not valid Mermaid ids, so nodes are numbered.
+	ids := make(map[string]string)
+	id := func(branch string) string {
+		if _, ok := ids[branch]; !ok {
+			ids[branch] = fmt.Sprintf("b%d", len(ids))
+		}
+		return ids[branch]
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, l := range order {
+		fmt.Fprintf(&b, "  subgraph %s[%s]\n", mermaidID(l), mermaidQuote(string(l)))
+		for _, branch := range byLabel[l] {
+			fmt.Fprintf(&b, "    %s[%s]\n", id(branch), mermaidQuote(branch))
+		}
+		b.WriteString("  end\n")
+	}
+	for _, dep := range missing {
+		fmt.Fprintf(&b, "  %s[%s]\n", id(dep), mermaidQuote(dep))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %s -.->|depends on| %s\n", id(e[0]), id(e[1]))
+	}
+	return b.String()
+}
+
+// dotQuote returns s as a Graphviz quoted string.
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// mermaidQuote returns s as a Mermaid quoted label.
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
+
+// mermaidID turns a label into a Mermaid identifier. The prefix keeps it
+// apart from branch node ids and from keywords such as end or graph.
+func mermaidID(l Label) string {
+	return "l_" + strings.ReplaceAll(ShortLabel(l), "-", "_")
+}
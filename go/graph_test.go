@@ -0,0 +1,147 @@
+package cindy
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineDOT(t *testing.T) {
+	dot := DefaultPipeline().DOT()
+	for _, want := range []string{
+		`digraph "cindy" {`,
+		`start -> "cindy:ready";`,
+		`"cindy:rejected" [peripheries=2];`,
+		`"cindy:ready" -> "cindy:analyzing";`,
+		`"cindy:rollback" -> "cindy:revision-requested" [label="rollback-review"];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output missing %q:\n%s", want, dot)
+		}
+	}
+	if n := strings.Count(dot, "->"); n != len(DefaultPipeline().Config().Transitions)+1 {
+		t.Errorf("expected one edge per transition plus the entry edge, got %d", n)
+	}
+}
+
+func TestPipelineMermaid(t *testing.T) {
+	m := loadStagingPipeline(t).Mermaid()
+	for _, want := range []string{
+		"stateDiagram-v2\n",
+		`state "cindy:human-review" as l_human_review`,
+		"[*] --> l_ready\n",
+		"l_staging --> l_deploying : staging-soak\n",
+		"l_rejected --> [*]\n",
+	} {
+		if !strings.Contains(m, want) {
+			t.Errorf("Mermaid output missing %q:\n%s", want, m)
+		}
+	}
+}
+
+func TestMermaid_LabelIDs(t *testing.T) {
+	// Labels named like branch node ids or Mermaid keywords stay distinct.
+	p, err := NewPipeline(PipelineConfig{
+		Labels:      []Label{"cindy:b0", "cindy:end", "cindy:graph"},
+		Transitions: []TransitionConfig{{From: "cindy:b0", To: "cindy:end"}, {From: "cindy:end", To: "cindy:graph"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := p.Mermaid(); !strings.Contains(m, "  l_b0 --> l_end\n") || strings.Contains(m, " end\n") {
+		t.Errorf("unexpected Mermaid ids:\n%s", m)
+	}
+	ml := NewMemoryLabeler()
+	ml.SetPipeline(p)
+	walk(t, ml, "feature/a", time.Now(), "cindy:b0")
+	lp, err := Snapshot(ml, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := lp.Mermaid(); !strings.Contains(m, "subgraph l_b0[\"cindy:b0\"]\n    b0[\"feature/a\"]\n") {
+		t.Errorf("unexpected Mermaid ids:\n%s", m)
+	}
+}
+
+func TestLivePipeline(t *testing.T) {
+	at := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	ml := NewMemoryLabeler()
+	walk(t, ml, "feature/a", at, Ready, Analyzing, Approved)
+	walk(t, ml, "feature/b", at, Ready, Analyzing, Blocked)
+	walk(t, ml, "feature/c", at, Ready)
+
+	lp, err := Snapshot(ml, map[string]*Manifest{
+		"feature/b": {DependsOn: []string{"feature/a", "feature/gone"}},
+	})
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	dot := lp.DOT()
+	for _, want := range []string{
+		"label=\"cindy:blocked\";\n    \"feature/b\";",
+		`"feature/gone" [style=dashed];`,
+		`"feature/b" -> "feature/a" [style=dashed, label="depends on"];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output missing %q:\n%s", want, dot)
+		}
+	}
+	if strings.Index(dot, `"cindy:ready"`) > strings.Index(dot, `"cindy:approved"`) {
+		t.Errorf("expected clusters in pipeline order:\n%s", dot)
+	}
+
+	m := lp.Mermaid()
+	for _, want := range []string{
+		"flowchart LR\n",
+		"subgraph l_approved[\"cindy:approved\"]\n    b1[\"feature/a\"]\n  end\n",
+		"b2 -.->|depends on| b1\n",
+		"b2 -.->|depends on| b3\n",
+	} {
+		if !strings.Contains(m, want) {
+			t.Errorf("Mermaid output missing %q:\n%s", want, m)
+		}
+	}
+}
+
+// TestDocsMatchTransitionTable keeps the transition listings in README.md and
+// SPEC.md in sync with the default pipeline.
+func TestDocsMatchTransitionTable(t *testing.T) {
+	want := make(map[string][]string)
+	for _, l := range DefaultPipeline().Labels() {
+		for _, to := range DefaultPipeline().ValidTransitionsFrom(l) {
+			want[string(l)] = append(want[string(l)], string(to))
+		}
+	}
+
+	for _, doc := range []string{"../README.md", "../SPEC.md"} {
+		data, err := os.ReadFile(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string][]string)
+		for _, line := range strings.Split(string(data), "\n") {
+			from, to, ok := strings.Cut(line, "→")
+			if !ok || !strings.HasPrefix(line, "cindy:") {
+				continue
+			}
+			for _, target := range strings.Split(to, "|") {
+				got[strings.TrimSpace(from)] = append(got[strings.TrimSpace(from)], strings.TrimSpace(target))
+			}
+		}
+		for from := range want {
+			sort.Strings(want[from])
+			sort.Strings(got[from])
+			if strings.Join(got[from], " ") != strings.Join(want[from], " ") {
+				t.Errorf("%s: %s → %v, pipeline has %v", doc, from, got[from], want[from])
+			}
+		}
+		for from := range got {
+			if _, ok := want[from]; !ok {
+				t.Errorf("%s lists transitions from %s, pipeline has none", doc, from)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package cindy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metadata describes why and by whom a label was applied (SPEC §3.3).
+type Metadata struct {
+	Actor        string    `json:"actor"`
+	Reason       string    `json:"reason"`
+	Timestamp    time.Time `json:"timestamp"`
+	Dependencies []string  `json:"dependencies,omitempty"`
+	RiskLevel    string    `json:"risk_level,omitempty"`
+	// Correction marks an administrative repair, such as one made by Fsck,
+	// that is exempt from the transition rules.
+	Correction bool `json:"correction,omitempty"`
+	// ActorKind, ActorTeam and ActorRoles record the identity Permissions
+	// authorized the change under.
+	ActorKind  ActorKind `json:"actor_kind,omitempty"`
+	ActorTeam  string    `json:"actor_team,omitempty"`
+	ActorRoles []string  `json:"actor_roles,omitempty"`
+	// ManifestDigest is the ManifestDigest of the change's manifest, so a
+	// signature over the transition also covers the manifest.
+	ManifestDigest string `json:"manifest_sha256,omitempty"`
+	// Signature, if set, is the actor's signature over the transition.
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+// Transition is a recorded label change for a branch.
+// From is empty for a branch's first label; To is empty when a label is removed.
+type Transition struct {
+	Branch string `json:"branch"`
+	From   Label  `json:"from"`
+	To     Label  `json:"to"`
+	Metadata
+}
+
+func (t Transition) String() string {
+	return fmt.Sprintf("%s: %s → %s", t.Branch, labelOrNone(t.From), labelOrNone(t.To))
+}
+
+// RecordingLabeler is a Labeler that records metadata for every change and
+// keeps a per-branch transition history.
+type RecordingLabeler interface {
+	Labeler
+	// SetLabelWithMetadata sets the label for a branch and records the transition.
+	// A zero meta.Timestamp is replaced with the current time.
+	SetLabelWithMetadata(branch string, label Label, meta Metadata) error
+	// RemoveLabel removes any label from a branch and records the transition.
+	RemoveLabel(branch string, meta Metadata) error
+	// History returns the recorded transitions for a branch, oldest first.
+	History(branch string) ([]Transition, error)
+}
+
+// HistoryViolation describes a recorded transition that breaks the state machine.
+type HistoryViolation struct {
+	Index      int
+	Transition Transition
+	Rule       string
+}
+
+func (v HistoryViolation) String() string {
+	return fmt.Sprintf("#%d %s — %s", v.Index, v.Transition, v.Rule)
+}
+
+// ValidateHistory checks a branch's transition history against the default pipeline.
+// See Pipeline.ValidateHistory.
+func ValidateHistory(history []Transition) []HistoryViolation {
+	return DefaultPipeline().ValidateHistory(history)
+}
+
+// ValidateHistory checks a branch's transition history:
+//   - each transition starts from the label the previous one ended on
+//   - a branch enters the pipeline only through its entry label
+//   - every other change is a valid transition of the pipeline
+//
+// Re-applying the current label, removing a label and corrections are always allowed.
+func (p *Pipeline) ValidateHistory(history []Transition) []HistoryViolation {
+	var violations []HistoryViolation
+	var current Label
+	for i, t := range history {
+		switch {
+		case t.Correction:
+		case t.From != current:
+			violations = append(violations, HistoryViolation{
+				Index: i, Transition: t,
+				Rule: fmt.Sprintf("starts from %s but branch was %s", labelOrNone(t.From), labelOrNone(current)),
+			})
+		case t.To == "" || t.From == t.To:
+		case t.From == "" && t.To != p.entry:
+			violations = append(violations, HistoryViolation{
+				Index: i, Transition: t,
+				Rule: "branches must enter the pipeline at " + string(p.entry),
+			})
+		case t.From != "" && !p.CanTransition(t.From, t.To):
+			violations = append(violations, HistoryViolation{
+				Index: i, Transition: t,
+				Rule: "not a valid transition",
+			})
+		}
+		current = t.To
+	}
+	return violations
+}
+
+// stamp fills in defaults for metadata about to be recorded.
+func (m Metadata) stamp() Metadata {
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now().UTC()
+	}
+	return m
+}
+
+// pipelineOf returns the pipeline a Labeler was configured with, or the
+// default pipeline if it does not expose one.
+func pipelineOf(l Labeler) *Pipeline {
+	if pl, ok := l.(interface{ Pipeline() *Pipeline }); ok {
+		if p := pl.Pipeline(); p != nil {
+			return p
+		}
+	}
+	return DefaultPipeline()
+}
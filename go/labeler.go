@@ -1,10 +1,18 @@
 package cindy
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 // TagPrefix is the prefix for all Cindy git tags.
 const TagPrefix = "cindy/"
 
+// ErrConflict is returned when a label was changed by another writer while
+// an operation was in progress.
+var ErrConflict = errors.New("label changed concurrently")
+
 // Labeler manages Cindy labels for branches.
 type Labeler interface {
 	// GetLabel returns the current label for a branch, or ("", nil) if unlabeled.
@@ -13,6 +21,46 @@ type Labeler interface {
 	SetLabel(branch string, label Label) error
 	// AllLabels returns all currently labeled branches.
 	AllLabels() (map[string]Label, error)
+	// BranchesWithLabel returns the branches currently carrying label, sorted by name.
+	BranchesWithLabel(label Label) ([]string, error)
+}
+
+// LabelSwapper is implemented by Labelers that can change a label atomically
+// only if the branch still carries the expected one.
+type LabelSwapper interface {
+	// CompareAndSwapLabel sets the label for a branch to label, recording
+	// meta, if the branch currently carries old ("" meaning unlabeled).
+	// Otherwise it changes nothing and returns a *ConflictError.
+	CompareAndSwapLabel(branch string, old, label Label, meta Metadata) error
+}
+
+// ConflictError reports a compare-and-swap that found a different label than expected.
+type ConflictError struct {
+	Branch   string
+	Expected Label
+	Actual   Label
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: %s is %s, expected %s", ErrConflict, e.Branch, labelOrNone(e.Actual), labelOrNone(e.Expected))
+}
+
+func (e *ConflictError) Unwrap() error { return ErrConflict }
+
+// swapLabel applies label if the branch still carries old. Labelers that
+// are not LabelSwappers get a check followed by a separate write.
+func swapLabel(l Labeler, branch string, old, label Label, meta Metadata) error {
+	if s, ok := l.(LabelSwapper); ok {
+		return s.CompareAndSwapLabel(branch, old, label, meta)
+	}
+	current, err := l.GetLabel(branch)
+	if err != nil {
+		return err
+	}
+	if current != old {
+		return &ConflictError{Branch: branch, Expected: old, Actual: current}
+	}
+	return setLabel(l, branch, label, meta)
 }
 
 // ShortLabel strips the "cindy:" prefix from a label.
@@ -23,33 +71,169 @@ func ShortLabel(l Label) string {
 
 // TagName returns the git tag name for a label and branch.
 // For example, TagName(Approved, "feature/foo") returns "cindy/approved/feature/foo".
+//
+// Bytes outside [A-Za-z0-9._/+-] are percent-encoded (e.g. "%" becomes "%25"),
+// so every branch accepted by ValidateBranchName round-trips through ParseTag
+// and tags stay portable across forges. TagName does not validate branch;
+// Labelers call ValidateBranchName before creating tags.
 func TagName(label Label, branch string) string {
-	return TagPrefix + ShortLabel(label) + "/" + branch
+	return TagPrefix + ShortLabel(label) + "/" + escapeBranch(branch)
 }
 
-// ParseTag parses a git tag name into a label and branch.
-// It tries each known label to find the matching prefix.
+// ParseTag parses a git tag name into a label of the default pipeline and a branch.
 // Returns (label, branch, true) on success, or ("", "", false) if the tag is not a Cindy tag.
 func ParseTag(tag string) (Label, string, bool) {
+	return DefaultPipeline().ParseTag(tag)
+}
+
+// ParseTag parses a git tag name into one of the pipeline's labels and a branch.
+// When several labels match the tag prefix, the longest one wins, so the
+// result does not depend on label order. The branch part is percent-decoded
+// and must be a valid branch name.
+// Returns (label, branch, true) on success, or ("", "", false) if the tag is not a Cindy tag.
+func (p *Pipeline) ParseTag(tag string) (Label, string, bool) {
 	if !strings.HasPrefix(tag, TagPrefix) {
 		return "", "", false
 	}
 	rest := strings.TrimPrefix(tag, TagPrefix)
 
-	for _, l := range allLabels() {
-		short := ShortLabel(l)
-		prefix := short + "/"
-		if strings.HasPrefix(rest, prefix) {
-			branch := strings.TrimPrefix(rest, prefix)
-			if branch != "" {
-				return l, branch, true
-			}
+	var match Label
+	for _, l := range p.labels {
+		prefix := ShortLabel(l) + "/"
+		if strings.HasPrefix(rest, prefix) && len(l) > len(match) {
+			match = l
+		}
+	}
+	if match == "" {
+		return "", "", false
+	}
+	branch, ok := unescapeBranch(strings.TrimPrefix(rest, ShortLabel(match)+"/"))
+	if !ok || ValidateBranchName(branch) != nil {
+		return "", "", false
+	}
+	return match, branch, true
+}
+
+// ErrInvalidBranch is wrapped by errors reporting a malformed branch name.
+var ErrInvalidBranch = errors.New("invalid branch name")
+
+// ErrUnknownLabel is wrapped by errors reporting a label outside the cindy: namespace.
+var ErrUnknownLabel = errors.New("unknown label")
+
+// BranchNameError reports why a branch name was rejected.
+type BranchNameError struct {
+	Branch string
+	Reason string
+}
+
+func (e *BranchNameError) Error() string {
+	return fmt.Sprintf("%s %q: %s", ErrInvalidBranch, e.Branch, e.Reason)
+}
+
+func (e *BranchNameError) Unwrap() error { return ErrInvalidBranch }
+
+// ValidateBranchName checks branch against git's ref naming rules
+// (git-check-ref-format) plus the extra restrictions git applies to branches.
+// It returns a *BranchNameError describing the first violation, or nil.
+func ValidateBranchName(branch string) error {
+	reject := func(reason string) error {
+		return &BranchNameError{Branch: branch, Reason: reason}
+	}
+
+	switch {
+	case branch == "":
+		return reject("empty")
+	case branch == "@":
+		return reject(`cannot be "@"`)
+	case branch == "HEAD":
+		return reject(`cannot be "HEAD"`)
+	case strings.HasPrefix(branch, "-"):
+		return reject(`cannot start with "-"`)
+	case strings.HasPrefix(branch, "/") || strings.HasSuffix(branch, "/"):
+		return reject(`cannot start or end with "/"`)
+	case strings.HasSuffix(branch, "."):
+		return reject(`cannot end with "."`)
+	case strings.Contains(branch, "//"):
+		return reject(`cannot contain "//"`)
+	case strings.Contains(branch, ".."):
+		return reject(`cannot contain ".."`)
+	case strings.Contains(branch, "@{"):
+		return reject(`cannot contain "@{"`)
+	}
+
+	for _, r := range branch {
+		if r < 0x20 || r == 0x7f {
+			return reject("cannot contain control characters")
+		}
+		if strings.ContainsRune(" ~^:?*[\\", r) {
+			return reject(fmt.Sprintf("cannot contain %q", r))
 		}
 	}
-	return "", "", false
+
+	for _, component := range strings.Split(branch, "/") {
+		if strings.HasPrefix(component, ".") {
+			return reject(`path components cannot start with "."`)
+		}
+		if strings.HasSuffix(component, ".lock") {
+			return reject(`path components cannot end with ".lock"`)
+		}
+	}
+	return nil
 }
 
-// allLabels is a private alias to avoid shadowing the Labeler interface method name.
-func allLabels() []Label {
-	return AllLabels()
+// escapeBranch percent-encodes every byte outside the tag-safe set.
+func escapeBranch(branch string) string {
+	var b strings.Builder
+	for i := 0; i < len(branch); i++ {
+		c := branch[i]
+		if isTagSafe(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// unescapeBranch reverses escapeBranch. Bytes that were not escaped (for
+// example in hand-made tags) pass through unchanged; a malformed escape fails.
+func unescapeBranch(s string) (string, bool) {
+	if !strings.Contains(s, "%") {
+		return s, true
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", false
+		}
+		hi, ok1 := unhex(s[i+1])
+		lo, ok2 := unhex(s[i+2])
+		if !ok1 || !ok2 {
+			return "", false
+		}
+		b.WriteByte(hi<<4 | lo)
+		i += 2
+	}
+	return b.String(), true
+}
+
+func isTagSafe(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '.' || c == '_' || c == '/' || c == '+' || c == '-'
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
 }
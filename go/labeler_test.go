@@ -1,8 +1,13 @@
 package cindy
 
 import (
+	"errors"
+	"fmt"
 	"os/exec"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestShortLabel(t *testing.T) {
@@ -327,3 +332,595 @@ func TestGitLabeler_TagPrefix(t *testing.T) {
 	}
 }
 
+// addBareRemote creates a bare repository and registers it as a remote of repo.
+func addBareRemote(t *testing.T, repo, name string) string {
+	t.Helper()
+	bare := t.TempDir()
+
+	cmds := [][]string{
+		{"git", "init", "--bare", bare},
+		{"git", "-C", repo, "remote", "add", name, bare},
+		{"git", "-C", repo, "push", name, "HEAD:refs/heads/main"},
+	}
+	for _, args := range cmds {
+		cmd := exec.Command(args[0], args[1:]...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git setup %v: %s: %v", args, out, err)
+		}
+	}
+	return bare
+}
+
+// remoteTags lists the cindy tags present in a bare repository.
+func remoteTags(t *testing.T, bare string) string {
+	t.Helper()
+	out, err := exec.Command("git", "-C", bare, "tag", "-l", "cindy/*").Output()
+	if err != nil {
+		t.Fatalf("listing remote tags: %v", err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitLabeler_PushBestEffort(t *testing.T) {
+	repo := initGitRepo(t)
+	bare := addBareRemote(t, repo, "upstream")
+
+	gl, err := NewGitLabeler(repo, WithRemote("upstream"))
+	if err != nil {
+		t.Fatalf("NewGitLabeler: %v", err)
+	}
+	if err := gl.SetLabel("feature/test", Ready); err != nil {
+		t.Fatalf("SetLabel: %v", err)
+	}
+	if err := gl.SetLabel("feature/test", Analyzing); err != nil {
+		t.Fatalf("SetLabel: %v", err)
+	}
+	if got := remoteTags(t, bare); got != "cindy/analyzing/feature/test" {
+		t.Errorf("remote tags = %q, want only the analyzing tag", got)
+	}
+	if p := gl.PendingPushes(); len(p) != 0 {
+		t.Errorf("expected no pending pushes, got %v", p)
+	}
+}
+
+func TestGitLabeler_PushRequiredFailure(t *testing.T) {
+	repo := initGitRepo(t)
+	bare := addBareRemote(t, repo, "origin")
+
+	gl, err := NewGitLabeler(repo, WithPushPolicy(PushRequired))
+	if err != nil {
+		t.Fatalf("NewGitLabeler: %v", err)
+	}
+
+	// Break the remote so pushes fail.
+	exec.Command("git", "-C", repo, "remote", "set-url", "origin", bare+"-missing").Run()
+
+	err = gl.SetLabel("feature/test", Ready)
+	var pushErr *PushError
+	if !errors.As(err, &pushErr) {
+		t.Fatalf("expected *PushError, got %v", err)
+	}
+	if pushErr.Branch != "feature/test" || pushErr.Remote != "origin" {
+		t.Errorf("unexpected push error fields: %+v", pushErr)
+	}
+
+	// The local change is kept and stays pending.
+	if label, _ := gl.GetLabel("feature/test"); label != Ready {
+		t.Errorf("expected local label ready, got %s", label)
+	}
+	pending := gl.PendingPushes()
+	if len(pending) != 1 || pending[0].To != Ready || pending[0].Err == nil {
+		t.Fatalf("expected one failed pending push, got %+v", pending)
+	}
+
+	// Once the remote is back, Push flushes the queue.
+	exec.Command("git", "-C", repo, "remote", "set-url", "origin", bare).Run()
+	if err := gl.Push(); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if got := remoteTags(t, bare); got != "cindy/ready/feature/test" {
+		t.Errorf("remote tags = %q", got)
+	}
+	if p := gl.PendingPushes(); len(p) != 0 {
+		t.Errorf("expected no pending pushes, got %v", p)
+	}
+}
+
+func TestGitLabeler_PushRequiredNoRemote(t *testing.T) {
+	repo := initGitRepo(t)
+
+	gl, err := NewGitLabeler(repo, WithPushPolicy(PushRequired))
+	if err != nil {
+		t.Fatalf("NewGitLabeler: %v", err)
+	}
+	if err := gl.SetLabel("feature/test", Ready); !errors.Is(err, ErrNoRemote) {
+		t.Errorf("expected ErrNoRemote, got %v", err)
+	}
+}
+
+func TestGitLabeler_PushDeferred(t *testing.T) {
+	repo := initGitRepo(t)
+	bare := addBareRemote(t, repo, "origin")
+
+	gl, err := NewGitLabeler(repo, WithPushPolicy(PushDeferred))
+	if err != nil {
+		t.Fatalf("NewGitLabeler: %v", err)
+	}
+	gl.SetLabel("feature/test", Ready)
+	gl.SetLabel("feature/test", Analyzing)
+
+	if got := remoteTags(t, bare); got != "" {
+		t.Errorf("expected nothing pushed yet, got %q", got)
+	}
+	pending := gl.PendingPushes()
+	if len(pending) != 1 || pending[0].From != "" || pending[0].To != Analyzing {
+		t.Fatalf("expected coalesced pending push to analyzing, got %+v", pending)
+	}
+
+	if err := gl.Push(); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if got := remoteTags(t, bare); got != "cindy/analyzing/feature/test" {
+		t.Errorf("remote tags = %q", got)
+	}
+}
+
+func TestGitLabeler_Sync(t *testing.T) {
+	repoA := initGitRepo(t)
+	bare := addBareRemote(t, repoA, "origin")
+	repoB := initGitRepo(t)
+	if out, err := exec.Command("git", "-C", repoB, "remote", "add", "origin", bare).CombinedOutput(); err != nil {
+		t.Fatalf("remote add: %s", out)
+	}
+	exec.Command("git", "-C", repoB, "fetch", "origin").Run()
+
+	a, _ := NewGitLabeler(repoA)
+	b, _ := NewGitLabeler(repoB, WithPushPolicy(PushDeferred))
+
+	// Agent A labels two branches and pushes them.
+	a.SetLabel("feature/pulled", Approved)
+	a.SetLabel("feature/conflict", Ready)
+
+	// Agent B works offline: one branch only it knows about, one that A also changed.
+	b.SetLabel("feature/pushed", Ready)
+	b.SetLabel("feature/conflict", Analyzing)
+
+	report, err := b.Sync()
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(report.Pushed) != 1 || report.Pushed[0] != "feature/pushed" {
+		t.Errorf("Pushed = %v", report.Pushed)
+	}
+	if len(report.Pulled) != 1 || report.Pulled[0] != "feature/pulled" {
+		t.Errorf("Pulled = %v", report.Pulled)
+	}
+	if len(report.Conflicts) != 1 {
+		t.Fatalf("Conflicts = %v", report.Conflicts)
+	}
+	c := report.Conflicts[0]
+	if c.Branch != "feature/conflict" || c.Local != Analyzing || c.Remote != Ready || c.Base != "" {
+		t.Errorf("unexpected conflict %+v", c)
+	}
+
+	if label, _ := b.GetLabel("feature/pulled"); label != Approved {
+		t.Errorf("expected pulled label approved, got %s", label)
+	}
+	if label, _ := b.GetLabel("feature/conflict"); label != Analyzing {
+		t.Errorf("expected conflicting local label untouched, got %s", label)
+	}
+	if report, _ := b.Sync(); len(report.Conflicts) != 1 || len(report.Pushed) != 0 {
+		t.Errorf("conflict not reported again: %+v", report)
+	}
+
+	// A now sees B's pushed branch.
+	report, err = a.Sync()
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if label, _ := a.GetLabel("feature/pushed"); label != Ready {
+		t.Errorf("expected A to pull feature/pushed, got %s (report %+v)", label, report)
+	}
+}
+
+func TestGitLabeler_SyncAfterRestart(t *testing.T) {
+	repo := initGitRepo(t)
+	bare := addBareRemote(t, repo, "origin")
+
+	gl, _ := NewGitLabeler(repo, WithPushPolicy(PushDeferred))
+	gl.SetLabel("feature/deferred", Ready)
+	gl.SetLabel("feature/unreachable", Ready)
+	if _, err := gl.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// A deferred change, and a best-effort one while the remote is down.
+	gl.SetLabel("feature/deferred", Analyzing)
+	exec.Command("git", "-C", repo, "remote", "set-url", "origin", bare+"-missing").Run()
+	down, _ := NewGitLabeler(repo)
+	down.SetLabel("feature/unreachable", Analyzing)
+	exec.Command("git", "-C", repo, "remote", "set-url", "origin", bare).Run()
+
+	// A new labeler knows nothing of the pending changes, but still pushes
+	// them rather than reverting them to the remote labels.
+	restarted, _ := NewGitLabeler(repo)
+	report, err := restarted.Sync()
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if strings.Join(report.Pushed, ",") != "feature/deferred,feature/unreachable" || len(report.Pulled) != 0 || len(report.Conflicts) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if got := remoteTags(t, bare); got != "cindy/analyzing/feature/deferred\ncindy/analyzing/feature/unreachable" {
+		t.Errorf("remote tags = %q", got)
+	}
+	for _, b := range []string{"feature/deferred", "feature/unreachable"} {
+		if label, _ := restarted.GetLabel(b); label != Analyzing {
+			t.Errorf("%s: local label %s, want analyzing", b, label)
+		}
+	}
+}
+
+func TestGitLabeler_SyncNoRemote(t *testing.T) {
+	repo := initGitRepo(t)
+	gl, _ := NewGitLabeler(repo, WithRemote("nowhere"))
+	if _, err := gl.Sync(); !errors.Is(err, ErrNoRemote) {
+		t.Errorf("expected ErrNoRemote, got %v", err)
+	}
+}
+
+func TestMemoryLabeler_Concurrent(t *testing.T) {
+	ml := NewMemoryLabeler()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			branch := fmt.Sprintf("feature/agent-%d", i)
+			for _, l := range []Label{Ready, Analyzing, Approved} {
+				if err := ml.SetLabel(branch, l); err != nil {
+					t.Errorf("SetLabel: %v", err)
+				}
+				if _, err := ml.AllLabels(); err != nil {
+					t.Errorf("AllLabels: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	all, _ := ml.AllLabels()
+	if len(all) != 20 {
+		t.Errorf("expected 20 branches, got %d", len(all))
+	}
+	for b, l := range all {
+		if l != Approved {
+			t.Errorf("%s: expected approved, got %s", b, l)
+		}
+	}
+}
+
+func TestMemoryLabeler_FailNextWrites(t *testing.T) {
+	ml := NewMemoryLabeler()
+	ml.SetLabel("feature/test", Ready)
+
+	boom := errors.New("backend down")
+	ml.FailNextWrites(2, boom)
+
+	for i := 0; i < 2; i++ {
+		if err := ml.SetLabel("feature/test", Analyzing); !errors.Is(err, boom) {
+			t.Errorf("write %d: expected injected error, got %v", i, err)
+		}
+	}
+	if label, _ := ml.GetLabel("feature/test"); label != Ready {
+		t.Errorf("failed writes must not change state, got %s", label)
+	}
+
+	if err := ml.SetLabel("feature/test", Analyzing); err != nil {
+		t.Errorf("expected third write to succeed, got %v", err)
+	}
+}
+
+func TestMemoryLabeler_FailNextReads(t *testing.T) {
+	ml := NewMemoryLabeler()
+	ml.FailNextReads(1, nil)
+
+	if _, err := ml.GetLabel("feature/test"); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected ErrInjected, got %v", err)
+	}
+	if _, err := ml.AllLabels(); err != nil {
+		t.Errorf("expected second read to succeed, got %v", err)
+	}
+}
+
+func TestMemoryLabeler_InjectConflict(t *testing.T) {
+	ml := NewMemoryLabeler()
+	ml.SetLabel("feature/test", Analyzing)
+	ml.InjectConflict("feature/test", Rejected)
+
+	if err := ml.SetLabel("feature/test", Approved); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if label, _ := ml.GetLabel("feature/test"); label != Rejected {
+		t.Errorf("expected the concurrent writer's label, got %s", label)
+	}
+	if err := ml.SetLabel("feature/other", Ready); err != nil {
+		t.Errorf("conflict must only affect its branch, got %v", err)
+	}
+}
+
+func TestMemoryLabeler_Latency(t *testing.T) {
+	ml := NewMemoryLabeler()
+	ml.SetLatency(20 * time.Millisecond)
+
+	start := time.Now()
+	ml.GetLabel("feature/test")
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected injected latency, took %s", elapsed)
+	}
+
+	ml.ClearFaults()
+	start = time.Now()
+	ml.GetLabel("feature/test")
+	if elapsed := time.Since(start); elapsed >= 20*time.Millisecond {
+		t.Errorf("expected latency cleared, took %s", elapsed)
+	}
+}
+
+func TestGitLabeler_BranchesWithLabel(t *testing.T) {
+	repo := initGitRepo(t)
+	gl, _ := NewGitLabeler(repo)
+
+	gl.SetLabel("feature/b", Approved)
+	gl.SetLabel("feature/a", Approved)
+	gl.SetLabel("feature/c", Blocked)
+
+	got, err := gl.BranchesWithLabel(Approved)
+	if err != nil {
+		t.Fatalf("BranchesWithLabel: %v", err)
+	}
+	if strings.Join(got, ",") != "feature/a,feature/b" {
+		t.Errorf("expected [feature/a feature/b], got %v", got)
+	}
+
+	got, _ = gl.BranchesWithLabel(Deployed)
+	if len(got) != 0 {
+		t.Errorf("expected no deployed branches, got %v", got)
+	}
+}
+
+func TestGitLabeler_IndexCache(t *testing.T) {
+	repo := initGitRepo(t)
+	gl, _ := NewGitLabeler(repo)
+	gl.SetLabel("feature/test", Ready)
+
+	if _, err := gl.AllLabels(); err != nil {
+		t.Fatalf("AllLabels: %v", err)
+	}
+	first := gl.cache
+	if first == nil {
+		t.Fatal("expected index to be cached after a read")
+	}
+	gl.GetLabel("feature/test")
+	if gl.cache != first {
+		t.Error("expected unchanged refs to reuse the cached index")
+	}
+
+	// A tag created behind the labeler's back must be picked up.
+	if out, err := exec.Command("git", "-C", repo, "tag", "cindy/blocked/feature/external", "HEAD").CombinedOutput(); err != nil {
+		t.Fatalf("git tag: %s", out)
+	}
+	label, err := gl.GetLabel("feature/external")
+	if err != nil {
+		t.Fatalf("GetLabel: %v", err)
+	}
+	if label != Blocked {
+		t.Errorf("expected external tag to invalidate cache, got %q", label)
+	}
+
+	// Packing refs moves them into packed-refs; the index must follow.
+	if out, err := exec.Command("git", "-C", repo, "pack-refs", "--all").CombinedOutput(); err != nil {
+		t.Fatalf("git pack-refs: %s", out)
+	}
+	if out, err := exec.Command("git", "-C", repo, "tag", "-d", "cindy/blocked/feature/external").CombinedOutput(); err != nil {
+		t.Fatalf("git tag -d: %s", out)
+	}
+	if label, _ := gl.GetLabel("feature/external"); label != "" {
+		t.Errorf("expected deleted packed tag to disappear, got %s", label)
+	}
+}
+
+func TestMemoryLabeler_BranchesWithLabel(t *testing.T) {
+	ml := NewMemoryLabeler()
+	ml.SetLabel("feature/b", HumanReview)
+	ml.SetLabel("feature/a", HumanReview)
+	ml.SetLabel("feature/c", Ready)
+
+	got, err := ml.BranchesWithLabel(HumanReview)
+	if err != nil {
+		t.Fatalf("BranchesWithLabel: %v", err)
+	}
+	if strings.Join(got, ",") != "feature/a,feature/b" {
+		t.Errorf("expected [feature/a feature/b], got %v", got)
+	}
+}
+
+func TestValidateBranchName(t *testing.T) {
+	valid := []string{
+		"main", "feature/foo", "approved/x", "cindy/ready/x", "fix-100%", "feat/ünïcode",
+		"a.b", "release/v1.2+build", "user@host", "x/y.locked",
+	}
+	for _, b := range valid {
+		if err := ValidateBranchName(b); err != nil {
+			t.Errorf("ValidateBranchName(%q) = %v, want nil", b, err)
+		}
+	}
+
+	invalid := []string{
+		"", "@", "HEAD", "-foo", "/foo", "foo/", "foo.", "a//b", "a..b", "a@{1}",
+		"has space", "tilde~1", "caret^", "co:lon", "que?", "st*r", "br[acket", `back\slash`,
+		"ctrl\x01", "del\x7f", "feature/.hidden", "feature/x.lock",
+	}
+	for _, b := range invalid {
+		err := ValidateBranchName(b)
+		if !errors.Is(err, ErrInvalidBranch) {
+			t.Errorf("ValidateBranchName(%q) = %v, want ErrInvalidBranch", b, err)
+		}
+		var bne *BranchNameError
+		if errors.As(err, &bne) && bne.Branch != b {
+			t.Errorf("BranchNameError.Branch = %q, want %q", bne.Branch, b)
+		}
+	}
+}
+
+func TestTagName_Escaping(t *testing.T) {
+	tests := []struct {
+		branch string
+		want   string
+	}{
+		{"fix-100%", "cindy/ready/fix-100%25"},
+		{"user@host", "cindy/ready/user%40host"},
+		{"feat/é", "cindy/ready/feat/%C3%A9"},
+		{"release/v1.2+build_3", "cindy/ready/release/v1.2+build_3"},
+	}
+	for _, tt := range tests {
+		if got := TagName(Ready, tt.branch); got != tt.want {
+			t.Errorf("TagName(Ready, %q) = %q, want %q", tt.branch, got, tt.want)
+		}
+	}
+}
+
+func TestParseTag_EscapedAndAmbiguous(t *testing.T) {
+	tests := []struct {
+		tag        string
+		wantLabel  Label
+		wantBranch string
+		wantOK     bool
+	}{
+		// Branches that start with a label name stay attached to the tag's label.
+		{"cindy/ready/approved/x", Ready, "approved/x", true},
+		{"cindy/approved/ready/x", Approved, "ready/x", true},
+		// Escaped and unescaped spellings decode to the same branch.
+		{"cindy/ready/user%40host", Ready, "user@host", true},
+		{"cindy/ready/user@host", Ready, "user@host", true},
+		// Malformed escapes and branch names are rejected.
+		{"cindy/ready/bad%zz", "", "", false},
+		{"cindy/ready/trailing%4", "", "", false},
+		{"cindy/ready/has%20space", "", "", false},
+		{"cindy/ready/a..b", "", "", false},
+	}
+	for _, tt := range tests {
+		label, branch, ok := ParseTag(tt.tag)
+		if !tt.wantOK {
+			if ok {
+				t.Errorf("ParseTag(%q) = (%s, %q), want failure", tt.tag, label, branch)
+			}
+			continue
+		}
+		if !ok || label != tt.wantLabel || branch != tt.wantBranch {
+			t.Errorf("ParseTag(%q) = (%s, %q, %v), want (%s, %q, true)", tt.tag, label, branch, ok, tt.wantLabel, tt.wantBranch)
+		}
+	}
+}
+
+func TestParseTag_RoundTripEscaped(t *testing.T) {
+	branches := []string{"fix-100%", "user@host", "feat/ünïcode", "a{b}c", "semi;colon", "x=y,z"}
+	for _, l := range AllLabels() {
+		for _, b := range branches {
+			gotLabel, gotBranch, ok := ParseTag(TagName(l, b))
+			if !ok || gotLabel != l || gotBranch != b {
+				t.Errorf("round-trip (%s, %q): got (%s, %q, %v)", l, b, gotLabel, gotBranch, ok)
+			}
+		}
+	}
+}
+
+func TestGitLabeler_RejectsMalformedInput(t *testing.T) {
+	repo := initGitRepo(t)
+	gl, _ := NewGitLabeler(repo)
+
+	if err := gl.SetLabel("bad..branch", Ready); !errors.Is(err, ErrInvalidBranch) {
+		t.Errorf("expected ErrInvalidBranch, got %v", err)
+	}
+	if _, err := gl.GetLabel("bad branch"); !errors.Is(err, ErrInvalidBranch) {
+		t.Errorf("expected ErrInvalidBranch, got %v", err)
+	}
+	if err := gl.SetLabel("feature/x", Label("cindy:shipped")); !errors.Is(err, ErrUnknownLabel) {
+		t.Errorf("expected ErrUnknownLabel, got %v", err)
+	}
+}
+
+func TestGitLabeler_EscapedBranch(t *testing.T) {
+	repo := initGitRepo(t)
+	gl, _ := NewGitLabeler(repo)
+
+	branch := "agent/fix-100%"
+	if err := gl.SetLabel(branch, Approved); err != nil {
+		t.Fatalf("SetLabel: %v", err)
+	}
+	if label, _ := gl.GetLabel(branch); label != Approved {
+		t.Errorf("expected approved, got %s", label)
+	}
+	out, _ := exec.Command("git", "-C", repo, "tag", "-l", "cindy/*").Output()
+	if got := strings.TrimSpace(string(out)); got != "cindy/approved/agent/fix-100%25" {
+		t.Errorf("unexpected tag %q", got)
+	}
+}
+
+func TestMemoryLabeler_RejectsMalformedInput(t *testing.T) {
+	ml := NewMemoryLabeler()
+	if err := ml.SetLabel("-rm", Ready); !errors.Is(err, ErrInvalidBranch) {
+		t.Errorf("expected ErrInvalidBranch, got %v", err)
+	}
+	if err := ml.SetLabel("feature/x", Label("ready")); !errors.Is(err, ErrUnknownLabel) {
+		t.Errorf("expected ErrUnknownLabel, got %v", err)
+	}
+}
+
+func TestGitLabeler_CompareAndSwapConcurrent(t *testing.T) {
+	repo := initGitRepo(t)
+	gl, err := NewGitLabeler(repo)
+	if err != nil {
+		t.Fatalf("NewGitLabeler: %v", err)
+	}
+	if err := gl.SetLabel("feature/x", Ready); err != nil {
+		t.Fatal(err)
+	}
+
+	// Separate labelers, as separate processes would be, all racing to
+	// move the branch out of cindy:ready.
+	targets := []Label{Analyzing, Analyzing, Analyzing, Analyzing}
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, to := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			other, _ := NewGitLabeler(repo)
+			errs[i] = other.CompareAndSwapLabel("feature/x", Ready, to, Metadata{Actor: fmt.Sprintf("writer-%d", i)})
+		}()
+	}
+	wg.Wait()
+
+	won := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrConflict):
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("expected exactly one writer to win, got %d (%v)", won, errs)
+	}
+	raw, _ := gl.RawLabels()
+	if got := raw["feature/x"]; len(got) != 1 || got[0] != Analyzing {
+		t.Errorf("expected a single %s tag, got %v", Analyzing, got)
+	}
+	history, _ := gl.History("feature/x")
+	if len(history) != 2 {
+		t.Errorf("expected the losing writers to leave no history, got %v", history)
+	}
+}
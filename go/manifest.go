@@ -2,8 +2,11 @@ package cindy
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"strings"
 )
 
 // SchemaChangeType describes the kind of schema change.
@@ -53,10 +56,44 @@ func LoadManifest(path string) (*Manifest, error) {
 	return ParseManifest(data)
 }
 
+// ManifestPath is where a branch carries its manifest (SPEC §4.1).
+const ManifestPath = ".cindy/manifest.json"
+
+// ErrNoManifest is wrapped by errors reporting a branch without a manifest.
+var ErrNoManifest = errors.New("no manifest")
+
+// LoadBranchManifest reads and parses the manifest committed on a branch of
+// the git repository at repoPath.
+func LoadBranchManifest(repoPath, branch string) (*Manifest, error) {
+	if err := ValidateBranchName(branch); err != nil {
+		return nil, err
+	}
+	data, err := ReadManifestAt(repoPath, "refs/heads/"+branch)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(data)
+}
+
+// ReadManifestAt returns the raw manifest at revision rev (a commit or ref)
+// of the git repository at repoPath.
+func ReadManifestAt(repoPath, rev string) ([]byte, error) {
+	out, err := exec.Command("git", "-C", repoPath, "show", "--end-of-options", rev+":"+ManifestPath).Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return nil, fmt.Errorf("%w: %s has no %s", ErrNoManifest, strings.TrimPrefix(rev, "refs/heads/"), ManifestPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest at %s: %w", rev, err)
+	}
+	return out, nil
+}
+
 // SchemaViolation describes a schema safety rule violation.
 type SchemaViolation struct {
 	Subject string
 	Field   string
+	Code    string // "field-removed" or "field-modified"
 	Rule    string
 }
 
@@ -82,6 +119,7 @@ func ValidateSchemaChanges(m *Manifest) []SchemaViolation {
 			violations = append(violations, SchemaViolation{
 				Subject: sc.Subject,
 				Field:   f,
+				Code:    "field-removed",
 				Rule:    "field removal not allowed (deprecate instead)",
 			})
 		}
@@ -89,6 +127,7 @@ func ValidateSchemaChanges(m *Manifest) []SchemaViolation {
 			violations = append(violations, SchemaViolation{
 				Subject: sc.Subject,
 				Field:   f,
+				Code:    "field-modified",
 				Rule:    "field type modification not allowed (add new field instead)",
 			})
 		}
@@ -1,31 +1,315 @@
 package cindy
 
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the default error returned by MemoryLabeler fault injection.
+var ErrInjected = errors.New("injected failure")
+
 // MemoryLabeler is an in-memory Labeler implementation for testing.
+// It is safe for concurrent use and can inject failures, latency and
+// conflicting writes to exercise orchestrator error handling.
 type MemoryLabeler struct {
-	labels map[string]Label
+	mu       sync.Mutex
+	labels   map[string]Label
+	pipeline *Pipeline
+
+	latency     time.Duration
+	failReads   int
+	readErr     error
+	failWrites  int
+	writeErr    error
+	conflicting map[string]Label
+	history     map[string][]Transition
+	archived    map[string]Label
 }
 
 // NewMemoryLabeler creates a new MemoryLabeler.
 func NewMemoryLabeler() *MemoryLabeler {
-	return &MemoryLabeler{labels: make(map[string]Label)}
+	return &MemoryLabeler{
+		labels:      make(map[string]Label),
+		pipeline:    DefaultPipeline(),
+		conflicting: make(map[string]Label),
+		history:     make(map[string][]Transition),
+		archived:    make(map[string]Label),
+	}
 }
 
 // GetLabel returns the label for a branch, or ("", nil) if not set.
 func (ml *MemoryLabeler) GetLabel(branch string) (Label, error) {
+	if err := ValidateBranchName(branch); err != nil {
+		return "", err
+	}
+	ml.delay()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if err := ml.readFault(); err != nil {
+		return "", err
+	}
 	return ml.labels[branch], nil
 }
 
 // SetLabel sets the label for a branch.
+// Like GitLabeler, it rejects malformed branch names and unknown labels.
 func (ml *MemoryLabeler) SetLabel(branch string, label Label) error {
+	return ml.SetLabelWithMetadata(branch, label, Metadata{})
+}
+
+// SetLabelWithMetadata sets the label for a branch and records the transition.
+func (ml *MemoryLabeler) SetLabelWithMetadata(branch string, label Label, meta Metadata) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+	ml.delay()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if err := ml.pipeline.checkLabel(label); err != nil {
+		return err
+	}
+	if err := ml.writeFault(branch); err != nil {
+		return err
+	}
+	ml.record(branch, label, meta)
 	ml.labels[branch] = label
 	return nil
 }
 
+// CompareAndSwapLabel sets the label for a branch if it currently carries old.
+func (ml *MemoryLabeler) CompareAndSwapLabel(branch string, old, label Label, meta Metadata) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+	ml.delay()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if err := ml.pipeline.checkLabel(label); err != nil {
+		return err
+	}
+	if err := ml.writeFault(branch); err != nil {
+		return err
+	}
+	if current := ml.labels[branch]; current != old {
+		return &ConflictError{Branch: branch, Expected: old, Actual: current}
+	}
+	ml.record(branch, label, meta)
+	ml.labels[branch] = label
+	return nil
+}
+
+// RemoveLabel removes any label from a branch and records the transition.
+func (ml *MemoryLabeler) RemoveLabel(branch string, meta Metadata) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+	ml.delay()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if err := ml.writeFault(branch); err != nil {
+		return err
+	}
+	if _, ok := ml.labels[branch]; !ok {
+		return nil
+	}
+	ml.record(branch, "", meta)
+	delete(ml.labels, branch)
+	return nil
+}
+
+// History returns the recorded transitions for a branch, oldest first.
+func (ml *MemoryLabeler) History(branch string) ([]Transition, error) {
+	ml.delay()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if err := ml.readFault(); err != nil {
+		return nil, err
+	}
+	return append([]Transition(nil), ml.history[branch]...), nil
+}
+
 // AllLabels returns all labeled branches.
 func (ml *MemoryLabeler) AllLabels() (map[string]Label, error) {
+	ml.delay()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if err := ml.readFault(); err != nil {
+		return nil, err
+	}
 	result := make(map[string]Label, len(ml.labels))
 	for k, v := range ml.labels {
 		result[k] = v
 	}
 	return result, nil
 }
+
+// ArchiveLabel moves the branch's label to the archive and records the removal.
+func (ml *MemoryLabeler) ArchiveLabel(branch string, meta Metadata) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+	ml.delay()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if err := ml.writeFault(branch); err != nil {
+		return err
+	}
+	label, ok := ml.labels[branch]
+	if !ok {
+		return nil
+	}
+	ml.record(branch, "", meta)
+	ml.archived[branch] = label
+	delete(ml.labels, branch)
+	return nil
+}
+
+// ArchivedLabels returns the last archived label of each archived branch.
+func (ml *MemoryLabeler) ArchivedLabels() (map[string]Label, error) {
+	ml.delay()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if err := ml.readFault(); err != nil {
+		return nil, err
+	}
+	result := make(map[string]Label, len(ml.archived))
+	for k, v := range ml.archived {
+		result[k] = v
+	}
+	return result, nil
+}
+
+// BranchesWithLabel returns the branches currently carrying label, sorted by name.
+func (ml *MemoryLabeler) BranchesWithLabel(label Label) ([]string, error) {
+	ml.delay()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if err := ml.readFault(); err != nil {
+		return nil, err
+	}
+	var branches []string
+	for b, l := range ml.labels {
+		if l == label {
+			branches = append(branches, b)
+		}
+	}
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// SetPipeline sets the pipeline whose labels the labeler accepts.
+// Defaults to DefaultPipeline.
+func (ml *MemoryLabeler) SetPipeline(p *Pipeline) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.pipeline = p
+}
+
+// Pipeline returns the pipeline the labeler accepts labels from.
+func (ml *MemoryLabeler) Pipeline() *Pipeline {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	return ml.pipeline
+}
+
+// FailNextReads makes the next n read operations fail with err.
+// A nil err means ErrInjected.
+func (ml *MemoryLabeler) FailNextReads(n int, err error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.failReads, ml.readErr = n, err
+}
+
+// FailNextWrites makes the next n write operations fail with err without
+// changing any label. A nil err means ErrInjected.
+func (ml *MemoryLabeler) FailNextWrites(n int, err error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.failWrites, ml.writeErr = n, err
+}
+
+// SetLatency delays every operation by d, simulating a slow backend.
+func (ml *MemoryLabeler) SetLatency(d time.Duration) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.latency = d
+}
+
+// InjectConflict simulates another writer racing on branch: the next write to
+// branch finds label already applied by someone else and fails with ErrConflict.
+func (ml *MemoryLabeler) InjectConflict(branch string, label Label) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.conflicting[branch] = label
+}
+
+// ClearFaults removes all injected failures, latency and conflicts.
+func (ml *MemoryLabeler) ClearFaults() {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.latency = 0
+	ml.failReads, ml.readErr = 0, nil
+	ml.failWrites, ml.writeErr = 0, nil
+	ml.conflicting = make(map[string]Label)
+}
+
+func (ml *MemoryLabeler) delay() {
+	ml.mu.Lock()
+	d := ml.latency
+	ml.mu.Unlock()
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// readFault consumes one injected read failure, if any. Callers hold ml.mu.
+func (ml *MemoryLabeler) readFault() error {
+	if ml.failReads == 0 {
+		return nil
+	}
+	ml.failReads--
+	if ml.readErr != nil {
+		return ml.readErr
+	}
+	return ErrInjected
+}
+
+// writeFault consumes one injected write failure or conflict for branch, if
+// any. Callers hold ml.mu.
+func (ml *MemoryLabeler) writeFault(branch string) error {
+	if ml.failWrites > 0 {
+		ml.failWrites--
+		if ml.writeErr != nil {
+			return ml.writeErr
+		}
+		return ErrInjected
+	}
+	if l, ok := ml.conflicting[branch]; ok {
+		delete(ml.conflicting, branch)
+		ml.record(branch, l, Metadata{Actor: "injected-conflict"})
+		ml.labels[branch] = l
+		return ErrConflict
+	}
+	return nil
+}
+
+// record appends a transition to branch's history. Callers hold ml.mu.
+func (ml *MemoryLabeler) record(branch string, to Label, meta Metadata) {
+	ml.history[branch] = append(ml.history[branch], Transition{
+		Branch:   branch,
+		From:     ml.labels[branch],
+		To:       to,
+		Metadata: meta.stamp(),
+	})
+}
@@ -0,0 +1,240 @@
+package cindy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrMergeConflict is wrapped by errors reporting a branch that cannot be
+// merged into its target.
+var ErrMergeConflict = errors.New("merge conflict")
+
+// MergeConflictError reports why a branch could not be merged.
+type MergeConflictError struct {
+	Branch string
+	Target string
+	// Files are the conflicting paths, if the merge got that far.
+	Files  []string
+	Reason string
+}
+
+func (e *MergeConflictError) Error() string {
+	msg := fmt.Sprintf("%s merging %s into %s: %s", ErrMergeConflict, e.Branch, e.Target, e.Reason)
+	if len(e.Files) > 0 {
+		msg += " (" + strings.Join(e.Files, ", ") + ")"
+	}
+	return msg
+}
+
+func (e *MergeConflictError) Unwrap() error { return ErrMergeConflict }
+
+// MergeStrategy selects how a branch is merged into its target.
+type MergeStrategy string
+
+const (
+	// MergeCommit creates a merge commit with the target and the branch as parents.
+	MergeCommit MergeStrategy = "merge"
+	// MergeFastForward moves the target to the branch. It fails unless the
+	// target is an ancestor of the branch.
+	MergeFastForward MergeStrategy = "fast-forward"
+	// MergeSquash creates a single commit on the target with the branch's changes.
+	MergeSquash MergeStrategy = "squash"
+)
+
+// Merger merges branches into a target branch of a git repository without a
+// working tree: merges are computed with `git merge-tree` and the target ref
+// is updated with compare-and-swap, so a concurrent push to the target is a
+// conflict rather than a lost update. Branches and the target are local
+// branches (refs/heads/).
+type Merger struct {
+	Repo     string
+	Target   string
+	Strategy MergeStrategy // defaults to MergeCommit
+	// AuthorName and AuthorEmail sign the commits the merger creates.
+	// Empty values fall back to the repository's git configuration.
+	AuthorName  string
+	AuthorEmail string
+}
+
+// Merge merges branch into the target and returns the target's new commit.
+// m, if not nil, provides the squash commit message. Merging a branch whose
+// changes the target already contains is a no-op.
+func (mg *Merger) Merge(branch string, m *Manifest) (string, error) {
+	if err := ValidateBranchName(branch); err != nil {
+		return "", err
+	}
+	conflict := func(reason string, files ...string) error {
+		return &MergeConflictError{Branch: branch, Target: mg.Target, Files: files, Reason: reason}
+	}
+
+	target, err := mg.rev("refs/heads/" + mg.Target)
+	if err != nil {
+		return "", err
+	}
+	head, err := mg.rev("refs/heads/" + branch)
+	if err != nil {
+		return "", err
+	}
+	if mg.isAncestor(head, target) {
+		return target, nil
+	}
+
+	var commit string
+	switch mg.Strategy {
+	case MergeFastForward:
+		if !mg.isAncestor(target, head) {
+			return "", conflict(mg.Target + " has diverged; cannot fast-forward")
+		}
+		commit = head
+	case MergeCommit, MergeSquash, "":
+		out, err := mg.git("merge-tree", "--write-tree", "--name-only", "--no-messages", target, head)
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if err != nil {
+			var exit *exec.ExitError
+			if errors.As(err, &exit) && exit.ExitCode() == 1 && len(lines) > 1 {
+				return "", conflict("conflicting changes", lines[1:]...)
+			}
+			return "", fmt.Errorf("merging %s into %s: %w", branch, mg.Target, err)
+		}
+		tree := lines[0]
+		if base, err := mg.git("rev-parse", target+"^{tree}"); err == nil && strings.TrimSpace(base) == tree {
+			// Already contained, e.g. squashed earlier.
+			return target, nil
+		}
+		if mg.Strategy == MergeSquash {
+			msg := "Squashed branch '" + branch + "'"
+			if m != nil && m.Description != "" {
+				msg = m.Description + "\n\n" + msg
+			}
+			commit, err = mg.commitTree(tree, msg, target)
+		} else {
+			commit, err = mg.commitTree(tree, fmt.Sprintf("Merge branch '%s' into %s", branch, mg.Target), target, head)
+		}
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown merge strategy %q", mg.Strategy)
+	}
+
+	if _, err := mg.git("update-ref", "refs/heads/"+mg.Target, commit, target); err != nil {
+		return "", conflict(mg.Target + " changed during the merge")
+	}
+	return commit, nil
+}
+
+func (mg *Merger) rev(ref string) (string, error) {
+	out, err := mg.git("rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", ref, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (mg *Merger) isAncestor(a, b string) bool {
+	_, err := mg.git("merge-base", "--is-ancestor", a, b)
+	return err == nil
+}
+
+func (mg *Merger) commitTree(tree, msg string, parents ...string) (string, error) {
+	args := []string{"commit-tree", tree, "-m", msg}
+	for _, p := range parents {
+		args = append(args, "-p", p)
+	}
+	out, err := mg.git(args...)
+	if err != nil {
+		return "", fmt.Errorf("creating commit: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (mg *Merger) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", mg.Repo}, args...)...)
+	cmd.Env = os.Environ()
+	if mg.AuthorName != "" {
+		cmd.Env = append(cmd.Env, "GIT_AUTHOR_NAME="+mg.AuthorName, "GIT_COMMITTER_NAME="+mg.AuthorName)
+	}
+	if mg.AuthorEmail != "" {
+		cmd.Env = append(cmd.Env, "GIT_AUTHOR_EMAIL="+mg.AuthorEmail, "GIT_COMMITTER_EMAIL="+mg.AuthorEmail)
+	}
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// MergeConflictReviewID returns the ID of the review created when revision
+// of branch could not be merged.
+func MergeConflictReviewID(branch string, revision int) string {
+	return fmt.Sprintf("merge-conflict/%s/%d", branch, revision)
+}
+
+// MergeOptions configures RegisterMerger.
+type MergeOptions struct {
+	// Reviews, if set, stores the review created for a conflict.
+	Reviews ReviewStore
+	// Actor is recorded in the metadata of the transitions and reviews the
+	// merger creates. Defaults to "cindy-merger".
+	Actor string
+}
+
+// RegisterMerger wires mg into e so a branch entering cindy:deployed is
+// merged into the target. When it cannot be merged, the branch moves to
+// cindy:revision-requested with a request_changes review listing the
+// conflicts, which the hook error reports as well.
+func RegisterMerger(e *Engine, mg *Merger, opts MergeOptions) {
+	if opts.Actor == "" {
+		opts.Actor = "cindy-merger"
+	}
+	e.AddHook(AnyLabel, Deployed, "merge", func(tc *TransitionContext) error {
+		_, err := mg.Merge(tc.Branch, tc.Manifest)
+		var conflict *MergeConflictError
+		if !errors.As(err, &conflict) {
+			return err
+		}
+
+		review := mergeConflictReview(conflict, tc.Manifest, opts.Actor)
+		if opts.Reviews != nil {
+			if serr := opts.Reviews.SaveReview(review); serr != nil {
+				return errors.Join(err, serr)
+			}
+		}
+		_, terr := e.ApplyTransition(TransitionRequest{
+			Branch:   tc.Branch,
+			To:       RevisionRequested,
+			Metadata: Metadata{Actor: opts.Actor, Reason: err.Error()},
+			Manifest: tc.Manifest,
+			Reviews:  append(append([]Review(nil), tc.Reviews...), review),
+		})
+		return errors.Join(err, terr)
+	})
+}
+
+func mergeConflictReview(c *MergeConflictError, m *Manifest, actor string) Review {
+	revision := 0
+	if m != nil {
+		revision = m.Revision
+	}
+	r := Review{
+		ID:        MergeConflictReviewID(c.Branch, revision),
+		Branch:    c.Branch,
+		Revision:  revision,
+		Actor:     actor,
+		Verdict:   RequestChanges,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, f := range c.Files {
+		file := f
+		r.Comments = append(r.Comments, ReviewComment{
+			ID:   "conflict:" + f,
+			File: &file,
+			Body: fmt.Sprintf("Conflicts with %s; rebase onto %s and resolve.", c.Target, c.Target),
+		})
+	}
+	if len(r.Comments) == 0 {
+		r.Comments = []ReviewComment{{ID: "merge", Body: fmt.Sprintf("Cannot merge into %s: %s.", c.Target, c.Reason)}}
+	}
+	return r
+}
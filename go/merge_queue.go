@@ -0,0 +1,393 @@
+package cindy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QueueAnalysis is what a MergeQueue's analyzer sees of a rebased branch.
+type QueueAnalysis struct {
+	Branch   string
+	Manifest *Manifest
+	// Commit is the rebased head of the branch.
+	Commit string
+	// Dir is a temporary worktree checked out at Commit, removed once the
+	// analyzer returns. Analyzers can build and test the combined result in it.
+	Dir string
+}
+
+// QueueResult is the outcome for one branch of a MergeQueue run.
+type QueueResult struct {
+	Branch string
+	// To is the label the branch moved to, or empty if it stayed put.
+	To     Label
+	Reason string
+	// Err reports a failed step. When To is set, the transition was applied
+	// and Err comes from its hooks, such as a failed deployment.
+	Err error
+}
+
+// MergeQueue serializes approved branches into deployment. Each run takes
+// the cindy:approved branches in dependency order, then by priority, then
+// first approved first; for each it rebases the branch onto the latest
+// target, reruns analysis on the combined result and only then moves it to
+// cindy:deploying. Because the next branch is rebased after the previous one
+// has been deployed (and merged, see RegisterMerger), two changes that each
+// pass against a stale base cannot both reach production untested together.
+//
+// Branches that cannot be rebased or fail re-analysis move to
+// cindy:revision-requested with a request_changes review, as do branches
+// whose manifest cannot be read; branches whose dependencies have not been
+// deployed move to cindy:blocked, and back to cindy:approved once they have.
+type MergeQueue struct {
+	Engine *Engine
+	Repo   string
+	Target string
+	// Manifests returns a branch's manifest. Defaults to the manifest
+	// committed on the branch (LoadBranchManifest).
+	Manifests func(branch string) (*Manifest, error)
+	// Analyze reruns analysis on a rebased branch; an error sends the branch
+	// back for revision. If nil, only the schema safety rules are checked.
+	Analyze func(ctx context.Context, a QueueAnalysis) error
+	// Priority orders branches whose dependencies allow either order; higher
+	// goes first. If nil, all branches have the same priority.
+	Priority func(branch string, m *Manifest) int
+	// Reviews, if set, stores the reviews created for failed branches.
+	Reviews ReviewStore
+	// Actor is recorded in the metadata of the transitions the queue applies.
+	// Defaults to "cindy-merge-queue".
+	Actor string
+}
+
+type queueEntry struct {
+	branch   string
+	manifest *Manifest
+	priority int
+	approved time.Time
+}
+
+// Run processes every queued branch once, serially, and reports what
+// happened to each. It stops early only if ctx is done or the labeler fails.
+func (q *MergeQueue) Run(ctx context.Context) ([]QueueResult, error) {
+	var results []QueueResult
+	unblocked, err := q.unblock()
+	results = append(results, unblocked...)
+	if err != nil {
+		return results, err
+	}
+
+	order, blocked, err := q.order()
+	if err != nil {
+		return results, err
+	}
+	for _, b := range blocked {
+		if b.err != nil {
+			results = append(results, q.sendBack(queueEntry{branch: b.branch, manifest: &Manifest{}}, "manifest", b.err, nil))
+			continue
+		}
+		results = append(results, q.move(b.branch, Blocked, b.reason, nil))
+	}
+	for _, e := range order {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		results = append(results, q.process(ctx, e))
+	}
+	return results, nil
+}
+
+// Order returns the branches the next Run would process, in order.
+func (q *MergeQueue) Order() ([]string, error) {
+	order, _, err := q.order()
+	var branches []string
+	for _, e := range order {
+		branches = append(branches, e.branch)
+	}
+	return branches, err
+}
+
+type blockedEntry struct {
+	branch, reason string
+	// err is set if the branch's manifest could not be read.
+	err error
+}
+
+// order sorts the approved branches topologically by depends_on, breaking
+// ties by priority, approval time and name. Branches in a dependency cycle
+// or whose manifest cannot be read are returned as blocked.
+func (q *MergeQueue) order() ([]queueEntry, []blockedEntry, error) {
+	l := q.Engine.Labeler()
+	branches, err := l.BranchesWithLabel(Approved)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make(map[string]*queueEntry, len(branches))
+	var blocked []blockedEntry
+	for _, b := range branches {
+		m, err := q.manifest(b)
+		if err != nil {
+			blocked = append(blocked, blockedEntry{branch: b, err: fmt.Errorf("reading manifest: %w", err)})
+			continue
+		}
+		e := &queueEntry{branch: b, manifest: m}
+		if q.Priority != nil {
+			e.priority = q.Priority(b, m)
+		}
+		if rl, ok := l.(RecordingLabeler); ok {
+			history, err := rl.History(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			for i := len(history) - 1; i >= 0; i-- {
+				if history[i].To == Approved {
+					e.approved = history[i].Timestamp
+					break
+				}
+			}
+		}
+		entries[b] = e
+	}
+
+	// waiting counts each entry's dependencies that are still queued.
+	waiting := make(map[string]int)
+	dependents := make(map[string][]string)
+	for b, e := range entries {
+		for _, dep := range e.manifest.DependsOn {
+			if _, queued := entries[dep]; queued {
+				waiting[b]++
+				dependents[dep] = append(dependents[dep], b)
+			}
+		}
+	}
+
+	less := func(a, b *queueEntry) bool {
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if !a.approved.Equal(b.approved) {
+			return a.approved.Before(b.approved)
+		}
+		return a.branch < b.branch
+	}
+	var ready []*queueEntry
+	for b, e := range entries {
+		if waiting[b] == 0 {
+			ready = append(ready, e)
+		}
+	}
+	var order []queueEntry
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return less(ready[i], ready[j]) })
+		e := ready[0]
+		ready = ready[1:]
+		order = append(order, *e)
+		for _, d := range dependents[e.branch] {
+			if waiting[d]--; waiting[d] == 0 {
+				ready = append(ready, entries[d])
+			}
+		}
+	}
+
+	var cyclic []string
+	for b := range entries {
+		if waiting[b] > 0 {
+			cyclic = append(cyclic, b)
+		}
+	}
+	sort.Strings(cyclic)
+	for _, b := range cyclic {
+		blocked = append(blocked, blockedEntry{branch: b, reason: "dependency cycle among " + strings.Join(cyclic, ", ")})
+	}
+	return order, blocked, nil
+}
+
+// unblock moves blocked branches whose dependencies have all been deployed
+// back to cindy:approved. A branch whose manifest cannot be read stays
+// blocked, with the error in its result.
+func (q *MergeQueue) unblock() ([]QueueResult, error) {
+	l := q.Engine.Labeler()
+	branches, err := l.BranchesWithLabel(Blocked)
+	if err != nil {
+		return nil, err
+	}
+	var results []QueueResult
+	for _, b := range branches {
+		m, err := q.manifest(b)
+		if err != nil {
+			results = append(results, QueueResult{Branch: b, Err: fmt.Errorf("reading manifest: %w", err)})
+			continue
+		}
+		pending, err := q.pendingDependencies(m)
+		if err != nil {
+			return results, err
+		}
+		if len(pending) == 0 {
+			results = append(results, q.move(b, Approved, "dependencies deployed", m))
+		}
+	}
+	return results, nil
+}
+
+func (q *MergeQueue) pendingDependencies(m *Manifest) ([]string, error) {
+	var pending []string
+	for _, dep := range m.DependsOn {
+		label, err := q.Engine.Labeler().GetLabel(dep)
+		if err != nil {
+			return nil, err
+		}
+		if label != Deployed {
+			pending = append(pending, fmt.Sprintf("%s (%s)", dep, labelOrNone(label)))
+		}
+	}
+	return pending, nil
+}
+
+func (q *MergeQueue) process(ctx context.Context, e queueEntry) QueueResult {
+	// Dependencies processed earlier in this run may have failed to deploy.
+	pending, err := q.pendingDependencies(e.manifest)
+	if err != nil {
+		return QueueResult{Branch: e.branch, Err: err}
+	}
+	if len(pending) > 0 {
+		return q.move(e.branch, Blocked, "waiting for "+strings.Join(pending, ", "), e.manifest)
+	}
+
+	var analysisErr error
+	_, err = q.rebase(e.branch, func(dir, commit string) error {
+		if q.Analyze != nil {
+			analysisErr = q.Analyze(ctx, QueueAnalysis{Branch: e.branch, Manifest: e.manifest, Commit: commit, Dir: dir})
+		} else if v := ValidateSchemaChanges(e.manifest); len(v) > 0 {
+			analysisErr = fmt.Errorf("%d schema violation(s), first: %s", len(v), v[0])
+		}
+		return analysisErr
+	})
+	var conflict *MergeConflictError
+	switch {
+	case errors.As(err, &conflict):
+		return q.sendBack(e, "rebase-conflict", err, conflict.Files)
+	case analysisErr != nil:
+		return q.sendBack(e, "analysis", fmt.Errorf("re-analysis on %s failed: %w", q.Target, analysisErr), nil)
+	case err != nil:
+		return QueueResult{Branch: e.branch, Err: err}
+	}
+	return q.move(e.branch, Deploying, "rebased onto "+q.Target+" and re-analyzed", e.manifest)
+}
+
+// rebase rebases branch onto the target in a temporary worktree, calls check
+// on the result and, if it passes, moves the branch to the rebased commit.
+func (q *MergeQueue) rebase(branch string, check func(dir, commit string) error) (string, error) {
+	dir, err := os.MkdirTemp("", "cindy-queue-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	wt := dir + "/worktree"
+
+	old, err := q.git("", "rev-parse", "--verify", "refs/heads/"+branch+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", branch, err)
+	}
+	if _, err := q.git("", "worktree", "add", "--detach", wt, old); err != nil {
+		return "", fmt.Errorf("creating worktree for %s: %w", branch, err)
+	}
+	defer q.git("", "worktree", "remove", "--force", wt)
+
+	if _, err := q.git(wt, "rebase", "refs/heads/"+q.Target); err != nil {
+		files, _ := q.git(wt, "diff", "--name-only", "--diff-filter=U")
+		q.git(wt, "rebase", "--abort")
+		return "", &MergeConflictError{Branch: branch, Target: q.Target, Files: strings.Fields(files), Reason: "cannot rebase"}
+	}
+	commit, err := q.git(wt, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if err := check(wt, commit); err != nil {
+		return "", err
+	}
+	if commit != old {
+		if _, err := q.git("", "update-ref", "refs/heads/"+branch, commit, old); err != nil {
+			return "", fmt.Errorf("%w: %s changed during the rebase", ErrConflict, branch)
+		}
+	}
+	return commit, nil
+}
+
+// sendBack moves a branch to cindy:revision-requested with a review explaining why.
+func (q *MergeQueue) sendBack(e queueEntry, kind string, cause error, files []string) QueueResult {
+	review := Review{
+		ID:        fmt.Sprintf("merge-queue/%s/%s/%d", kind, e.branch, e.manifest.Revision),
+		Branch:    e.branch,
+		Revision:  e.manifest.Revision,
+		Actor:     q.actor(),
+		Verdict:   RequestChanges,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, f := range files {
+		file := f
+		review.Comments = append(review.Comments, ReviewComment{
+			ID:   "conflict:" + f,
+			File: &file,
+			Body: fmt.Sprintf("Conflicts with %s; rebase onto %s and resolve.", q.Target, q.Target),
+		})
+	}
+	if len(review.Comments) == 0 {
+		review.Comments = []ReviewComment{{ID: kind, Body: cause.Error()}}
+	}
+	if q.Reviews != nil {
+		if err := q.Reviews.SaveReview(review); err != nil {
+			return QueueResult{Branch: e.branch, Err: err}
+		}
+	}
+	return q.move(e.branch, RevisionRequested, cause.Error(), e.manifest)
+}
+
+func (q *MergeQueue) move(branch string, to Label, reason string, m *Manifest) QueueResult {
+	_, err := q.Engine.ApplyTransition(TransitionRequest{
+		Branch:   branch,
+		To:       to,
+		Metadata: Metadata{Actor: q.actor(), Reason: reason},
+		Manifest: m,
+	})
+	var he *HookError
+	if err != nil && !errors.As(err, &he) {
+		return QueueResult{Branch: branch, Reason: reason, Err: err}
+	}
+	return QueueResult{Branch: branch, To: to, Reason: reason, Err: err}
+}
+
+func (q *MergeQueue) manifest(branch string) (*Manifest, error) {
+	load := q.Manifests
+	if load == nil {
+		load = func(b string) (*Manifest, error) { return LoadBranchManifest(q.Repo, b) }
+	}
+	m, err := load(branch)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = &Manifest{}
+	}
+	return m, nil
+}
+
+func (q *MergeQueue) actor() string {
+	if q.Actor == "" {
+		return "cindy-merge-queue"
+	}
+	return q.Actor
+}
+
+func (q *MergeQueue) git(dir string, args ...string) (string, error) {
+	if dir == "" {
+		dir = q.Repo
+	}
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,256 @@
+package cindy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queueFixture sets up a repository, an engine with a command deployer and
+// a merger into main, and approves the given branches.
+type queueFixture struct {
+	repo      string
+	git       func(args ...string) string
+	ml        *MemoryLabeler
+	queue     *MergeQueue
+	store     *MemoryReviewStore
+	manifests map[string]*Manifest
+	approved  time.Time
+}
+
+func newQueueFixture(t *testing.T) *queueFixture {
+	t.Helper()
+	repo, git := mergeRepo(t)
+	commitFile(t, repo, git, "base.txt", "base\n")
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	RegisterDeployer(e, &CommandDeployer{Commands: DeployCommands{Deploy: "true"}}, DeployOptions{})
+	RegisterMerger(e, &Merger{Repo: repo, Target: "main", Strategy: MergeFastForward}, MergeOptions{})
+
+	f := &queueFixture{repo: repo, git: git, ml: ml, store: NewMemoryReviewStore(), manifests: map[string]*Manifest{},
+		approved: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)}
+	f.queue = &MergeQueue{
+		Engine:    e,
+		Repo:      repo,
+		Target:    "main",
+		Reviews:   f.store,
+		Manifests: func(b string) (*Manifest, error) { return f.manifests[b], nil },
+	}
+	return f
+}
+
+// branch creates branch off main with one commit writing file, and approves
+// it a minute after the previously approved branch.
+func (f *queueFixture) branch(t *testing.T, name, file, content string, m *Manifest) {
+	t.Helper()
+	f.git("checkout", "-q", "-b", name, "main")
+	commitFile(t, f.repo, f.git, file, content)
+	f.git("checkout", "-q", "--detach", "main")
+	if m == nil {
+		m = &Manifest{Revision: 1}
+	}
+	f.manifests[name] = m
+	f.approved = f.approved.Add(time.Minute)
+	walk(t, f.ml, name, f.approved, Ready, Analyzing, Approved)
+}
+
+func resultsByBranch(results []QueueResult) map[string]QueueResult {
+	out := make(map[string]QueueResult)
+	for _, r := range results {
+		out[r.Branch] = r
+	}
+	return out
+}
+
+func TestMergeQueue_Order(t *testing.T) {
+	f := newQueueFixture(t)
+	f.branch(t, "feature/a", "a.txt", "a\n", nil)
+	f.branch(t, "feature/b", "b.txt", "b\n", nil)
+	f.branch(t, "feature/c", "c.txt", "c\n", &Manifest{DependsOn: []string{"feature/a"}})
+	f.branch(t, "feature/urgent", "u.txt", "u\n", nil)
+	f.queue.Priority = func(branch string, m *Manifest) int {
+		if branch == "feature/urgent" {
+			return 10
+		}
+		return 0
+	}
+
+	order, err := f.queue.Order()
+	if err != nil {
+		t.Fatalf("Order: %v", err)
+	}
+	want := []string{"feature/urgent", "feature/a", "feature/b", "feature/c"}
+	if len(order) != len(want) {
+		t.Fatalf("order %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order %v, want %v", order, want)
+		}
+	}
+}
+
+func TestMergeQueue_SerializesDeploys(t *testing.T) {
+	f := newQueueFixture(t)
+	f.branch(t, "feature/a", "a.txt", "a\n", nil)
+	f.branch(t, "feature/b", "b.txt", "b\n", nil)
+
+	results, err := f.queue.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	for _, b := range []string{"feature/a", "feature/b"} {
+		if r := resultsByBranch(results)[b]; r.To != Deploying || r.Err != nil {
+			t.Errorf("%s: unexpected result %+v", b, r)
+		}
+		if label, _ := f.ml.GetLabel(b); label != Deployed {
+			t.Errorf("%s: expected deployed, got %s", b, label)
+		}
+	}
+	// feature/b was rebased onto main after feature/a merged, so main
+	// fast-forwarded to a history containing both.
+	if f.git("rev-parse", "main") != f.git("rev-parse", "feature/b") {
+		t.Error("expected main to be fast-forwarded to the rebased feature/b")
+	}
+	if f.git("ls-tree", "--name-only", "main") != "a.txt\nb.txt\nbase.txt" {
+		t.Errorf("unexpected main tree %q", f.git("ls-tree", "--name-only", "main"))
+	}
+}
+
+func TestMergeQueue_RebaseConflict(t *testing.T) {
+	f := newQueueFixture(t)
+	f.branch(t, "feature/a", "shared.txt", "a\n", nil)
+	f.branch(t, "feature/b", "shared.txt", "b\n", &Manifest{Revision: 3})
+
+	results, err := f.queue.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	r := resultsByBranch(results)["feature/b"]
+	if r.To != RevisionRequested || r.Err != nil {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	reviews, _ := f.store.Reviews("feature/b")
+	if len(reviews) != 1 || reviews[0].Revision != 3 || *reviews[0].Comments[0].File != "shared.txt" {
+		t.Fatalf("expected a review on shared.txt, got %+v", reviews)
+	}
+	if _, err := os.Stat(filepath.Join(f.repo, ".git", "worktrees")); err == nil {
+		if entries, _ := os.ReadDir(filepath.Join(f.repo, ".git", "worktrees")); len(entries) > 0 {
+			t.Errorf("temporary worktrees left behind: %v", entries)
+		}
+	}
+}
+
+func TestMergeQueue_ReanalysisOnCombinedResult(t *testing.T) {
+	f := newQueueFixture(t)
+	f.branch(t, "feature/a", "a.txt", "a\n", nil)
+	f.branch(t, "feature/b", "b.txt", "b\n", nil)
+	// a and b are fine alone but must not ship together.
+	f.queue.Analyze = func(ctx context.Context, a QueueAnalysis) error {
+		_, errA := os.Stat(filepath.Join(a.Dir, "a.txt"))
+		_, errB := os.Stat(filepath.Join(a.Dir, "b.txt"))
+		if errA == nil && errB == nil {
+			return errors.New("a and b are incompatible")
+		}
+		return nil
+	}
+
+	results, err := f.queue.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	got := resultsByBranch(results)
+	if got["feature/a"].To != Deploying || got["feature/b"].To != RevisionRequested {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if f.git("rev-parse", "feature/b") == f.git("rev-parse", "main") {
+		t.Error("a branch failing re-analysis must not be moved to the rebased commit")
+	}
+}
+
+func TestMergeQueue_Dependencies(t *testing.T) {
+	f := newQueueFixture(t)
+	f.branch(t, "feature/b", "b.txt", "b\n", &Manifest{DependsOn: []string{"feature/a"}})
+
+	results, err := f.queue.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if r := resultsByBranch(results)["feature/b"]; r.To != Blocked {
+		t.Fatalf("expected feature/b blocked on its dependency, got %+v", r)
+	}
+
+	f.branch(t, "feature/a", "a.txt", "a\n", nil)
+	results, err = f.queue.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// feature/a deploys first, unblocking feature/b on the next run.
+	if label, _ := f.ml.GetLabel("feature/a"); label != Deployed {
+		t.Fatalf("expected feature/a deployed, got %s (%+v)", label, results)
+	}
+	// The next run unblocks feature/b and, now that it is approved again,
+	// processes it.
+	results, err = f.queue.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(results) != 2 || results[0].To != Approved || results[1].To != Deploying {
+		t.Fatalf("expected feature/b unblocked then deployed, got %+v", results)
+	}
+	if label, _ := f.ml.GetLabel("feature/b"); label != Deployed {
+		t.Errorf("expected feature/b deployed, got %s", label)
+	}
+}
+
+func TestMergeQueue_DependencyCycle(t *testing.T) {
+	f := newQueueFixture(t)
+	f.branch(t, "feature/a", "a.txt", "a\n", &Manifest{DependsOn: []string{"feature/b"}})
+	f.branch(t, "feature/b", "b.txt", "b\n", &Manifest{DependsOn: []string{"feature/a"}})
+
+	results, err := f.queue.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	for _, b := range []string{"feature/a", "feature/b"} {
+		if r := resultsByBranch(results)[b]; r.To != Blocked {
+			t.Errorf("%s: expected blocked by the cycle, got %+v", b, r)
+		}
+	}
+}
+
+func TestMergeQueue_UnreadableManifest(t *testing.T) {
+	f := newQueueFixture(t)
+	f.branch(t, "feature/a", "a.txt", "a\n", nil)
+	f.branch(t, "feature/broken", "b.txt", "b\n", nil)
+	walk(t, f.ml, "feature/stuck", f.approved, Ready, Analyzing, Approved, Blocked)
+	delete(f.manifests, "feature/broken")
+	f.queue.Manifests = func(b string) (*Manifest, error) {
+		if m, ok := f.manifests[b]; ok {
+			return m, nil
+		}
+		return nil, ErrNoManifest
+	}
+
+	results, err := f.queue.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	byBranch := resultsByBranch(results)
+	if r := byBranch["feature/a"]; r.To != Deploying || r.Err != nil {
+		t.Errorf("feature/a: unexpected result %+v", r)
+	}
+	if r := byBranch["feature/broken"]; r.To != RevisionRequested || !strings.Contains(r.Reason, ErrNoManifest.Error()) {
+		t.Errorf("feature/broken: unexpected result %+v", r)
+	}
+	if r := byBranch["feature/stuck"]; r.To != "" || !errors.Is(r.Err, ErrNoManifest) {
+		t.Errorf("feature/stuck: unexpected result %+v", r)
+	}
+	if label, _ := f.ml.GetLabel("feature/stuck"); label != Blocked {
+		t.Errorf("feature/stuck: expected to stay blocked, got %s", label)
+	}
+}
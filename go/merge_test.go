@@ -0,0 +1,160 @@
+package cindy
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mergeRepo creates a repository with a main branch and returns a helper
+// that runs git in it.
+func mergeRepo(t *testing.T) (string, func(args ...string) string) {
+	t.Helper()
+	repo := initGitRepo(t)
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("branch", "-M", "main")
+	return repo, git
+}
+
+// commitFile writes content to name on the current branch and commits it.
+func commitFile(t *testing.T, repo string, git func(...string) string, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", name)
+	git("commit", "-m", "change "+name)
+}
+
+func TestMerger_Strategies(t *testing.T) {
+	for _, strategy := range []MergeStrategy{MergeCommit, MergeSquash, MergeFastForward} {
+		t.Run(string(strategy), func(t *testing.T) {
+			repo, git := mergeRepo(t)
+			git("checkout", "-b", "feature/x")
+			commitFile(t, repo, git, "a.txt", "a\n")
+			commitFile(t, repo, git, "b.txt", "b\n")
+			git("checkout", "main")
+
+			mg := &Merger{Repo: repo, Target: "main", Strategy: strategy}
+			commit, err := mg.Merge("feature/x", &Manifest{Description: "Add a and b"})
+			if err != nil {
+				t.Fatalf("Merge: %v", err)
+			}
+			if head := git("rev-parse", "main"); head != commit {
+				t.Errorf("main is %s, want %s", head, commit)
+			}
+			if files := git("ls-tree", "--name-only", "main"); files != "a.txt\nb.txt" {
+				t.Errorf("unexpected tree %q", files)
+			}
+			parents := strings.Fields(git("log", "-1", "--format=%P", "main"))
+			switch strategy {
+			case MergeCommit:
+				if len(parents) != 2 {
+					t.Errorf("expected a merge commit, got parents %v", parents)
+				}
+			case MergeSquash:
+				if len(parents) != 1 || !strings.HasPrefix(git("log", "-1", "--format=%s", "main"), "Add a and b") {
+					t.Errorf("expected a squash commit with the manifest description, got %v", parents)
+				}
+			case MergeFastForward:
+				if commit != git("rev-parse", "feature/x") {
+					t.Error("expected main to fast-forward to the branch")
+				}
+			}
+
+			again, err := mg.Merge("feature/x", nil)
+			if err != nil || again != commit {
+				t.Errorf("merging again should be a no-op, got %s, %v", again, err)
+			}
+		})
+	}
+}
+
+func TestMerger_Conflicts(t *testing.T) {
+	repo, git := mergeRepo(t)
+	git("checkout", "-b", "feature/x")
+	commitFile(t, repo, git, "shared.txt", "from branch\n")
+	git("checkout", "main")
+	commitFile(t, repo, git, "shared.txt", "from main\n")
+	before := git("rev-parse", "main")
+
+	_, err := (&Merger{Repo: repo, Target: "main"}).Merge("feature/x", nil)
+	var ce *MergeConflictError
+	if !errors.As(err, &ce) || !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("expected MergeConflictError, got %v", err)
+	}
+	if len(ce.Files) != 1 || ce.Files[0] != "shared.txt" {
+		t.Errorf("unexpected conflicting files %v", ce.Files)
+	}
+	if git("rev-parse", "main") != before {
+		t.Error("a failed merge must not move the target")
+	}
+
+	_, err = (&Merger{Repo: repo, Target: "main", Strategy: MergeFastForward}).Merge("feature/x", nil)
+	if !errors.Is(err, ErrMergeConflict) {
+		t.Errorf("expected diverged fast-forward to conflict, got %v", err)
+	}
+}
+
+func TestRegisterMerger(t *testing.T) {
+	repo, git := mergeRepo(t)
+	git("checkout", "-b", "feature/ok")
+	commitFile(t, repo, git, "ok.txt", "ok\n")
+	git("checkout", "main")
+	git("checkout", "-b", "feature/conflict")
+	commitFile(t, repo, git, "shared.txt", "from branch\n")
+	git("checkout", "main")
+	commitFile(t, repo, git, "shared.txt", "from main\n")
+
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	store := NewMemoryReviewStore()
+	RegisterMerger(e, &Merger{Repo: repo, Target: "main"}, MergeOptions{Reviews: store})
+
+	deploy := func(branch string) error {
+		for _, to := range []Label{Ready, Analyzing, Approved, Deploying} {
+			if _, err := e.ApplyTransition(TransitionRequest{Branch: branch, To: to}); err != nil {
+				t.Fatalf("→ %s: %v", to, err)
+			}
+		}
+		_, err := e.ApplyTransition(TransitionRequest{Branch: branch, To: Deployed, Manifest: &Manifest{Revision: 2}})
+		return err
+	}
+
+	if err := deploy("feature/ok"); err != nil {
+		t.Fatalf("deploying feature/ok: %v", err)
+	}
+	if label, _ := ml.GetLabel("feature/ok"); label != Deployed {
+		t.Errorf("expected feature/ok to stay deployed, got %s", label)
+	}
+	if git("show", "main:ok.txt") != "ok" {
+		t.Error("feature/ok was not merged")
+	}
+
+	err := deploy("feature/conflict")
+	if !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("expected merge conflict hook error, got %v", err)
+	}
+	if label, _ := ml.GetLabel("feature/conflict"); label != RevisionRequested {
+		t.Errorf("expected revision-requested, got %s", label)
+	}
+	reviews, _ := store.Reviews("feature/conflict")
+	if len(reviews) != 1 || reviews[0].ID != MergeConflictReviewID("feature/conflict", 2) || !IsBlocking(&reviews[0]) {
+		t.Fatalf("expected a blocking merge conflict review, got %+v", reviews)
+	}
+	if c := reviews[0].Comments[0]; c.File == nil || *c.File != "shared.txt" {
+		t.Errorf("expected a comment on shared.txt, got %+v", c)
+	}
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"time"
+
+	cindy "github.com/nimsforest/cindy/go"
+)
+
+// Instrument returns l with the latency and errors of every call recorded,
+// and makes m report l's labels. The returned Labeler keeps l's pipeline
+// and, if l has them, its cindy.RecordingLabeler and cindy.LabelSwapper
+// methods; other optional interfaces are not passed through.
+func (m *Metrics) Instrument(l cindy.Labeler) cindy.Labeler {
+	m.mu.Lock()
+	m.labeler = l
+	m.mu.Unlock()
+
+	base := &timedLabeler{l: l, m: m}
+	rl, recording := l.(cindy.RecordingLabeler)
+	s, swapping := l.(cindy.LabelSwapper)
+	switch {
+	case recording && swapping:
+		return &timedRecordingSwapper{&timedRecorder{base, rl}, s}
+	case recording:
+		return &timedRecorder{base, rl}
+	case swapping:
+		return &timedSwapper{base, s}
+	}
+	return base
+}
+
+type timedLabeler struct {
+	l cindy.Labeler
+	m *Metrics
+}
+
+func (t *timedLabeler) GetLabel(branch string) (cindy.Label, error) {
+	start := time.Now()
+	label, err := t.l.GetLabel(branch)
+	t.m.observeCall("GetLabel", start, err)
+	return label, err
+}
+
+func (t *timedLabeler) SetLabel(branch string, label cindy.Label) error {
+	start := time.Now()
+	err := t.l.SetLabel(branch, label)
+	t.m.observeCall("SetLabel", start, err)
+	return err
+}
+
+func (t *timedLabeler) AllLabels() (map[string]cindy.Label, error) {
+	start := time.Now()
+	labels, err := t.l.AllLabels()
+	t.m.observeCall("AllLabels", start, err)
+	return labels, err
+}
+
+func (t *timedLabeler) BranchesWithLabel(label cindy.Label) ([]string, error) {
+	start := time.Now()
+	branches, err := t.l.BranchesWithLabel(label)
+	t.m.observeCall("BranchesWithLabel", start, err)
+	return branches, err
+}
+
+// Pipeline returns the pipeline of the wrapped Labeler, so engines built
+// over the instrumented one enforce the same pipeline.
+func (t *timedLabeler) Pipeline() *cindy.Pipeline {
+	if pl, ok := t.l.(interface{ Pipeline() *cindy.Pipeline }); ok {
+		return pl.Pipeline()
+	}
+	return nil
+}
+
+func (t *timedLabeler) compareAndSwap(s cindy.LabelSwapper, branch string, old, label cindy.Label, meta cindy.Metadata) error {
+	start := time.Now()
+	err := s.CompareAndSwapLabel(branch, old, label, meta)
+	t.m.observeCall("CompareAndSwapLabel", start, err)
+	return err
+}
+
+type timedRecorder struct {
+	*timedLabeler
+	rl cindy.RecordingLabeler
+}
+
+func (t *timedRecorder) SetLabelWithMetadata(branch string, label cindy.Label, meta cindy.Metadata) error {
+	start := time.Now()
+	err := t.rl.SetLabelWithMetadata(branch, label, meta)
+	t.m.observeCall("SetLabelWithMetadata", start, err)
+	return err
+}
+
+func (t *timedRecorder) RemoveLabel(branch string, meta cindy.Metadata) error {
+	start := time.Now()
+	err := t.rl.RemoveLabel(branch, meta)
+	t.m.observeCall("RemoveLabel", start, err)
+	return err
+}
+
+func (t *timedRecorder) History(branch string) ([]cindy.Transition, error) {
+	start := time.Now()
+	history, err := t.rl.History(branch)
+	t.m.observeCall("History", start, err)
+	return history, err
+}
+
+type timedSwapper struct {
+	*timedLabeler
+	s cindy.LabelSwapper
+}
+
+func (t *timedSwapper) CompareAndSwapLabel(branch string, old, label cindy.Label, meta cindy.Metadata) error {
+	return t.compareAndSwap(t.s, branch, old, label, meta)
+}
+
+type timedRecordingSwapper struct {
+	*timedRecorder
+	s cindy.LabelSwapper
+}
+
+func (t *timedRecordingSwapper) CompareAndSwapLabel(branch string, old, label cindy.Label, meta cindy.Metadata) error {
+	return t.compareAndSwap(t.s, branch, old, label, meta)
+}
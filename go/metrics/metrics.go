@@ -0,0 +1,384 @@
+// Package metrics exposes the flow of a Cindy pipeline as Prometheus
+// metrics: how many branches carry each label, which transitions are
+// applied and refused, how long branches stay in each state, and how fast
+// the Labeler answers.
+//
+//	m := metrics.New()
+//	engine := cindy.NewEngine(m.Instrument(labeler), nil)
+//	m.Register(engine)
+//	http.Handle("/metrics", m)
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cindy "github.com/nimsforest/cindy/go"
+)
+
+// timeInStateBuckets spans minutes to a week, the range changes spend in
+// review and analysis.
+var timeInStateBuckets = []float64{60, 300, 900, 1800, 3600, 2 * 3600, 4 * 3600, 8 * 3600, 24 * 3600, 2 * 24 * 3600, 7 * 24 * 3600}
+
+// latencyBuckets spans in-memory lookups to git pushes.
+var latencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects pipeline metrics and serves them in the Prometheus text
+// exposition format. It is safe for concurrent use.
+type Metrics struct {
+	mu       sync.Mutex
+	labeler  cindy.Labeler
+	pipeline *cindy.Pipeline
+
+	transitions   map[transitionKey]float64
+	rejections    map[string]float64
+	timeInState   map[cindy.Label]*histogram
+	latency       map[string]*histogram
+	labelerErrors map[string]float64
+}
+
+type transitionKey struct {
+	from, to cindy.Label
+	actor    string
+}
+
+// New returns empty Metrics. Label counts need a Labeler, from Instrument
+// or Register.
+func New() *Metrics {
+	return &Metrics{
+		transitions:   make(map[transitionKey]float64),
+		rejections:    make(map[string]float64),
+		timeInState:   make(map[cindy.Label]*histogram),
+		latency:       make(map[string]*histogram),
+		labelerErrors: make(map[string]float64),
+	}
+}
+
+// Register counts the transitions e applies and refuses, and reports the
+// labels of e's Labeler unless Instrument named one. Transitions applied
+// without e, e.g. by agents pushing label tags, are not seen; feed those to
+// Observe instead, e.g. from cindy.WatchLabels, and register only
+// m.Rejected with e.OnReject.
+func (m *Metrics) Register(e *cindy.Engine) {
+	m.mu.Lock()
+	if m.labeler == nil {
+		m.labeler = e.Labeler()
+	}
+	m.pipeline = e.Pipeline()
+	m.mu.Unlock()
+	e.AddHook(cindy.AnyLabel, cindy.AnyLabel, "metrics", func(ctx *cindy.TransitionContext) error {
+		m.Observe(cindy.Transition{Branch: ctx.Branch, From: ctx.From, To: ctx.To, Metadata: ctx.Metadata})
+		return nil
+	})
+	e.OnReject(m.Rejected)
+}
+
+// Observe counts a transition. If the Labeler records history, the time
+// the branch spent in t.From is observed too.
+func (m *Metrics) Observe(t cindy.Transition) {
+	m.mu.Lock()
+	l := m.labeler
+	m.transitions[transitionKey{t.From, t.To, t.Actor}]++
+	m.mu.Unlock()
+
+	rl, ok := l.(cindy.RecordingLabeler)
+	if !ok || t.From == "" {
+		return
+	}
+	history, err := rl.History(t.Branch)
+	if err != nil {
+		return
+	}
+	entered, ok := enteredAt(history, t)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.timeInState[t.From]
+	if h == nil {
+		h = newHistogram(timeInStateBuckets)
+		m.timeInState[t.From] = h
+	}
+	h.observe(t.Timestamp.Sub(entered).Seconds())
+}
+
+// enteredAt returns when the branch last entered t.From before t.
+func enteredAt(history []cindy.Transition, t cindy.Transition) (time.Time, bool) {
+	for i := len(history) - 1; i >= 0; i-- {
+		h := history[i]
+		if h.To == t.From && !h.Timestamp.After(t.Timestamp) {
+			return h.Timestamp, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Rejected counts a refused transition under its RejectionCodes. It is a
+// cindy.RejectHook.
+func (m *Metrics) Rejected(ctx *cindy.TransitionContext, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, code := range RejectionCodes(err) {
+		m.rejections[code]++
+	}
+}
+
+// RejectionCodes classifies why the engine refused a transition:
+// "invalid-branch", "unknown-label", "conflict", "invalid-transition",
+// "permission-denied", the code of each schema violation (e.g.
+// "field-removed"), "guard:<name>" for other refusing guards, or "error".
+// Each code is reported once.
+func RejectionCodes(err error) []string {
+	var codes []string
+	var classify func(err error)
+	classify = func(err error) {
+		if j, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range j.Unwrap() {
+				classify(e)
+			}
+			return
+		}
+		var sv *cindy.SchemaViolationError
+		var ge *cindy.GuardError
+		switch {
+		case errors.As(err, &sv):
+			for _, v := range sv.Violations {
+				codes = appendNew(codes, v.Code)
+			}
+		case errors.As(err, &ge):
+			codes = appendNew(codes, "guard:"+ge.Guard)
+		case errors.Is(err, cindy.ErrInvalidBranch):
+			codes = appendNew(codes, "invalid-branch")
+		case errors.Is(err, cindy.ErrUnknownLabel):
+			codes = appendNew(codes, "unknown-label")
+		case errors.Is(err, cindy.ErrConflict):
+			codes = appendNew(codes, "conflict")
+		case errors.Is(err, cindy.ErrInvalidTransition):
+			codes = appendNew(codes, "invalid-transition")
+		case errors.Is(err, cindy.ErrPermissionDenied):
+			codes = appendNew(codes, "permission-denied")
+		default:
+			codes = appendNew(codes, "error")
+		}
+	}
+	classify(err)
+	return codes
+}
+
+func appendNew(list []string, s string) []string {
+	for _, x := range list {
+		if x == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// observeCall records the latency and outcome of a Labeler call.
+func (m *Metrics) observeCall(op string, start time.Time, err error) {
+	d := time.Since(start).Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.latency[op]
+	if h == nil {
+		h = newHistogram(latencyBuckets)
+		m.latency[op] = h
+	}
+	h.observe(d)
+	if err != nil {
+		m.labelerErrors[op]++
+	}
+}
+
+// ServeHTTP writes the metrics in the text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// WriteTo writes the metrics to w in the text exposition format. Label
+// counts are read from the Labeler at the time of the call.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	l, p := m.labeler, m.pipeline
+	m.mu.Unlock()
+
+	counts := make(map[cindy.Label]float64)
+	if l != nil {
+		if p == nil {
+			p = pipelineOf(l)
+		}
+		for _, label := range p.Labels() {
+			counts[label] = 0
+		}
+		all, err := l.AllLabels()
+		if err != nil {
+			return 0, fmt.Errorf("reading labels: %w", err)
+		}
+		for _, label := range all {
+			counts[label]++
+		}
+	}
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(cw, "cindy_branches", "gauge", "Branches currently carrying each label.")
+	for _, label := range sortedKeys(counts) {
+		sample(cw, "cindy_branches", counts[label], "label", string(label))
+	}
+
+	header(cw, "cindy_transitions_total", "counter", "Label transitions applied, by from, to and actor.")
+	keys := make([]transitionKey, 0, len(m.transitions))
+	for k := range m.transitions {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.from != b.from {
+			return a.from < b.from
+		}
+		if a.to != b.to {
+			return a.to < b.to
+		}
+		return a.actor < b.actor
+	})
+	for _, k := range keys {
+		sample(cw, "cindy_transitions_total", m.transitions[k], "from", string(k.from), "to", string(k.to), "actor", k.actor)
+	}
+
+	header(cw, "cindy_transition_rejections_total", "counter", "Transitions refused by the engine, by reason code.")
+	for _, code := range sortedKeys(m.rejections) {
+		sample(cw, "cindy_transition_rejections_total", m.rejections[code], "code", code)
+	}
+
+	header(cw, "cindy_time_in_state_seconds", "histogram", "Time branches spent in a label before leaving it.")
+	for _, label := range sortedKeys(m.timeInState) {
+		m.timeInState[label].write(cw, "cindy_time_in_state_seconds", "label", string(label))
+	}
+
+	header(cw, "cindy_labeler_duration_seconds", "histogram", "Latency of Labeler calls, by operation.")
+	for _, op := range sortedKeys(m.latency) {
+		m.latency[op].write(cw, "cindy_labeler_duration_seconds", "operation", op)
+	}
+
+	header(cw, "cindy_labeler_errors_total", "counter", "Labeler calls that returned an error, by operation.")
+	for _, op := range sortedKeys(m.labelerErrors) {
+		sample(cw, "cindy_labeler_errors_total", m.labelerErrors[op], "operation", op)
+	}
+
+	err := cw.w.Flush()
+	if cw.err != nil {
+		err = cw.err
+	}
+	return cw.n, err
+}
+
+// pipelineOf returns the pipeline l was configured with, or the default
+// pipeline if it does not expose one.
+func pipelineOf(l cindy.Labeler) *cindy.Pipeline {
+	if pl, ok := l.(interface{ Pipeline() *cindy.Pipeline }); ok {
+		if p := pl.Pipeline(); p != nil {
+			return p
+		}
+	}
+	return cindy.DefaultPipeline()
+}
+
+// histogram counts observations into cumulative buckets.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // counts[i] observations ≤ bounds[i], not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.sum += v
+	h.count++
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+}
+
+func (h *histogram) write(w io.Writer, name string, labels ...string) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		sample(w, name+"_bucket", float64(cumulative), append(labels, "le", formatFloat(bound))...)
+	}
+	sample(w, name+"_bucket", float64(h.count), append(labels, "le", "+Inf")...)
+	sample(w, name+"_sum", h.sum, labels...)
+	sample(w, name+"_count", float64(h.count), labels...)
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample line; labels alternate names and values.
+func sample(w io.Writer, name string, v float64, labels ...string) {
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", formatFloat(v))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// countingWriter counts the bytes written and keeps the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
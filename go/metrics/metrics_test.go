@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	cindy "github.com/nimsforest/cindy/go"
+	"github.com/nimsforest/cindy/go/cindytest"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	e := cindy.NewEngine(m.Instrument(cindy.NewMemoryLabeler()), nil)
+	m.Register(e)
+	e.AddGuard(cindy.Analyzing, cindy.HumanReview, "no-schema-violations", cindy.NoSchemaViolations())
+
+	start := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	apply := func(branch string, to cindy.Label, actor string, after time.Duration, m *cindy.Manifest) error {
+		_, err := e.ApplyTransition(cindy.TransitionRequest{Branch: branch, To: to, Manifest: m,
+			Metadata: cindy.Metadata{Actor: actor, Timestamp: start.Add(after)}})
+		return err
+	}
+	ok := &cindy.Manifest{Revision: 1}
+	for _, step := range []struct {
+		to    cindy.Label
+		actor string
+		after time.Duration
+	}{
+		{cindy.Ready, "agent-7", 0},
+		{cindy.Analyzing, "analyzer", 10 * time.Minute},
+		{cindy.HumanReview, "analyzer", 20 * time.Minute},
+		{cindy.Approved, "alice", 2*time.Hour + 20*time.Minute},
+	} {
+		if err := apply("feature/x", step.to, step.actor, step.after, ok); err != nil {
+			t.Fatalf("%s: %v", step.to, err)
+		}
+	}
+	apply("feature/y", cindy.Ready, "agent-7", 0, nil)
+	apply("feature/y", cindy.Analyzing, "analyzer", time.Minute, nil)
+	breaking := &cindy.Manifest{Revision: 1, SchemaChanges: []cindy.SchemaChange{{Subject: "orders", FieldsRemoved: []string{"total"}}}}
+	if err := apply("feature/y", cindy.HumanReview, "analyzer", 2*time.Minute, breaking); err == nil {
+		t.Fatal("expected schema violation")
+	}
+	apply("feature/y", cindy.Deployed, "agent-7", 3*time.Minute, nil)
+
+	srv := httptest.NewServer(&cindy.Server{Engine: e, Metrics: m})
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		`cindy_branches{label="cindy:approved"} 1`,
+		`cindy_branches{label="cindy:analyzing"} 1`,
+		`cindy_branches{label="cindy:deployed"} 0`,
+		`cindy_transitions_total{from="cindy:human-review",to="cindy:approved",actor="alice"} 1`,
+		`cindy_transitions_total{from="cindy:ready",to="cindy:analyzing",actor="analyzer"} 2`,
+		`cindy_transition_rejections_total{code="field-removed"} 1`,
+		`cindy_transition_rejections_total{code="invalid-transition"} 1`,
+		`cindy_time_in_state_seconds_bucket{label="cindy:human-review",le="3600"} 0`,
+		`cindy_time_in_state_seconds_bucket{label="cindy:human-review",le="7200"} 1`,
+		`cindy_time_in_state_seconds_sum{label="cindy:human-review"} 7200`,
+		`cindy_time_in_state_seconds_count{label="cindy:ready"} 2`,
+		`cindy_labeler_duration_seconds_count{operation="CompareAndSwapLabel"} 6`,
+		"# TYPE cindy_time_in_state_seconds histogram",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestRejectionCodes(t *testing.T) {
+	tests := []struct {
+		err  error
+		want []string
+	}{
+		{&cindy.ConflictError{Branch: "x"}, []string{"conflict"}},
+		{&cindy.TransitionError{Branch: "x"}, []string{"invalid-transition"}},
+		{&cindy.PermissionError{Actor: "x"}, []string{"permission-denied"}},
+		{&cindy.BranchNameError{Branch: "-x"}, []string{"invalid-branch"}},
+		{errors.Join(
+			&cindy.GuardError{Guard: "signed", Err: errors.New("unsigned")},
+			&cindy.GuardError{Guard: "no-schema-violations", Err: &cindy.SchemaViolationError{Violations: []cindy.SchemaViolation{{Code: "field-removed"}, {Code: "field-removed"}}}},
+		), []string{"guard:signed", "field-removed"}},
+		{errors.New("disk full"), []string{"error"}},
+	}
+	for _, tt := range tests {
+		if got := RejectionCodes(tt.err); !slices.Equal(got, tt.want) {
+			t.Errorf("RejectionCodes(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestObserve_WatchedTransitions(t *testing.T) {
+	ml := cindy.NewMemoryLabeler()
+	m := New()
+	m.Instrument(ml)
+	start := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	ml.SetLabelWithMetadata("feature/x", cindy.Ready, cindy.Metadata{Actor: "agent-7", Timestamp: start})
+	ml.SetLabelWithMetadata("feature/x", cindy.Analyzing, cindy.Metadata{Actor: "analyzer", Timestamp: start.Add(90 * time.Second)})
+	history, _ := ml.History("feature/x")
+	for _, tr := range history {
+		m.Observe(tr)
+	}
+	var b strings.Builder
+	m.WriteTo(&b)
+	if want := `cindy_time_in_state_seconds_sum{label="cindy:ready"} 90`; !strings.Contains(b.String(), want) {
+		t.Errorf("missing %q in\n%s", want, b.String())
+	}
+}
+
+func TestInstrumentConformance(t *testing.T) {
+	cindytest.RunLabelerConformance(t, func(t *testing.T) cindy.Labeler {
+		return New().Instrument(cindy.NewMemoryLabeler())
+	})
+}
@@ -0,0 +1,235 @@
+package cindy
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// enums lists the values of string types whose constants reflection cannot see.
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(Verdict("")):          {string(Approve), string(RequestChanges), string(Comment)},
+	reflect.TypeOf(SchemaChangeType("")): {string(SchemaExtension), string(SchemaNew)},
+	reflect.TypeOf(RiskLevel("")):        {string(RiskLow), string(RiskMedium), string(RiskHigh)},
+}
+
+// OpenAPI returns the OpenAPI 3.1 description of the Server API for
+// pipeline p, ready to be encoded as JSON. Schemas are generated from the
+// Go types the server encodes and decodes, so they cannot drift from it.
+func OpenAPI(p *Pipeline) map[string]any {
+	g := &schemaGen{defs: make(map[string]any), labels: p.Labels()}
+	g.defs["APIError"] = g.object(reflect.TypeOf(APIError{}))
+
+	paths := make(map[string]any)
+	for _, rt := range routes {
+		op := map[string]any{
+			"summary":     rt.summary,
+			"operationId": operationID(rt),
+			"responses": map[string]any{
+				"200":     jsonContent("OK", g.schema(reflect.TypeOf(rt.response))),
+				"default": jsonContent("Error", map[string]any{"$ref": "#/components/schemas/APIError"}),
+			},
+		}
+		var params []any
+		if strings.Contains(rt.path, "{branch}") {
+			params = append(params, map[string]any{
+				"name": "branch", "in": "path", "required": true,
+				"description": "Branch name; may contain '/'.",
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		for _, name := range sortedKeys(rt.query) {
+			params = append(params, map[string]any{
+				"name": name, "in": "query", "description": rt.query[name],
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if rt.body != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(rt.body))}},
+			}
+		}
+		item, _ := paths[rt.path].(map[string]any)
+		if item == nil {
+			item = make(map[string]any)
+			paths[rt.path] = item
+		}
+		item[strings.ToLower(rt.method)] = op
+	}
+	paths["/v1/events"] = map[string]any{
+		"get": map[string]any{
+			"summary":     "Stream label changes as server-sent events; each data line is an Event",
+			"operationId": "getEvents",
+			"parameters": []any{
+				map[string]any{"name": "branch", "in": "query", "description": "only events for this branch", "schema": map[string]any{"type": "string"}},
+				map[string]any{"name": "label", "in": "query", "description": "only transitions into this label", "schema": map[string]any{"type": "string"}},
+				map[string]any{"name": "Last-Event-ID", "in": "header", "description": "resume after this event", "schema": map[string]any{"type": "string"}},
+			},
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "Event stream",
+					"content":     map[string]any{"text/event-stream": map[string]any{"schema": g.schema(reflect.TypeOf(Event{}))}},
+				},
+			},
+		},
+	}
+	paths["/v1/push"] = map[string]any{
+		"post": map[string]any{
+			"summary": "Receive a GitHub or Gitea push webhook, submitting the branch if its manifest is valid; " +
+				"deliveries must be signed with X-Hub-Signature-256 or X-Gitea-Signature",
+			"operationId": "postPush",
+			"requestBody": map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": map[string]any{"type": "object"}}},
+			},
+			"responses": map[string]any{
+				"200":     jsonContent("OK", g.schema(reflect.TypeOf(PushResult{}))),
+				"default": jsonContent("Error", map[string]any{"$ref": "#/components/schemas/APIError"}),
+			},
+		},
+	}
+	paths["/v1/openapi.json"] = map[string]any{
+		"get": map[string]any{
+			"summary":     "Get this OpenAPI description",
+			"operationId": "getOpenAPI",
+			"responses":   map[string]any{"200": map[string]any{"description": "OK"}},
+		},
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Cindy",
+			"version": "1",
+			"description": "Branch labels, manifests and reviews of the " + p.Name() +
+				" pipeline. Label changes are compare-and-swap: they fail with 409 unless the branch still carries \"from\".",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": g.defs},
+	}
+}
+
+// operationID derives an identifier like "postLabels" from a route.
+func operationID(rt route) string {
+	id := strings.ToLower(rt.method)
+	for _, part := range strings.Split(rt.path, "/")[2:] {
+		if part == "{branch}" {
+			id += "ByBranch"
+			continue
+		}
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+func jsonContent(description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+// schemaGen builds JSON Schemas for Go types, collecting named structs under
+// components/schemas.
+type schemaGen struct {
+	defs   map[string]any
+	labels []Label
+}
+
+var (
+	labelType = reflect.TypeOf(Label(""))
+	timeType  = reflect.TypeOf(time.Time{})
+)
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == labelType:
+		enum := []any{""}
+		for _, l := range g.labels {
+			enum = append(enum, string(l))
+		}
+		return map[string]any{"type": "string", "enum": enum, "description": `A pipeline label, "" for none.`}
+	case enums[t] != nil:
+		return map[string]any{"type": "string", "enum": enums[t]}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return map[string]any{"anyOf": []any{g.schema(t.Elem()), map[string]any{"type": "null"}}}
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // placeholder for recursive types
+			g.defs[t.Name()] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{}
+}
+
+// object describes a struct the way encoding/json encodes it: exported
+// fields by their json names, embedded structs inlined, omitempty fields
+// optional.
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	g.fields(t, props, &required)
+	sort.Strings(required)
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func (g *schemaGen) fields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.fields(f.Type, props, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
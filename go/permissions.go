@@ -0,0 +1,279 @@
+package cindy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrPermissionDenied is wrapped by errors reporting an actor not allowed to
+// apply a transition.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// PermissionError reports a transition refused by Permissions.
+type PermissionError struct {
+	Actor  string
+	From   Label
+	To     Label
+	Reason string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("%s: %s may not apply %s → %s: %s", ErrPermissionDenied, orUnknown(e.Actor), labelOrNone(e.From), e.To, e.Reason)
+}
+
+func (e *PermissionError) Unwrap() error { return ErrPermissionDenied }
+
+// ActorKind tells agents from humans.
+type ActorKind string
+
+const (
+	KindAgent ActorKind = "agent"
+	KindHuman ActorKind = "human"
+)
+
+// Actor is an identity that applies labels.
+type Actor struct {
+	Name  string    `json:"name"`
+	Kind  ActorKind `json:"kind"`
+	Team  string    `json:"team,omitempty"`
+	Roles []string  `json:"roles,omitempty"`
+}
+
+// HasRole reports whether a holds role.
+func (a Actor) HasRole(role string) bool {
+	return contains(a.Roles, role)
+}
+
+// PermissionsConfig is the JSON form of Permissions, as loaded by
+// LoadPermissions.
+//
+//	{
+//	  "actors": [
+//	    {"name": "alice", "kind": "human", "team": "payments", "roles": ["reviewer"]},
+//	    {"name": "deploy-bot", "kind": "agent", "roles": ["deployer"]}
+//	  ],
+//	  "rules": [
+//	    {"from": "cindy:human-review", "kinds": ["human"]},
+//	    {"to": "cindy:deployed", "roles": ["deployer"]},
+//	    {"to": "cindy:approved", "not_author": true}
+//	  ],
+//	  "review_editors": ["release-manager"]
+//	}
+type PermissionsConfig struct {
+	Actors []Actor          `json:"actors"`
+	Rules  []PermissionRule `json:"rules"`
+	// ReviewEditors are the roles whose actors may replace reviews written
+	// by others. Everyone else may only replace their own.
+	ReviewEditors []string `json:"review_editors,omitempty"`
+}
+
+// PermissionRule restricts the transitions it matches to the actors it
+// allows. A rule matches a transition when From and To match; an omitted
+// label matches any. It allows an actor that has one of Kinds, one of Roles
+// and one of Teams — an empty list allows any — and, with NotAuthor, did not
+// submit the branch.
+type PermissionRule struct {
+	From      Label       `json:"from,omitempty"`
+	To        Label       `json:"to,omitempty"`
+	Kinds     []ActorKind `json:"kinds,omitempty"`
+	Roles     []string    `json:"roles,omitempty"`
+	Teams     []string    `json:"teams,omitempty"`
+	NotAuthor bool        `json:"not_author,omitempty"`
+}
+
+func (r PermissionRule) matches(from, to Label) bool {
+	return (r.From == "" || r.From == from) && (r.To == "" || r.To == to)
+}
+
+// denies returns why the rule refuses a, or "" if it allows a.
+func (r PermissionRule) denies(a Actor, authors []string) string {
+	if len(r.Kinds) > 0 && !contains(r.Kinds, a.Kind) {
+		return fmt.Sprintf("requires a %s", joinAny(r.Kinds, " or "))
+	}
+	if len(r.Roles) > 0 && !anyOf(r.Roles, a.HasRole) {
+		return fmt.Sprintf("requires role %s", strings.Join(r.Roles, " or "))
+	}
+	if len(r.Teams) > 0 && !contains(r.Teams, a.Team) {
+		return fmt.Sprintf("requires team %s", strings.Join(r.Teams, " or "))
+	}
+	if r.NotAuthor && contains(authors, a.Name) {
+		return "authors may not apply it to their own branch"
+	}
+	return ""
+}
+
+// Permissions decides which actors may apply which transitions. Every actor
+// must be declared; every rule matching a transition must allow the actor.
+// Transitions no rule matches are open to every declared actor. Permissions
+// are immutable once built.
+type Permissions struct {
+	actors   map[string]Actor
+	rules    []PermissionRule
+	editors  []string
+	pipeline *Pipeline
+}
+
+// NewPermissions builds Permissions from cfg after checking that actors are
+// unique and of a known kind, and that rules only name labels of pipeline
+// p. A nil p means DefaultPipeline.
+func NewPermissions(cfg PermissionsConfig, p *Pipeline) (*Permissions, error) {
+	if p == nil {
+		p = DefaultPipeline()
+	}
+	perms := &Permissions{
+		actors:   make(map[string]Actor, len(cfg.Actors)),
+		editors:  append([]string(nil), cfg.ReviewEditors...),
+		pipeline: p,
+	}
+	for _, a := range cfg.Actors {
+		switch {
+		case a.Name == "":
+			return nil, errors.New("permissions: actor without a name")
+		case a.Kind != KindAgent && a.Kind != KindHuman:
+			return nil, fmt.Errorf("permissions: actor %s: kind must be agent or human, got %q", a.Name, a.Kind)
+		}
+		if _, dup := perms.actors[a.Name]; dup {
+			return nil, fmt.Errorf("permissions: actor %s declared twice", a.Name)
+		}
+		a.Roles = append([]string(nil), a.Roles...)
+		perms.actors[a.Name] = a
+	}
+	for i, r := range cfg.Rules {
+		for _, l := range []Label{r.From, r.To} {
+			if l != "" && !p.HasLabel(l) {
+				return nil, fmt.Errorf("permissions: rule %d: %w: %q", i+1, ErrUnknownLabel, l)
+			}
+		}
+		for _, k := range r.Kinds {
+			if k != KindAgent && k != KindHuman {
+				return nil, fmt.Errorf("permissions: rule %d: unknown kind %q", i+1, k)
+			}
+		}
+		perms.rules = append(perms.rules, r)
+	}
+	return perms, nil
+}
+
+// ParsePermissions parses and validates a permissions definition from JSON bytes.
+func ParsePermissions(data []byte, p *Pipeline) (*Permissions, error) {
+	var cfg PermissionsConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing permissions: %w", err)
+	}
+	return NewPermissions(cfg, p)
+}
+
+// LoadPermissions reads and parses a permissions definition from a file path.
+func LoadPermissions(path string, p *Pipeline) (*Permissions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading permissions: %w", err)
+	}
+	return ParsePermissions(data, p)
+}
+
+// Actor returns the declared actor called name.
+func (p *Permissions) Actor(name string) (Actor, bool) {
+	a, ok := p.actors[name]
+	return a, ok
+}
+
+// IsHuman reports whether name is a declared human, for RequireHumanActor.
+func (p *Permissions) IsHuman(name string) bool {
+	a, ok := p.actors[name]
+	return ok && a.Kind == KindHuman
+}
+
+// CanEditReviews reports whether name is a declared actor with one of the
+// review_editors roles, who may replace reviews written by others.
+func (p *Permissions) CanEditReviews(name string) bool {
+	a, ok := p.actors[name]
+	return ok && anyOf(p.editors, a.HasRole)
+}
+
+// Check returns a *PermissionError unless actor may move a branch from
+// from to to. authors are the actors who submitted the branch; see Authors.
+func (p *Permissions) Check(actor string, from, to Label, authors []string) error {
+	deny := func(reason string) error {
+		return &PermissionError{Actor: actor, From: from, To: to, Reason: reason}
+	}
+	a, ok := p.actors[actor]
+	if !ok {
+		return deny("unknown actor")
+	}
+	for _, r := range p.rules {
+		if !r.matches(from, to) {
+			continue
+		}
+		if reason := r.denies(a, authors); reason != "" {
+			return deny(reason)
+		}
+	}
+	return nil
+}
+
+// Authorizer adapts p to ReceiveHook.Authorize, taking the branch's authors
+// from the history l records and the steps pushed before t.
+func (p *Permissions) Authorizer(l RecordingLabeler) func(actor string, t Transition, prior []Transition) error {
+	return func(actor string, t Transition, prior []Transition) error {
+		history, err := l.History(t.Branch)
+		if err != nil {
+			return err
+		}
+		return p.Check(actor, t.From, t.To, p.pipeline.Authors(append(history, prior...)))
+	}
+}
+
+// Authors returns the actors who submitted a branch in history under the
+// default pipeline. See Pipeline.Authors.
+func Authors(history []Transition) []string {
+	return DefaultPipeline().Authors(history)
+}
+
+// Authors returns the actors who submitted a branch, i.e. moved it to the
+// pipeline's entry label, in history.
+func (p *Pipeline) Authors(history []Transition) []string {
+	var authors []string
+	for _, t := range history {
+		if t.To == p.entry && t.Actor != "" && !contains(authors, t.Actor) {
+			authors = append(authors, t.Actor)
+		}
+	}
+	return authors
+}
+
+// identify records a's identity in m.
+func (m Metadata) identify(a Actor) Metadata {
+	m.ActorKind, m.ActorTeam = a.Kind, a.Team
+	m.ActorRoles = append([]string(nil), a.Roles...)
+	return m
+}
+
+func contains[T comparable](list []T, v T) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func anyOf(list []string, f func(string) bool) bool {
+	for _, x := range list {
+		if f(x) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinAny[T ~string](list []T, sep string) string {
+	s := make([]string, len(list))
+	for i, x := range list {
+		s[i] = string(x)
+	}
+	return strings.Join(s, sep)
+}
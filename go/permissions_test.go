@@ -0,0 +1,156 @@
+package cindy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func loadExamplePermissions(t *testing.T) *Permissions {
+	t.Helper()
+	perms, err := LoadPermissions("../examples/permissions.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return perms
+}
+
+func TestPermissions_Check(t *testing.T) {
+	perms := loadExamplePermissions(t)
+	tests := []struct {
+		actor    string
+		from, to Label
+		authors  []string
+		want     string
+	}{
+		{"agent-7", "", Ready, nil, ""},
+		{"alice", "", Ready, nil, "requires role author"},
+		{"analyzer", Ready, Analyzing, nil, ""},
+		{"agent-7", Ready, Analyzing, nil, "requires role analyzer"},
+		{"analyzer", Analyzing, HumanReview, nil, ""},
+		{"alice", HumanReview, Approved, []string{"agent-7"}, ""},
+		{"analyzer", HumanReview, Approved, []string{"agent-7"}, "requires a human"},
+		{"alice", HumanReview, Approved, []string{"alice"}, "authors may not apply it to their own branch"},
+		{"deploy-bot", Deploying, Deployed, nil, ""},
+		{"bob", Deploying, Deployed, nil, "requires role deployer"},
+		{"bob", Approved, Deploying, nil, ""},
+		{"mallory", Blocked, Approved, nil, "unknown actor"},
+	}
+	for _, tt := range tests {
+		err := perms.Check(tt.actor, tt.from, tt.to, tt.authors)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s %s → %s: unexpected %v", tt.actor, tt.from, tt.to, err)
+		case tt.want != "" && (!errors.Is(err, ErrPermissionDenied) || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s %s → %s: got %v, want %q", tt.actor, tt.from, tt.to, err, tt.want)
+		}
+	}
+}
+
+func TestNewPermissions_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  PermissionsConfig
+		want string
+	}{
+		{"unnamed actor", PermissionsConfig{Actors: []Actor{{Kind: KindAgent}}}, "without a name"},
+		{"unknown kind", PermissionsConfig{Actors: []Actor{{Name: "x", Kind: "robot"}}}, "kind must be agent or human"},
+		{"duplicate actor", PermissionsConfig{Actors: []Actor{{Name: "x", Kind: KindAgent}, {Name: "x", Kind: KindHuman}}}, "declared twice"},
+		{"unknown label", PermissionsConfig{Rules: []PermissionRule{{To: "cindy:shipped"}}}, "unknown label"},
+		{"unknown rule kind", PermissionsConfig{Rules: []PermissionRule{{Kinds: []ActorKind{"robot"}}}}, "unknown kind"},
+	}
+	for _, tt := range tests {
+		if _, err := NewPermissions(tt.cfg, nil); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEngine_Permissions(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	e.SetPermissions(loadExamplePermissions(t))
+	apply := func(to Label, actor string) error {
+		_, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: to, Metadata: Metadata{Actor: actor}})
+		return err
+	}
+
+	if err := apply(Ready, "analyzer"); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected permission error, got %v", err)
+	}
+	for _, step := range []struct {
+		to    Label
+		actor string
+	}{{Ready, "agent-7"}, {Analyzing, "analyzer"}, {HumanReview, "analyzer"}} {
+		if err := apply(step.to, step.actor); err != nil {
+			t.Fatalf("%s: %v", step.to, err)
+		}
+	}
+
+	// A human who submitted the branch may not approve it.
+	ml.SetLabelWithMetadata("feature/y", Ready, Metadata{Actor: "alice"})
+	ml.SetLabelWithMetadata("feature/y", Analyzing, Metadata{Actor: "analyzer"})
+	ml.SetLabelWithMetadata("feature/y", HumanReview, Metadata{Actor: "analyzer"})
+	_, err := e.ApplyTransition(TransitionRequest{Branch: "feature/y", To: Approved, Metadata: Metadata{Actor: "alice"}})
+	if !errors.Is(err, ErrPermissionDenied) || !strings.Contains(err.Error(), "own branch") {
+		t.Errorf("expected self-approval to be refused, got %v", err)
+	}
+
+	if err := apply(Approved, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	history, _ := ml.History("feature/x")
+	last := history[len(history)-1]
+	if last.ActorKind != KindHuman || last.ActorTeam != "payments" || len(last.ActorRoles) != 1 || last.ActorRoles[0] != "reviewer" {
+		t.Errorf("identity not recorded: %+v", last.Metadata)
+	}
+}
+
+func TestPermissions_ReceiveHook(t *testing.T) {
+	f := newReceiveFixture(t)
+	server, err := NewGitLabeler(f.server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.hook.Authorize = loadExamplePermissions(t).Authorizer(server)
+	f.hook.Pusher = "agent-7"
+	if r := f.label("feature/x", Ready, "agent-7"); r != nil {
+		t.Fatalf("submission rejected: %v", r)
+	}
+	expectRejected(t, f.label("feature/x", Analyzing, "analyzer"), "requires role analyzer")
+}
+
+func TestPermissions_ReceiveHookOnePushApproval(t *testing.T) {
+	f := newReceiveFixture(t)
+	server, err := NewGitLabeler(f.server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perms, err := ParsePermissions([]byte(`{
+		"actors": [
+			{"name": "agent-7", "kind": "agent"},
+			{"name": "alice", "kind": "human"}
+		],
+		"rules": [{"to": "cindy:approved", "not_author": true}]
+	}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.hook.Authorize = perms.Authorizer(server)
+	f.hook.TrustRecordedActors = true
+
+	// The author submits and approves a new branch in a single push; the
+	// submission is not on the server yet but still makes them its author.
+	f.setLabel("feature/x", Ready, "agent-7")
+	f.setLabel("feature/x", Analyzing, "agent-7")
+	f.setLabel("feature/x", Approved, "agent-7")
+	expectRejected(t, f.push(labelRefs("feature/x", Approved)...), "authors may not apply it to their own branch")
+
+	f.reset()
+	f.setLabel("feature/x", Ready, "agent-7")
+	f.setLabel("feature/x", Analyzing, "agent-7")
+	f.setLabel("feature/x", Approved, "alice")
+	if r := f.push(labelRefs("feature/x", Approved)...); r != nil {
+		t.Fatalf("approval by a reviewer rejected: %v", r)
+	}
+}
@@ -0,0 +1,279 @@
+package cindy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PipelineConfig is the JSON form of a Pipeline, as loaded by LoadPipeline.
+//
+//	{
+//	  "name": "payments",
+//	  "labels": ["cindy:ready", "cindy:analyzing", "cindy:staging", ...],
+//	  "entry": "cindy:ready",
+//	  "transitions": [
+//	    {"from": "cindy:approved", "to": "cindy:staging"},
+//	    {"from": "cindy:staging", "to": "cindy:deploying", "guards": ["soak-24h"]}
+//	  ],
+//	  "terminal": ["cindy:rejected", "cindy:rollback"]
+//	}
+type PipelineConfig struct {
+	Name   string  `json:"name"`
+	Labels []Label `json:"labels"`
+	// Entry is the label unlabeled branches enter the pipeline at. It
+	// defaults to cindy:ready if declared, else to the first label.
+	Entry       Label              `json:"entry,omitempty"`
+	Transitions []TransitionConfig `json:"transitions"`
+	Terminal    []Label            `json:"terminal"`
+}
+
+// TransitionConfig declares one allowed transition and the names of the
+// guards that must pass before it is applied.
+type TransitionConfig struct {
+	From   Label    `json:"from"`
+	To     Label    `json:"to"`
+	Guards []string `json:"guards,omitempty"`
+}
+
+// Pipeline is a label state machine: the labels a branch can carry, the
+// transitions between them, which states end a change's lifecycle, and the
+// guards attached to each transition. A Pipeline is immutable once built.
+type Pipeline struct {
+	name        string
+	labels      []Label
+	entry       Label
+	transitions map[Label][]Label
+	guards      map[[2]Label][]string
+	terminal    map[Label]bool
+	config      PipelineConfig
+}
+
+// defaultPipelineConfig is the state machine defined in SPEC.md §3.
+var defaultPipelineConfig = PipelineConfig{
+	Name: "cindy",
+	Labels: []Label{
+		Ready, Analyzing, Approved, Blocked, Deploying,
+		Deployed, Rejected, Rollback, HumanReview, RevisionRequested,
+	},
+	Transitions: []TransitionConfig{
+		{From: Ready, To: Analyzing},
+		{From: Analyzing, To: Approved},
+		{From: Analyzing, To: Rejected},
+		{From: Analyzing, To: HumanReview},
+		{From: Analyzing, To: Blocked},
+		{From: Analyzing, To: RevisionRequested},
+		{From: Approved, To: Deploying},
+		{From: Approved, To: Blocked},
+		{From: Approved, To: RevisionRequested},
+		{From: Blocked, To: Approved},
+		{From: Deploying, To: Deployed},
+		{From: Deploying, To: Rollback, Guards: []string{"rollback-cause"}},
+		{From: HumanReview, To: Approved},
+		{From: HumanReview, To: Rejected},
+		{From: HumanReview, To: RevisionRequested},
+		{From: RevisionRequested, To: Ready, Guards: []string{"no-blocking-reviews", "rollback-resubmission"}},
+		{From: Deployed, To: Rollback, Guards: []string{"rollback-cause"}},
+		{From: Deployed, To: RevisionRequested},
+		{From: Rollback, To: RevisionRequested, Guards: []string{"rollback-review"}},
+	},
+	Terminal: []Label{Rejected},
+}
+
+var defaultPipeline = mustPipeline(defaultPipelineConfig)
+
+// DefaultPipeline returns the built-in Cindy protocol state machine.
+func DefaultPipeline() *Pipeline {
+	return defaultPipeline
+}
+
+// NewPipeline builds a Pipeline from cfg after validating it:
+//   - labels are unique, in the cindy: namespace, and usable in tag names
+//   - the entry label, transitions and terminal states only reference
+//     declared labels
+//   - no transition is declared twice
+func NewPipeline(cfg PipelineConfig) (*Pipeline, error) {
+	if len(cfg.Labels) == 0 {
+		return nil, fmt.Errorf("pipeline %q: no labels declared", cfg.Name)
+	}
+
+	p := &Pipeline{
+		name:        cfg.Name,
+		transitions: make(map[Label][]Label),
+		guards:      make(map[[2]Label][]string),
+		terminal:    make(map[Label]bool),
+	}
+	declared := make(map[Label]bool, len(cfg.Labels))
+	for _, l := range cfg.Labels {
+		if err := checkLabelName(l); err != nil {
+			return nil, fmt.Errorf("pipeline %q: %w", cfg.Name, err)
+		}
+		if declared[l] {
+			return nil, fmt.Errorf("pipeline %q: label %s declared twice", cfg.Name, l)
+		}
+		declared[l] = true
+		p.labels = append(p.labels, l)
+	}
+
+	switch {
+	case cfg.Entry == "" && declared[Ready]:
+		p.entry = Ready
+	case cfg.Entry == "":
+		p.entry = cfg.Labels[0]
+	case !declared[cfg.Entry]:
+		return nil, fmt.Errorf("pipeline %q: entry %s is not a declared label", cfg.Name, cfg.Entry)
+	default:
+		p.entry = cfg.Entry
+	}
+
+	for _, t := range cfg.Transitions {
+		if !declared[t.From] || !declared[t.To] {
+			return nil, fmt.Errorf("pipeline %q: transition %s → %s uses an undeclared label", cfg.Name, t.From, t.To)
+		}
+		edge := [2]Label{t.From, t.To}
+		if _, dup := p.guards[edge]; dup {
+			return nil, fmt.Errorf("pipeline %q: transition %s → %s declared twice", cfg.Name, t.From, t.To)
+		}
+		p.transitions[t.From] = append(p.transitions[t.From], t.To)
+		p.guards[edge] = append([]string{}, t.Guards...)
+	}
+
+	for _, l := range cfg.Terminal {
+		if !declared[l] {
+			return nil, fmt.Errorf("pipeline %q: terminal state %s is not a declared label", cfg.Name, l)
+		}
+		p.terminal[l] = true
+	}
+
+	p.config = cloneConfig(cfg)
+	return p, nil
+}
+
+// ParsePipeline parses and validates a pipeline definition from JSON bytes.
+func ParsePipeline(data []byte) (*Pipeline, error) {
+	var cfg PipelineConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing pipeline: %w", err)
+	}
+	return NewPipeline(cfg)
+}
+
+// LoadPipeline reads and parses a pipeline definition from a file path.
+func LoadPipeline(path string) (*Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading pipeline: %w", err)
+	}
+	return ParsePipeline(data)
+}
+
+// Name returns the pipeline's name.
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+// Labels returns the pipeline's labels in declaration order.
+func (p *Pipeline) Labels() []Label {
+	return append([]Label(nil), p.labels...)
+}
+
+// Entry returns the label unlabeled branches enter the pipeline at.
+func (p *Pipeline) Entry() Label {
+	return p.entry
+}
+
+// HasLabel reports whether l is declared by the pipeline.
+func (p *Pipeline) HasLabel(l Label) bool {
+	for _, known := range p.labels {
+		if known == l {
+			return true
+		}
+	}
+	return false
+}
+
+// CanTransition returns true if the pipeline allows moving from one label to another.
+func (p *Pipeline) CanTransition(from, to Label) bool {
+	for _, t := range p.transitions[from] {
+		if t == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidTransitionsFrom returns the labels reachable from a given label in one step.
+// Returns nil if the label has no outgoing transitions or is unknown.
+func (p *Pipeline) ValidTransitionsFrom(from Label) []Label {
+	targets := p.transitions[from]
+	if len(targets) == 0 {
+		return nil
+	}
+	return append([]Label(nil), targets...)
+}
+
+// IsTerminal returns true if the pipeline declares l as a terminal state,
+// i.e. one that ends a change's lifecycle.
+func (p *Pipeline) IsTerminal(l Label) bool {
+	return p.terminal[l]
+}
+
+// Guards returns the names of the guards declared on a transition.
+func (p *Pipeline) Guards(from, to Label) []string {
+	return append([]string(nil), p.guards[[2]Label{from, to}]...)
+}
+
+// Config returns the pipeline's definition, suitable for encoding as JSON.
+func (p *Pipeline) Config() PipelineConfig {
+	return cloneConfig(p.config)
+}
+
+// checkLabel reports whether l is one of the pipeline's labels.
+func (p *Pipeline) checkLabel(l Label) error {
+	if p.HasLabel(l) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownLabel, l)
+}
+
+// checkLabelName validates a label declared in a pipeline definition. Labels
+// become a single tag path component, so the short name is restricted to
+// lowercase letters, digits and dashes.
+func checkLabelName(l Label) error {
+	if !strings.HasPrefix(string(l), "cindy:") {
+		return fmt.Errorf("label %q is not in the cindy: namespace", l)
+	}
+	short := ShortLabel(l)
+	if short == "" {
+		return fmt.Errorf("label %q has an empty name", l)
+	}
+	for _, r := range short {
+		if !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-') {
+			return fmt.Errorf("label %q: names may only contain a-z, 0-9 and '-'", l)
+		}
+	}
+	return nil
+}
+
+func cloneConfig(cfg PipelineConfig) PipelineConfig {
+	out := PipelineConfig{
+		Name:     cfg.Name,
+		Labels:   append([]Label(nil), cfg.Labels...),
+		Entry:    cfg.Entry,
+		Terminal: append([]Label(nil), cfg.Terminal...),
+	}
+	for _, t := range cfg.Transitions {
+		t.Guards = append([]string(nil), t.Guards...)
+		out.Transitions = append(out.Transitions, t)
+	}
+	return out
+}
+
+func mustPipeline(cfg PipelineConfig) *Pipeline {
+	p, err := NewPipeline(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
@@ -0,0 +1,197 @@
+package cindy
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+const staging Label = "cindy:staging"
+
+func loadStagingPipeline(t *testing.T) *Pipeline {
+	t.Helper()
+	p, err := LoadPipeline("../examples/pipeline-staging.json")
+	if err != nil {
+		t.Fatalf("LoadPipeline: %v", err)
+	}
+	return p
+}
+
+func TestDefaultPipeline_MatchesPackageFunctions(t *testing.T) {
+	p := DefaultPipeline()
+	for _, from := range AllLabels() {
+		for _, to := range AllLabels() {
+			if p.CanTransition(from, to) != CanTransition(from, to) {
+				t.Errorf("%s → %s: pipeline and package disagree", from, to)
+			}
+		}
+		if p.IsTerminal(from) != IsTerminal(from) {
+			t.Errorf("IsTerminal(%s): pipeline and package disagree", from)
+		}
+	}
+	if p.Name() != "cindy" || len(p.Labels()) != 10 {
+		t.Errorf("unexpected default pipeline %q with %d labels", p.Name(), len(p.Labels()))
+	}
+}
+
+func TestPipeline_ValidTransitionsFromIsCopy(t *testing.T) {
+	targets := ValidTransitionsFrom(Analyzing)
+	targets[0] = Deployed
+	if ValidTransitionsFrom(Analyzing)[0] == Deployed {
+		t.Error("mutating the returned slice must not change the pipeline")
+	}
+}
+
+func TestLoadPipeline_Staging(t *testing.T) {
+	p := loadStagingPipeline(t)
+
+	if p.Name() != "payments" {
+		t.Errorf("expected name payments, got %q", p.Name())
+	}
+	if !p.CanTransition(Approved, staging) || !p.CanTransition(staging, Deploying) {
+		t.Error("expected approved → staging → deploying")
+	}
+	if p.CanTransition(Approved, Deploying) {
+		t.Error("expected the staging step to be mandatory")
+	}
+	if got := p.Guards(staging, Deploying); len(got) != 1 || got[0] != "staging-soak" {
+		t.Errorf("expected staging-soak guard, got %v", got)
+	}
+	if got := p.Guards(Ready, Analyzing); len(got) != 0 {
+		t.Errorf("expected no guards, got %v", got)
+	}
+	if !p.IsTerminal(Rejected) || p.IsTerminal(staging) {
+		t.Error("unexpected terminal states")
+	}
+}
+
+func TestParsePipeline_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want string
+	}{
+		{"not json", `{`, "parsing pipeline"},
+		{"no labels", `{"name": "x"}`, "no labels"},
+		{"namespace", `{"labels": ["ready"]}`, "cindy: namespace"},
+		{"slash", `{"labels": ["cindy:a/b"]}`, "may only contain"},
+		{"duplicate label", `{"labels": ["cindy:ready", "cindy:ready"]}`, "declared twice"},
+		{"undeclared", `{"labels": ["cindy:ready"], "transitions": [{"from": "cindy:ready", "to": "cindy:analyzing"}]}`, "undeclared"},
+		{"duplicate edge", `{"labels": ["cindy:ready", "cindy:analyzing"], "transitions": [
+			{"from": "cindy:ready", "to": "cindy:analyzing"}, {"from": "cindy:ready", "to": "cindy:analyzing"}]}`, "declared twice"},
+		{"terminal", `{"labels": ["cindy:ready"], "terminal": ["cindy:done"]}`, "terminal state"},
+		{"entry", `{"labels": ["cindy:ready"], "entry": "cindy:queued"}`, "entry cindy:queued is not a declared label"},
+	}
+	for _, tt := range tests {
+		_, err := ParsePipeline([]byte(tt.json))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestPipeline_Entry(t *testing.T) {
+	if e := DefaultPipeline().Entry(); e != Ready {
+		t.Errorf("default entry %s, want %s", e, Ready)
+	}
+	first, _ := NewPipeline(PipelineConfig{Labels: []Label{"cindy:queued", "cindy:done"}})
+	if e := first.Entry(); e != "cindy:queued" {
+		t.Errorf("entry without cindy:ready %s, want the first label", e)
+	}
+
+	p, err := NewPipeline(PipelineConfig{
+		Name:        "triage",
+		Labels:      []Label{Ready, "cindy:triage"},
+		Entry:       "cindy:triage",
+		Transitions: []TransitionConfig{{From: "cindy:triage", To: Ready}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml := NewMemoryLabeler()
+	ml.SetPipeline(p)
+	e := NewEngine(ml, nil)
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready}); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("entering at %s: got %v, want ErrInvalidTransition", Ready, err)
+	}
+	for _, to := range []Label{"cindy:triage", Ready} {
+		if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: to}); err != nil {
+			t.Fatalf("%s: %v", to, err)
+		}
+	}
+	history, _ := ml.History("feature/x")
+	if v := p.ValidateHistory(history); len(v) != 0 {
+		t.Errorf("expected no violations, got %v", v)
+	}
+	if v := p.ValidateHistory([]Transition{{To: Ready}}); len(v) != 1 || !strings.Contains(v[0].Rule, "enter the pipeline at cindy:triage") {
+		t.Errorf("expected entry violation, got %v", v)
+	}
+}
+
+func TestPipeline_ConfigRoundTrip(t *testing.T) {
+	data, err := json.Marshal(DefaultPipeline().Config())
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	p, err := ParsePipeline(data)
+	if err != nil {
+		t.Fatalf("ParsePipeline: %v", err)
+	}
+	for _, from := range AllLabels() {
+		for _, to := range AllLabels() {
+			if p.CanTransition(from, to) != CanTransition(from, to) {
+				t.Errorf("%s → %s differs after round trip", from, to)
+			}
+		}
+	}
+}
+
+func TestPipeline_ParseTag(t *testing.T) {
+	p := loadStagingPipeline(t)
+	label, branch, ok := p.ParseTag("cindy/staging/feature/pay")
+	if !ok || label != staging || branch != "feature/pay" {
+		t.Errorf("got (%s, %q, %v)", label, branch, ok)
+	}
+	if _, _, ok := ParseTag("cindy/staging/feature/pay"); ok {
+		t.Error("default pipeline must not parse custom labels")
+	}
+}
+
+func TestLabelers_CustomPipeline(t *testing.T) {
+	p := loadStagingPipeline(t)
+
+	repo := initGitRepo(t)
+	exec.Command("git", "-C", repo, "branch", "feature/pay").Run()
+	gl, _ := NewGitLabeler(repo, WithPipeline(p))
+	ml := NewMemoryLabeler()
+	ml.SetPipeline(p)
+
+	for name, l := range map[string]RecordingLabeler{"git": gl, "memory": ml} {
+		for _, label := range []Label{Ready, Analyzing, Approved, staging} {
+			if err := l.SetLabel("feature/pay", label); err != nil {
+				t.Fatalf("%s: SetLabel(%s): %v", name, label, err)
+			}
+		}
+		if got, _ := l.GetLabel("feature/pay"); got != staging {
+			t.Errorf("%s: expected staging, got %s", name, got)
+		}
+		if got, _ := l.BranchesWithLabel(staging); len(got) != 1 {
+			t.Errorf("%s: expected one staging branch, got %v", name, got)
+		}
+
+		// The history is valid under the custom pipeline.
+		issues, err := Fsck(l, FsckOptions{})
+		if err != nil {
+			t.Fatalf("%s: Fsck: %v", name, err)
+		}
+		if len(issues) != 0 {
+			t.Errorf("%s: expected clean fsck, got %v", name, issues)
+		}
+	}
+
+	if err := NewMemoryLabeler().SetLabel("feature/pay", staging); err == nil {
+		t.Error("expected default pipeline to reject custom label")
+	}
+}
@@ -0,0 +1,369 @@
+package cindy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// PolicyConfig is the JSON form of a Policy, as loaded by LoadPolicy.
+//
+//	{
+//	  "name": "payments",
+//	  "from": "cindy:analyzing",
+//	  "rules": [
+//	    {"name": "schema-safety", "when": "violations > 0", "then": "cindy:rejected", "reason": "schema safety violations"},
+//	    {"name": "risk", "when": "risk.level == \"high\" || risk.underreported", "then": "cindy:human-review"}
+//	  ],
+//	  "default": {"then": "cindy:approved", "reason": "passed analysis"},
+//	  "tests": [{"manifest": "manifest-extension.json", "expect": "cindy:approved"}]
+//	}
+type PolicyConfig struct {
+	Name string `json:"name"`
+	// From is the label the policy decides from. Defaults to
+	// cindy:analyzing.
+	From    Label              `json:"from,omitempty"`
+	Rules   []PolicyRuleConfig `json:"rules"`
+	Default PolicyRuleConfig   `json:"default"`
+	// Tests are example manifests and the label the policy must yield for
+	// them. Manifest paths are relative to the policy file.
+	Tests []PolicyTestConfig `json:"tests,omitempty"`
+}
+
+// PolicyRuleConfig is one rule: when its condition holds, the change moves to
+// Then. The default rule has no condition.
+type PolicyRuleConfig struct {
+	Name   string `json:"name,omitempty"`
+	When   string `json:"when,omitempty"`
+	Then   Label  `json:"then"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// PolicyTestConfig is an example manifest and the label expected for it.
+type PolicyTestConfig struct {
+	Manifest string `json:"manifest"`
+	Expect   Label  `json:"expect"`
+}
+
+// PolicyInput is what a policy is evaluated against. Risk, Reviews and
+// Dependencies are optional.
+type PolicyInput struct {
+	Manifest   *Manifest
+	Violations []SchemaViolation
+	Risk       *RiskAssessment
+	Reviews    []Review
+	// Dependencies maps the branches in the manifest's depends_on to their
+	// current labels. Branches missing from the map count as pending.
+	Dependencies map[string]Label
+}
+
+// PolicyDecision is the outcome of evaluating a policy.
+type PolicyDecision struct {
+	Label  Label
+	Reason string
+	// Rule is the name of the rule that matched, or "default".
+	Rule string
+}
+
+func (d PolicyDecision) String() string {
+	return fmt.Sprintf("%s (%s): %s", d.Label, d.Rule, d.Reason)
+}
+
+// policyVariables documents the variables available to rule conditions.
+var policyVariables = map[string]string{
+	"manifest.revision":             "number: manifest revision",
+	"manifest.subjects":             "list: subjects_affected",
+	"manifest.consumers":            "list: consumers",
+	"manifest.depends_on":           "list: depends_on",
+	"manifest.risk_self_assessment": "string: risk_self_assessment",
+	"manifest.schema_changes":       "number: schema changes",
+	"manifest.new_subjects":         "number: schema changes of type new",
+	"manifest.extensions":           "number: schema changes of type extension",
+	"violations":                    "number: schema safety violations",
+	"violation_codes":               "list: distinct violation codes (field-removed, field-modified)",
+	"risk.score":                    "number: computed risk score (0 without a risk assessment)",
+	"risk.level":                    "string: computed risk level (\"\" without a risk assessment)",
+	"risk.self_assessment":          "string: self-assessed risk level as normalized by the scorer",
+	"risk.underreported":            "boolean: computed risk exceeds the self-assessment",
+	"reviews.count":                 "number: reviews",
+	"reviews.blocking":              "number: blocking reviews",
+	"reviews.approvals":             "number: approving reviews",
+	"dependencies.pending":          "number: dependencies not yet deployed",
+	"dependencies.failed":           "number: dependencies rejected or rolled back",
+}
+
+// PolicyVariables returns the variables rule conditions may use, with a
+// short description of each.
+func PolicyVariables() map[string]string {
+	out := make(map[string]string, len(policyVariables))
+	for k, v := range policyVariables {
+		out[k] = v
+	}
+	return out
+}
+
+type policyRule struct {
+	PolicyRuleConfig
+	cond *expr
+}
+
+// Policy decides where a change goes from its From label, cindy:analyzing
+// unless configured otherwise. Rules are tried in order; the first whose
+// condition holds decides, otherwise the default rule does. A Policy is
+// immutable once built.
+type Policy struct {
+	name   string
+	from   Label
+	rules  []policyRule
+	def    PolicyRuleConfig
+	config PolicyConfig
+}
+
+// NewPolicy builds a Policy from cfg, compiling every condition and
+// checking that every outcome is a transition out of the policy's From
+// label in pipeline p. A nil p means DefaultPipeline.
+func NewPolicy(cfg PolicyConfig, p *Pipeline) (*Policy, error) {
+	if p == nil {
+		p = DefaultPipeline()
+	}
+	from := cfg.From
+	if from == "" {
+		from = Analyzing
+	}
+	if !p.HasLabel(from) {
+		return nil, fmt.Errorf("policy %q: decides from %s, which pipeline %q does not declare", cfg.Name, from, p.Name())
+	}
+	vars := make(map[string]bool, len(policyVariables))
+	for v := range policyVariables {
+		vars[v] = true
+	}
+	check := func(r PolicyRuleConfig, what string) error {
+		if !p.CanTransition(from, r.Then) {
+			return fmt.Errorf("policy %q: %s: %s is not a transition from %s", cfg.Name, what, labelOrNone(r.Then), from)
+		}
+		return nil
+	}
+
+	pol := &Policy{name: cfg.Name, from: from, def: cfg.Default}
+	for i, rc := range cfg.Rules {
+		what := fmt.Sprintf("rule %d", i+1)
+		if rc.Name != "" {
+			what = fmt.Sprintf("rule %q", rc.Name)
+		}
+		if rc.When == "" {
+			return nil, fmt.Errorf("policy %q: %s: missing condition", cfg.Name, what)
+		}
+		cond, err := compileExpr(rc.When, vars)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %s: %w", cfg.Name, what, err)
+		}
+		if err := check(rc, what); err != nil {
+			return nil, err
+		}
+		pol.rules = append(pol.rules, policyRule{PolicyRuleConfig: rc, cond: cond})
+	}
+	if cfg.Default.When != "" {
+		return nil, fmt.Errorf("policy %q: default rule cannot have a condition", cfg.Name)
+	}
+	if err := check(cfg.Default, "default rule"); err != nil {
+		return nil, err
+	}
+	pol.config = cfg
+	return pol, nil
+}
+
+// ParsePolicy parses and validates a policy from JSON bytes.
+func ParsePolicy(data []byte, p *Pipeline) (*Policy, error) {
+	var cfg PolicyConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing policy: %w", err)
+	}
+	return NewPolicy(cfg, p)
+}
+
+// LoadPolicy reads and parses a policy from a file path.
+func LoadPolicy(path string, p *Pipeline) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy: %w", err)
+	}
+	return ParsePolicy(data, p)
+}
+
+// Name returns the policy's name.
+func (pol *Policy) Name() string {
+	return pol.name
+}
+
+// From returns the label the policy decides from.
+func (pol *Policy) From() Label {
+	return pol.from
+}
+
+// Config returns the policy's definition.
+func (pol *Policy) Config() PolicyConfig {
+	cfg := pol.config
+	cfg.Rules = append([]PolicyRuleConfig(nil), cfg.Rules...)
+	cfg.Tests = append([]PolicyTestConfig(nil), cfg.Tests...)
+	return cfg
+}
+
+// Evaluate returns the decision for in. A condition that fails to evaluate,
+// for example by comparing a string with a number, is an error.
+func (pol *Policy) Evaluate(in PolicyInput) (PolicyDecision, error) {
+	env := policyEnv(in)
+	for i, r := range pol.rules {
+		ok, err := r.cond.evalBool(env)
+		if err != nil {
+			return PolicyDecision{}, fmt.Errorf("policy %q: rule %d (%s): %w", pol.name, i+1, r.When, err)
+		}
+		if ok {
+			return decision(r.PolicyRuleConfig, fmt.Sprintf("rule %d", i+1)), nil
+		}
+	}
+	return decision(pol.def, "default"), nil
+}
+
+func decision(r PolicyRuleConfig, fallback string) PolicyDecision {
+	d := PolicyDecision{Label: r.Then, Reason: r.Reason, Rule: r.Name}
+	if d.Rule == "" {
+		d.Rule = fallback
+	}
+	if d.Reason == "" {
+		d.Reason = r.When
+	}
+	return d
+}
+
+func policyEnv(in PolicyInput) map[string]any {
+	m := in.Manifest
+	if m == nil {
+		m = &Manifest{}
+	}
+	var newSubjects, extensions float64
+	for _, sc := range m.SchemaChanges {
+		switch sc.Type {
+		case SchemaNew:
+			newSubjects++
+		case SchemaExtension:
+			extensions++
+		}
+	}
+	env := map[string]any{
+		"manifest.revision":             float64(m.Revision),
+		"manifest.subjects":             nonNil(m.SubjectsAffected),
+		"manifest.consumers":            nonNil(m.Consumers),
+		"manifest.depends_on":           nonNil(m.DependsOn),
+		"manifest.risk_self_assessment": m.RiskSelfAssessment,
+		"manifest.schema_changes":       float64(len(m.SchemaChanges)),
+		"manifest.new_subjects":         newSubjects,
+		"manifest.extensions":           extensions,
+		"violations":                    float64(len(in.Violations)),
+		"risk.score":                    0.0,
+		"risk.level":                    "",
+		"risk.self_assessment":          m.RiskSelfAssessment,
+		"risk.underreported":            false,
+	}
+
+	codes := map[string]bool{}
+	for _, v := range in.Violations {
+		codes[v.Code] = true
+	}
+	list := make([]string, 0, len(codes))
+	for c := range codes {
+		list = append(list, c)
+	}
+	sort.Strings(list)
+	env["violation_codes"] = list
+
+	if a := in.Risk; a != nil {
+		env["risk.score"] = float64(a.Score)
+		env["risk.level"] = string(a.Level)
+		env["risk.self_assessment"] = string(a.SelfAssessment)
+		env["risk.underreported"] = a.Underreported
+	}
+
+	var blocking, approvals float64
+	for i := range in.Reviews {
+		if IsBlocking(&in.Reviews[i]) {
+			blocking++
+		}
+		if in.Reviews[i].Verdict == Approve {
+			approvals++
+		}
+	}
+	env["reviews.count"] = float64(len(in.Reviews))
+	env["reviews.blocking"] = blocking
+	env["reviews.approvals"] = approvals
+
+	var pending, failed float64
+	for _, dep := range m.DependsOn {
+		switch in.Dependencies[dep] {
+		case Deployed:
+		case Rejected, Rollback:
+			failed++
+		default:
+			pending++
+		}
+	}
+	env["dependencies.pending"] = pending
+	env["dependencies.failed"] = failed
+	return env
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// PolicyTestResult is the outcome of one policy test.
+type PolicyTestResult struct {
+	Manifest string
+	Expect   Label
+	Decision PolicyDecision
+	Err      error
+}
+
+// Passed reports whether the policy yielded the expected label.
+func (r PolicyTestResult) Passed() bool {
+	return r.Err == nil && r.Decision.Label == r.Expect
+}
+
+// RunPolicyTests evaluates the policy's tests, resolving manifest paths
+// against dir. Each manifest is checked for schema violations and scored
+// with scorer (DefaultRiskScorer if nil) from the manifest alone.
+func (pol *Policy) RunPolicyTests(dir string, scorer *RiskScorer) []PolicyTestResult {
+	var results []PolicyTestResult
+	for _, tc := range pol.config.Tests {
+		r := PolicyTestResult{Manifest: tc.Manifest, Expect: tc.Expect}
+		path := tc.Manifest
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		r.Decision, r.Err = pol.EvaluateManifestFile(path, scorer)
+		results = append(results, r)
+	}
+	return results
+}
+
+// EvaluateManifestFile loads a manifest and evaluates the policy against it,
+// its schema violations and its risk as computed by scorer (DefaultRiskScorer
+// if nil) without a diff.
+func (pol *Policy) EvaluateManifestFile(path string, scorer *RiskScorer) (PolicyDecision, error) {
+	if scorer == nil {
+		scorer = DefaultRiskScorer()
+	}
+	m, err := LoadManifest(path)
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	risk, err := scorer.Score(m, nil)
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	return pol.Evaluate(PolicyInput{Manifest: m, Violations: ValidateSchemaChanges(m), Risk: risk})
+}
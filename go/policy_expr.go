@@ -0,0 +1,427 @@
+package cindy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Policy expressions are a small, side-effect-free language for rule
+// conditions:
+//
+//	risk.level == "high" || risk.underreported
+//	violations > 0 && "field-removed" in violation_codes
+//	len(manifest.depends_on) > 0 && dependencies.pending > 0
+//
+// Values are booleans, numbers, strings and lists of strings. Operators, by
+// increasing precedence: ||, &&, !, comparisons (== != < <= > >= in).
+// Parentheses group, [ ] builds a list and len() counts list elements or
+// string bytes. Identifiers must be policy variables (see PolicyVariables).
+
+type exprNode interface {
+	eval(env map[string]any) (any, error)
+}
+
+type (
+	literal  struct{ v any }
+	variable struct{ name string }
+	listExpr struct{ items []exprNode }
+	notExpr  struct{ x exprNode }
+	lenExpr  struct{ x exprNode }
+	binary   struct {
+		op   string
+		l, r exprNode
+	}
+)
+
+// expr is a compiled policy expression.
+type expr struct {
+	src  string
+	root exprNode
+}
+
+// compileExpr parses src, checking that every identifier is in vars.
+func compileExpr(src string, vars map[string]bool) (*expr, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks, vars: vars}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+	}
+	return &expr{src: src, root: root}, nil
+}
+
+// evalBool evaluates the expression, which must yield a boolean.
+func (e *expr) evalBool(env map[string]any) (bool, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression yields %s, not a boolean", typeName(v))
+	}
+	return b, nil
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d: %w", i, err)
+			}
+			toks = append(toks, token{tokString, s, i})
+			i = j + 1
+		case '0' <= c && c <= '9':
+			j := i
+			for j < len(src) && ('0' <= src[j] && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, src[i:j], i})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '.' || unicode.IsLetter(rune(src[j])) || '0' <= src[j] && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, token{tokIdent, src[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			toks = append(toks, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tokEOF, "end of expression", len(src)}), nil
+}
+
+var comparisonOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "in": true}
+
+type exprParser struct {
+	toks []token
+	pos  int
+	vars map[string]bool
+}
+
+func (p *exprParser) peek() token { return p.toks[p.pos] }
+
+func (p *exprParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at offset %d, found %q", op, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	l, err := p.and()
+	for err == nil && p.accept("||") {
+		var r exprNode
+		if r, err = p.and(); err == nil {
+			l = &binary{"||", l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	l, err := p.unary()
+	for err == nil && p.accept("&&") {
+		var r exprNode
+		if r, err = p.unary(); err == nil {
+			l = &binary{"&&", l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.accept("!") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (exprNode, error) {
+	l, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if !comparisonOps[t.text] || t.kind == tokString {
+		return l, nil
+	}
+	p.next()
+	r, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	return &binary{t.text, l, r}, nil
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", t.text, t.pos)
+		}
+		return &literal{f}, nil
+	case tokString:
+		return &literal{t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literal{true}, nil
+		case "false":
+			return &literal{false}, nil
+		case "len":
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			x, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return &lenExpr{x}, nil
+		}
+		if !p.vars[t.text] {
+			return nil, fmt.Errorf("unknown variable %q at offset %d", t.text, t.pos)
+		}
+		return &variable{t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			l := &listExpr{}
+			for !p.accept("]") {
+				if len(l.items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				x, err := p.primary()
+				if err != nil {
+					return nil, err
+				}
+				l.items = append(l.items, x)
+			}
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+}
+
+func (n *literal) eval(map[string]any) (any, error) { return n.v, nil }
+
+func (n *variable) eval(env map[string]any) (any, error) {
+	v, ok := env[n.name]
+	if !ok {
+		return nil, fmt.Errorf("variable %s is not set", n.name)
+	}
+	return v, nil
+}
+
+func (n *listExpr) eval(env map[string]any) (any, error) {
+	out := make([]string, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("list elements must be strings, got %s", typeName(v))
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (n *notExpr) eval(env map[string]any) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! needs a boolean, got %s", typeName(v))
+	}
+	return !b, nil
+}
+
+func (n *lenExpr) eval(env map[string]any) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case []string:
+		return float64(len(v)), nil
+	case string:
+		return float64(len(v)), nil
+	}
+	return nil, fmt.Errorf("len needs a list or string, got %s", typeName(v))
+}
+
+func (n *binary) eval(env map[string]any) (any, error) {
+	l, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "||" || n.op == "&&" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs booleans, got %s", n.op, typeName(l))
+		}
+		if n.op == "||" && lb || n.op == "&&" && !lb {
+			return lb, nil
+		}
+		r, err := n.r.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs booleans, got %s", n.op, typeName(r))
+		}
+		return rb, nil
+	}
+
+	r, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "in":
+		s, ok := l.(string)
+		list, ok2 := r.([]string)
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("in needs a string and a list, got %s and %s", typeName(l), typeName(r))
+		}
+		for _, item := range list {
+			if item == s {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "==", "!=":
+		if typeName(l) != typeName(r) {
+			return nil, fmt.Errorf("cannot compare %s with %s", typeName(l), typeName(r))
+		}
+		var eq bool
+		if ll, ok := l.([]string); ok {
+			eq = strings.Join(ll, "\x00") == strings.Join(r.([]string), "\x00") && len(ll) == len(r.([]string))
+		} else {
+			eq = l == r
+		}
+		return eq == (n.op == "=="), nil
+	}
+
+	lf, ok := l.(float64)
+	rf, ok2 := r.(float64)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("%s needs numbers, got %s and %s", n.op, typeName(l), typeName(r))
+	}
+	switch n.op {
+	case "<":
+		return lf < rf, nil
+	case "<=":
+		return lf <= rf, nil
+	case ">":
+		return lf > rf, nil
+	default:
+		return lf >= rf, nil
+	}
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []string:
+		return "list"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,188 @@
+package cindy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicyExpressions(t *testing.T) {
+	vars := map[string]bool{"n": true, "s": true, "list": true, "flag": true}
+	env := map[string]any{"n": 3.0, "s": "high", "list": []string{"a", "b"}, "flag": false}
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"n > 2", true},
+		{"n >= 3 && n <= 3", true},
+		{"n < 1 || s == \"high\"", true},
+		{"!flag", true},
+		{"!(n > 2)", false},
+		{"\"a\" in list", true},
+		{"\"c\" in list", false},
+		{"s in [\"medium\", \"high\"]", true},
+		{"len(list) == 2 && len(s) == 4", true},
+		{"flag && missing_is_never_evaluated", false},
+		{"list == [\"a\", \"b\"]", true},
+		{"s != \"low\"", true},
+	}
+	vars["missing_is_never_evaluated"] = true
+	for _, tt := range tests {
+		e, err := compileExpr(tt.src, vars)
+		if err != nil {
+			t.Errorf("compile %q: %v", tt.src, err)
+			continue
+		}
+		got, err := e.evalBool(env)
+		if err != nil || got != tt.want {
+			t.Errorf("%q = %v, %v; want %v", tt.src, got, err, tt.want)
+		}
+	}
+}
+
+func TestPolicyExpressions_Errors(t *testing.T) {
+	vars := map[string]bool{"n": true, "s": true}
+	for _, src := range []string{"", "n >", "n > 2 &&", "(n > 2", "unknown > 1", "n = 2", "\"open", "n > 2 n"} {
+		if _, err := compileExpr(src, vars); err == nil {
+			t.Errorf("compile %q: expected error", src)
+		}
+	}
+
+	env := map[string]any{"n": 1.0, "s": "x"}
+	for _, src := range []string{"n", "s > 1", "n == s", "!n", "n && true", "len(n) > 0", "n in s"} {
+		e, err := compileExpr(src, vars)
+		if err != nil {
+			t.Fatalf("compile %q: %v", src, err)
+		}
+		if _, err := e.evalBool(env); err == nil {
+			t.Errorf("eval %q: expected type error", src)
+		}
+	}
+}
+
+func TestPolicy_Evaluate(t *testing.T) {
+	pol, err := LoadPolicy("../examples/policy.json", nil)
+	if err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	m := &Manifest{Revision: 1, DependsOn: []string{"feature/a", "feature/b"}, RiskSelfAssessment: "low"}
+
+	tests := []struct {
+		name string
+		in   PolicyInput
+		want Label
+	}{
+		{"pending dependency", PolicyInput{Manifest: m, Dependencies: map[string]Label{"feature/a": Deployed}}, Blocked},
+		{"failed dependency", PolicyInput{Manifest: m, Dependencies: map[string]Label{"feature/a": Deployed, "feature/b": Rollback}}, Rejected},
+		{"blocking review", PolicyInput{
+			Manifest:     m,
+			Dependencies: map[string]Label{"feature/a": Deployed, "feature/b": Deployed},
+			Reviews:      []Review{{ID: "r1", Verdict: RequestChanges, Comments: []ReviewComment{{ID: "c1"}}}},
+		}, RevisionRequested},
+		{"under-reported risk", PolicyInput{
+			Manifest:     m,
+			Dependencies: map[string]Label{"feature/a": Deployed, "feature/b": Deployed},
+			Risk:         &RiskAssessment{Level: RiskMedium, SelfAssessment: RiskLow, Underreported: true},
+		}, HumanReview},
+		{"clean", PolicyInput{Manifest: m, Dependencies: map[string]Label{"feature/a": Deployed, "feature/b": Deployed}}, Approved},
+	}
+	for _, tt := range tests {
+		d, err := pol.Evaluate(tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if d.Label != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, d, tt.want)
+		}
+	}
+}
+
+func TestPolicy_ViolationCodes(t *testing.T) {
+	pol, err := ParsePolicy([]byte(`{
+		"name": "codes",
+		"rules": [{"when": "\"field-modified\" in violation_codes", "then": "cindy:human-review"}],
+		"default": {"then": "cindy:approved"}
+	}`), nil)
+	if err != nil {
+		t.Fatalf("ParsePolicy: %v", err)
+	}
+	m := &Manifest{SchemaChanges: []SchemaChange{{Subject: "a.b", FieldsModified: []string{"amount"}}}}
+	d, err := pol.Evaluate(PolicyInput{Manifest: m, Violations: ValidateSchemaChanges(m)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Label != HumanReview || d.Rule != "rule 1" || d.Reason != `"field-modified" in violation_codes` {
+		t.Errorf("unexpected decision %+v", d)
+	}
+}
+
+func TestNewPolicy_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  PolicyConfig
+		want string
+	}{
+		{"bad expression", PolicyConfig{Rules: []PolicyRuleConfig{{Name: "r", When: "risk.level ==", Then: Approved}}, Default: PolicyRuleConfig{Then: Approved}}, `rule "r"`},
+		{"unknown variable", PolicyConfig{Rules: []PolicyRuleConfig{{When: "riks.level == \"high\"", Then: Approved}}, Default: PolicyRuleConfig{Then: Approved}}, "unknown variable"},
+		{"missing condition", PolicyConfig{Rules: []PolicyRuleConfig{{Then: Approved}}, Default: PolicyRuleConfig{Then: Approved}}, "missing condition"},
+		{"unreachable outcome", PolicyConfig{Rules: []PolicyRuleConfig{{When: "true", Then: Deployed}}, Default: PolicyRuleConfig{Then: Approved}}, "not a transition"},
+		{"no default", PolicyConfig{}, "default rule"},
+		{"conditional default", PolicyConfig{Default: PolicyRuleConfig{When: "true", Then: Approved}}, "cannot have a condition"},
+		{"undeclared from", PolicyConfig{From: "cindy:checking", Default: PolicyRuleConfig{Then: Approved}}, `decides from cindy:checking, which pipeline "cindy" does not declare`},
+	}
+	for _, tt := range tests {
+		_, err := NewPolicy(tt.cfg, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestNewPolicy_From(t *testing.T) {
+	p, err := NewPipeline(PipelineConfig{
+		Name:   "ops",
+		Labels: []Label{"cindy:queued", "cindy:checking", "cindy:ok", "cindy:no"},
+		Transitions: []TransitionConfig{
+			{From: "cindy:queued", To: "cindy:checking"},
+			{From: "cindy:checking", To: "cindy:ok"},
+			{From: "cindy:checking", To: "cindy:no"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := PolicyConfig{
+		Rules:   []PolicyRuleConfig{{When: "violations > 0", Then: "cindy:no"}},
+		Default: PolicyRuleConfig{Then: "cindy:ok"},
+	}
+	if _, err := NewPolicy(cfg, p); err == nil || !strings.Contains(err.Error(), "decides from cindy:analyzing") {
+		t.Fatalf("expected the default from label to be refused, got %v", err)
+	}
+	cfg.From = "cindy:checking"
+	pol, err := NewPolicy(cfg, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pol.From() != "cindy:checking" {
+		t.Errorf("From() = %s", pol.From())
+	}
+	if d, err := pol.Evaluate(PolicyInput{Manifest: &Manifest{}}); err != nil || d.Label != "cindy:ok" {
+		t.Errorf("unexpected decision %v, %v", d, err)
+	}
+}
+
+func TestPolicy_ExampleTests(t *testing.T) {
+	pol, err := LoadPolicy("../examples/policy.json", nil)
+	if err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	results := pol.RunPolicyTests("../examples", nil)
+	if len(results) == 0 {
+		t.Fatal("expected example policy to declare tests")
+	}
+	for _, r := range results {
+		if !r.Passed() {
+			t.Errorf("%s: got %s, %v; want %s", r.Manifest, r.Decision, r.Err, r.Expect)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package cindy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrArchiveUnsupported is returned by Prune when the Labeler cannot archive labels.
+var ErrArchiveUnsupported = errors.New("labeler does not support archiving")
+
+// Archiver is implemented by Labelers that can move a label out of the active
+// set while keeping it, and the branch's history, for later inspection.
+type Archiver interface {
+	// ArchiveLabel removes the branch's label from the active set, records
+	// the removal in its history and keeps the label in the archive.
+	ArchiveLabel(branch string, meta Metadata) error
+	// ArchivedLabels returns the last archived label of each archived branch.
+	ArchivedLabels() (map[string]Label, error)
+}
+
+// RetentionPolicy decides when finished work is pruned from the active pipeline.
+type RetentionPolicy struct {
+	// Labels are the states eligible for pruning.
+	Labels []Label
+	// MaxAge prunes an eligible label once its last transition is older than
+	// MaxAge. Requires a RecordingLabeler. Zero disables age-based pruning.
+	MaxAge time.Duration
+	// PruneDeletedBranches prunes eligible labels whose branch no longer
+	// exists, regardless of age. Requires a BranchLister.
+	PruneDeletedBranches bool
+}
+
+// DefaultRetentionPolicy prunes deployed and rejected branches after 30
+// days, or as soon as their branch is deleted. Rolled-back branches are kept
+// until they continue as a new revision.
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		Labels:               []Label{Deployed, Rejected},
+		MaxAge:               30 * 24 * time.Hour,
+		PruneDeletedBranches: true,
+	}
+}
+
+// PruneOptions configures a Prune run.
+type PruneOptions struct {
+	// DryRun reports what would be pruned without changing anything.
+	DryRun bool
+	// Actor is recorded in the metadata of archived labels. Defaults to "cindy-prune".
+	Actor string
+	// Now is the reference time for MaxAge. Defaults to time.Now().
+	Now time.Time
+}
+
+// PrunedLabel describes a label archived by Prune.
+type PrunedLabel struct {
+	Branch string
+	Label  Label
+	Reason string
+}
+
+func (p PrunedLabel) String() string {
+	return fmt.Sprintf("%s (%s): %s", p.Branch, p.Label, p.Reason)
+}
+
+// Prune archives the labels that policy marks as finished so that active
+// pipeline queries only see live work. Labels are never deleted outright:
+// l must implement Archiver, and the archived label stays recoverable.
+func Prune(l Labeler, policy RetentionPolicy, opts PruneOptions) ([]PrunedLabel, error) {
+	archiver, ok := l.(Archiver)
+	if !ok {
+		return nil, ErrArchiveUnsupported
+	}
+	if opts.Actor == "" {
+		opts.Actor = "cindy-prune"
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	eligible := make(map[Label]bool, len(policy.Labels))
+	for _, label := range policy.Labels {
+		eligible[label] = true
+	}
+
+	current, err := l.AllLabels()
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]string, 0, len(current))
+	for b, label := range current {
+		if eligible[label] {
+			branches = append(branches, b)
+		}
+	}
+	sort.Strings(branches)
+
+	var existing map[string]bool
+	if lister, ok := l.(BranchLister); ok && policy.PruneDeletedBranches {
+		if existing, err = lister.Branches(); err != nil {
+			return nil, err
+		}
+	}
+	recorder, _ := l.(RecordingLabeler)
+
+	var pruned []PrunedLabel
+	for _, branch := range branches {
+		var reason string
+		if existing != nil && !existing[branch] {
+			reason = "branch no longer exists"
+		} else if policy.MaxAge > 0 && recorder != nil {
+			history, err := recorder.History(branch)
+			if err != nil {
+				return pruned, err
+			}
+			if n := len(history); n > 0 {
+				age := opts.Now.Sub(history[n-1].Timestamp)
+				if age > policy.MaxAge {
+					reason = fmt.Sprintf("%s for %s", ShortLabel(current[branch]), age.Round(time.Hour))
+				}
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		if !opts.DryRun {
+			meta := Metadata{Actor: opts.Actor, Reason: "pruned: " + reason, Timestamp: opts.Now}
+			if err := archiver.ArchiveLabel(branch, meta); err != nil {
+				return pruned, fmt.Errorf("archiving %s: %w", branch, err)
+			}
+		}
+		pruned = append(pruned, PrunedLabel{Branch: branch, Label: current[branch], Reason: reason})
+	}
+	return pruned, nil
+}
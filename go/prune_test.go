@@ -0,0 +1,158 @@
+package cindy
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// walk moves branch through labels on l, stamping each step with at.
+func walk(t *testing.T, l RecordingLabeler, branch string, at time.Time, labels ...Label) {
+	t.Helper()
+	for _, label := range labels {
+		if err := l.SetLabelWithMetadata(branch, label, Metadata{Actor: "test", Timestamp: at}); err != nil {
+			t.Fatalf("SetLabelWithMetadata(%s, %s): %v", branch, label, err)
+		}
+	}
+}
+
+func TestPrune_MaxAge(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-45 * 24 * time.Hour)
+	recent := now.Add(-2 * 24 * time.Hour)
+
+	ml := NewMemoryLabeler()
+	walk(t, ml, "feature/old-deployed", old, Ready, Analyzing, Approved, Deploying, Deployed)
+	walk(t, ml, "feature/old-rejected", old, Ready, Analyzing, Rejected)
+	walk(t, ml, "feature/new-deployed", recent, Ready, Analyzing, Approved, Deploying, Deployed)
+	walk(t, ml, "feature/old-active", old, Ready, Analyzing, HumanReview)
+	walk(t, ml, "feature/old-rollback", old, Ready, Analyzing, Approved, Deploying, Deployed, Rollback)
+
+	policy := DefaultRetentionPolicy()
+
+	dry, err := Prune(ml, policy, PruneOptions{DryRun: true, Now: now})
+	if err != nil {
+		t.Fatalf("Prune dry run: %v", err)
+	}
+	if len(dry) != 2 {
+		t.Fatalf("expected 2 prunable labels, got %v", dry)
+	}
+	if all, _ := ml.AllLabels(); len(all) != 5 {
+		t.Errorf("dry run must not change labels, got %v", all)
+	}
+
+	pruned, err := Prune(ml, policy, PruneOptions{Now: now})
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if len(pruned) != 2 || pruned[0].Branch != "feature/old-deployed" || pruned[1].Branch != "feature/old-rejected" {
+		t.Fatalf("unexpected pruned labels %v", pruned)
+	}
+
+	all, _ := ml.AllLabels()
+	if len(all) != 3 || all["feature/new-deployed"] != Deployed || all["feature/old-active"] != HumanReview || all["feature/old-rollback"] != Rollback {
+		t.Errorf("expected only live work to remain, got %v", all)
+	}
+	archived, _ := ml.ArchivedLabels()
+	if archived["feature/old-deployed"] != Deployed || archived["feature/old-rejected"] != Rejected {
+		t.Errorf("expected archived labels, got %v", archived)
+	}
+
+	history, _ := ml.History("feature/old-deployed")
+	if len(history) != 6 {
+		t.Fatalf("expected history kept plus archive entry, got %d", len(history))
+	}
+	if last := history[5]; last.To != "" || last.Actor != "cindy-prune" {
+		t.Errorf("unexpected archive transition %+v", last)
+	}
+	if v := ValidateHistory(history); len(v) != 0 {
+		t.Errorf("archived history should stay valid, got %v", v)
+	}
+}
+
+func TestPrune_DeletedBranch(t *testing.T) {
+	repo := initGitRepo(t)
+	exec.Command("git", "-C", repo, "branch", "feature/live").Run()
+	gl, _ := NewGitLabeler(repo)
+
+	now := time.Now()
+	walk(t, gl, "feature/live", now, Ready, Analyzing, Rejected)
+	walk(t, gl, "feature/merged", now, Ready, Analyzing, Rejected)
+	walk(t, gl, "feature/wip", now, Ready)
+
+	pruned, err := Prune(gl, DefaultRetentionPolicy(), PruneOptions{})
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if len(pruned) != 1 || pruned[0].Branch != "feature/merged" || pruned[0].Label != Rejected {
+		t.Fatalf("unexpected pruned labels %v", pruned)
+	}
+
+	all, _ := gl.AllLabels()
+	if _, ok := all["feature/merged"]; ok {
+		t.Error("expected pruned label to leave the active set")
+	}
+	if all["feature/wip"] != Ready {
+		t.Error("non-eligible labels must be kept even for deleted branches")
+	}
+	archived, err := gl.ArchivedLabels()
+	if err != nil {
+		t.Fatalf("ArchivedLabels: %v", err)
+	}
+	if len(archived) != 1 || archived["feature/merged"] != Rejected {
+		t.Errorf("unexpected archive %v", archived)
+	}
+	history, _ := gl.History("feature/merged")
+	if len(history) != 4 {
+		t.Errorf("expected history kept, got %d entries", len(history))
+	}
+}
+
+func TestGitLabeler_ArchiveLabelAtomic(t *testing.T) {
+	repo := initGitRepo(t)
+	gl, _ := NewGitLabeler(repo)
+	now := time.Now()
+	walk(t, gl, "feature/x", now, Ready, Analyzing, Rejected)
+	if err := gl.ArchiveLabel("feature/x", Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	walk(t, gl, "feature/x", now, Ready, Analyzing, Approved, Deploying, Deployed)
+
+	// With the history ref locked, nothing of the archive step happens.
+	lock := filepath.Join(repo, ".git", historyRef("feature/x")+".lock")
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gl.ArchiveLabel("feature/x", Metadata{}); err == nil {
+		t.Fatal("expected ArchiveLabel to fail")
+	}
+	if label, _ := gl.GetLabel("feature/x"); label != Deployed {
+		t.Errorf("label %s, want %s kept", label, Deployed)
+	}
+	if archived, _ := gl.ArchivedLabels(); archived["feature/x"] != Rejected {
+		t.Errorf("archive %v, want the previous entry kept", archived)
+	}
+
+	os.Remove(lock)
+	if err := gl.ArchiveLabel("feature/x", Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if label, _ := gl.GetLabel("feature/x"); label != "" {
+		t.Errorf("label %s, want none", label)
+	}
+	if archived, _ := gl.ArchivedLabels(); len(archived) != 1 || archived["feature/x"] != Deployed {
+		t.Errorf("archive %v, want only %s", archived, Deployed)
+	}
+}
+
+type plainLabeler struct{ Labeler }
+
+func TestPrune_Unsupported(t *testing.T) {
+	_, err := Prune(plainLabeler{NewMemoryLabeler()}, DefaultRetentionPolicy(), PruneOptions{})
+	if !errors.Is(err, ErrArchiveUnsupported) {
+		t.Errorf("expected ErrArchiveUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package cindy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// PushEvent is a branch push reported by a forge webhook.
+type PushEvent struct {
+	Branch string
+	// Commit is the pushed head of the branch.
+	Commit  string
+	Deleted bool
+	Pusher  string
+}
+
+// pushPayload holds the fields GitHub and Gitea push payloads share.
+type pushPayload struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Deleted bool   `json:"deleted"`
+	Pusher  struct {
+		Name     string `json:"name"`
+		Login    string `json:"login"`
+		Username string `json:"username"`
+	} `json:"pusher"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+// ParsePushEvent decodes a push webhook from GitHub or Gitea (including
+// Forgejo, which sends Gitea's headers). It returns nil, nil for deliveries
+// that are not branch pushes, such as pings and tag pushes.
+func ParsePushEvent(header http.Header, body []byte) (*PushEvent, error) {
+	event := header.Get("X-Gitea-Event")
+	if event == "" {
+		event = header.Get("X-GitHub-Event")
+	}
+	if event != "push" {
+		return nil, nil
+	}
+	var p pushPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("parsing push event: %w", err)
+	}
+	branch, ok := strings.CutPrefix(p.Ref, "refs/heads/")
+	if !ok {
+		return nil, nil
+	}
+	ev := &PushEvent{
+		Branch:  branch,
+		Commit:  p.After,
+		Deleted: p.Deleted || strings.Trim(p.After, "0") == "",
+	}
+	for _, name := range []string{p.Pusher.Login, p.Pusher.Username, p.Pusher.Name, p.Sender.Login} {
+		if name != "" {
+			ev.Pusher = name
+			break
+		}
+	}
+	return ev, nil
+}
+
+// isObjectID reports whether s is a full SHA-1 or SHA-256 object ID, so a
+// commit named in a webhook cannot be taken by git for an option or a
+// revision expression.
+func isObjectID(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyPushSignature checks the signature GitHub (X-Hub-Signature-256,
+// "sha256=<hex>") or Gitea (X-Gitea-Signature, "<hex>") sends with a delivery.
+func verifyPushSignature(secret string, header http.Header, body []byte) bool {
+	if sig := header.Get("X-Hub-Signature-256"); sig != "" {
+		return VerifySignature(secret, body, sig)
+	}
+	if sig := header.Get("X-Gitea-Signature"); sig != "" {
+		return VerifySignature(secret, body, "sha256="+sig)
+	}
+	return false
+}
+
+// PushAction is what a PushReceiver did about a push.
+type PushAction string
+
+const (
+	// PushLabeled means an unlabeled branch was submitted at cindy:ready.
+	PushLabeled PushAction = "labeled"
+	// PushResubmitted means a cindy:revision-requested branch went back to cindy:ready.
+	PushResubmitted PushAction = "resubmitted"
+	// PushInvalid means the pushed manifest is malformed; nothing changed.
+	PushInvalid PushAction = "invalid"
+	// PushRefused means the resubmission broke the revision or resolution rules.
+	PushRefused PushAction = "refused"
+	// PushIgnored means the push needs no action, e.g. the branch has no
+	// manifest or is already in the pipeline.
+	PushIgnored PushAction = "ignored"
+)
+
+// PushResult reports how a PushReceiver handled a push.
+type PushResult struct {
+	Branch   string     `json:"branch"`
+	Action   PushAction `json:"action"`
+	Label    Label      `json:"label,omitempty"`
+	Reason   string     `json:"reason"`
+	Problems []string   `json:"problems,omitempty"`
+}
+
+// PushReceiver turns forge push webhooks into submissions, so an agent only
+// has to push. A push of a branch carrying a valid .cindy/manifest.json
+// labels an unlabeled branch cindy:ready, and moves a
+// cindy:revision-requested branch back to cindy:ready if the manifest is a
+// new revision answering the latest request_changes review and the pipeline's
+// resolution guards pass. Pushes to branches elsewhere in the pipeline are
+// ignored.
+//
+// Responses are JSON PushResults. Every handled push answers 200, so forges
+// only redeliver on real failures.
+type PushReceiver struct {
+	Engine *Engine
+	// Repo is the git repository the pushed commits are read from.
+	Repo string
+	// Remote, if set, is fetched from before reading a pushed commit, for
+	// receivers running on a clone rather than on the git server.
+	Remote string
+	// Secret verifies the signature of every delivery. Deliveries without
+	// a valid signature are refused; leave empty only on trusted networks.
+	Secret string
+	// Reviews provides the reviews resubmissions are checked against.
+	Reviews ReviewStore
+	// Actor is recorded in the metadata of the transitions the receiver
+	// applies when the push does not name its pusher. Defaults to
+	// "cindy-push".
+	Actor string
+}
+
+// ServeHTTP implements http.Handler.
+func (pr *PushReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, &requestError{http.StatusMethodNotAllowed, "push events must be POSTed"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 25<<20))
+	if err != nil {
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+	if pr.Secret != "" && !verifyPushSignature(pr.Secret, r.Header, body) {
+		writeError(w, &requestError{http.StatusUnauthorized, "missing or invalid signature"})
+		return
+	}
+	ev, err := ParsePushEvent(r.Header, body)
+	if err != nil {
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+	if ev == nil {
+		writeJSON(w, http.StatusOK, PushResult{Action: PushIgnored, Reason: "not a branch push"})
+		return
+	}
+	result, err := pr.HandlePush(ev)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// HandlePush acts on a push event.
+func (pr *PushReceiver) HandlePush(ev *PushEvent) (*PushResult, error) {
+	res := &PushResult{Branch: ev.Branch, Action: PushIgnored}
+	if err := ValidateBranchName(ev.Branch); err != nil {
+		return nil, err
+	}
+	if ev.Deleted {
+		res.Reason = "branch deleted"
+		return res, nil
+	}
+	if !isObjectID(ev.Commit) {
+		return nil, badRequest(fmt.Sprintf("pushed commit %q is not a full object ID", ev.Commit))
+	}
+	if pr.Remote != "" {
+		out, err := exec.Command("git", "-C", pr.Repo, "fetch", "--quiet", pr.Remote,
+			"+refs/heads/"+ev.Branch+":refs/remotes/"+pr.Remote+"/"+ev.Branch).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("fetching %s: %s", ev.Branch, strings.TrimSpace(string(out)))
+		}
+	}
+	data, err := ReadManifestAt(pr.Repo, ev.Commit)
+	if errors.Is(err, ErrNoManifest) {
+		res.Reason = "no " + ManifestPath
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m, err := ValidateManifest(data)
+	var me *ManifestError
+	if errors.As(err, &me) {
+		res.Action, res.Reason, res.Problems = PushInvalid, "manifest is invalid", me.Problems
+		return res, nil
+	}
+
+	from, err := pr.Engine.Labeler().GetLabel(ev.Branch)
+	if err != nil {
+		return nil, err
+	}
+	res.Label = from
+	req := TransitionRequest{
+		Branch:   ev.Branch,
+		To:       pr.Engine.Pipeline().Entry(),
+		Metadata: Metadata{Actor: pr.actor(ev), Reason: fmt.Sprintf("revision %d pushed at %s", m.Revision, ev.Commit)},
+		Manifest: m,
+		Expect:   &from,
+	}
+	switch from {
+	case "":
+		res.Action = PushLabeled
+	case RevisionRequested:
+		if pr.Reviews != nil {
+			reviews, err := pr.Reviews.Reviews(ev.Branch)
+			if err != nil {
+				return nil, err
+			}
+			req.Reviews = append([]Review{}, reviews...)
+		}
+		if err := ValidateRevision(m, req.Reviews); err != nil {
+			res.Action, res.Reason = PushRefused, err.Error()
+			return res, nil
+		}
+		res.Action = PushResubmitted
+	default:
+		res.Reason = "branch is already " + string(from)
+		return res, nil
+	}
+
+	_, err = pr.Engine.ApplyTransition(req)
+	var he *HookError
+	var ge *GuardError
+	switch {
+	case err == nil || errors.As(err, &he):
+		res.Label, res.Reason = req.To, req.Metadata.Reason
+		return res, nil
+	case errors.As(err, &ge), errors.Is(err, ErrPermissionDenied):
+		res.Action, res.Reason = PushRefused, err.Error()
+		return res, nil
+	}
+	return nil, err
+}
+
+func (pr *PushReceiver) actor(ev *PushEvent) string {
+	if ev.Pusher != "" {
+		return ev.Pusher
+	}
+	if pr.Actor == "" {
+		return "cindy-push"
+	}
+	return pr.Actor
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,270 @@
+package cindy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateManifest_Examples(t *testing.T) {
+	paths, _ := filepath.Glob("../examples/manifest-*.json")
+	if len(paths) == 0 {
+		t.Fatal("no example manifests")
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateManifest(data); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestValidateManifest(t *testing.T) {
+	valid := map[string]any{
+		"revision":             1,
+		"responds_to":          nil,
+		"subjects_affected":    []string{"orders.created"},
+		"schema_changes":       []any{map[string]any{"subject": "orders.created", "type": "extension", "fields_added": []string{"x"}, "fields_removed": []string{}, "fields_modified": []string{}}},
+		"consumers":            []string{},
+		"risk_self_assessment": "low",
+		"depends_on":           []string{"feature/base"},
+		"description":          "Add x",
+	}
+	tests := []struct {
+		name   string
+		change func(m map[string]any)
+		want   string
+	}{
+		{"valid", func(m map[string]any) {}, ""},
+		{"missing field", func(m map[string]any) { delete(m, "consumers") }, "missing required field consumers"},
+		{"wrong type", func(m map[string]any) { m["revision"] = "2" }, "cannot unmarshal"},
+		{"null list", func(m map[string]any) { m["depends_on"] = nil }, "depends_on must be an array"},
+		{"revision zero", func(m map[string]any) { m["revision"] = 0 }, "at least 1"},
+		{"first revision responds", func(m map[string]any) { m["responds_to"] = "r1" }, "null on revision 1"},
+		{"resubmission without responds_to", func(m map[string]any) { m["revision"] = 2 }, "must set responds_to"},
+		{"unknown risk", func(m map[string]any) { m["risk_self_assessment"] = "none" }, "risk_self_assessment"},
+		{"empty description", func(m map[string]any) { m["description"] = " " }, "description must not be empty"},
+		{"unlisted subject", func(m map[string]any) { m["subjects_affected"] = []string{"orders.updated"} }, `subject "orders.created" is not listed`},
+		{"bad change type", func(m map[string]any) {
+			m["schema_changes"] = []any{map[string]any{"subject": "orders.created", "type": "rename"}}
+		}, "schema_changes[0].type"},
+		{"incomplete change", func(m map[string]any) {
+			m["schema_changes"] = []any{map[string]any{"subject": "orders.created", "type": "new"}}
+		}, "missing required field schema_changes[0].fields_added"},
+		{"bad dependency", func(m map[string]any) { m["depends_on"] = []string{"feature..x"} }, "depends_on[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := make(map[string]any)
+			for k, v := range valid {
+				m[k] = v
+			}
+			tt.change(m)
+			data, _ := json.Marshal(m)
+			_, err := ValidateManifest(data)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidManifest) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePushEvent(t *testing.T) {
+	github := http.Header{"X-Github-Event": {"push"}}
+	gitea := http.Header{"X-Gitea-Event": {"push"}, "X-Github-Event": {"push"}}
+	tests := []struct {
+		name   string
+		header http.Header
+		body   string
+		want   *PushEvent
+	}{
+		{"github", github, `{"ref":"refs/heads/feature/x","after":"abc","pusher":{"name":"agent-7"}}`,
+			&PushEvent{Branch: "feature/x", Commit: "abc", Pusher: "agent-7"}},
+		{"gitea", gitea, `{"ref":"refs/heads/feature/x","after":"abc","pusher":{"login":"agent-7","username":"agent-7"}}`,
+			&PushEvent{Branch: "feature/x", Commit: "abc", Pusher: "agent-7"}},
+		{"github delete", github, `{"ref":"refs/heads/feature/x","after":"0000000000000000000000000000000000000000","deleted":true}`,
+			&PushEvent{Branch: "feature/x", Commit: "0000000000000000000000000000000000000000", Deleted: true}},
+		{"gitea delete", gitea, `{"ref":"refs/heads/feature/x","after":"0000000000000000000000000000000000000000"}`,
+			&PushEvent{Branch: "feature/x", Commit: "0000000000000000000000000000000000000000", Deleted: true}},
+		{"tag push", github, `{"ref":"refs/tags/v1","after":"abc"}`, nil},
+		{"ping", http.Header{"X-Github-Event": {"ping"}}, `{"zen":"hi"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePushEvent(tt.header, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// pushFixture is a repository with a PushReceiver serving it.
+type pushFixture struct {
+	repo  string
+	git   func(args ...string) string
+	ml    *MemoryLabeler
+	store *MemoryReviewStore
+	srv   *httptest.Server
+}
+
+const pushSecret = "hook-secret"
+
+func newPushFixture(t *testing.T) *pushFixture {
+	t.Helper()
+	repo, git := mergeRepo(t)
+	f := &pushFixture{repo: repo, git: git, ml: NewMemoryLabeler(), store: NewMemoryReviewStore()}
+	f.srv = httptest.NewServer(&PushReceiver{Engine: NewEngine(f.ml, nil), Repo: repo, Secret: pushSecret, Reviews: f.store})
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// commitManifest commits manifest on branch and returns the new head.
+func (f *pushFixture) commitManifest(t *testing.T, branch, manifest string) string {
+	t.Helper()
+	f.git("checkout", "-q", "-B", branch)
+	if err := os.MkdirAll(filepath.Join(f.repo, ".cindy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, f.repo, f.git, ManifestPath, manifest)
+	return f.git("rev-parse", "HEAD")
+}
+
+// push delivers a GitHub push event for branch at commit.
+func (f *pushFixture) push(t *testing.T, branch, commit, secret string) (int, PushResult) {
+	t.Helper()
+	body := fmt.Sprintf(`{"ref":"refs/heads/%s","after":"%s","pusher":{"name":"agent-7"}}`, branch, commit)
+	req, _ := http.NewRequest("POST", f.srv.URL, bytes.NewReader([]byte(body)))
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Hub-Signature-256", Sign(secret, []byte(body)))
+	resp, err := f.srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res PushResult
+	json.NewDecoder(resp.Body).Decode(&res)
+	return resp.StatusCode, res
+}
+
+func manifestJSON(revision int, respondsTo string) string {
+	rt := "null"
+	if respondsTo != "" {
+		rt = `"` + respondsTo + `"`
+	}
+	return fmt.Sprintf(`{"revision": %d, "responds_to": %s, "subjects_affected": [], "schema_changes": [],
+		"consumers": [], "risk_self_assessment": "low", "depends_on": [], "description": "change"}`, revision, rt)
+}
+
+func TestPushReceiver_Submission(t *testing.T) {
+	f := newPushFixture(t)
+	head := f.commitManifest(t, "feature/x", manifestJSON(1, ""))
+
+	if code, _ := f.push(t, "feature/x", head, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("bad signature: got %d", code)
+	}
+	code, res := f.push(t, "feature/x", head, pushSecret)
+	if code != http.StatusOK || res.Action != PushLabeled || res.Label != Ready {
+		t.Fatalf("submission: %d %+v", code, res)
+	}
+	history, _ := f.ml.History("feature/x")
+	if len(history) != 1 || history[0].Actor != "agent-7" || !strings.Contains(history[0].Reason, "revision 1") {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	// Pushing again while the branch is in the pipeline changes nothing.
+	if _, res := f.push(t, "feature/x", head, pushSecret); res.Action != PushIgnored {
+		t.Errorf("second push: %+v", res)
+	}
+
+	f.git("checkout", "-q", "-b", "feature/plain", "main")
+	commitFile(t, f.repo, f.git, "README", "hi\n")
+	if _, res := f.push(t, "feature/plain", f.git("rev-parse", "HEAD"), pushSecret); res.Action != PushIgnored || !strings.Contains(res.Reason, "no .cindy/manifest.json") {
+		t.Errorf("branch without manifest: %+v", res)
+	}
+
+	for _, commit := range []string{"--output=/tmp/pwned", "HEAD", "abc"} {
+		if code, _ := f.push(t, "feature/plain", commit, pushSecret); code != http.StatusBadRequest {
+			t.Errorf("commit %q: got %d, want 400", commit, code)
+		}
+	}
+
+	bad := f.commitManifest(t, "feature/bad", `{"revision": 1}`)
+	if _, res := f.push(t, "feature/bad", bad, pushSecret); res.Action != PushInvalid || len(res.Problems) == 0 {
+		t.Errorf("invalid manifest: %+v", res)
+	}
+	if label, _ := f.ml.GetLabel("feature/bad"); label != "" {
+		t.Errorf("invalid manifest was labeled %s", label)
+	}
+}
+
+func TestPushReceiver_Resubmission(t *testing.T) {
+	f := newPushFixture(t)
+	walk(t, f.ml, "feature/x", time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), Ready, Analyzing, RevisionRequested)
+	file := "orders.go"
+	review := Review{ID: "r1", Branch: "feature/x", Revision: 1, Actor: "reviewer", Verdict: RequestChanges,
+		Comments: []ReviewComment{{ID: "c1", File: &file, Body: "handle nil"}}}
+	f.store.SaveReview(review)
+
+	stale := f.commitManifest(t, "feature/x", manifestJSON(1, ""))
+	if _, res := f.push(t, "feature/x", stale, pushSecret); res.Action != PushRefused || !strings.Contains(res.Reason, "does not increment") {
+		t.Errorf("push without a new revision: %+v", res)
+	}
+
+	head := f.commitManifest(t, "feature/x", manifestJSON(2, "r1"))
+	if _, res := f.push(t, "feature/x", head, pushSecret); res.Action != PushRefused || !strings.Contains(res.Reason, "no-blocking-reviews") {
+		t.Errorf("push with unresolved comments: %+v", res)
+	}
+
+	review.Comments[0].Resolved = true
+	f.store.SaveReview(review)
+	if _, res := f.push(t, "feature/x", head, pushSecret); res.Action != PushResubmitted || res.Label != Ready {
+		t.Errorf("resubmission: %+v", res)
+	}
+	if label, _ := f.ml.GetLabel("feature/x"); label != Ready {
+		t.Errorf("expected %s, got %s", Ready, label)
+	}
+}
+
+func TestPushReceiver_FetchesFromRemote(t *testing.T) {
+	origin, git := mergeRepo(t)
+	git("checkout", "-q", "-b", "feature/x")
+	os.MkdirAll(filepath.Join(origin, ".cindy"), 0o755)
+	commitFile(t, origin, git, ManifestPath, manifestJSON(1, ""))
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	if out, err := exec.Command("git", "clone", "-q", "--no-checkout", origin, clone).CombinedOutput(); err != nil {
+		t.Fatalf("clone: %s", out)
+	}
+	// The agent pushes a new commit after the receiver's clone was made.
+	commitFile(t, origin, git, "later.txt", "later\n")
+	head := git("rev-parse", "HEAD")
+
+	ml := NewMemoryLabeler()
+	pr := &PushReceiver{Engine: NewEngine(ml, nil), Repo: clone, Remote: "origin"}
+	res, err := pr.HandlePush(&PushEvent{Branch: "feature/x", Commit: head})
+	if err != nil || res.Action != PushLabeled {
+		t.Fatalf("HandlePush: %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,439 @@
+package cindy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// RefUpdate is one ref a push changes, as git reports it to the
+// pre-receive and update hooks. Old is all zeros when the ref is created and
+// New is all zeros when it is deleted.
+type RefUpdate struct {
+	Ref string
+	Old string
+	New string
+}
+
+func (u RefUpdate) created() bool { return isZeroID(u.Old) }
+func (u RefUpdate) deleted() bool { return isZeroID(u.New) }
+
+func isZeroID(id string) bool { return strings.Trim(id, "0") == "" }
+
+// ParseRefUpdates reads the "<old> <new> <ref>" lines git passes to a
+// pre-receive hook on stdin.
+func ParseRefUpdates(r io.Reader) ([]RefUpdate, error) {
+	var updates []RefUpdate
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed ref update %q", line)
+		}
+		updates = append(updates, RefUpdate{Old: fields[0], New: fields[1], Ref: fields[2]})
+	}
+	return updates, sc.Err()
+}
+
+// RefRejection is a ref update refused by a ReceiveHook.
+type RefRejection struct {
+	Ref    string
+	Reason string
+}
+
+func (r RefRejection) String() string {
+	return r.Ref + ": " + r.Reason
+}
+
+// ReceiveHook enforces the protocol on the git server, where pushes arrive,
+// so that agents cannot label their own branches around the engine:
+//   - cindy/* tags must name a pipeline label and a valid branch, and are
+//     never moved
+//   - a push may only change a branch's label along the pipeline's
+//     transitions, recording each step in the branch's history ref
+//   - the history ref only ever fast-forwards
+//   - every step must be allowed by Authorize, and steps into RequireSigned
+//     labels must be signed
+//   - branch names must be valid, and a pushed .cindy/manifest.json must pass
+//     ValidateManifest
+//
+// A branch's label on the server is its tag or, while it has none, the last
+// label its history records, so deleting a tag cannot skip a state.
+// Transition guards are not checked: the server has no reviews to check them
+// against.
+type ReceiveHook struct {
+	// Repo is the repository receiving the push; hooks run in it.
+	Repo string
+	// Pipeline defines the labels and transitions. Defaults to DefaultPipeline.
+	Pipeline *Pipeline
+	// Pusher is the user the git server authenticated for the push. Steps
+	// are authorized as the pusher.
+	Pusher string
+	// TrustRecordedActors authorizes steps against the actor recorded in
+	// their metadata when Pusher is empty. The pusher writes that metadata,
+	// so it can name any actor; without this, a push that changes labels
+	// is refused if Authorize is set and Pusher is empty.
+	TrustRecordedActors bool
+	// Authorize, if set, decides whether actor may apply t. prior are the
+	// steps the same push records before t, with the actor that applied
+	// them; the server's history does not include them yet.
+	Authorize func(actor string, t Transition, prior []Transition) error
+	// RequireSigned lists the labels whose transitions must be signed by a
+	// key in Keys belonging to their actor.
+	RequireSigned []Label
+	Keys          *KeyRing
+	// Removable lists the labels a branch may drop without a transition,
+	// besides the pipeline's terminal states. Defaults to the labels of
+	// DefaultRetentionPolicy, so pruned branches can be pushed.
+	Removable []Label
+}
+
+// branchPush collects the updates of one push that concern a branch's label.
+type branchPush struct {
+	tags    []RefUpdate
+	history *RefUpdate
+}
+
+// PreReceive checks a whole push and returns the updates to refuse. Git
+// rejects every ref of the push if the hook fails, so a label change and
+// its history are judged together.
+func (h *ReceiveHook) PreReceive(updates []RefUpdate) ([]RefRejection, error) {
+	gl, err := h.labeler()
+	if err != nil {
+		return nil, err
+	}
+	var rejections []RefRejection
+	branches := make(map[string]*branchPush)
+	touch := func(branch string) *branchPush {
+		if branches[branch] == nil {
+			branches[branch] = &branchPush{}
+		}
+		return branches[branch]
+	}
+	for i, u := range updates {
+		branch, reason, err := h.classify(u)
+		switch {
+		case err != nil:
+			return nil, err
+		case reason != "":
+			rejections = append(rejections, RefRejection{u.Ref, reason})
+		case branch == "":
+		case strings.HasPrefix(u.Ref, historyRefPrefix):
+			touch(branch).history = &updates[i]
+		default:
+			touch(branch).tags = append(touch(branch).tags, u)
+		}
+	}
+
+	raw, err := gl.RawLabels()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(branches))
+	for b := range branches {
+		names = append(names, b)
+	}
+	sort.Strings(names)
+	for _, branch := range names {
+		bp := branches[branch]
+		ref := historyRef(branch)
+		if len(bp.tags) > 0 {
+			ref = bp.tags[0].Ref
+		}
+		reason, err := h.checkBranch(gl, branch, raw[branch], bp)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			rejections = append(rejections, RefRejection{ref, reason})
+		}
+	}
+	return rejections, nil
+}
+
+// Update checks a single ref, as git's update hook does. It cannot see the
+// rest of the push, so it lets label tags be deleted — a deleted label still
+// counts until the history records its removal — and checks each new label
+// as one step from the branch's current label, authorized against Pusher.
+// Prefer PreReceive where the git server allows it.
+func (h *ReceiveHook) Update(u RefUpdate) ([]RefRejection, error) {
+	gl, err := h.labeler()
+	if err != nil {
+		return nil, err
+	}
+	branch, reason, err := h.classify(u)
+	if err != nil {
+		return nil, err
+	}
+	if reason == "" && branch != "" {
+		reason, err = h.checkUpdate(gl, branch, u)
+	}
+	if err != nil || reason == "" {
+		return nil, err
+	}
+	return []RefRejection{{u.Ref, reason}}, nil
+}
+
+// checkUpdate checks a single label tag or history ref update of branch.
+func (h *ReceiveHook) checkUpdate(gl *GitLabeler, branch string, u RefUpdate) (string, error) {
+	if strings.HasPrefix(u.Ref, historyRefPrefix) {
+		from, err := h.serverLabel(gl, branch, nil)
+		if err != nil {
+			return "", err
+		}
+		transitions, reason, err := h.pushedHistory(u)
+		if err != nil || reason != "" {
+			return reason, err
+		}
+		prev, err := lastTransition(gl, branch)
+		if err != nil {
+			return "", err
+		}
+		_, reason = h.checkSteps(branch, from, prev, transitions, true)
+		return reason, nil
+	}
+	if u.deleted() {
+		return "", nil
+	}
+	to, _, _ := h.pipeline().ParseTag(strings.TrimPrefix(u.Ref, "refs/tags/"))
+	from, err := h.serverLabel(gl, branch, []Label{to})
+	if err != nil {
+		return "", err
+	}
+	step := Transition{Branch: branch, From: from, To: to, Metadata: Metadata{Actor: h.Pusher}}
+	_, reason := h.checkSteps(branch, from, nil, []Transition{step}, false)
+	return reason, nil
+}
+
+// classify returns the branch whose label u changes, or the reason u is
+// refused outright. Branch pushes are checked here; other refs pass.
+func (h *ReceiveHook) classify(u RefUpdate) (branch, reason string, err error) {
+	switch {
+	case strings.HasPrefix(u.Ref, "refs/heads/"):
+		reason, err := h.checkBranchPush(u)
+		return "", reason, err
+	case strings.HasPrefix(u.Ref, "refs/tags/"+TagPrefix):
+		tag := strings.TrimPrefix(u.Ref, "refs/tags/")
+		_, branch, ok := h.pipeline().ParseTag(tag)
+		if !ok {
+			return "", fmt.Sprintf("%s is not a %s label of a valid branch", tag, h.pipeline().Name()), nil
+		}
+		if !u.created() && !u.deleted() {
+			return "", "label tags cannot be moved", nil
+		}
+		return branch, "", nil
+	case strings.HasPrefix(u.Ref, historyRefPrefix):
+		branch, ok := unescapeBranch(strings.TrimPrefix(u.Ref, historyRefPrefix))
+		if !ok || ValidateBranchName(branch) != nil {
+			return "", "history ref of an invalid branch name", nil
+		}
+		if u.deleted() {
+			return "", "history cannot be deleted", nil
+		}
+		return branch, "", nil
+	}
+	return "", "", nil
+}
+
+// checkBranchPush validates the name of a pushed branch and its manifest.
+func (h *ReceiveHook) checkBranchPush(u RefUpdate) (string, error) {
+	branch := strings.TrimPrefix(u.Ref, "refs/heads/")
+	if err := ValidateBranchName(branch); err != nil {
+		return err.Error(), nil
+	}
+	if u.deleted() {
+		return "", nil
+	}
+	data, err := ReadManifestAt(h.Repo, u.New)
+	if errors.Is(err, ErrNoManifest) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := ValidateManifest(data); err != nil {
+		return err.Error(), nil
+	}
+	return "", nil
+}
+
+// checkBranch checks the net label change a push makes to branch, whose
+// tags on the server are current.
+func (h *ReceiveHook) checkBranch(gl *GitLabeler, branch string, current []Label, bp *branchPush) (string, error) {
+	from, err := h.serverLabel(gl, branch, nil)
+	if err != nil {
+		return "", err
+	}
+	after := make(map[Label]bool)
+	for _, l := range current {
+		after[l] = true
+	}
+	for _, u := range bp.tags {
+		l, _, _ := h.pipeline().ParseTag(strings.TrimPrefix(u.Ref, "refs/tags/"))
+		after[l] = u.created()
+	}
+	var to Label
+	n := 0
+	for l, ok := range after {
+		if ok {
+			to = l
+			n++
+		}
+	}
+	if n > 1 {
+		return fmt.Sprintf("%s would carry %d labels", branch, n), nil
+	}
+
+	var transitions []Transition
+	if bp.history != nil {
+		var reason string
+		if transitions, reason, err = h.pushedHistory(*bp.history); err != nil || reason != "" {
+			return reason, err
+		}
+	}
+	if len(transitions) == 0 {
+		if len(bp.tags) > 0 && from != to {
+			return fmt.Sprintf("label change %s → %s is not recorded in %s", labelOrNone(from), labelOrNone(to), historyRef(branch)), nil
+		}
+		return "", nil
+	}
+	prev, err := lastTransition(gl, branch)
+	if err != nil {
+		return "", err
+	}
+	last, reason := h.checkSteps(branch, from, prev, transitions, true)
+	if reason == "" && last != to {
+		reason = fmt.Sprintf("history ends at %s but the tags say %s", labelOrNone(last), labelOrNone(to))
+	}
+	return reason, nil
+}
+
+// checkSteps checks that transitions form a chain of allowed steps starting
+// at from, and returns the label the chain ends at. Signatures are only
+// checked on recorded transitions, which follow prev, the last transition
+// the server records.
+func (h *ReceiveHook) checkSteps(branch string, from Label, prev *Transition, transitions []Transition, recorded bool) (Label, string) {
+	p := h.pipeline()
+	var prior []Transition
+	for i, t := range transitions {
+		switch {
+		case t.Branch != branch:
+			return from, fmt.Sprintf("history records a transition of %s", t.Branch)
+		case t.From != from:
+			return from, fmt.Sprintf("%s starts from %s, but the branch is %s", t, labelOrNone(t.From), labelOrNone(from))
+		case t.To == "" && !h.removable(from):
+			return from, fmt.Sprintf("%s: %s cannot be removed", t, from)
+		case t.To != "" && !(from == "" && t.To == p.Entry()) && !p.CanTransition(from, t.To):
+			return from, fmt.Sprintf("%s: %v", t, ErrInvalidTransition)
+		}
+		actor := h.Pusher
+		if actor == "" {
+			if h.Authorize != nil && !h.TrustRecordedActors {
+				return from, fmt.Sprintf("%s: no authenticated pusher to authorize", t)
+			}
+			actor = t.Actor
+		}
+		if h.Authorize != nil {
+			if err := h.Authorize(actor, t, prior); err != nil {
+				return from, fmt.Sprintf("%s: %v", t, err)
+			}
+		}
+		if recorded && h.Keys != nil && contains(h.RequireSigned, t.To) {
+			if v := h.Keys.VerifyAfter(t, prev); v.Status != SignatureValid {
+				return from, fmt.Sprintf("%s: signature %s: %s", t, v.Status, v.Reason)
+			}
+		}
+		step := t
+		step.Actor = actor
+		prior = append(prior, step)
+		prev = &transitions[i]
+		from = t.To
+	}
+	return from, ""
+}
+
+// pushedHistory returns the transitions a history ref update appends, or
+// the reason the update is refused.
+func (h *ReceiveHook) pushedHistory(u RefUpdate) ([]Transition, string, error) {
+	rev := u.New
+	if !u.created() {
+		if err := exec.Command("git", "-C", h.Repo, "merge-base", "--is-ancestor", u.Old, u.New).Run(); err != nil {
+			return nil, "history can only be appended to", nil
+		}
+		rev = u.Old + ".." + u.New
+	}
+	out, err := exec.Command("git", "-C", h.Repo, "log", "--reverse", "--format=%B", rev).Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("reading pushed history %s: %w", u.Ref, err)
+	}
+	var transitions []Transition
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var t Transition
+		if err := json.Unmarshal([]byte(line), &t); err != nil {
+			return nil, fmt.Sprintf("history entry is not a transition: %v", err), nil
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions, "", nil
+}
+
+// serverLabel returns the branch's label on the server, ignoring the labels
+// in except: its tag, or the last label its history records.
+func (h *ReceiveHook) serverLabel(gl *GitLabeler, branch string, except []Label) (Label, error) {
+	raw, err := gl.RawLabels()
+	if err != nil {
+		return "", err
+	}
+	for _, l := range raw[branch] {
+		if !contains(except, l) {
+			return l, nil
+		}
+	}
+	history, err := gl.History(branch)
+	if err != nil || len(history) == 0 {
+		return "", err
+	}
+	return history[len(history)-1].To, nil
+}
+
+// lastTransition returns the last transition gl records for branch, or nil.
+func lastTransition(gl *GitLabeler, branch string) (*Transition, error) {
+	history, err := gl.History(branch)
+	if err != nil || len(history) == 0 {
+		return nil, err
+	}
+	return &history[len(history)-1], nil
+}
+
+func (h *ReceiveHook) removable(l Label) bool {
+	if h.pipeline().IsTerminal(l) {
+		return true
+	}
+	if h.Removable != nil {
+		return contains(h.Removable, l)
+	}
+	return contains(DefaultRetentionPolicy().Labels, l)
+}
+
+func (h *ReceiveHook) pipeline() *Pipeline {
+	if h.Pipeline == nil {
+		return DefaultPipeline()
+	}
+	return h.Pipeline
+}
+
+func (h *ReceiveHook) labeler() (*GitLabeler, error) {
+	return NewGitLabeler(h.Repo, WithPipeline(h.pipeline()), WithPushPolicy(PushDeferred))
+}
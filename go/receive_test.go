@@ -0,0 +1,294 @@
+package cindy
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// receiveFixture is an agent's clone and the bare repository it pushes to,
+// whose pushes are checked by a ReceiveHook instead of git.
+type receiveFixture struct {
+	t      *testing.T
+	work   string
+	server string
+	gl     *GitLabeler
+	hook   *ReceiveHook
+}
+
+func newReceiveFixture(t *testing.T) *receiveFixture {
+	t.Helper()
+	work, git := mergeRepo(t)
+	server := filepath.Join(t.TempDir(), "server.git")
+	git("init", "-q", "--bare", server)
+	git("remote", "add", "origin", server)
+	git("push", "-q", "origin", "main")
+	gl, err := NewGitLabeler(work, WithPushPolicy(PushDeferred))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &receiveFixture{t: t, work: work, server: server, gl: gl, hook: &ReceiveHook{Repo: server}}
+}
+
+func (f *receiveFixture) rev(repo, ref string) string {
+	out, err := exec.Command("git", "-C", repo, "rev-parse", "--verify", "-q", ref).Output()
+	if err != nil {
+		return strings.Repeat("0", 40)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// push offers the differences between the clone and the server for refs to
+// the hook's pre-receive check, and applies them if none is rejected.
+func (f *receiveFixture) push(refs ...string) []RefRejection {
+	f.t.Helper()
+	var updates []RefUpdate
+	for i, ref := range refs {
+		u := RefUpdate{Ref: ref, Old: f.rev(f.server, ref), New: f.rev(f.work, ref)}
+		if u.Old == u.New {
+			continue
+		}
+		if !u.deleted() {
+			// Receive the objects without updating the ref, as git does
+			// before running the hook.
+			if out, err := exec.Command("git", "-C", f.server, "fetch", "-q", "--no-tags", f.work, fmt.Sprintf("+%s:refs/incoming/%d", ref, i)).CombinedOutput(); err != nil {
+				f.t.Fatalf("fetch %s: %s", ref, out)
+			}
+		}
+		updates = append(updates, u)
+	}
+	rejections, err := f.hook.PreReceive(updates)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if len(rejections) == 0 {
+		for _, u := range updates {
+			args := []string{"-C", f.server, "update-ref", u.Ref, u.New}
+			if u.deleted() {
+				args = []string{"-C", f.server, "update-ref", "-d", u.Ref}
+			}
+			if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+				f.t.Fatalf("update-ref: %s", out)
+			}
+		}
+	}
+	return rejections
+}
+
+// label changes the clone's label of branch and pushes it.
+func (f *receiveFixture) label(branch string, to Label, actor string) []RefRejection {
+	f.t.Helper()
+	from, _ := f.gl.GetLabel(branch)
+	f.setLabel(branch, to, actor)
+	return f.push(labelRefs(branch, from, to)...)
+}
+
+func (f *receiveFixture) setLabel(branch string, to Label, actor string) {
+	f.t.Helper()
+	var err error
+	if to == "" {
+		err = f.gl.RemoveLabel(branch, Metadata{Actor: actor})
+	} else {
+		err = f.gl.SetLabelWithMetadata(branch, to, Metadata{Actor: actor})
+	}
+	if err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// reset drops the clone's rejected label changes.
+func (f *receiveFixture) reset() {
+	f.t.Helper()
+	out, err := exec.Command("git", "-C", f.work, "fetch", "-q", "--prune", "--no-tags", "origin",
+		"+refs/tags/cindy/*:refs/tags/cindy/*", "+refs/cindy/history/*:refs/cindy/history/*").CombinedOutput()
+	if err != nil {
+		f.t.Fatalf("fetch: %s", out)
+	}
+	f.gl.Invalidate()
+}
+
+func labelRefs(branch string, labels ...Label) []string {
+	refs := []string{historyRef(branch)}
+	for _, l := range labels {
+		if l != "" {
+			refs = append(refs, "refs/tags/"+TagName(l, branch))
+		}
+	}
+	return refs
+}
+
+func expectRejected(t *testing.T, rejections []RefRejection, want string) {
+	t.Helper()
+	if len(rejections) == 0 {
+		t.Fatalf("push accepted, want rejection %q", want)
+	}
+	for _, r := range rejections {
+		if strings.Contains(r.Reason, want) {
+			return
+		}
+	}
+	t.Errorf("got rejections %v, want %q", rejections, want)
+}
+
+func TestReceiveHook_Transitions(t *testing.T) {
+	f := newReceiveFixture(t)
+	for _, to := range []Label{Ready, Analyzing} {
+		if r := f.label("feature/x", to, "agent"); r != nil {
+			t.Fatalf("%s rejected: %v", to, r)
+		}
+	}
+	// Deferred changes arrive as several history entries in one push.
+	f.setLabel("feature/x", Approved, "analyzer")
+	f.setLabel("feature/x", Deploying, "deployer")
+	if r := f.push(labelRefs("feature/x", Analyzing, Deploying)...); r != nil {
+		t.Fatalf("multi-step push rejected: %v", r)
+	}
+
+	expectRejected(t, f.label("feature/x", Ready, "agent"), "invalid transition")
+	f.reset()
+
+	// A label change without its history entry.
+	exec.Command("git", "-C", f.work, "tag", "-d", TagName(Deploying, "feature/x")).Run()
+	exec.Command("git", "-C", f.work, "tag", TagName(Deployed, "feature/x")).Run()
+	f.gl.Invalidate()
+	expectRejected(t, f.push("refs/tags/"+TagName(Deploying, "feature/x"), "refs/tags/"+TagName(Deployed, "feature/x")), "not recorded")
+
+	// Two labels at once.
+	expectRejected(t, f.push("refs/tags/"+TagName(Deployed, "feature/x")), "would carry 2 labels")
+}
+
+func TestReceiveHook_Authorize(t *testing.T) {
+	f := newReceiveFixture(t)
+	f.hook.Authorize = func(actor string, tr Transition, _ []Transition) error {
+		if tr.To == Deployed && actor != "deployer" {
+			return fmt.Errorf("%s may not deploy", actor)
+		}
+		return nil
+	}
+	f.hook.TrustRecordedActors = true
+	for _, to := range []Label{Ready, Analyzing, Approved, Deploying} {
+		if r := f.label("feature/x", to, "agent"); r != nil {
+			t.Fatalf("%s rejected: %v", to, r)
+		}
+	}
+	expectRejected(t, f.label("feature/x", Deployed, "agent"), "agent may not deploy")
+
+	// Without a pusher, an agent recording itself as the deployer is not
+	// believed.
+	f.reset()
+	f.hook.TrustRecordedActors = false
+	expectRejected(t, f.label("feature/x", Deployed, "deployer"), "no authenticated pusher")
+
+	// The authenticated pusher counts, not the actor the push claims.
+	f.reset()
+	f.hook.Pusher = "agent"
+	expectRejected(t, f.label("feature/x", Deployed, "deployer"), "agent may not deploy")
+
+	f.reset()
+	f.hook.Pusher = "deployer"
+	if r := f.label("feature/x", Deployed, "deployer"); r != nil {
+		t.Fatalf("deployer rejected: %v", r)
+	}
+}
+
+func TestReceiveHook_Removal(t *testing.T) {
+	f := newReceiveFixture(t)
+	for _, to := range []Label{Ready, Analyzing, Blocked} {
+		f.label("feature/x", to, "agent")
+	}
+	expectRejected(t, f.label("feature/x", "", "agent"), "cannot be removed")
+
+	for _, to := range []Label{Ready, Analyzing, Rejected} {
+		f.label("feature/y", to, "agent")
+	}
+	if r := f.label("feature/y", "", "pruner"); r != nil {
+		t.Fatalf("removing a terminal label rejected: %v", r)
+	}
+}
+
+func TestReceiveHook_Refs(t *testing.T) {
+	f := newReceiveFixture(t)
+	f.label("feature/x", Ready, "agent")
+	f.label("feature/x", Analyzing, "agent")
+	history := f.rev(f.server, historyRef("feature/x"))
+	parent := f.rev(f.server, historyRef("feature/x")+"^")
+	tag := "refs/tags/" + TagName(Analyzing, "feature/x")
+	zero := strings.Repeat("0", 40)
+
+	tests := []struct {
+		name   string
+		update RefUpdate
+		want   string
+	}{
+		{"unknown label", RefUpdate{"refs/tags/cindy/shipped/feature/x", zero, history}, "not a cindy label"},
+		{"moved tag", RefUpdate{tag, f.rev(f.server, tag), history}, "cannot be moved"},
+		{"history rewrite", RefUpdate{historyRef("feature/x"), history, parent}, "only be appended"},
+		{"history deletion", RefUpdate{historyRef("feature/x"), history, zero}, "cannot be deleted"},
+		{"bad branch name", RefUpdate{"refs/heads/-x", zero, f.rev(f.server, "main")}, `cannot start with "-"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := f.hook.PreReceive([]RefUpdate{tt.update})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectRejected(t, r, tt.want)
+		})
+	}
+}
+
+func TestReceiveHook_Manifest(t *testing.T) {
+	f := newReceiveFixture(t)
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", f.work}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("checkout", "-q", "-b", "feature/x")
+	if err := os.MkdirAll(filepath.Join(f.work, ".cindy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, f.work, git, ManifestPath, `{"revision": 0}`)
+	expectRejected(t, f.push("refs/heads/feature/x"), "missing required field description")
+
+	commitFile(t, f.work, git, ManifestPath, manifestJSON(1, ""))
+	if r := f.push("refs/heads/feature/x"); r != nil {
+		t.Fatalf("valid manifest rejected: %v", r)
+	}
+}
+
+func TestReceiveHook_Update(t *testing.T) {
+	f := newReceiveFixture(t)
+	for _, to := range []Label{Ready, Analyzing, Blocked} {
+		f.label("feature/x", to, "agent")
+	}
+	blocked := "refs/tags/" + TagName(Blocked, "feature/x")
+	zero := strings.Repeat("0", 40)
+	check := func(u RefUpdate) []RefRejection {
+		t.Helper()
+		r, err := f.hook.Update(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// The update hook sees deletions alone, so it lets them through...
+	if r := check(RefUpdate{blocked, f.rev(f.server, blocked), zero}); r != nil {
+		t.Fatalf("deletion rejected: %v", r)
+	}
+	exec.Command("git", "-C", f.server, "update-ref", "-d", blocked).Run()
+
+	// ...but the deleted label still counts for the next one.
+	ready := "refs/tags/" + TagName(Ready, "feature/x")
+	expectRejected(t, check(RefUpdate{ready, zero, f.rev(f.server, "main")}), "invalid transition")
+	approved := "refs/tags/" + TagName(Approved, "feature/x")
+	if r := check(RefUpdate{approved, zero, f.rev(f.server, "main")}); r != nil {
+		t.Fatalf("valid step rejected: %v", r)
+	}
+}
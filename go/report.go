@@ -0,0 +1,375 @@
+package cindy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportOptions configures BuildReport.
+type ReportOptions struct {
+	// Since and Until bound the window of transitions counted, Until
+	// excluded. A zero Since means the beginning of history, a zero Until now.
+	Since, Until time.Time
+	// Manifests maps branches to their manifests, for the breakdown by
+	// subject. Branches without one are only counted by actor.
+	Manifests map[string]*Manifest
+	// Labels maps the states the report counts to the labeler's pipeline.
+	Labels ReportLabels
+}
+
+// ReportLabels are the labels that play each role a Report counts. Empty
+// fields default to the built-in label of the same name, and Ready to the
+// pipeline's entry label; every label must be in the labeler's pipeline.
+type ReportLabels struct {
+	// Ready is the label changes are submitted with.
+	Ready       Label `json:"ready,omitempty"`
+	Analyzing   Label `json:"analyzing,omitempty"`
+	HumanReview Label `json:"human_review,omitempty"`
+	Approved    Label `json:"approved,omitempty"`
+	Rejected    Label `json:"rejected,omitempty"`
+	Deployed    Label `json:"deployed,omitempty"`
+	Rollback    Label `json:"rollback,omitempty"`
+}
+
+// resolve fills in the defaults of r and checks its labels against p.
+func (r ReportLabels) resolve(p *Pipeline) (ReportLabels, error) {
+	roles := []struct {
+		name  string
+		label *Label
+		def   Label
+	}{
+		{"ready", &r.Ready, p.Entry()},
+		{"analyzing", &r.Analyzing, Analyzing},
+		{"human_review", &r.HumanReview, HumanReview},
+		{"approved", &r.Approved, Approved},
+		{"rejected", &r.Rejected, Rejected},
+		{"deployed", &r.Deployed, Deployed},
+		{"rollback", &r.Rollback, Rollback},
+	}
+	var missing []string
+	for _, role := range roles {
+		if *role.label == "" {
+			*role.label = role.def
+		}
+		if !p.HasLabel(*role.label) {
+			missing = append(missing, fmt.Sprintf("%s (%s)", role.name, *role.label))
+		}
+	}
+	if len(missing) > 0 {
+		return r, fmt.Errorf("report: pipeline %q has no label for %s", p.Name(), strings.Join(missing, ", "))
+	}
+	return r, nil
+}
+
+// Report is a summary of how changes moved through the pipeline over a
+// window of time, overall and broken down by actor and by subject.
+type Report struct {
+	Since     time.Time     `json:"since"`
+	Until     time.Time     `json:"until"`
+	Total     ReportStats   `json:"total"`
+	ByActor   []ReportGroup `json:"by_actor"`
+	BySubject []ReportGroup `json:"by_subject"`
+}
+
+// ReportGroup is the share of a Report attributed to one actor or subject.
+type ReportGroup struct {
+	Key string `json:"key"`
+	ReportStats
+}
+
+// ReportStats are DORA-style numbers for a set of changes. Transitions are
+// attributed to the actor who submitted the change, i.e. applied the
+// cindy:ready label that started its current round. Labels are named by
+// their role; see ReportLabels.
+type ReportStats struct {
+	// Submissions counts transitions into cindy:ready, resubmissions
+	// included.
+	Submissions int `json:"submissions"`
+	// Deployments counts transitions into cindy:deployed. LeadTime runs from
+	// the first cindy:ready after the branch's previous deployment to the
+	// deployment.
+	Deployments int             `json:"deployments"`
+	LeadTime    DurationSummary `json:"lead_time"`
+	// FailedDeployments counts deployments whose next transition is a
+	// rollback; ChangeFailureRate is their share of Deployments.
+	FailedDeployments int     `json:"failed_deployments"`
+	ChangeFailureRate float64 `json:"change_failure_rate"`
+	// Rollbacks counts transitions into cindy:rollback, from deploying or
+	// deployed.
+	Rollbacks int `json:"rollbacks"`
+	// Approvals and Rejections count decisions out of analysis or human
+	// review; RejectionRate is the share of Rejections among them.
+	Approvals     int     `json:"approvals"`
+	Rejections    int     `json:"rejections"`
+	RejectionRate float64 `json:"rejection_rate"`
+	// Reviews counts branches leaving cindy:human-review; ReviewTurnaround
+	// is how long they waited there.
+	Reviews          int             `json:"reviews"`
+	ReviewTurnaround DurationSummary `json:"review_turnaround"`
+
+	leadTimes, turnarounds []time.Duration
+}
+
+// DurationSummary summarizes a set of durations. It is encoded in JSON
+// with durations in seconds.
+type DurationSummary struct {
+	Count  int
+	Median time.Duration
+	P90    time.Duration
+}
+
+// MarshalJSON encodes s with its durations in seconds.
+func (s DurationSummary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count  int     `json:"count"`
+		Median float64 `json:"median_seconds"`
+		P90    float64 `json:"p90_seconds"`
+	}{s.Count, s.Median.Seconds(), s.P90.Seconds()})
+}
+
+func summarize(ds []time.Duration) DurationSummary {
+	if len(ds) == 0 {
+		return DurationSummary{}
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(p float64) time.Duration {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+	return DurationSummary{Count: len(sorted), Median: rank(0.5), P90: rank(0.9)}
+}
+
+// BuildReport computes a Report from the history l records for every
+// labeled or archived branch. Correction transitions are ignored. It fails
+// if the pipeline of l lacks a label opts.Labels needs.
+func BuildReport(l RecordingLabeler, opts ReportOptions) (*Report, error) {
+	roles, err := opts.Labels.resolve(pipelineOf(l))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Until.IsZero() {
+		opts.Until = time.Now().UTC()
+	}
+	labels, err := l.AllLabels()
+	if err != nil {
+		return nil, err
+	}
+	branches := make(map[string]bool, len(labels))
+	for b := range labels {
+		branches[b] = true
+	}
+	if a, ok := l.(Archiver); ok {
+		archived, err := a.ArchivedLabels()
+		if err != nil {
+			return nil, err
+		}
+		for b := range archived {
+			branches[b] = true
+		}
+	}
+
+	total := &ReportStats{}
+	byActor := make(map[string]*ReportStats)
+	bySubject := make(map[string]*ReportStats)
+	group := func(m map[string]*ReportStats, key string) *ReportStats {
+		if m[key] == nil {
+			m[key] = &ReportStats{}
+		}
+		return m[key]
+	}
+	for _, branch := range sortedBranches(branches) {
+		history, err := l.History(branch)
+		if err != nil {
+			return nil, err
+		}
+		var subjects []string
+		if m := opts.Manifests[branch]; m != nil {
+			subjects = m.SubjectsAffected
+		}
+		var started time.Time
+		var author string
+		var prev *Transition
+		for i := range history {
+			t := history[i]
+			if t.Correction {
+				continue
+			}
+			if t.To == roles.Ready {
+				author = t.Actor
+				if started.IsZero() {
+					started = t.Timestamp
+				}
+			}
+			if !t.Timestamp.Before(opts.Since) && t.Timestamp.Before(opts.Until) {
+				stats := []*ReportStats{total, group(byActor, orUnknown(author))}
+				for _, s := range subjects {
+					stats = append(stats, group(bySubject, s))
+				}
+				failed := t.To == roles.Deployed && i+1 < len(history) && history[i+1].To == roles.Rollback
+				for _, s := range stats {
+					s.count(roles, t, prev, started, failed)
+				}
+			}
+			if t.To == roles.Deployed {
+				started = time.Time{}
+			}
+			prev = &history[i]
+		}
+	}
+
+	r := &Report{Since: opts.Since, Until: opts.Until, Total: total.finish()}
+	for _, actor := range sortedKeys(byActor) {
+		r.ByActor = append(r.ByActor, ReportGroup{Key: actor, ReportStats: byActor[actor].finish()})
+	}
+	for _, subject := range sortedKeys(bySubject) {
+		r.BySubject = append(r.BySubject, ReportGroup{Key: subject, ReportStats: bySubject[subject].finish()})
+	}
+	return r, nil
+}
+
+// count adds t, the transition after prev, to s, with the labels of roles.
+// started is when the change was submitted; failed tells whether a
+// deployment was rolled back.
+func (s *ReportStats) count(roles ReportLabels, t Transition, prev *Transition, started time.Time, failed bool) {
+	switch t.To {
+	case roles.Ready:
+		s.Submissions++
+	case roles.Deployed:
+		s.Deployments++
+		if !started.IsZero() {
+			s.leadTimes = append(s.leadTimes, t.Timestamp.Sub(started))
+		}
+		if failed {
+			s.FailedDeployments++
+		}
+	case roles.Rollback:
+		s.Rollbacks++
+	case roles.Approved, roles.Rejected:
+		if t.From != roles.Analyzing && t.From != roles.HumanReview {
+			break
+		}
+		if t.To == roles.Approved {
+			s.Approvals++
+		} else {
+			s.Rejections++
+		}
+	}
+	if t.From == roles.HumanReview && prev != nil && prev.To == roles.HumanReview {
+		s.Reviews++
+		s.turnarounds = append(s.turnarounds, t.Timestamp.Sub(prev.Timestamp))
+	}
+}
+
+// finish computes the summaries and rates from the counts.
+func (s *ReportStats) finish() ReportStats {
+	s.LeadTime = summarize(s.leadTimes)
+	s.ReviewTurnaround = summarize(s.turnarounds)
+	s.ChangeFailureRate = ratio(s.FailedDeployments, s.Deployments)
+	s.RejectionRate = ratio(s.Rejections, s.Approvals+s.Rejections)
+	return *s
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// Markdown renders the report as Markdown tables.
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	since := "start of history"
+	if !r.Since.IsZero() {
+		since = r.Since.Format(time.DateOnly)
+	}
+	fmt.Fprintf(&b, "# Pipeline report\n\n%s – %s\n\n", since, r.Until.Format(time.DateOnly))
+	table := func(title string, groups []ReportGroup) {
+		if title != "" {
+			fmt.Fprintf(&b, "\n## %s\n\n", title)
+		}
+		b.WriteString("| | Submissions | Deployments | Lead time (median) | Lead time (p90) | Change failure rate | Rollbacks | Rejection rate | Reviews | Review turnaround (median) |\n")
+		b.WriteString("|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|\n")
+		for _, g := range groups {
+			s := g.ReportStats
+			fmt.Fprintf(&b, "| %s | %d | %d | %s | %s | %s | %d | %s | %d | %s |\n",
+				g.Key, s.Submissions, s.Deployments,
+				formatReportDuration(s.LeadTime.Count, s.LeadTime.Median), formatReportDuration(s.LeadTime.Count, s.LeadTime.P90),
+				formatRate(s.FailedDeployments, s.Deployments), s.Rollbacks,
+				formatRate(s.Rejections, s.Approvals+s.Rejections), s.Reviews,
+				formatReportDuration(s.ReviewTurnaround.Count, s.ReviewTurnaround.Median))
+		}
+	}
+	table("", []ReportGroup{{Key: "**All changes**", ReportStats: r.Total}})
+	if len(r.ByActor) > 0 {
+		table("By actor", r.ByActor)
+	}
+	if len(r.BySubject) > 0 {
+		table("By subject", r.BySubject)
+	}
+	return b.String()
+}
+
+func formatReportDuration(n int, d time.Duration) string {
+	if n == 0 {
+		return "–"
+	}
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	s := d.Round(time.Minute).String()
+	return strings.TrimSuffix(s, "0s")
+}
+
+func formatRate(n, d int) string {
+	if d == 0 {
+		return "–"
+	}
+	return strconv.FormatFloat(100*float64(n)/float64(d), 'f', 1, 64) + "%"
+}
+
+// CSV renders the report as CSV, one row for the total and one per actor
+// and subject. Durations are in seconds; rates without any events are
+// left empty.
+func (r *Report) CSV() string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write([]string{"scope", "key", "submissions", "deployments", "lead_time_median_seconds", "lead_time_p90_seconds",
+		"failed_deployments", "change_failure_rate", "rollbacks", "approvals", "rejections", "rejection_rate",
+		"reviews", "review_turnaround_median_seconds", "review_turnaround_p90_seconds"})
+	row := func(scope string, g ReportGroup) {
+		s := g.ReportStats
+		seconds := func(n int, d time.Duration) string {
+			if n == 0 {
+				return ""
+			}
+			return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+		}
+		rate := func(v float64, d int) string {
+			if d == 0 {
+				return ""
+			}
+			return strconv.FormatFloat(v, 'f', 4, 64)
+		}
+		w.Write([]string{scope, g.Key, strconv.Itoa(s.Submissions), strconv.Itoa(s.Deployments),
+			seconds(s.LeadTime.Count, s.LeadTime.Median), seconds(s.LeadTime.Count, s.LeadTime.P90),
+			strconv.Itoa(s.FailedDeployments), rate(s.ChangeFailureRate, s.Deployments), strconv.Itoa(s.Rollbacks),
+			strconv.Itoa(s.Approvals), strconv.Itoa(s.Rejections), rate(s.RejectionRate, s.Approvals+s.Rejections),
+			strconv.Itoa(s.Reviews), seconds(s.ReviewTurnaround.Count, s.ReviewTurnaround.Median), seconds(s.ReviewTurnaround.Count, s.ReviewTurnaround.P90)})
+	}
+	row("total", ReportGroup{ReportStats: r.Total})
+	for _, g := range r.ByActor {
+		row("actor", g)
+	}
+	for _, g := range r.BySubject {
+		row("subject", g)
+	}
+	w.Flush()
+	return b.String()
+}
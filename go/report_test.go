@@ -0,0 +1,187 @@
+package cindy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func reportFixture(t *testing.T) (*MemoryLabeler, time.Time) {
+	t.Helper()
+	start := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	ml := NewMemoryLabeler()
+	steps := func(branch string, steps ...any) {
+		t.Helper()
+		for i := 0; i < len(steps); i += 3 {
+			meta := Metadata{Actor: steps[i+1].(string), Timestamp: start.Add(steps[i+2].(time.Duration))}
+			if err := ml.SetLabelWithMetadata(branch, steps[i].(Label), meta); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	steps("feature/a",
+		Ready, "agent-7", time.Duration(0),
+		Analyzing, "analyzer", 10*time.Minute,
+		HumanReview, "analyzer", 20*time.Minute,
+		Approved, "alice", 2*time.Hour+20*time.Minute,
+		Deploying, "deploy-bot", 3*time.Hour,
+		Deployed, "deploy-bot", 4*time.Hour)
+	steps("feature/b",
+		Ready, "agent-9", time.Duration(0),
+		Analyzing, "analyzer", 10*time.Minute,
+		Approved, "analyzer", time.Hour,
+		Deploying, "deploy-bot", time.Hour,
+		Deployed, "deploy-bot", 2*time.Hour,
+		Rollback, "deploy-bot", 3*time.Hour)
+	steps("feature/c",
+		Ready, "agent-7", time.Duration(0),
+		Analyzing, "analyzer", 10*time.Minute,
+		Rejected, "analyzer", 30*time.Minute)
+	if err := ml.ArchiveLabel("feature/c", Metadata{Actor: "cindy-prune", Timestamp: start.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	// Outside the window.
+	walk(t, ml, "feature/old", start.AddDate(0, 0, -40), Ready, Analyzing, Approved, Deploying, Deployed)
+	return ml, start
+}
+
+func TestBuildReport(t *testing.T) {
+	ml, start := reportFixture(t)
+	r, err := BuildReport(ml, ReportOptions{
+		Since: start.AddDate(0, 0, -1),
+		Until: start.AddDate(0, 0, 1),
+		Manifests: map[string]*Manifest{
+			"feature/a": {SubjectsAffected: []string{"orders"}},
+			"feature/b": {SubjectsAffected: []string{"orders", "payments"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := r.Total
+	if total.Submissions != 3 || total.Deployments != 2 || total.FailedDeployments != 1 || total.Rollbacks != 1 ||
+		total.Approvals != 2 || total.Rejections != 1 || total.Reviews != 1 {
+		t.Errorf("unexpected counts %+v", total)
+	}
+	if total.LeadTime != (DurationSummary{Count: 2, Median: 2 * time.Hour, P90: 4 * time.Hour}) {
+		t.Errorf("lead time %+v", total.LeadTime)
+	}
+	if total.ReviewTurnaround.Median != 2*time.Hour {
+		t.Errorf("review turnaround %+v", total.ReviewTurnaround)
+	}
+	if total.ChangeFailureRate != 0.5 || total.RejectionRate != 1.0/3 {
+		t.Errorf("rates %v, %v", total.ChangeFailureRate, total.RejectionRate)
+	}
+
+	var actors, subjects []string
+	for _, g := range r.ByActor {
+		actors = append(actors, g.Key)
+	}
+	for _, g := range r.BySubject {
+		subjects = append(subjects, g.Key)
+	}
+	if strings.Join(actors, ",") != "agent-7,agent-9" || strings.Join(subjects, ",") != "orders,payments" {
+		t.Fatalf("groups %v, %v", actors, subjects)
+	}
+	// The approval by alice counts for the change's author.
+	if a := r.ByActor[0]; a.Submissions != 2 || a.Approvals != 1 || a.Rejections != 1 || a.Reviews != 1 {
+		t.Errorf("agent-7: %+v", a.ReportStats)
+	}
+	if s := r.BySubject[0]; s.Deployments != 2 || s.FailedDeployments != 1 {
+		t.Errorf("orders: %+v", s.ReportStats)
+	}
+}
+
+func TestBuildReport_LeadTimeAfterRevision(t *testing.T) {
+	start := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	ml := NewMemoryLabeler()
+	walk(t, ml, "feature/x", start, Ready, Analyzing, RevisionRequested)
+	walk(t, ml, "feature/x", start.Add(3*time.Hour), Ready, Analyzing, Approved, Deploying)
+	walk(t, ml, "feature/x", start.Add(5*time.Hour), Deployed)
+	r, err := BuildReport(ml, ReportOptions{Until: start.AddDate(0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Total.Submissions != 2 || r.Total.LeadTime.Median != 5*time.Hour {
+		t.Errorf("lead time should run from the first submission: %+v", r.Total)
+	}
+}
+
+func TestReport_Formats(t *testing.T) {
+	ml, start := reportFixture(t)
+	r, err := BuildReport(ml, ReportOptions{Since: start.AddDate(0, 0, -1), Until: start.AddDate(0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := r.Markdown()
+	for _, want := range []string{
+		"2026-05-31 – 2026-06-02",
+		"| **All changes** | 3 | 2 | 2h0m | 4h0m | 50.0% | 1 | 33.3% | 1 | 2h0m |",
+		"## By actor",
+		"| agent-9 | 1 | 1 | 2h0m | 2h0m | 100.0% | 1 | 0.0% | 0 | – |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown lacks %q:\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "## By subject") {
+		t.Error("subject table without manifests")
+	}
+
+	csv := r.CSV()
+	for _, want := range []string{
+		"scope,key,submissions,deployments,lead_time_median_seconds,",
+		"total,,3,2,7200,14400,1,0.5000,1,2,1,0.3333,1,7200,7200\n",
+		"actor,agent-9,1,1,7200,7200,1,1.0000,1,1,0,0.0000,0,,\n",
+	} {
+		if !strings.Contains(csv, want) {
+			t.Errorf("CSV lacks %q:\n%s", want, csv)
+		}
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"lead_time":{"count":2,"median_seconds":7200,"p90_seconds":14400}`; !strings.Contains(string(data), want) {
+		t.Errorf("JSON lacks %s:\n%s", want, data)
+	}
+}
+
+func TestBuildReport_CustomPipeline(t *testing.T) {
+	p, err := NewPipeline(PipelineConfig{
+		Name:   "ops",
+		Labels: []Label{"cindy:queued", "cindy:checking", "cindy:escalated", "cindy:ok", "cindy:no", "cindy:live", "cindy:reverted"},
+		Transitions: []TransitionConfig{
+			{From: "cindy:queued", To: "cindy:checking"},
+			{From: "cindy:checking", To: "cindy:ok"},
+			{From: "cindy:ok", To: "cindy:live"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml := NewMemoryLabeler()
+	ml.SetPipeline(p)
+	// Submissions are counted at the pipeline's entry label, cindy:queued.
+	start := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	walk(t, ml, "feature/x", start, "cindy:queued", "cindy:checking", "cindy:ok")
+	walk(t, ml, "feature/x", start.Add(3*time.Hour), "cindy:live")
+
+	if _, err := BuildReport(ml, ReportOptions{}); err == nil || !strings.Contains(err.Error(), `pipeline "ops" has no label for analyzing (cindy:analyzing)`) {
+		t.Fatalf("expected the default labels to be refused, got %v", err)
+	}
+	r, err := BuildReport(ml, ReportOptions{Until: start.AddDate(0, 0, 1), Labels: ReportLabels{
+		Analyzing: "cindy:checking", HumanReview: "cindy:escalated",
+		Approved: "cindy:ok", Rejected: "cindy:no", Deployed: "cindy:live", Rollback: "cindy:reverted",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Total.Submissions != 1 || r.Total.Approvals != 1 || r.Total.Deployments != 1 || r.Total.LeadTime.Median != 3*time.Hour {
+		t.Errorf("unexpected stats %+v", r.Total)
+	}
+}
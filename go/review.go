@@ -1,5 +1,14 @@
 package cindy
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
 // Verdict represents the outcome of a review.
 type Verdict string
 
@@ -27,6 +36,8 @@ type Review struct {
 	Verdict   Verdict         `json:"verdict"`
 	Comments  []ReviewComment `json:"comments"`
 	Timestamp string          `json:"timestamp"`
+	// Rollback is set on reviews requesting a revision after a rollback.
+	Rollback *RollbackRecord `json:"rollback,omitempty"`
 }
 
 // AllResolved returns true if every comment in the review is marked resolved.
@@ -56,3 +67,128 @@ func UnresolvedComments(r *Review) []ReviewComment {
 func IsBlocking(r *Review) bool {
 	return r.Verdict == RequestChanges && !AllResolved(r)
 }
+
+// ReviewStore persists reviews (SPEC §7.5). Implementations must return a
+// branch's reviews in the order they were first saved.
+type ReviewStore interface {
+	// SaveReview stores r, replacing any review with the same ID.
+	SaveReview(r Review) error
+	// Reviews returns the reviews for a branch.
+	Reviews(branch string) ([]Review, error)
+}
+
+// MemoryReviewStore is an in-memory ReviewStore. It is safe for concurrent use.
+type MemoryReviewStore struct {
+	mu      sync.Mutex
+	reviews map[string][]Review
+}
+
+// NewMemoryReviewStore creates a new MemoryReviewStore.
+func NewMemoryReviewStore() *MemoryReviewStore {
+	return &MemoryReviewStore{reviews: make(map[string][]Review)}
+}
+
+// SaveReview stores r, replacing any review with the same ID.
+func (s *MemoryReviewStore) SaveReview(r Review) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reviews := s.reviews[r.Branch]
+	for i := range reviews {
+		if reviews[i].ID == r.ID {
+			reviews[i] = r
+			return nil
+		}
+	}
+	s.reviews[r.Branch] = append(s.reviews[r.Branch], r)
+	return nil
+}
+
+// Reviews returns the reviews for a branch in the order they were first saved.
+func (s *MemoryReviewStore) Reviews(branch string) ([]Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Review(nil), s.reviews[branch]...), nil
+}
+
+// FileReviewStore is a ReviewStore that keeps each branch's reviews as a JSON
+// array in one file under a directory, e.g. a checkout's .cindy/reviews.
+// It is safe for concurrent use within a process.
+type FileReviewStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileReviewStore creates a FileReviewStore in dir, creating it if needed.
+func NewFileReviewStore(dir string) (*FileReviewStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating review store: %w", err)
+	}
+	return &FileReviewStore{dir: dir}, nil
+}
+
+// SaveReview stores r, replacing any review with the same ID.
+func (s *FileReviewStore) SaveReview(r Review) error {
+	if err := ValidateBranchName(r.Branch); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reviews, err := s.load(r.Branch)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range reviews {
+		if reviews[i].ID == r.ID {
+			reviews[i], replaced = r, true
+			break
+		}
+	}
+	if !replaced {
+		reviews = append(reviews, r)
+	}
+	data, err := json.MarshalIndent(reviews, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding reviews: %w", err)
+	}
+	path := s.path(r.Branch)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("saving review %s: %w", r.ID, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("saving review %s: %w", r.ID, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Reviews returns the reviews for a branch in the order they were first saved.
+func (s *FileReviewStore) Reviews(branch string) ([]Review, error) {
+	if err := ValidateBranchName(branch); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(branch)
+}
+
+func (s *FileReviewStore) path(branch string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(escapeBranch(branch))+".json")
+}
+
+func (s *FileReviewStore) load(branch string) ([]Review, error) {
+	data, err := os.ReadFile(s.path(branch))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading reviews for %s: %w", branch, err)
+	}
+	var reviews []Review
+	if err := json.Unmarshal(data, &reviews); err != nil {
+		return nil, fmt.Errorf("reading reviews for %s: %w", branch, err)
+	}
+	return reviews, nil
+}
@@ -0,0 +1,305 @@
+package cindy
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// RiskLevel is a coarse risk rating, as used in a manifest's
+// risk_self_assessment and a transition's risk_level metadata.
+type RiskLevel string
+
+const (
+	RiskLow    RiskLevel = "low"
+	RiskMedium RiskLevel = "medium"
+	RiskHigh   RiskLevel = "high"
+)
+
+func (r RiskLevel) rank() int {
+	switch r {
+	case RiskMedium:
+		return 1
+	case RiskHigh:
+		return 2
+	}
+	return 0
+}
+
+// FileChange is one file in a diff.
+type FileChange struct {
+	Path    string
+	Added   int
+	Deleted int
+}
+
+// DiffStats summarizes the diff of a branch against its base.
+type DiffStats struct {
+	Files []FileChange
+}
+
+// Lines returns the number of added and deleted lines.
+func (d *DiffStats) Lines() int {
+	n := 0
+	for _, f := range d.Files {
+		n += f.Added + f.Deleted
+	}
+	return n
+}
+
+// GitDiffStats returns the diff stats of head against its merge base with
+// base, as computed by `git diff --numstat base...head`. Binary files count
+// as changed with zero lines.
+func GitDiffStats(repoPath, base, head string) (*DiffStats, error) {
+	cmd := exec.Command("git", "-C", repoPath, "diff", "--numstat", "-z", "--no-renames", base+"..."+head, "--")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("diffing %s...%s: %w", base, head, err)
+	}
+	stats := &DiffStats{}
+	for _, rec := range strings.Split(string(out), "\x00") {
+		fields := strings.SplitN(rec, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		added, _ := strconv.Atoi(fields[0])
+		deleted, _ := strconv.Atoi(fields[1])
+		stats.Files = append(stats.Files, FileChange{Path: fields[2], Added: added, Deleted: deleted})
+	}
+	return stats, nil
+}
+
+// RiskSignal is one contribution to a computed risk score.
+type RiskSignal struct {
+	Name   string
+	Points int
+	Detail string
+}
+
+// RiskAssessment is the result of scoring a change.
+type RiskAssessment struct {
+	Score   int
+	Level   RiskLevel
+	Signals []RiskSignal
+	// SelfAssessment is the manifest's risk_self_assessment. Values other
+	// than low, medium and high are treated as low.
+	SelfAssessment RiskLevel
+	// Underreported is true if the computed level exceeds SelfAssessment.
+	Underreported bool
+}
+
+// NeedsHumanReview reports whether the change should be routed to
+// cindy:human-review: the computed risk is high or the author under-reported it.
+func (a *RiskAssessment) NeedsHumanReview() bool {
+	return a.Level == RiskHigh || a.Underreported
+}
+
+// Reason explains the assessment in one line, suitable for transition metadata.
+func (a *RiskAssessment) Reason() string {
+	var parts []string
+	for _, s := range a.Signals {
+		parts = append(parts, fmt.Sprintf("%s +%d", s.Detail, s.Points))
+	}
+	reason := fmt.Sprintf("computed risk %s (score %d)", a.Level, a.Score)
+	if a.Underreported {
+		reason += fmt.Sprintf(", self-assessed %s", a.SelfAssessment)
+	}
+	if len(parts) > 0 {
+		reason += ": " + strings.Join(parts, ", ")
+	}
+	return reason
+}
+
+// RiskScorer computes a risk level from a change's manifest and diff.
+// Each signal adds points; the total is mapped to a level by MediumAt and HighAt.
+type RiskScorer struct {
+	// MediumAt and HighAt are the scores at which risk becomes medium and
+	// high. Zero means the default, 3 and 7.
+	MediumAt, HighAt int
+	// SensitivePaths are path patterns that raise risk when touched. A
+	// pattern ending in "/" matches everything under that directory; other
+	// patterns are matched with path.Match against the full path and the
+	// base name.
+	SensitivePaths []string
+	// Manifests looks up the manifest of a dependency to compute the depth
+	// of the dependency chain. If nil, only direct dependencies count.
+	Manifests func(branch string) (*Manifest, error)
+}
+
+const defaultMediumAt, defaultHighAt = 3, 7
+
+// DefaultRiskScorer returns a RiskScorer with the default thresholds and
+// sensitive paths.
+func DefaultRiskScorer() *RiskScorer {
+	return &RiskScorer{
+		MediumAt:       defaultMediumAt,
+		HighAt:         defaultHighAt,
+		SensitivePaths: []string{"migrations/", "schema/", ".github/", "*.sql", "go.mod", "Dockerfile"},
+	}
+}
+
+// Score assesses the risk of the change described by m and diff. diff may
+// be nil when only the manifest is known.
+func (s *RiskScorer) Score(m *Manifest, diff *DiffStats) (*RiskAssessment, error) {
+	a := &RiskAssessment{SelfAssessment: RiskLow}
+	switch l := RiskLevel(strings.ToLower(m.RiskSelfAssessment)); l {
+	case RiskLow, RiskMedium, RiskHigh:
+		a.SelfAssessment = l
+	}
+	add := func(name string, points int, detail string) {
+		if points > 0 {
+			a.Signals = append(a.Signals, RiskSignal{Name: name, Points: points, Detail: detail})
+			a.Score += points
+		}
+	}
+
+	if n := len(m.SubjectsAffected); n > 1 {
+		add("subjects", min(n-1, 3), fmt.Sprintf("%d subjects", n))
+	}
+	if n := len(m.Consumers); n > 0 {
+		add("consumers", min(n, 3), fmt.Sprintf("%d consumers", n))
+	}
+	var extensions, added int
+	for _, sc := range m.SchemaChanges {
+		switch sc.Type {
+		case SchemaExtension:
+			extensions++
+		case SchemaNew:
+			added++
+		}
+	}
+	add("schema-extensions", min(extensions, 3), fmt.Sprintf("%d schema extensions", extensions))
+	add("schema-new", min(added, 2), fmt.Sprintf("%d new subjects", added))
+	if v := ValidateSchemaChanges(m); len(v) > 0 {
+		add("schema-violations", s.HighAt, fmt.Sprintf("%d schema violations", len(v)))
+	}
+
+	depth, err := s.dependencyDepth(m, map[string]bool{}, map[string]int{})
+	if err != nil {
+		return nil, err
+	}
+	if depth > 0 {
+		add("dependencies", min(depth, 3), fmt.Sprintf("dependency depth %d", depth))
+	}
+
+	if diff != nil {
+		lines := diff.Lines()
+		switch {
+		case lines > 500:
+			add("diff-size", 3, fmt.Sprintf("%d changed lines", lines))
+		case lines > 200:
+			add("diff-size", 2, fmt.Sprintf("%d changed lines", lines))
+		case lines > 50:
+			add("diff-size", 1, fmt.Sprintf("%d changed lines", lines))
+		}
+		if n := len(diff.Files); n > 20 {
+			add("diff-files", 1, fmt.Sprintf("%d changed files", n))
+		}
+		var touched []string
+		for _, f := range diff.Files {
+			if s.sensitive(f.Path) {
+				touched = append(touched, f.Path)
+			}
+		}
+		if len(touched) > 0 {
+			add("sensitive-paths", min(2*len(touched), 4), "touches "+strings.Join(touched, ", "))
+		}
+	}
+
+	mediumAt, highAt := s.MediumAt, s.HighAt
+	if mediumAt == 0 {
+		mediumAt = defaultMediumAt
+	}
+	if highAt == 0 {
+		highAt = defaultHighAt
+	}
+	switch {
+	case a.Score >= highAt:
+		a.Level = RiskHigh
+	case a.Score >= mediumAt:
+		a.Level = RiskMedium
+	default:
+		a.Level = RiskLow
+	}
+	a.Underreported = a.Level.rank() > a.SelfAssessment.rank()
+	return a, nil
+}
+
+// dependencyDepth returns the length of the longest depends_on chain from m.
+// onPath holds the branches of the chain being walked, so cycles end it, and
+// depths the depth of each branch already walked, so branches shared by
+// several chains are read and walked once.
+func (s *RiskScorer) dependencyDepth(m *Manifest, onPath map[string]bool, depths map[string]int) (int, error) {
+	depth := 0
+	for _, dep := range m.DependsOn {
+		d := 1
+		if sub, ok := depths[dep]; ok {
+			d += sub
+		} else if s.Manifests != nil && !onPath[dep] {
+			onPath[dep] = true
+			dm, err := s.Manifests(dep)
+			if err != nil {
+				return 0, fmt.Errorf("reading manifest of dependency %s: %w", dep, err)
+			}
+			sub := 0
+			if dm != nil {
+				if sub, err = s.dependencyDepth(dm, onPath, depths); err != nil {
+					return 0, err
+				}
+			}
+			delete(onPath, dep)
+			depths[dep] = sub
+			d += sub
+		}
+		depth = max(depth, d)
+	}
+	return depth, nil
+}
+
+func (s *RiskScorer) sensitive(p string) bool {
+	for _, pattern := range s.SensitivePaths {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(p, pattern) || strings.Contains(p, "/"+pattern) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(p)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireAcceptableRisk refuses transitions for changes that NeedsHumanReview
+// according to s, so they are routed to cindy:human-review instead. Typical
+// use is guarding Analyzing → Approved. It requires TransitionRequest.Manifest;
+// diff, if not nil, supplies the branch's diff stats.
+func RequireAcceptableRisk(s *RiskScorer, diff func(branch string) (*DiffStats, error)) Guard {
+	return func(ctx *TransitionContext) error {
+		if ctx.Manifest == nil {
+			return errors.New("manifest required")
+		}
+		var stats *DiffStats
+		if diff != nil {
+			var err error
+			if stats, err = diff(ctx.Branch); err != nil {
+				return err
+			}
+		}
+		a, err := s.Score(ctx.Manifest, stats)
+		if err != nil {
+			return err
+		}
+		if a.NeedsHumanReview() {
+			return fmt.Errorf("human review required: %s", a.Reason())
+		}
+		return nil
+	}
+}
@@ -0,0 +1,181 @@
+package cindy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRiskScorer_Manifests(t *testing.T) {
+	// The zero value scores like DefaultRiskScorer, minus sensitive paths.
+	for _, s := range []*RiskScorer{DefaultRiskScorer(), {}} {
+		testRiskScorerManifests(t, s)
+	}
+}
+
+func testRiskScorerManifests(t *testing.T, s *RiskScorer) {
+	t.Helper()
+	tests := []struct {
+		file  string
+		level RiskLevel
+	}{
+		{"../examples/manifest-extension.json", RiskMedium},
+		{"../examples/manifest-new-subject.json", RiskLow},
+		{"../examples/manifest-multi-change.json", RiskMedium},
+	}
+	for _, tt := range tests {
+		m, err := LoadManifest(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := s.Score(m, nil)
+		if err != nil {
+			t.Fatalf("Score(%s): %v", tt.file, err)
+		}
+		if a.Level != tt.level {
+			t.Errorf("%s: level %s, want %s (%s)", tt.file, a.Level, tt.level, a.Reason())
+		}
+	}
+}
+
+func TestRiskScorer_Underreported(t *testing.T) {
+	s := DefaultRiskScorer()
+	m := &Manifest{
+		SubjectsAffected:   []string{"a.b"},
+		Consumers:          []string{"x", "y"},
+		SchemaChanges:      []SchemaChange{{Subject: "a.b", Type: SchemaExtension, FieldsAdded: []string{"f"}}},
+		RiskSelfAssessment: "low",
+	}
+	a, err := s.Score(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Level != RiskMedium || !a.Underreported || !a.NeedsHumanReview() {
+		t.Errorf("expected under-reported medium risk, got %+v", a)
+	}
+
+	m.RiskSelfAssessment = "Medium"
+	if a, _ = s.Score(m, nil); a.Underreported || a.NeedsHumanReview() {
+		t.Errorf("expected matching self-assessment to pass, got %+v", a)
+	}
+
+	m.RiskSelfAssessment = "trust me"
+	if a, _ = s.Score(m, nil); a.SelfAssessment != RiskLow || !a.Underreported {
+		t.Errorf("expected unknown self-assessment treated as low, got %+v", a)
+	}
+}
+
+func TestRiskScorer_Diff(t *testing.T) {
+	s := DefaultRiskScorer()
+	m := &Manifest{RiskSelfAssessment: "high"}
+	diff := &DiffStats{Files: []FileChange{
+		{Path: "handlers/sale.go", Added: 300, Deleted: 20},
+		{Path: "db/migrations/0042_add_tier.sql", Added: 10},
+	}}
+	a, err := s.Score(m, diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Level != RiskMedium || a.Score != 4 {
+		t.Errorf("expected medium risk with score 4, got %s (%s)", a.Level, a.Reason())
+	}
+	if !strings.Contains(a.Reason(), "touches db/migrations/0042_add_tier.sql") {
+		t.Errorf("expected sensitive path in reason, got %s", a.Reason())
+	}
+}
+
+func TestRiskScorer_DependencyDepth(t *testing.T) {
+	manifests := map[string]*Manifest{
+		"feature/b": {DependsOn: []string{"feature/c"}},
+		"feature/c": {DependsOn: []string{"feature/a"}}, // cycle back to a
+	}
+	s := DefaultRiskScorer()
+	s.Manifests = func(branch string) (*Manifest, error) { return manifests[branch], nil }
+
+	a, err := s.Score(&Manifest{DependsOn: []string{"feature/b"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Score != 3 || a.Signals[0].Detail != "dependency depth 3" {
+		t.Errorf("expected dependency depth 3, got %s", a.Reason())
+	}
+
+	// A chain of diamonds is walked once per branch, not once per path.
+	const layers = 30
+	manifests = map[string]*Manifest{}
+	for i := 0; i < layers-1; i++ {
+		next := []string{fmt.Sprintf("feature/%d-l", i+1), fmt.Sprintf("feature/%d-r", i+1)}
+		manifests[fmt.Sprintf("feature/%d-l", i)] = &Manifest{DependsOn: next}
+		manifests[fmt.Sprintf("feature/%d-r", i)] = &Manifest{DependsOn: next}
+	}
+	reads := 0
+	s.Manifests = func(branch string) (*Manifest, error) {
+		reads++
+		return manifests[branch], nil
+	}
+	a, err = s.Score(&Manifest{DependsOn: []string{"feature/0-l", "feature/0-r"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Signals[0].Detail != fmt.Sprintf("dependency depth %d", layers) || reads != 2*layers {
+		t.Errorf("got %s after %d manifest reads, want depth %d after %d", a.Signals[0].Detail, reads, layers, 2*layers)
+	}
+
+	s.Manifests = func(string) (*Manifest, error) { return nil, os.ErrNotExist }
+	if _, err := s.Score(&Manifest{DependsOn: []string{"feature/b"}}, nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected lookup error, got %v", err)
+	}
+}
+
+func TestGitDiffStats(t *testing.T) {
+	repo := initGitRepo(t)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	git("branch", "-M", "main")
+	git("checkout", "-b", "feature/x")
+	os.MkdirAll(filepath.Join(repo, "schema"), 0o755)
+	os.WriteFile(filepath.Join(repo, "schema", "sale.json"), []byte("{\n}\n"), 0o644)
+	os.WriteFile(filepath.Join(repo, "with space.go"), []byte("package x\n"), 0o644)
+	git("add", "-A")
+	git("commit", "-m", "change")
+
+	diff, err := GitDiffStats(repo, "main", "feature/x")
+	if err != nil {
+		t.Fatalf("GitDiffStats: %v", err)
+	}
+	if len(diff.Files) != 2 || diff.Lines() != 3 {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+	if diff.Files[0].Path != "schema/sale.json" || diff.Files[1].Path != "with space.go" {
+		t.Errorf("unexpected paths %+v", diff.Files)
+	}
+}
+
+func TestRequireAcceptableRisk(t *testing.T) {
+	ml := NewMemoryLabeler()
+	walk(t, ml, "feature/x", time.Now(), Ready, Analyzing)
+	e := NewEngine(ml, nil)
+	diff := &DiffStats{Files: []FileChange{{Path: "go.mod", Added: 1}, {Path: "migrations/001.sql", Added: 5}}}
+	e.AddGuard(Analyzing, Approved, "acceptable-risk", RequireAcceptableRisk(DefaultRiskScorer(), func(string) (*DiffStats, error) {
+		return diff, nil
+	}))
+
+	m := &Manifest{RiskSelfAssessment: "low"}
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Approved, Manifest: m}); err == nil {
+		t.Fatal("expected under-reported risk to be refused")
+	}
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: HumanReview, Manifest: m}); err != nil {
+		t.Fatalf("routing to human review: %v", err)
+	}
+}
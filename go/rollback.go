@@ -0,0 +1,162 @@
+package cindy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RollbackRecord captures why a deployed revision was rolled back.
+type RollbackRecord struct {
+	Branch    string `json:"branch"`
+	Revision  int    `json:"revision"`
+	Cause     string `json:"cause"`
+	Actor     string `json:"actor"`
+	Timestamp string `json:"timestamp"`
+}
+
+// RollbackReviewID returns the ID of the review requesting a revision after
+// revision of branch was rolled back.
+func RollbackReviewID(branch string, revision int) string {
+	return "rollback/" + branch + "/" + strconv.Itoa(revision)
+}
+
+// NewRollbackReview builds the request_changes review that links a rollback
+// to the branch's next revision. Its single comment carries the cause and
+// stays unresolved until the author addresses it.
+func NewRollbackReview(rb RollbackRecord) Review {
+	return Review{
+		ID:       RollbackReviewID(rb.Branch, rb.Revision),
+		Branch:   rb.Branch,
+		Revision: rb.Revision,
+		Actor:    rb.Actor,
+		Verdict:  RequestChanges,
+		Comments: []ReviewComment{{
+			ID:   "rollback-cause",
+			Body: "Rolled back: " + rb.Cause,
+		}},
+		Timestamp: rb.Timestamp,
+		Rollback:  &rb,
+	}
+}
+
+// LatestRollbackReview returns the most recent rollback review among reviews,
+// or nil if the latest request_changes review is not a rollback review.
+func LatestRollbackReview(reviews []Review) *Review {
+	latest := LatestChangeRequest(reviews)
+	if latest == nil || latest.Rollback == nil {
+		return nil
+	}
+	return latest
+}
+
+// ValidateResubmission checks that manifest m, resubmitted after a rollback,
+// references the rollback review and increments the revision (SPEC §3.2, §6).
+// It returns nil if the latest request_changes review is not a rollback review.
+func ValidateResubmission(m *Manifest, reviews []Review) error {
+	rr := LatestRollbackReview(reviews)
+	if rr == nil {
+		return nil
+	}
+	if m == nil {
+		return errors.New("manifest required to resubmit after a rollback")
+	}
+	if m.RespondsTo == nil || *m.RespondsTo != rr.ID {
+		return fmt.Errorf("manifest must respond to rollback review %s", rr.ID)
+	}
+	if m.Revision <= rr.Revision {
+		return fmt.Errorf("manifest revision %d must be greater than rolled-back revision %d", m.Revision, rr.Revision)
+	}
+	return nil
+}
+
+// RequestRollbackRevision moves a rolled-back branch to
+// cindy:revision-requested so it can be resubmitted on the same branch,
+// keeping its history. It creates the linked rollback review from the cause
+// recorded on the branch's last transition into cindy:rollback (falling back
+// to meta.Reason), applies the transition through e and, once it is applied,
+// saves the review to store.
+func RequestRollbackRevision(e *Engine, store ReviewStore, branch string, revision int, meta Metadata) (*Review, error) {
+	meta = meta.stamp()
+	rb := RollbackRecord{
+		Branch:    branch,
+		Revision:  revision,
+		Cause:     meta.Reason,
+		Actor:     meta.Actor,
+		Timestamp: meta.Timestamp.Format(time.RFC3339),
+	}
+	if rl, ok := e.Labeler().(RecordingLabeler); ok {
+		history, err := rl.History(branch)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(history) - 1; i >= 0; i-- {
+			if history[i].To == Rollback {
+				rb.Cause = history[i].Reason
+				rb.Actor = history[i].Actor
+				rb.Timestamp = history[i].Timestamp.Format(time.RFC3339)
+				break
+			}
+		}
+	}
+	if rb.Cause == "" {
+		return nil, errors.New("rollback cause unknown")
+	}
+
+	review := NewRollbackReview(rb)
+	reviews, err := store.Reviews(branch)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Reason == "" {
+		meta.Reason = "resubmission requested after rollback: " + rb.Cause
+	}
+	_, err = e.ApplyTransition(TransitionRequest{
+		Branch:   branch,
+		To:       RevisionRequested,
+		Metadata: meta,
+		Reviews:  append(reviews, review),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := store.SaveReview(review); err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// RequireRollbackCause refuses transitions into cindy:rollback whose metadata
+// carries no reason, so every rollback records its cause.
+func RequireRollbackCause() Guard {
+	return func(ctx *TransitionContext) error {
+		if ctx.Metadata.Reason == "" {
+			return errors.New("rollback cause (metadata reason) required")
+		}
+		return nil
+	}
+}
+
+// RequireRollbackReview refuses cindy:rollback → cindy:revision-requested
+// unless TransitionRequest.Reviews contains the linked rollback review.
+func RequireRollbackReview() Guard {
+	return func(ctx *TransitionContext) error {
+		if LatestRollbackReview(ctx.Reviews) == nil {
+			return errors.New("linked rollback review required")
+		}
+		return nil
+	}
+}
+
+// RequireRollbackResubmission refuses cindy:revision-requested → cindy:ready
+// after a rollback unless the manifest passes ValidateResubmission. It
+// requires TransitionRequest.Reviews, to find the rollback review.
+func RequireRollbackResubmission() Guard {
+	return func(ctx *TransitionContext) error {
+		if ctx.Reviews == nil {
+			return errors.New("reviews required")
+		}
+		return ValidateResubmission(ctx.Manifest, ctx.Reviews)
+	}
+}
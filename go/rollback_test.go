@@ -0,0 +1,154 @@
+package cindy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deployAndRollBack walks branch through a valid path to cindy:rollback.
+func deployAndRollBack(t *testing.T, e *Engine, branch, cause string) {
+	t.Helper()
+	for _, to := range []Label{Ready, Analyzing, Approved, Deploying, Deployed} {
+		if _, err := e.ApplyTransition(TransitionRequest{Branch: branch, To: to, Metadata: Metadata{Actor: "agent-1"}}); err != nil {
+			t.Fatalf("→ %s: %v", to, err)
+		}
+	}
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: branch, To: Rollback, Metadata: Metadata{Actor: "oncall", Reason: cause}}); err != nil {
+		t.Fatalf("→ rollback: %v", err)
+	}
+}
+
+func TestRollback_RequiresCause(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	for _, to := range []Label{Ready, Analyzing, Approved, Deploying} {
+		if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: to}); err != nil {
+			t.Fatalf("→ %s: %v", to, err)
+		}
+	}
+
+	_, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Rollback})
+	var ge *GuardError
+	if !errors.As(err, &ge) || ge.Guard != "rollback-cause" {
+		t.Fatalf("expected rollback-cause guard to refuse, got %v", err)
+	}
+}
+
+func TestRollback_ResubmissionOnSameBranch(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	store := NewMemoryReviewStore()
+	deployAndRollBack(t, e, "feature/x", "error rate spiked to 4%")
+
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: RevisionRequested}); err == nil {
+		t.Fatal("expected rollback → revision-requested without a rollback review to be refused")
+	}
+
+	review, err := RequestRollbackRevision(e, store, "feature/x", 1, Metadata{Actor: "oncall"})
+	if err != nil {
+		t.Fatalf("RequestRollbackRevision: %v", err)
+	}
+	if review.ID != RollbackReviewID("feature/x", 1) || review.Verdict != RequestChanges {
+		t.Errorf("unexpected review %+v", review)
+	}
+	if review.Rollback == nil || review.Rollback.Cause != "error rate spiked to 4%" || review.Rollback.Actor != "oncall" {
+		t.Errorf("expected rollback record from the rollback transition, got %+v", review.Rollback)
+	}
+	if label, _ := ml.GetLabel("feature/x"); label != RevisionRequested {
+		t.Fatalf("expected revision-requested, got %s", label)
+	}
+
+	reviews, _ := store.Reviews("feature/x")
+	other := "some-other-review"
+	tests := []struct {
+		name     string
+		manifest *Manifest
+	}{
+		{"no manifest", nil},
+		{"no responds_to", &Manifest{Revision: 2}},
+		{"wrong review", &Manifest{Revision: 2, RespondsTo: &other}},
+		{"same revision", &Manifest{Revision: 1, RespondsTo: &review.ID}},
+	}
+	for _, tt := range tests {
+		_, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready, Manifest: tt.manifest, Reviews: reviews})
+		if !errors.As(err, new(*GuardError)) {
+			t.Errorf("%s: expected resubmission to be refused, got %v", tt.name, err)
+		}
+	}
+
+	// The rollback review blocks until its comment is resolved.
+	good := &Manifest{Revision: 2, RespondsTo: &review.ID}
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready, Manifest: good, Reviews: reviews}); err == nil {
+		t.Fatal("expected unresolved rollback review to block resubmission")
+	}
+	reviews[0].Comments[0].Resolved = true
+	if err := store.SaveReview(reviews[0]); err != nil {
+		t.Fatal(err)
+	}
+	reviews, _ = store.Reviews("feature/x")
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready, Manifest: good, Reviews: reviews}); err != nil {
+		t.Fatalf("resubmission: %v", err)
+	}
+
+	history, _ := ml.History("feature/x")
+	if len(history) != 8 {
+		t.Errorf("expected the full history kept on the branch, got %d transitions", len(history))
+	}
+	if v := ValidateHistory(history); len(v) > 0 {
+		t.Errorf("unexpected history violations: %v", v)
+	}
+}
+
+func TestRequestRollbackRevision_FailedTransitionSavesNoReview(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	store := NewMemoryReviewStore()
+	deployAndRollBack(t, e, "feature/x", "error rate spiked to 4%")
+	e.AddGuard(Rollback, RevisionRequested, "freeze", func(*TransitionContext) error {
+		return errors.New("change freeze")
+	})
+
+	if _, err := RequestRollbackRevision(e, store, "feature/x", 1, Metadata{Actor: "oncall"}); err == nil {
+		t.Fatal("expected the transition to be refused")
+	}
+	if reviews, _ := store.Reviews("feature/x"); len(reviews) != 0 {
+		t.Errorf("refused transition left reviews behind: %+v", reviews)
+	}
+}
+
+func TestReviewGuards_RequireReviews(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	walk(t, ml, "feature/x", time.Now(), Ready, Analyzing, RevisionRequested)
+
+	_, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready})
+	if err == nil || !strings.Contains(err.Error(), "no-blocking-reviews") || !strings.Contains(err.Error(), "reviews required") {
+		t.Fatalf("expected missing reviews to be refused, got %v", err)
+	}
+
+	// With a ReviewStore, the engine loads them itself.
+	store := NewMemoryReviewStore()
+	store.SaveReview(Review{ID: "r1", Branch: "feature/x", Actor: "alice", Verdict: RequestChanges, Comments: []ReviewComment{{ID: "c1", Body: "handle nil"}}})
+	e.SetReviewStore(store)
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready}); err == nil || !strings.Contains(err.Error(), "unresolved requested changes") {
+		t.Fatalf("expected the stored blocking review to be found, got %v", err)
+	}
+	if _, err := e.ApplyTransition(TransitionRequest{Branch: "feature/x", To: Ready, Reviews: []Review{}}); err != nil {
+		t.Fatalf("explicitly empty reviews: %v", err)
+	}
+}
+
+func TestValidateResubmission_IgnoresOrdinaryReviews(t *testing.T) {
+	reviews := []Review{{ID: "r1", Revision: 1, Verdict: RequestChanges}}
+	if err := ValidateResubmission(&Manifest{Revision: 2}, reviews); err != nil {
+		t.Errorf("expected no rollback requirements, got %v", err)
+	}
+
+	rb := NewRollbackReview(RollbackRecord{Branch: "feature/x", Revision: 1, Cause: "latency"})
+	later := Review{ID: "r2", Revision: 2, Verdict: RequestChanges}
+	if LatestRollbackReview([]Review{rb, later}) != nil {
+		t.Error("expected a later request_changes review to supersede the rollback review")
+	}
+}
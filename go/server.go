@@ -0,0 +1,582 @@
+package cindy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LabelState is a branch's current label and the labels it may move to next.
+type LabelState struct {
+	Branch string  `json:"branch"`
+	Label  Label   `json:"label"`
+	Next   []Label `json:"next"`
+}
+
+// LabelList is the set of labeled branches.
+type LabelList struct {
+	Labels map[string]Label `json:"labels"`
+}
+
+// LabelChange asks the server to transition a branch. From is the label the
+// client expects the branch to carry ("" for unlabeled); the change is
+// refused with 409 Conflict if it carries anything else, so two agents acting
+// on the same observed state cannot both succeed. Actor is required unless
+// the server authenticates callers.
+type LabelChange struct {
+	To           Label    `json:"to"`
+	From         *Label   `json:"from"`
+	Actor        string   `json:"actor,omitempty"`
+	Reason       string   `json:"reason,omitempty"`
+	Dependencies []string `json:"dependencies,omitempty"`
+	RiskLevel    string   `json:"risk_level,omitempty"`
+	// Timestamp, ManifestDigest and Signature carry a transition the
+	// client signed; see Signer.SignRequest.
+	Timestamp      time.Time  `json:"timestamp,omitzero"`
+	ManifestDigest string     `json:"manifest_sha256,omitempty"`
+	Signature      *Signature `json:"signature,omitempty"`
+}
+
+// LabelChangeResult is the outcome of an applied LabelChange. HookErrors
+// lists the post-transition hooks that failed; the transition stands.
+type LabelChangeResult struct {
+	Transition Transition `json:"transition"`
+	HookErrors []string   `json:"hook_errors,omitempty"`
+}
+
+// VerificationReport lists the signature status of labeled branches.
+type VerificationReport struct {
+	Labels []LabelVerification `json:"labels"`
+}
+
+// ReviewList is the set of reviews for a branch.
+type ReviewList struct {
+	Reviews []Review `json:"reviews"`
+}
+
+// HistoryList is a branch's transition history, oldest first.
+type HistoryList struct {
+	Transitions []Transition `json:"transitions"`
+}
+
+// DependencyGraph is the live pipeline: every labeled branch and the
+// branches each one depends on, from its manifest.
+type DependencyGraph struct {
+	Labels       map[string]Label    `json:"labels"`
+	Dependencies map[string][]string `json:"dependencies"`
+}
+
+// APIError is the body of every error response. Label is the branch's
+// current label when a compare-and-swap fails.
+type APIError struct {
+	Error string `json:"error"`
+	Label *Label `json:"label,omitempty"`
+}
+
+// Server is an HTTP/JSON API over an Engine's labeler and a ReviewStore, for
+// agents that cannot link this package. Transitions go through the Engine,
+// so the pipeline, its guards and hooks apply exactly as they do in process.
+// Branch names in paths may contain slashes. The routes are described by the
+// OpenAPI document served at /v1/openapi.json.
+type Server struct {
+	Engine *Engine
+	// Repo is the git repository manifests are read from, if Manifests is nil.
+	Repo string
+	// Manifests returns a branch's manifest, or an error wrapping
+	// ErrNoManifest. Defaults to the manifest committed on the branch in Repo.
+	Manifests func(branch string) (*Manifest, error)
+	// Reviews stores reviews. If nil, the review routes respond 501 and
+	// transitions are applied without reviews.
+	Reviews ReviewStore
+	// Events, if set, streams label changes as server-sent events at
+	// /v1/events.
+	Events *Dispatcher
+	// Push, if set, receives forge push webhooks at /v1/push.
+	Push *PushReceiver
+	// Keys, if set, verifies label signatures at /v1/verify.
+	Keys *KeyRing
+	// Metrics, if set, is served at /metrics, e.g. a *metrics.Metrics.
+	Metrics http.Handler
+	// Authenticate, if set, identifies the caller of label changes and
+	// reviews, which are then attributed to that actor: an actor in the body
+	// may be omitted, and is refused with 403 if it names someone else.
+	// Errors answer 401.
+	Authenticate func(r *http.Request) (actor string, err error)
+
+	once sync.Once
+	mux  *http.ServeMux
+}
+
+// errNotImplemented reports a route the server's configuration cannot serve.
+var errNotImplemented = errors.New("not supported by this server")
+
+// textBody is a handler result written as is instead of as JSON.
+type textBody struct {
+	contentType string
+	body        string
+}
+
+// route is one API operation. The route table drives both the mux and the
+// OpenAPI document.
+type route struct {
+	method, path, summary string
+	query                 map[string]string
+	body                  any
+	response              any
+	handle                func(s *Server, r *http.Request) (any, error)
+}
+
+var routes = []route{
+	{method: "GET", path: "/v1/pipeline", summary: "Get the pipeline definition",
+		response: PipelineConfig{}, handle: (*Server).getPipeline},
+	{method: "GET", path: "/v1/labels", summary: "List labeled branches",
+		query: map[string]string{"label": "only branches carrying this label"}, response: LabelList{}, handle: (*Server).listLabels},
+	{method: "GET", path: "/v1/labels/{branch}", summary: "Get a branch's label",
+		response: LabelState{}, handle: (*Server).getLabel},
+	{method: "POST", path: "/v1/labels/{branch}", summary: "Transition a branch, if it still carries the expected label",
+		body: LabelChange{}, response: LabelChangeResult{}, handle: (*Server).changeLabel},
+	{method: "GET", path: "/v1/manifests/{branch}", summary: "Get a branch's manifest",
+		response: Manifest{}, handle: (*Server).getManifest},
+	{method: "GET", path: "/v1/reviews/{branch}", summary: "List a branch's reviews",
+		response: ReviewList{}, handle: (*Server).listReviews},
+	{method: "POST", path: "/v1/reviews/{branch}", summary: "Save a review, replacing any with the same ID",
+		body: Review{}, response: Review{}, handle: (*Server).saveReview},
+	{method: "GET", path: "/v1/history/{branch}", summary: "Get a branch's transition history",
+		response: HistoryList{}, handle: (*Server).getHistory},
+	{method: "GET", path: "/v1/verify", summary: "Verify the signatures behind branches' current labels",
+		query: map[string]string{"label": "only branches carrying this label"}, response: VerificationReport{}, handle: (*Server).verifyLabels},
+	{method: "GET", path: "/v1/graph", summary: "Get the live pipeline and its dependency graph",
+		query: map[string]string{"format": "json (default), dot or mermaid"}, response: DependencyGraph{}, handle: (*Server).getGraph},
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(func() {
+		s.mux = http.NewServeMux()
+		for _, rt := range routes {
+			pattern := rt.method + " " + strings.Replace(rt.path, "{branch}", "{branch...}", 1)
+			s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+				v, err := rt.handle(s, r)
+				if err != nil {
+					writeError(w, err)
+					return
+				}
+				writeJSON(w, http.StatusOK, v)
+			})
+		}
+		s.mux.HandleFunc("GET /v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, OpenAPI(s.Engine.Pipeline()))
+		})
+		s.mux.HandleFunc("GET /v1/events", func(w http.ResponseWriter, r *http.Request) {
+			if s.Events == nil {
+				writeError(w, errNotImplemented)
+				return
+			}
+			s.Events.ServeHTTP(w, r)
+		})
+		s.mux.HandleFunc("POST /v1/push", func(w http.ResponseWriter, r *http.Request) {
+			if s.Push == nil {
+				writeError(w, errNotImplemented)
+				return
+			}
+			s.Push.ServeHTTP(w, r)
+		})
+		s.mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+			if s.Metrics == nil {
+				writeError(w, errNotImplemented)
+				return
+			}
+			s.Metrics.ServeHTTP(w, r)
+		})
+	})
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) getPipeline(r *http.Request) (any, error) {
+	return s.Engine.Pipeline().Config(), nil
+}
+
+func (s *Server) listLabels(r *http.Request) (any, error) {
+	l := s.Engine.Labeler()
+	if q := r.URL.Query().Get("label"); q != "" {
+		label := Label(q)
+		if err := s.Engine.Pipeline().checkLabel(label); err != nil {
+			return nil, err
+		}
+		branches, err := l.BranchesWithLabel(label)
+		if err != nil {
+			return nil, err
+		}
+		list := LabelList{Labels: make(map[string]Label, len(branches))}
+		for _, b := range branches {
+			list.Labels[b] = label
+		}
+		return list, nil
+	}
+	labels, err := l.AllLabels()
+	if err != nil {
+		return nil, err
+	}
+	return LabelList{Labels: labels}, nil
+}
+
+func (s *Server) getLabel(r *http.Request) (any, error) {
+	branch := r.PathValue("branch")
+	label, err := s.Engine.Labeler().GetLabel(branch)
+	if err != nil {
+		return nil, err
+	}
+	next := s.Engine.Pipeline().ValidTransitionsFrom(label)
+	if label == "" {
+		next = []Label{s.Engine.Pipeline().Entry()}
+	}
+	return LabelState{Branch: branch, Label: label, Next: next}, nil
+}
+
+func (s *Server) changeLabel(r *http.Request) (any, error) {
+	branch := r.PathValue("branch")
+	var c LabelChange
+	if err := decodeBody(r, &c); err != nil {
+		return nil, err
+	}
+	if c.From == nil {
+		return nil, errPreconditionRequired
+	}
+	actor, err := s.actor(r, c.Actor)
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.manifest(branch)
+	if err != nil && !errors.Is(err, ErrNoManifest) {
+		return nil, err
+	}
+	var reviews []Review
+	if s.Reviews != nil {
+		if reviews, err = s.Reviews.Reviews(branch); err != nil {
+			return nil, err
+		}
+		reviews = append([]Review{}, reviews...)
+	}
+
+	meta := Metadata{
+		Actor: actor, Reason: c.Reason, Dependencies: c.Dependencies, RiskLevel: c.RiskLevel,
+		Timestamp: c.Timestamp, ManifestDigest: c.ManifestDigest, Signature: c.Signature,
+	}
+	// The timestamp is part of what is signed; otherwise callers could
+	// backdate history, so the engine stamps the time of the change.
+	if !s.signedBy(Transition{Branch: branch, From: *c.From, To: c.To, Metadata: meta}) {
+		meta.Timestamp = time.Time{}
+	}
+	t, err := s.Engine.ApplyTransition(TransitionRequest{
+		Branch:   branch,
+		To:       c.To,
+		Metadata: meta,
+		Manifest: m,
+		Reviews:  reviews,
+		Expect:   c.From,
+	})
+	if t == nil {
+		return nil, err
+	}
+	result := LabelChangeResult{Transition: *t}
+	if err != nil {
+		for _, e := range unjoin(err) {
+			result.HookErrors = append(result.HookErrors, e.Error())
+		}
+	}
+	return result, nil
+}
+
+// signedBy reports whether t carries a signature s.Keys verifies.
+func (s *Server) signedBy(t Transition) bool {
+	return t.Signature != nil && s.Keys != nil && s.Keys.Verify(t).Status == SignatureValid
+}
+
+func (s *Server) getManifest(r *http.Request) (any, error) {
+	return s.manifest(r.PathValue("branch"))
+}
+
+func (s *Server) listReviews(r *http.Request) (any, error) {
+	branch := r.PathValue("branch")
+	if err := ValidateBranchName(branch); err != nil {
+		return nil, err
+	}
+	if s.Reviews == nil {
+		return nil, errNotImplemented
+	}
+	reviews, err := s.Reviews.Reviews(branch)
+	if err != nil {
+		return nil, err
+	}
+	return ReviewList{Reviews: append([]Review{}, reviews...)}, nil
+}
+
+func (s *Server) saveReview(r *http.Request) (any, error) {
+	branch := r.PathValue("branch")
+	if err := ValidateBranchName(branch); err != nil {
+		return nil, err
+	}
+	if s.Reviews == nil {
+		return nil, errNotImplemented
+	}
+	var review Review
+	if err := decodeBody(r, &review); err != nil {
+		return nil, err
+	}
+	actor, err := s.actor(r, review.Actor)
+	if err != nil {
+		return nil, err
+	}
+	review.Actor = actor
+	switch {
+	case review.Branch != "" && review.Branch != branch:
+		return nil, badRequest(fmt.Sprintf("review is for %s, not %s", review.Branch, branch))
+	case review.ID == "":
+		return nil, badRequest("review id is required")
+	case review.Verdict != Approve && review.Verdict != RequestChanges && review.Verdict != Comment:
+		return nil, badRequest(fmt.Sprintf("unknown verdict %q", review.Verdict))
+	}
+	review.Branch = branch
+	if err := s.checkReviewOwner(review); err != nil {
+		return nil, err
+	}
+	if review.Timestamp == "" {
+		review.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	if err := s.Reviews.SaveReview(review); err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// checkReviewOwner refuses to let r replace a review written by another
+// actor, unless the engine's Permissions let r's actor edit others' reviews.
+func (s *Server) checkReviewOwner(r Review) error {
+	existing, err := s.Reviews.Reviews(r.Branch)
+	if err != nil {
+		return err
+	}
+	for _, old := range existing {
+		if old.ID != r.ID || old.Actor == r.Actor {
+			continue
+		}
+		if p := s.Engine.Permissions(); p != nil && p.CanEditReviews(r.Actor) {
+			return nil
+		}
+		return &requestError{http.StatusForbidden, fmt.Sprintf("review %s was written by %s, not %s", r.ID, orUnknown(old.Actor), r.Actor)}
+	}
+	return nil
+}
+
+// actor returns who is making a change: the authenticated caller if the
+// server authenticates, otherwise the actor the request names.
+func (s *Server) actor(r *http.Request, named string) (string, error) {
+	if s.Authenticate == nil {
+		if named == "" {
+			return "", badRequest("actor is required")
+		}
+		return named, nil
+	}
+	actor, err := s.Authenticate(r)
+	if err != nil {
+		return "", &requestError{http.StatusUnauthorized, err.Error()}
+	}
+	if named != "" && named != actor {
+		return "", &requestError{http.StatusForbidden, fmt.Sprintf("authenticated as %s, not %s", actor, named)}
+	}
+	return actor, nil
+}
+
+// BearerTokens authenticates requests by their "Authorization: Bearer"
+// token, for Server.Authenticate. digests maps each actor to the hex SHA-256
+// digest of their token, so the tokens themselves need not be stored.
+func BearerTokens(digests map[string]string) func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			return "", errors.New("bearer token required")
+		}
+		sum := sha256.Sum256([]byte(token))
+		digest := hex.EncodeToString(sum[:])
+		for actor, want := range digests {
+			if subtle.ConstantTimeCompare([]byte(digest), []byte(strings.ToLower(want))) == 1 {
+				return actor, nil
+			}
+		}
+		return "", errors.New("unknown token")
+	}
+}
+
+func (s *Server) getHistory(r *http.Request) (any, error) {
+	branch := r.PathValue("branch")
+	rl, ok := s.Engine.Labeler().(RecordingLabeler)
+	if !ok {
+		return nil, errNotImplemented
+	}
+	history, err := rl.History(branch)
+	if err != nil {
+		return nil, err
+	}
+	return HistoryList{Transitions: append([]Transition{}, history...)}, nil
+}
+
+func (s *Server) verifyLabels(r *http.Request) (any, error) {
+	rl, ok := s.Engine.Labeler().(RecordingLabeler)
+	if !ok || s.Keys == nil {
+		return nil, errNotImplemented
+	}
+	var labels []Label
+	if l := r.URL.Query().Get("label"); l != "" {
+		if err := s.Engine.Pipeline().checkLabel(Label(l)); err != nil {
+			return nil, err
+		}
+		labels = append(labels, Label(l))
+	}
+	results, err := VerifyLabels(rl, s.Keys, labels...)
+	if err != nil {
+		return nil, err
+	}
+	return VerificationReport{Labels: append([]LabelVerification{}, results...)}, nil
+}
+
+func (s *Server) getGraph(r *http.Request) (any, error) {
+	labels, err := s.Engine.Labeler().AllLabels()
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]string, 0, len(labels))
+	for b := range labels {
+		branches = append(branches, b)
+	}
+	sort.Strings(branches)
+	manifests := make(map[string]*Manifest)
+	graph := DependencyGraph{Labels: labels, Dependencies: make(map[string][]string)}
+	for _, b := range branches {
+		m, err := s.manifest(b)
+		if errors.Is(err, ErrNoManifest) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifests[b] = m
+		if len(m.DependsOn) > 0 {
+			graph.Dependencies[b] = m.DependsOn
+		}
+	}
+
+	live := &LivePipeline{Pipeline: s.Engine.Pipeline(), Labels: labels, Manifests: manifests}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		return graph, nil
+	case "dot":
+		return textBody{"text/vnd.graphviz; charset=utf-8", live.DOT()}, nil
+	case "mermaid":
+		return textBody{"text/plain; charset=utf-8", live.Mermaid()}, nil
+	default:
+		return nil, badRequest(fmt.Sprintf("unknown format %q", format))
+	}
+}
+
+func (s *Server) manifest(branch string) (*Manifest, error) {
+	if err := ValidateBranchName(branch); err != nil {
+		return nil, err
+	}
+	if s.Manifests != nil {
+		return s.Manifests(branch)
+	}
+	if s.Repo == "" {
+		return nil, fmt.Errorf("%w: no repository configured", ErrNoManifest)
+	}
+	return LoadBranchManifest(s.Repo, branch)
+}
+
+// requestError is an error caused by the request itself.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string { return e.msg }
+
+var errPreconditionRequired = &requestError{http.StatusPreconditionRequired, `"from" is required: the label the branch is expected to carry, "" if unlabeled`}
+
+func badRequest(msg string) error {
+	return &requestError{http.StatusBadRequest, msg}
+}
+
+func decodeBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("decoding request body: " + err.Error())
+	}
+	return nil
+}
+
+// statusOf maps an error to the HTTP status reporting it.
+func statusOf(err error) int {
+	var re *requestError
+	var ge *GuardError
+	switch {
+	case errors.As(err, &re):
+		return re.status
+	case errors.Is(err, ErrInvalidBranch), errors.Is(err, ErrUnknownLabel):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrInvalidTransition), errors.As(err, &ge):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrNoManifest):
+		return http.StatusNotFound
+	case errors.Is(err, errNotImplemented):
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	body := APIError{Error: err.Error()}
+	var ce *ConflictError
+	if errors.As(err, &ce) {
+		body.Label = &ce.Actual
+	}
+	writeJSON(w, statusOf(err), body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	if t, ok := v.(textBody); ok {
+		w.Header().Set("Content-Type", t.contentType)
+		w.WriteHeader(status)
+		io.WriteString(w, t.body)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// unjoin flattens an errors.Join tree into its leaves.
+func unjoin(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []error
+		for _, e := range j.Unwrap() {
+			out = append(out, unjoin(e)...)
+		}
+		return out
+	}
+	return []error{err}
+}
@@ -0,0 +1,433 @@
+package cindy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiCall sends a request to srv and decodes the JSON response into out,
+// returning the status code.
+func apiCall(t *testing.T, srv *httptest.Server, method, path string, body any, out any) int {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func labelPtr(l Label) *Label { return &l }
+
+func newTestServer(t *testing.T) (*httptest.Server, *MemoryLabeler, *MemoryReviewStore) {
+	t.Helper()
+	ml := NewMemoryLabeler()
+	store := NewMemoryReviewStore()
+	manifests := map[string]*Manifest{
+		"feature/b": {Revision: 2, DependsOn: []string{"feature/a"}},
+	}
+	srv := httptest.NewServer(&Server{
+		Engine:  NewEngine(ml, nil),
+		Reviews: store,
+		Manifests: func(branch string) (*Manifest, error) {
+			if m, ok := manifests[branch]; ok {
+				return m, nil
+			}
+			return nil, ErrNoManifest
+		},
+	})
+	t.Cleanup(srv.Close)
+	return srv, ml, store
+}
+
+func TestServer_Transitions(t *testing.T) {
+	srv, ml, _ := newTestServer(t)
+
+	var apiErr APIError
+	if code := apiCall(t, srv, "POST", "/v1/labels/feature/a", LabelChange{To: Ready, Actor: "agent"}, &apiErr); code != http.StatusPreconditionRequired {
+		t.Errorf("transition without from: got %d %s", code, apiErr.Error)
+	}
+
+	var result LabelChangeResult
+	if code := apiCall(t, srv, "POST", "/v1/labels/feature/a", LabelChange{To: Ready, From: labelPtr(""), Actor: "agent", Reason: "pushed"}, &result); code != http.StatusOK {
+		t.Fatalf("transition to ready: got %d", code)
+	}
+	if result.Transition.To != Ready || result.Transition.Actor != "agent" || result.Transition.Timestamp.IsZero() {
+		t.Errorf("unexpected transition %+v", result.Transition)
+	}
+
+	var state LabelState
+	if code := apiCall(t, srv, "GET", "/v1/labels/feature/a", nil, &state); code != http.StatusOK || state.Label != Ready || len(state.Next) != 1 || state.Next[0] != Analyzing {
+		t.Errorf("GET label: %d %+v", code, state)
+	}
+
+	tests := []struct {
+		name   string
+		branch string
+		change LabelChange
+		status int
+	}{
+		{"stale from", "feature/a", LabelChange{To: Analyzing, From: labelPtr(""), Actor: "agent"}, http.StatusConflict},
+		{"invalid transition", "feature/a", LabelChange{To: Deployed, From: labelPtr(Ready), Actor: "agent"}, http.StatusUnprocessableEntity},
+		{"unknown label", "feature/a", LabelChange{To: "cindy:shipped", From: labelPtr(Ready), Actor: "agent"}, http.StatusBadRequest},
+		{"invalid branch", "feature/a..b", LabelChange{To: Ready, From: labelPtr(""), Actor: "agent"}, http.StatusBadRequest},
+		{"no actor", "feature/a", LabelChange{To: Analyzing, From: labelPtr(Ready)}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var apiErr APIError
+			if code := apiCall(t, srv, "POST", "/v1/labels/"+tt.branch, tt.change, &apiErr); code != tt.status {
+				t.Errorf("got %d (%s), want %d", code, apiErr.Error, tt.status)
+			}
+			if tt.status == http.StatusConflict && (apiErr.Label == nil || *apiErr.Label != Ready) {
+				t.Errorf("conflict should report the current label, got %+v", apiErr)
+			}
+		})
+	}
+	if label, _ := ml.GetLabel("feature/a"); label != Ready {
+		t.Errorf("refused transitions changed the label to %s", label)
+	}
+}
+
+func TestServer_ConcurrentTransitions(t *testing.T) {
+	srv, ml, _ := newTestServer(t)
+	walk(t, ml, "feature/a", time.Now(), Ready, Analyzing)
+
+	// Several agents observe cindy:analyzing and decide differently.
+	targets := []Label{Approved, Rejected, HumanReview, Blocked, Approved}
+	codes := make([]int, len(targets))
+	var wg sync.WaitGroup
+	for i, to := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = apiCall(t, srv, "POST", "/v1/labels/feature/a", LabelChange{To: to, From: labelPtr(Analyzing), Actor: "agent"}, nil)
+		}()
+	}
+	wg.Wait()
+
+	won := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			won++
+		case http.StatusConflict:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if won != 1 {
+		t.Errorf("expected exactly one agent to win, got %d (%v)", won, codes)
+	}
+}
+
+func TestServer_ReviewsGateTransitions(t *testing.T) {
+	srv, ml, _ := newTestServer(t)
+	walk(t, ml, "feature/a", time.Now(), Ready, Analyzing, RevisionRequested)
+
+	file := "orders.go"
+	review := Review{ID: "r1", Revision: 1, Actor: "reviewer", Verdict: RequestChanges,
+		Comments: []ReviewComment{{ID: "c1", File: &file, Body: "handle nil"}}}
+	var saved Review
+	if code := apiCall(t, srv, "POST", "/v1/reviews/feature/a", review, &saved); code != http.StatusOK || saved.Branch != "feature/a" || saved.Timestamp == "" {
+		t.Fatalf("save review: %d %+v", code, saved)
+	}
+	if code := apiCall(t, srv, "POST", "/v1/reviews/feature/a", Review{ID: "r2", Actor: "x", Verdict: "lgtm"}, nil); code != http.StatusBadRequest {
+		t.Errorf("unknown verdict: got %d", code)
+	}
+
+	resubmit := LabelChange{To: Ready, From: labelPtr(RevisionRequested), Actor: "agent"}
+	var apiErr APIError
+	if code := apiCall(t, srv, "POST", "/v1/labels/feature/a", resubmit, &apiErr); code != http.StatusUnprocessableEntity || !strings.Contains(apiErr.Error, "no-blocking-reviews") {
+		t.Fatalf("resubmission with a blocking review: %d %s", code, apiErr.Error)
+	}
+
+	review.Comments[0].Resolved = true
+	apiCall(t, srv, "POST", "/v1/reviews/feature/a", review, nil)
+	if code := apiCall(t, srv, "POST", "/v1/labels/feature/a", resubmit, nil); code != http.StatusOK {
+		t.Fatalf("resubmission after resolving: %d", code)
+	}
+
+	var reviews ReviewList
+	if code := apiCall(t, srv, "GET", "/v1/reviews/feature/a", nil, &reviews); code != http.StatusOK || len(reviews.Reviews) != 1 || !reviews.Reviews[0].Comments[0].Resolved {
+		t.Errorf("list reviews: %d %+v", code, reviews)
+	}
+}
+
+func TestServer_ReviewOwnership(t *testing.T) {
+	srv, _, store := newTestServer(t)
+	s := srv.Config.Handler.(*Server)
+	review := Review{ID: "r1", Actor: "alice", Verdict: RequestChanges, Comments: []ReviewComment{{ID: "c1", Body: "handle nil"}}}
+	if code := apiCall(t, srv, "POST", "/v1/reviews/feature/a", review, nil); code != http.StatusOK {
+		t.Fatalf("save review: got %d", code)
+	}
+
+	// Only the reviewer may replace the review...
+	takeover := Review{ID: "r1", Actor: "agent-7", Verdict: Approve}
+	if code := apiCall(t, srv, "POST", "/v1/reviews/feature/a", takeover, nil); code != http.StatusForbidden {
+		t.Errorf("replacing another actor's review: got %d, want 403", code)
+	}
+	review.Comments[0].Resolved = true
+	if code := apiCall(t, srv, "POST", "/v1/reviews/feature/a", review, nil); code != http.StatusOK {
+		t.Errorf("reviewer replacing their review: got %d", code)
+	}
+
+	// ...or an actor whose role lets them edit others' reviews.
+	perms, err := ParsePermissions([]byte(`{
+		"actors": [
+			{"name": "agent-7", "kind": "agent"},
+			{"name": "bob", "kind": "human", "roles": ["release-manager"]}
+		],
+		"review_editors": ["release-manager"]
+	}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Engine.SetPermissions(perms)
+	if code := apiCall(t, srv, "POST", "/v1/reviews/feature/a", takeover, nil); code != http.StatusForbidden {
+		t.Errorf("actor without an editor role: got %d, want 403", code)
+	}
+	if code := apiCall(t, srv, "POST", "/v1/reviews/feature/a", Review{ID: "r1", Actor: "bob", Verdict: Comment}, nil); code != http.StatusOK {
+		t.Errorf("review editor: got %d", code)
+	}
+	reviews, _ := store.Reviews("feature/a")
+	if len(reviews) != 1 || reviews[0].Actor != "bob" {
+		t.Errorf("reviews %+v", reviews)
+	}
+}
+
+func TestServer_UnsignedTimestampIgnored(t *testing.T) {
+	srv, ml, _ := newTestServer(t)
+	backdated := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	change := LabelChange{To: Ready, From: labelPtr(""), Actor: "agent", Timestamp: backdated}
+	if code := apiCall(t, srv, "POST", "/v1/labels/feature/a", change, nil); code != http.StatusOK {
+		t.Fatalf("change: got %d", code)
+	}
+	history, _ := ml.History("feature/a")
+	if len(history) != 1 || !history[0].Timestamp.After(backdated) {
+		t.Errorf("unsigned change kept its client timestamp: %+v", history)
+	}
+}
+
+func TestServer_ReadEndpoints(t *testing.T) {
+	srv, ml, _ := newTestServer(t)
+	walk(t, ml, "feature/a", time.Now(), Ready, Analyzing, Approved)
+	walk(t, ml, "feature/b", time.Now(), Ready, Analyzing, Blocked)
+
+	var labels LabelList
+	if apiCall(t, srv, "GET", "/v1/labels?label=cindy:blocked", nil, &labels); len(labels.Labels) != 1 || labels.Labels["feature/b"] != Blocked {
+		t.Errorf("filtered labels: %+v", labels)
+	}
+
+	var m Manifest
+	if code := apiCall(t, srv, "GET", "/v1/manifests/feature/b", nil, &m); code != http.StatusOK || m.Revision != 2 {
+		t.Errorf("manifest: %d %+v", code, m)
+	}
+	if code := apiCall(t, srv, "GET", "/v1/manifests/feature/a", nil, nil); code != http.StatusNotFound {
+		t.Errorf("missing manifest: got %d", code)
+	}
+
+	var history HistoryList
+	if code := apiCall(t, srv, "GET", "/v1/history/feature/a", nil, &history); code != http.StatusOK || len(history.Transitions) != 3 || history.Transitions[2].To != Approved {
+		t.Errorf("history: %d %+v", code, history)
+	}
+
+	var graph DependencyGraph
+	apiCall(t, srv, "GET", "/v1/graph", nil, &graph)
+	if len(graph.Labels) != 2 || len(graph.Dependencies["feature/b"]) != 1 || graph.Dependencies["feature/b"][0] != "feature/a" {
+		t.Errorf("graph: %+v", graph)
+	}
+	resp, err := srv.Client().Get(srv.URL + "/v1/graph?format=dot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(string(dot), "digraph") {
+		t.Errorf("DOT graph: %s", dot)
+	}
+
+	var cfg PipelineConfig
+	if apiCall(t, srv, "GET", "/v1/pipeline", nil, &cfg); cfg.Name != "cindy" || len(cfg.Transitions) == 0 {
+		t.Errorf("pipeline: %+v", cfg)
+	}
+}
+
+func TestServer_GitRepository(t *testing.T) {
+	repo, git := mergeRepo(t)
+	git("checkout", "-q", "-b", "feature/x")
+	if err := os.MkdirAll(filepath.Join(repo, ".cindy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, git, ManifestPath, `{"revision": 3, "depends_on": []}`)
+	git("checkout", "-q", "main")
+
+	gl, err := NewGitLabeler(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileReviewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&Server{Engine: NewEngine(gl, nil), Repo: repo, Reviews: store})
+	defer srv.Close()
+
+	var m Manifest
+	if code := apiCall(t, srv, "GET", "/v1/manifests/feature/x", nil, &m); code != http.StatusOK || m.Revision != 3 {
+		t.Errorf("manifest: %d %+v", code, m)
+	}
+	if code := apiCall(t, srv, "GET", "/v1/manifests/main", nil, nil); code != http.StatusNotFound {
+		t.Errorf("branch without a manifest: got %d", code)
+	}
+	if code := apiCall(t, srv, "POST", "/v1/labels/feature/x", LabelChange{To: Ready, From: labelPtr(""), Actor: "agent"}, nil); code != http.StatusOK {
+		t.Fatalf("transition: %d", code)
+	}
+	if label, _ := gl.GetLabel("feature/x"); label != Ready {
+		t.Errorf("expected the tag to be created, got %s", label)
+	}
+}
+
+func TestFileReviewStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileReviewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []Review{
+		{ID: "r1", Branch: "feature/a", Verdict: RequestChanges},
+		{ID: "r2", Branch: "feature/a", Verdict: Comment},
+		{ID: "r1", Branch: "feature/a", Verdict: Approve},
+		{ID: "r3", Branch: "feature/b", Verdict: Approve},
+	} {
+		if err := store.SaveReview(r); err != nil {
+			t.Fatalf("SaveReview: %v", err)
+		}
+	}
+
+	reopened, _ := NewFileReviewStore(dir)
+	reviews, err := reopened.Reviews("feature/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reviews) != 2 || reviews[0].ID != "r1" || reviews[0].Verdict != Approve || reviews[1].ID != "r2" {
+		t.Errorf("unexpected reviews %+v", reviews)
+	}
+	if reviews, _ := reopened.Reviews("feature/none"); len(reviews) != 0 {
+		t.Errorf("expected no reviews, got %+v", reviews)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc := OpenAPI(DefaultPipeline())
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("encoding: %v", err)
+	}
+	var spec struct {
+		Paths      map[string]map[string]json.RawMessage
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage
+				Required   []string
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, rt := range routes {
+		if _, ok := spec.Paths[rt.path][strings.ToLower(rt.method)]; !ok {
+			t.Errorf("route %s %s missing from the document", rt.method, rt.path)
+		}
+	}
+
+	change := spec.Components.Schemas["LabelChange"]
+	if !strings.Contains(strings.Join(change.Required, ","), "from") {
+		t.Errorf("LabelChange should require from, got %v", change.Required)
+	}
+	if !strings.Contains(string(change.Properties["to"]), `"cindy:revision-requested"`) {
+		t.Errorf("label schema should enumerate pipeline labels, got %s", change.Properties["to"])
+	}
+	// Transition embeds Metadata; its fields are inlined like encoding/json does.
+	if _, ok := spec.Components.Schemas["Transition"].Properties["actor"]; !ok {
+		t.Errorf("Transition schema lacks the embedded metadata fields")
+	}
+}
+
+func TestServer_Authenticate(t *testing.T) {
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	e.SetPermissions(loadExamplePermissions(t))
+	sum := sha256.Sum256([]byte("s3cret"))
+	srv := httptest.NewServer(&Server{
+		Engine:       e,
+		Manifests:    func(string) (*Manifest, error) { return nil, ErrNoManifest },
+		Authenticate: BearerTokens(map[string]string{"agent-7": hex.EncodeToString(sum[:])}),
+	})
+	defer srv.Close()
+
+	post := func(token string, change LabelChange) int {
+		t.Helper()
+		data, _ := json.Marshal(change)
+		req, _ := http.NewRequest("POST", srv.URL+"/v1/labels/feature/x", bytes.NewReader(data))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	none := Label("")
+	if code := post("", LabelChange{To: Ready, From: &none}); code != http.StatusUnauthorized {
+		t.Errorf("without a token: got %d", code)
+	}
+	if code := post("wrong", LabelChange{To: Ready, From: &none}); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: got %d", code)
+	}
+	if code := post("s3cret", LabelChange{To: Ready, From: &none, Actor: "deploy-bot"}); code != http.StatusForbidden {
+		t.Errorf("impersonation: got %d", code)
+	}
+	if code := post("s3cret", LabelChange{To: Ready, From: &none}); code != http.StatusOK {
+		t.Fatalf("authenticated change: got %d", code)
+	}
+	history, _ := ml.History("feature/x")
+	if len(history) != 1 || history[0].Actor != "agent-7" || history[0].ActorKind != KindAgent {
+		t.Errorf("change not attributed: %+v", history)
+	}
+	ready := Ready
+	if code := post("s3cret", LabelChange{To: Analyzing, From: &ready}); code != http.StatusForbidden {
+		t.Errorf("forbidden transition: got %d", code)
+	}
+}
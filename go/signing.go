@@ -0,0 +1,327 @@
+package cindy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Signature is a detached Ed25519 signature over a transition, carried in
+// its metadata. See TransitionPayload for what is signed.
+type Signature struct {
+	KeyID string `json:"key_id"`
+	// Value is the standard base64 encoding of the signature.
+	Value string `json:"value"`
+}
+
+// signedTransition is the part of a transition a signature covers. Fields
+// the engine adds after the actor signs, such as the actor's identity, are
+// left out.
+type signedTransition struct {
+	Branch         string    `json:"branch"`
+	From           Label     `json:"from"`
+	To             Label     `json:"to"`
+	Actor          string    `json:"actor"`
+	Reason         string    `json:"reason"`
+	Timestamp      time.Time `json:"timestamp"`
+	Dependencies   []string  `json:"dependencies,omitempty"`
+	RiskLevel      string    `json:"risk_level,omitempty"`
+	Correction     bool      `json:"correction,omitempty"`
+	ManifestDigest string    `json:"manifest_sha256,omitempty"`
+}
+
+// TransitionPayload returns the bytes a signature over t covers: the
+// branch, both labels, and the actor, reason, timestamp, dependencies, risk
+// level, correction flag and manifest digest of its metadata.
+func TransitionPayload(t Transition) []byte {
+	data, _ := json.Marshal(signedTransition{
+		Branch:         t.Branch,
+		From:           t.From,
+		To:             t.To,
+		Actor:          t.Actor,
+		Reason:         t.Reason,
+		Timestamp:      t.Timestamp.UTC(),
+		Dependencies:   t.Dependencies,
+		RiskLevel:      t.RiskLevel,
+		Correction:     t.Correction,
+		ManifestDigest: t.ManifestDigest,
+	})
+	return append([]byte("cindy-transition-v1\n"), data...)
+}
+
+// ManifestDigest returns the hex SHA-256 digest of m's JSON encoding. A
+// signed transition carrying it in its metadata also attests the manifest.
+func ManifestDigest(m *Manifest) string {
+	data, _ := json.Marshal(m)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Signer signs transitions with an Ed25519 key.
+type Signer struct {
+	KeyID string
+	Key   ed25519.PrivateKey
+}
+
+// GenerateSigner creates a Signer with a new random key.
+func GenerateSigner(keyID string) (*Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{KeyID: keyID, Key: key}, nil
+}
+
+// ParseSigner builds a Signer from the base64 encoding of a 32-byte Ed25519
+// seed, as written by cindy keygen.
+func ParseSigner(keyID, seed string) (*Signer, error) {
+	b, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil || len(b) != ed25519.SeedSize {
+		return nil, fmt.Errorf("key %s: want the base64 encoding of a %d-byte Ed25519 seed", keyID, ed25519.SeedSize)
+	}
+	return &Signer{KeyID: keyID, Key: ed25519.NewKeyFromSeed(b)}, nil
+}
+
+// Seed returns the base64 encoding of the signer's private key seed.
+func (s *Signer) Seed() string {
+	return base64.StdEncoding.EncodeToString(s.Key.Seed())
+}
+
+// PublicKey returns the base64 encoding of the signer's public key, for a
+// KeyRing.
+func (s *Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.Key.Public().(ed25519.PublicKey))
+}
+
+// Sign returns the signature over t.
+func (s *Signer) Sign(t Transition) *Signature {
+	sig := ed25519.Sign(s.Key, TransitionPayload(t))
+	return &Signature{KeyID: s.KeyID, Value: base64.StdEncoding.EncodeToString(sig)}
+}
+
+// SignRequest signs req as a transition from from, which it also sets as
+// req.Expect so the engine cannot apply the signature to another state. It
+// stamps the timestamp and, if req carries a manifest, its digest.
+func (s *Signer) SignRequest(req *TransitionRequest, from Label) {
+	req.Expect = &from
+	req.Metadata = req.Metadata.stamp()
+	if req.Manifest != nil {
+		req.Metadata.ManifestDigest = ManifestDigest(req.Manifest)
+	}
+	req.Metadata.Signature = s.Sign(Transition{Branch: req.Branch, From: from, To: req.To, Metadata: req.Metadata})
+}
+
+// TrustedKey is a public key and the actor it signs for.
+type TrustedKey struct {
+	ID    string `json:"id"`
+	Actor string `json:"actor"`
+	// PublicKey is the standard base64 encoding of an Ed25519 public key.
+	PublicKey string `json:"public_key"`
+}
+
+// KeyRingConfig is the JSON form of a KeyRing, as loaded by LoadKeyRing.
+//
+//	{
+//	  "keys": [
+//	    {"id": "deploy-bot-2026", "actor": "deploy-bot", "public_key": "q0N4...="}
+//	  ]
+//	}
+type KeyRingConfig struct {
+	Keys []TrustedKey `json:"keys"`
+}
+
+// KeyRing holds the keys whose signatures are trusted.
+type KeyRing struct {
+	keys map[string]TrustedKey
+	pubs map[string]ed25519.PublicKey
+}
+
+// NewKeyRing builds a KeyRing from cfg, checking that key IDs are unique,
+// every key names its actor, and public keys decode.
+func NewKeyRing(cfg KeyRingConfig) (*KeyRing, error) {
+	k := &KeyRing{keys: make(map[string]TrustedKey), pubs: make(map[string]ed25519.PublicKey)}
+	for _, key := range cfg.Keys {
+		switch {
+		case key.ID == "":
+			return nil, errors.New("key ring: key without an id")
+		case key.Actor == "":
+			return nil, fmt.Errorf("key ring: key %s has no actor", key.ID)
+		}
+		if _, dup := k.keys[key.ID]; dup {
+			return nil, fmt.Errorf("key ring: key %s declared twice", key.ID)
+		}
+		pub, err := base64.StdEncoding.DecodeString(key.PublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("key ring: key %s: want the base64 encoding of a %d-byte Ed25519 public key", key.ID, ed25519.PublicKeySize)
+		}
+		k.keys[key.ID] = key
+		k.pubs[key.ID] = pub
+	}
+	return k, nil
+}
+
+// ParseKeyRing parses and validates a key ring from JSON bytes.
+func ParseKeyRing(data []byte) (*KeyRing, error) {
+	var cfg KeyRingConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing key ring: %w", err)
+	}
+	return NewKeyRing(cfg)
+}
+
+// LoadKeyRing reads and parses a key ring from a file path.
+func LoadKeyRing(path string) (*KeyRing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key ring: %w", err)
+	}
+	return ParseKeyRing(data)
+}
+
+// SignatureStatus is the outcome of verifying a transition's signature.
+type SignatureStatus string
+
+const (
+	// SignatureValid means a trusted key of the transition's actor signed it.
+	SignatureValid SignatureStatus = "valid"
+	// SignatureMissing means the transition is not signed.
+	SignatureMissing SignatureStatus = "unsigned"
+	// SignatureUntrusted means the signing key is not in the key ring.
+	SignatureUntrusted SignatureStatus = "untrusted"
+	// SignatureInvalid means the signature does not match the transition, or
+	// the key belongs to another actor.
+	SignatureInvalid SignatureStatus = "invalid"
+)
+
+// Verification reports whether and by whom a transition was signed.
+type Verification struct {
+	Status SignatureStatus `json:"status"`
+	KeyID  string          `json:"key_id,omitempty"`
+	// Signer is the actor the signing key belongs to.
+	Signer string `json:"signer,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Verify checks the signature in t's metadata.
+func (k *KeyRing) Verify(t Transition) Verification {
+	sig := t.Signature
+	if sig == nil {
+		return Verification{Status: SignatureMissing, Reason: "no signature"}
+	}
+	v := Verification{KeyID: sig.KeyID}
+	key, ok := k.keys[sig.KeyID]
+	if !ok {
+		v.Status, v.Reason = SignatureUntrusted, "unknown key "+sig.KeyID
+		return v
+	}
+	v.Signer = key.Actor
+	raw, err := base64.StdEncoding.DecodeString(sig.Value)
+	switch {
+	case err != nil || !ed25519.Verify(k.pubs[sig.KeyID], TransitionPayload(t), raw):
+		v.Status, v.Reason = SignatureInvalid, "signature does not match the transition"
+	case key.Actor != t.Actor:
+		v.Status, v.Reason = SignatureInvalid, fmt.Sprintf("key %s belongs to %s, not %s", key.ID, key.Actor, orUnknown(t.Actor))
+	default:
+		v.Status = SignatureValid
+	}
+	return v
+}
+
+// VerifyAfter checks the signature in t's metadata like Verify, and that t
+// was signed after prev, the transition it follows in the branch's history.
+// A signature does not cover the history position of t, so without this an
+// old signed transition could be appended again. A nil prev means t is the
+// first transition.
+func (k *KeyRing) VerifyAfter(t Transition, prev *Transition) Verification {
+	v := k.Verify(t)
+	if v.Status == SignatureValid && prev != nil && !t.Timestamp.After(prev.Timestamp) {
+		v.Status = SignatureInvalid
+		v.Reason = fmt.Sprintf("signed at %s, not after the previous transition at %s", t.Timestamp.Format(time.RFC3339), prev.Timestamp.Format(time.RFC3339))
+	}
+	return v
+}
+
+// RequireSignature refuses transitions that are not signed by a key in k
+// belonging to their actor, that were signed before the branch's last
+// recorded transition, or whose signed manifest digest does not match
+// TransitionRequest.Manifest. Typical use is guarding cindy:deployed so
+// every production deploy is attributable.
+func RequireSignature(k *KeyRing) Guard {
+	return func(ctx *TransitionContext) error {
+		t := Transition{Branch: ctx.Branch, From: ctx.From, To: ctx.To, Metadata: ctx.Metadata}
+		var prev *Transition
+		if rl, ok := ctx.Labeler.(RecordingLabeler); ok {
+			history, err := rl.History(ctx.Branch)
+			if err != nil {
+				return err
+			}
+			if n := len(history); n > 0 {
+				prev = &history[n-1]
+			}
+		}
+		if v := k.VerifyAfter(t, prev); v.Status != SignatureValid {
+			return fmt.Errorf("signature %s: %s", v.Status, v.Reason)
+		}
+		if ctx.Manifest != nil && ctx.Metadata.ManifestDigest != "" && ctx.Metadata.ManifestDigest != ManifestDigest(ctx.Manifest) {
+			return errors.New("signed manifest digest does not match the manifest")
+		}
+		return nil
+	}
+}
+
+// LabelVerification reports the signature on the transition that gave a
+// branch its current label. Transition is nil if none is recorded.
+type LabelVerification struct {
+	Branch     string      `json:"branch"`
+	Label      Label       `json:"label"`
+	Transition *Transition `json:"transition"`
+	Verification
+}
+
+// VerifyLabels verifies, with VerifyAfter, the transition behind the
+// current label of every branch carrying one of labels, or every labeled branch if labels is
+// empty. Results are sorted by branch.
+func VerifyLabels(l RecordingLabeler, k *KeyRing, labels ...Label) ([]LabelVerification, error) {
+	all, err := l.AllLabels()
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]string, 0, len(all))
+	for b := range all {
+		branches = append(branches, b)
+	}
+	sort.Strings(branches)
+	var results []LabelVerification
+	for _, branch := range branches {
+		label := all[branch]
+		if len(labels) > 0 && !contains(labels, label) {
+			continue
+		}
+		history, err := l.History(branch)
+		if err != nil {
+			return nil, err
+		}
+		lv := LabelVerification{Branch: branch, Label: label}
+		if n := len(history); n > 0 && history[n-1].To == label {
+			t := history[n-1]
+			lv.Transition = &t
+			var prev *Transition
+			if n > 1 {
+				prev = &history[n-2]
+			}
+			lv.Verification = k.VerifyAfter(t, prev)
+		} else {
+			lv.Verification = Verification{Status: SignatureMissing, Reason: "no recorded transition"}
+		}
+		results = append(results, lv)
+	}
+	return results, nil
+}
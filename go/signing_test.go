@@ -0,0 +1,248 @@
+package cindy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T, keyID string) *Signer {
+	t.Helper()
+	s, err := GenerateSigner(keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTestKeyRing(t *testing.T, keys ...TrustedKey) *KeyRing {
+	t.Helper()
+	k, err := NewKeyRing(KeyRingConfig{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyRing_Verify(t *testing.T) {
+	bot := newTestSigner(t, "bot-1")
+	stranger := newTestSigner(t, "stranger-1")
+	ring := newTestKeyRing(t, TrustedKey{ID: "bot-1", Actor: "deploy-bot", PublicKey: bot.PublicKey()})
+
+	signed := func(actor string, s *Signer, tamper func(*Transition)) Transition {
+		tr := Transition{Branch: "feature/x", From: Deploying, To: Deployed, Metadata: Metadata{
+			Actor: actor, Reason: "rollout done", Timestamp: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC),
+		}}
+		if s != nil {
+			tr.Signature = s.Sign(tr)
+		}
+		if tamper != nil {
+			tamper(&tr)
+		}
+		return tr
+	}
+	tests := []struct {
+		name string
+		tr   Transition
+		want SignatureStatus
+	}{
+		{"valid", signed("deploy-bot", bot, nil), SignatureValid},
+		{"identity added later", signed("deploy-bot", bot, func(tr *Transition) { tr.ActorKind = KindAgent }), SignatureValid},
+		{"unsigned", signed("deploy-bot", nil, nil), SignatureMissing},
+		{"untrusted key", signed("deploy-bot", stranger, nil), SignatureUntrusted},
+		{"tampered reason", signed("deploy-bot", bot, func(tr *Transition) { tr.Reason = "forced" }), SignatureInvalid},
+		{"replayed on another branch", signed("deploy-bot", bot, func(tr *Transition) { tr.Branch = "feature/y" }), SignatureInvalid},
+		{"another actor's key", signed("agent-7", bot, nil), SignatureInvalid},
+	}
+	for _, tt := range tests {
+		v := ring.Verify(tt.tr)
+		if v.Status != tt.want {
+			t.Errorf("%s: got %s (%s), want %s", tt.name, v.Status, v.Reason, tt.want)
+		}
+		if v.Status == SignatureValid && v.Signer != "deploy-bot" {
+			t.Errorf("%s: signer %q, want deploy-bot", tt.name, v.Signer)
+		}
+	}
+}
+
+func TestNewKeyRing_Invalid(t *testing.T) {
+	pub := newTestSigner(t, "k").PublicKey()
+	tests := []struct {
+		name string
+		keys []TrustedKey
+		want string
+	}{
+		{"no id", []TrustedKey{{Actor: "a", PublicKey: pub}}, "without an id"},
+		{"no actor", []TrustedKey{{ID: "k", PublicKey: pub}}, "has no actor"},
+		{"duplicate", []TrustedKey{{ID: "k", Actor: "a", PublicKey: pub}, {ID: "k", Actor: "b", PublicKey: pub}}, "declared twice"},
+		{"bad key", []TrustedKey{{ID: "k", Actor: "a", PublicKey: "c2hvcnQ="}}, "32-byte Ed25519 public key"},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyRing(KeyRingConfig{Keys: tt.keys}); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestParseSigner(t *testing.T) {
+	s := newTestSigner(t, "k")
+	parsed, err := ParseSigner("k", s.Seed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.PublicKey() != s.PublicKey() {
+		t.Error("seed did not round-trip")
+	}
+	if _, err := ParseSigner("k", "c2hvcnQ="); err == nil {
+		t.Error("expected short seed to be refused")
+	}
+}
+
+func TestRequireSignature(t *testing.T) {
+	bot := newTestSigner(t, "bot-1")
+	ring := newTestKeyRing(t, TrustedKey{ID: "bot-1", Actor: "deploy-bot", PublicKey: bot.PublicKey()})
+	ml := NewMemoryLabeler()
+	e := NewEngine(ml, nil)
+	e.SetPermissions(loadExamplePermissions(t))
+	e.AddGuard(AnyLabel, Deployed, "signed", RequireSignature(ring))
+	for _, l := range []Label{Ready, Analyzing, Approved, Deploying} {
+		ml.SetLabel("feature/x", l)
+	}
+
+	unsigned := TransitionRequest{Branch: "feature/x", To: Deployed, Metadata: Metadata{Actor: "deploy-bot"}}
+	var guardErr *GuardError
+	if _, err := e.ApplyTransition(unsigned); !errors.As(err, &guardErr) || !strings.Contains(err.Error(), "signature unsigned") {
+		t.Fatalf("expected unsigned deploy to be refused, got %v", err)
+	}
+
+	m := &Manifest{Revision: 1, Description: "x"}
+	req := TransitionRequest{Branch: "feature/x", To: Deployed, Manifest: m, Metadata: Metadata{Actor: "deploy-bot", Reason: "rollout done"}}
+	bot.SignRequest(&req, Deploying)
+	tampered := req
+	tampered.Manifest = &Manifest{Revision: 2, Description: "x"}
+	if _, err := e.ApplyTransition(tampered); err == nil || !strings.Contains(err.Error(), "manifest digest") {
+		t.Fatalf("expected swapped manifest to be refused, got %v", err)
+	}
+	if _, err := e.ApplyTransition(req); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recorded transition, identity fields and all, still verifies.
+	results, err := VerifyLabels(ml, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != SignatureValid || results[0].Transition.ActorKind != KindAgent {
+		t.Errorf("unexpected verification %+v", results)
+	}
+	// The signed deploy cannot be replayed once the branch is back at
+	// cindy:deploying.
+	for _, l := range []Label{Rollback, RevisionRequested, Ready, Analyzing, Approved, Deploying} {
+		ml.SetLabel("feature/x", l)
+	}
+	if _, err := e.ApplyTransition(req); err == nil || !strings.Contains(err.Error(), "not after the previous transition") {
+		t.Fatalf("expected replayed signature to be refused, got %v", err)
+	}
+}
+
+func TestVerifyLabels(t *testing.T) {
+	bot := newTestSigner(t, "bot-1")
+	ring := newTestKeyRing(t, TrustedKey{ID: "bot-1", Actor: "deploy-bot", PublicKey: bot.PublicKey()})
+	ml := NewMemoryLabeler()
+	ml.SetLabel("feature/a", Ready)
+	ml.SetLabelWithMetadata("feature/b", Ready, Metadata{Actor: "deploy-bot"})
+	req := TransitionRequest{Branch: "feature/c", To: Ready, Metadata: Metadata{Actor: "deploy-bot"}}
+	bot.SignRequest(&req, "")
+	ml.SetLabelWithMetadata("feature/c", Ready, req.Metadata)
+	// feature/d ends with a copy of a signed transition from earlier in its
+	// history.
+	replayed := TransitionRequest{Branch: "feature/d", To: Ready, Metadata: Metadata{Actor: "deploy-bot"}}
+	bot.SignRequest(&replayed, "")
+	ml.SetLabelWithMetadata("feature/d", Ready, replayed.Metadata)
+	ml.RemoveLabel("feature/d", Metadata{Actor: "deploy-bot"})
+	ml.SetLabelWithMetadata("feature/d", Ready, replayed.Metadata)
+
+	results, err := VerifyLabels(ml, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Branch+"="+string(r.Status))
+	}
+	if want := "feature/a=unsigned feature/b=unsigned feature/c=valid feature/d=invalid"; strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+	if results, _ := VerifyLabels(ml, ring, Deployed); len(results) != 0 {
+		t.Errorf("label filter ignored: %+v", results)
+	}
+}
+
+func TestReceiveHook_RequireSigned(t *testing.T) {
+	bot := newTestSigner(t, "bot-1")
+	f := newReceiveFixture(t)
+	f.hook.Keys = newTestKeyRing(t, TrustedKey{ID: "bot-1", Actor: "deployer", PublicKey: bot.PublicKey()})
+	f.hook.RequireSigned = []Label{Deployed}
+	for _, to := range []Label{Ready, Analyzing, Approved, Deploying} {
+		if r := f.label("feature/x", to, "agent"); r != nil {
+			t.Fatalf("%s rejected: %v", to, r)
+		}
+	}
+	expectRejected(t, f.label("feature/x", Deployed, "deployer"), "signature unsigned")
+	f.reset()
+
+	req := TransitionRequest{Branch: "feature/x", To: Deployed, Metadata: Metadata{Actor: "deployer"}}
+	bot.SignRequest(&req, Deploying)
+	if err := f.gl.SetLabelWithMetadata("feature/x", Deployed, req.Metadata); err != nil {
+		t.Fatal(err)
+	}
+	if r := f.push(labelRefs("feature/x", Deploying, Deployed)...); r != nil {
+		t.Fatalf("signed deploy rejected: %v", r)
+	}
+	// Appending the same signed transition again later is refused.
+	for _, to := range []Label{Rollback, RevisionRequested, Ready, Analyzing, Approved, Deploying} {
+		if r := f.label("feature/x", to, "agent"); r != nil {
+			t.Fatalf("%s rejected: %v", to, r)
+		}
+	}
+	if err := f.gl.SetLabelWithMetadata("feature/x", Deployed, req.Metadata); err != nil {
+		t.Fatal(err)
+	}
+	expectRejected(t, f.push(labelRefs("feature/x", Deploying, Deployed)...), "not after the previous transition")
+}
+
+func TestServer_Verify(t *testing.T) {
+	bot := newTestSigner(t, "bot-1")
+	ml := NewMemoryLabeler()
+	s := &Server{Engine: NewEngine(ml, nil)}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	if code := apiCall(t, srv, "GET", "/v1/verify", nil, nil); code != http.StatusNotImplemented {
+		t.Fatalf("verify without keys: got %d, want 501", code)
+	}
+	s.Keys = newTestKeyRing(t, TrustedKey{ID: "bot-1", Actor: "agent", PublicKey: bot.PublicKey()})
+
+	// A signature passed through the API is recorded with the transition.
+	change := LabelChange{To: Ready, Actor: "agent", Reason: "submit", Timestamp: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)}
+	from := Label("")
+	change.From = &from
+	change.Signature = bot.Sign(Transition{Branch: "feature/a", To: Ready, Metadata: Metadata{Actor: change.Actor, Reason: change.Reason, Timestamp: change.Timestamp}})
+	if code := apiCall(t, srv, "POST", "/v1/labels/feature/a", change, nil); code != http.StatusOK {
+		t.Fatalf("signed change: got %d", code)
+	}
+	ml.SetLabelWithMetadata("feature/b", Ready, Metadata{Actor: "agent"})
+
+	var report VerificationReport
+	if code := apiCall(t, srv, "GET", "/v1/verify?label=cindy:ready", nil, &report); code != http.StatusOK {
+		t.Fatalf("verify: got %d", code)
+	}
+	if len(report.Labels) != 2 || report.Labels[0].Status != SignatureValid || report.Labels[1].Status != SignatureMissing {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if code := apiCall(t, srv, "GET", "/v1/verify?label=cindy:shipped", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("unknown label: got %d, want 400", code)
+	}
+}
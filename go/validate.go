@@ -0,0 +1,151 @@
+package cindy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidManifest is wrapped by errors reporting a manifest that does not
+// follow the format of SPEC §4.
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// ManifestError lists every way a manifest breaks the format.
+type ManifestError struct {
+	Problems []string
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrInvalidManifest, strings.Join(e.Problems, "; "))
+}
+
+func (e *ManifestError) Unwrap() error { return ErrInvalidManifest }
+
+var (
+	manifestFields     = []string{"revision", "responds_to", "subjects_affected", "schema_changes", "consumers", "risk_self_assessment", "depends_on", "description"}
+	schemaChangeFields = []string{"subject", "type", "fields_added", "fields_removed", "fields_modified"}
+)
+
+// ValidateManifest checks that data is a well-formed manifest (SPEC §4, §6):
+//   - every required field is present with the right type
+//   - revision starts at 1, and responds_to is null exactly on revision 1
+//   - risk_self_assessment and each schema change type are known values
+//   - every schema change's subject is listed in subjects_affected
+//   - depends_on holds valid branch names
+//
+// It returns the parsed manifest, or a *ManifestError listing every problem.
+// Schema safety (§5) is a separate check; see ValidateSchemaChanges.
+func ValidateManifest(data []byte) (*Manifest, error) {
+	var problems []string
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, &ManifestError{Problems: []string{"not a JSON object: " + err.Error()}}
+	}
+	problems = append(problems, missingFields(raw, manifestFields, "")...)
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, &ManifestError{Problems: append(problems, err.Error())}
+	}
+	for _, list := range []string{"subjects_affected", "schema_changes", "consumers", "depends_on"} {
+		if isNull(raw[list]) {
+			problems = append(problems, fmt.Sprintf("%s must be an array, not null", list))
+		}
+	}
+
+	switch {
+	case m.Revision < 1:
+		problems = append(problems, fmt.Sprintf("revision must be at least 1, got %d", m.Revision))
+	case m.Revision == 1 && m.RespondsTo != nil:
+		problems = append(problems, "responds_to must be null on revision 1")
+	case m.Revision > 1 && (m.RespondsTo == nil || *m.RespondsTo == ""):
+		problems = append(problems, fmt.Sprintf("revision %d must set responds_to to the review it addresses", m.Revision))
+	}
+	switch RiskLevel(m.RiskSelfAssessment) {
+	case RiskLow, RiskMedium, RiskHigh:
+	default:
+		problems = append(problems, fmt.Sprintf("risk_self_assessment must be low, medium or high, got %q", m.RiskSelfAssessment))
+	}
+	if strings.TrimSpace(m.Description) == "" {
+		problems = append(problems, "description must not be empty")
+	}
+
+	subjects := make(map[string]bool)
+	for i, s := range m.SubjectsAffected {
+		if strings.TrimSpace(s) == "" {
+			problems = append(problems, fmt.Sprintf("subjects_affected[%d] is empty", i))
+		}
+		subjects[s] = true
+	}
+	var rawChanges []map[string]json.RawMessage
+	json.Unmarshal(raw["schema_changes"], &rawChanges)
+	for i, sc := range m.SchemaChanges {
+		at := fmt.Sprintf("schema_changes[%d]", i)
+		if i < len(rawChanges) {
+			problems = append(problems, missingFields(rawChanges[i], schemaChangeFields, at+".")...)
+		}
+		if sc.Type != SchemaExtension && sc.Type != SchemaNew {
+			problems = append(problems, fmt.Sprintf("%s.type must be extension or new, got %q", at, sc.Type))
+		}
+		if !subjects[sc.Subject] {
+			problems = append(problems, fmt.Sprintf("%s.subject %q is not listed in subjects_affected", at, sc.Subject))
+		}
+	}
+	for i, dep := range m.DependsOn {
+		if err := ValidateBranchName(dep); err != nil {
+			problems = append(problems, fmt.Sprintf("depends_on[%d]: %v", i, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &ManifestError{Problems: problems}
+	}
+	return &m, nil
+}
+
+func missingFields(raw map[string]json.RawMessage, required []string, prefix string) []string {
+	var problems []string
+	for _, f := range required {
+		if _, ok := raw[f]; !ok {
+			problems = append(problems, fmt.Sprintf("missing required field %s%s", prefix, f))
+		}
+	}
+	return problems
+}
+
+func isNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// LatestChangeRequest returns the most recent request_changes review, by
+// revision then position, or nil if there is none.
+func LatestChangeRequest(reviews []Review) *Review {
+	var latest *Review
+	for i := range reviews {
+		r := &reviews[i]
+		if r.Verdict == RequestChanges && (latest == nil || r.Revision >= latest.Revision) {
+			latest = r
+		}
+	}
+	return latest
+}
+
+// ValidateRevision checks that m is a new revision answering the branch's
+// latest request_changes review (SPEC §6): its revision is higher than the
+// reviewed one and responds_to names the review. It returns nil if the
+// branch has no request_changes review.
+func ValidateRevision(m *Manifest, reviews []Review) error {
+	latest := LatestChangeRequest(reviews)
+	if latest == nil {
+		return nil
+	}
+	if m.Revision <= latest.Revision {
+		return fmt.Errorf("revision %d does not increment reviewed revision %d", m.Revision, latest.Revision)
+	}
+	if m.RespondsTo == nil || *m.RespondsTo != latest.ID {
+		return fmt.Errorf("manifest must respond to review %s", latest.ID)
+	}
+	return nil
+}